@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/noopduck/kdev/internal/progress"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/util/retry"
+)
+
+// fieldManager owns the fields kdev sets through server-side apply, so
+// re-running up drops what an earlier up set and the new one does not.
+const fieldManager = "kdev"
+
+// patchFunc is the Patch method every typed client has.
+type patchFunc[T any] func(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (T, error)
+
+// serverSideApply sends obj, which must carry its TypeMeta, as a
+// server-side apply patch. Conflicts with other managers are forced: the
+// spec given to kdev up is what the user wants.
+func serverSideApply[T any](ctx context.Context, patch patchFunc[T], name string, obj interface{}) (T, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	force := true
+	return patch(ctx, name, types.ApplyPatchType, data, metav1.PatchOptions{FieldManager: fieldManager, Force: &force})
+}
+
+// checkExisting refuses to take over an object up did not create.
+func checkExisting(kind string, meta *metav1.ObjectMeta) error {
+	if meta.Labels["app"] != "kdev" {
+		return fmt.Errorf("%s %s already exists and is not managed by kdev (missing app=kdev label)", kind, meta.Name)
+	}
+	return nil
+}
+
+// applyPVC creates the workspace PVC or converges an existing one. Fields
+// Kubernetes cannot change on a bound claim are taken from the live object,
+// and the claim is never shrunk.
+func applyPVC(ctx context.Context, pvc *corev1.PersistentVolumeClaim) error {
+	pvcs := kubeClient.CoreV1().PersistentVolumeClaims(pvc.Namespace)
+	live, err := pvcs.Get(ctx, pvc.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if _, err := serverSideApply(ctx, pvcs.Patch, pvc.Name, pvc); err != nil {
+			return fmt.Errorf("failed to create PVC: %w", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get PVC: %w", err)
+	}
+	if err := checkExisting("PVC", &live.ObjectMeta); err != nil {
+		return err
+	}
+
+	want := pvc.DeepCopy()
+	if ptrValue(want.Spec.StorageClassName) != ptrValue(live.Spec.StorageClassName) {
+		progress.Warnf("PVC %s keeps StorageClass %q; the StorageClass of an existing PVC cannot be changed", live.Name, ptrValue(live.Spec.StorageClassName))
+	}
+	want.Spec.StorageClassName = live.Spec.StorageClassName
+	want.Spec.AccessModes = live.Spec.AccessModes
+	want.Spec.VolumeMode = live.Spec.VolumeMode
+	want.Spec.VolumeName = live.Spec.VolumeName
+	want.Spec.Selector = live.Spec.Selector
+	want.Spec.DataSource, want.Spec.DataSourceRef = live.Spec.DataSource, live.Spec.DataSourceRef
+	have := live.Spec.Resources.Requests[corev1.ResourceStorage]
+	if size, ok := want.Spec.Resources.Requests[corev1.ResourceStorage]; !ok || size.Cmp(have) < 0 {
+		if ok {
+			progress.Infof("PVC %s keeps its size %s; PVCs cannot shrink", live.Name, have.String())
+		}
+		want.Spec.Resources.Requests = corev1.ResourceList{corev1.ResourceStorage: have.DeepCopy()}
+	}
+
+	if _, err := serverSideApply(ctx, pvcs.Patch, want.Name, want); err != nil {
+		return fmt.Errorf("failed to update PVC %s (does its StorageClass allow volume expansion?): %w", want.Name, err)
+	}
+	return nil
+}
+
+// applyPod creates the pod or converges an existing one. Most of a pod's
+// spec is immutable; when the new spec changes such fields the pod is
+// deleted and created again, keeping the workspace PVC.
+func applyPod(ctx context.Context, pod *corev1.Pod, timeout time.Duration) (string, error) {
+	pods := kubeClient.CoreV1().Pods(pod.Namespace)
+	live, err := pods.Get(ctx, pod.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if err := applyNewPod(ctx, pod); err != nil {
+			return "", fmt.Errorf("failed to create Pod: %w", err)
+		}
+		return "created", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get Pod: %w", err)
+	}
+	if err := checkExisting("Pod", &live.ObjectMeta); err != nil {
+		return "", err
+	}
+
+	applied, err := serverSideApply(ctx, pods.Patch, pod.Name, pod)
+	switch {
+	case err == nil:
+		return appliedAction(live.ObjectMeta, applied.ObjectMeta), nil
+	case !changesImmutableFields(err):
+		return "", fmt.Errorf("failed to update Pod: %w", err)
+	}
+
+	if err := pods.Delete(ctx, pod.Name, metav1.DeleteOptions{}); err != nil {
+		return "", fmt.Errorf("failed to delete pod: %w", err)
+	}
+	sp := progress.Start(fmt.Sprintf("Recreating pod %s to apply changes that cannot be made in place (PVC kept)", pod.Name))
+	err = waitForPodDeleted(ctx, pod.Namespace, pod.Name, timeout)
+	sp.Done(err)
+	if err != nil {
+		return "", err
+	}
+	if err := applyNewPod(ctx, pod); err != nil {
+		return "", fmt.Errorf("failed to recreate pod: %w", err)
+	}
+	return "recreated", nil
+}
+
+// changesImmutableFields reports whether err rejected a pod update only
+// because it changes fields that cannot be updated in place. Any other
+// invalid value would fail the recreate too, so the pod is left alone.
+func changesImmutableFields(err error) bool {
+	if !apierrors.IsInvalid(err) {
+		return false
+	}
+	status, ok := err.(apierrors.APIStatus)
+	if !ok || status.Status().Details == nil {
+		return false
+	}
+	causes := status.Status().Details.Causes
+	for _, c := range causes {
+		if c.Type != metav1.CauseType(field.ErrorTypeForbidden) || (c.Field != "spec" && !strings.HasPrefix(c.Field, "spec.")) {
+			return false
+		}
+	}
+	return len(causes) > 0
+}
+
+// applyNewPod is createPod through server-side apply, so kdev owns the
+// pod's fields from the start.
+func applyNewPod(ctx context.Context, pod *corev1.Pod) error {
+	return retry.OnError(saAdmissionBackoff, isServiceAccountNotReady, func() error {
+		_, err := serverSideApply(ctx, kubeClient.CoreV1().Pods(pod.Namespace).Patch, pod.Name, pod)
+		return err
+	})
+}
+
+// applyStatefulSet creates or converges the StatefulSet of an environment.
+// Its controller rolls the pod when the template changes.
+func applyStatefulSet(ctx context.Context, sts *appsv1.StatefulSet) (string, error) {
+	sets := kubeClient.AppsV1().StatefulSets(sts.Namespace)
+	live, err := sets.Get(ctx, sts.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if _, err := serverSideApply(ctx, sets.Patch, sts.Name, sts); err != nil {
+			return "", fmt.Errorf("failed to create StatefulSet: %w", err)
+		}
+		return "created", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get StatefulSet: %w", err)
+	}
+	if err := checkExisting("StatefulSet", &live.ObjectMeta); err != nil {
+		return "", err
+	}
+	applied, err := serverSideApply(ctx, sets.Patch, sts.Name, sts)
+	switch {
+	case apierrors.IsInvalid(err):
+		return "", fmt.Errorf("StatefulSet %s cannot be changed this way (its volume claim template is immutable); remove it with kdev rm first: %w", sts.Name, err)
+	case err != nil:
+		return "", fmt.Errorf("failed to update StatefulSet: %w", err)
+	}
+	return appliedAction(live.ObjectMeta, applied.ObjectMeta), nil
+}
+
+// applyDeployment creates or converges the Deployment of an environment.
+func applyDeployment(ctx context.Context, deploy *appsv1.Deployment) (string, error) {
+	deploys := kubeClient.AppsV1().Deployments(deploy.Namespace)
+	live, err := deploys.Get(ctx, deploy.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if _, err := serverSideApply(ctx, deploys.Patch, deploy.Name, deploy); err != nil {
+			return "", fmt.Errorf("failed to create Deployment: %w", err)
+		}
+		return "created", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get Deployment: %w", err)
+	}
+	if err := checkExisting("Deployment", &live.ObjectMeta); err != nil {
+		return "", err
+	}
+	applied, err := serverSideApply(ctx, deploys.Patch, deploy.Name, deploy)
+	if err != nil {
+		return "", fmt.Errorf("failed to update Deployment: %w", err)
+	}
+	return appliedAction(live.ObjectMeta, applied.ObjectMeta), nil
+}
+
+// appliedAction tells whether an apply changed the object.
+func appliedAction(before, after metav1.ObjectMeta) string {
+	if before.ResourceVersion == after.ResourceVersion {
+		return "unchanged"
+	}
+	return "configured"
+}
+
+// checkControllerKind refuses to apply m over an environment of the same
+// name that runs under a different --controller, which would leave two
+// pods behind.
+func checkControllerKind(ctx context.Context, m *manifests) error {
+	want := controllerPod
+	switch {
+	case m.StatefulSet != nil:
+		want = controllerStatefulSet
+	case m.Deployment != nil:
+		want = controllerDeployment
+	}
+	have, _, err := envWorkload(ctx, m.Pod.Namespace, m.Pod.Name)
+	if err != nil {
+		return err
+	}
+	if have == "" && want != controllerPod {
+		pod, err := kubeClient.CoreV1().Pods(m.Pod.Namespace).Get(ctx, m.Pod.Name, metav1.GetOptions{})
+		if err == nil && !controlledByWorkload(pod) {
+			have = controllerPod
+		}
+	}
+	if have != "" && have != want {
+		return fmt.Errorf("environment %s already runs with --controller %s; remove it with kdev rm before switching to %s", m.Pod.Name, have, want)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/noopduck/kdev/internal/progress"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// fallbackShells are tried in order when the environment's shell is not in
+// the image.
+var fallbackShells = []string{"/bin/bash", "/bin/zsh", "/bin/sh"}
+
+// defaultSessionName names the tmux or screen session of attach --persistent.
+const defaultSessionName = "kdev"
+
+// maxAttachBackoff caps the wait between reconnect attempts of attach.
+const maxAttachBackoff = 10 * time.Second
+
+// persistentScript starts or re-attaches tmux session $1, or screen session
+// $1 when there is no tmux, running shell $2 in a new session.
+const persistentScript = `if command -v tmux >/dev/null 2>&1; then
+	exec tmux new-session -A -s "$1" "$2"
+elif command -v screen >/dev/null 2>&1; then
+	exec screen -D -R -S "$1" "$2"
+fi
+echo "kdev: --persistent needs tmux or screen in the container" >&2
+exit 127`
+
+// attachShell runs an interactive shell in container of pod; with a
+// session name the shell runs in that tmux or screen session, created if
+// needed, so it outlives the connection. It reports whether the session got
+// as far as printing anything, which tells a connection that broke from one
+// that never worked.
+func attachShell(ctx context.Context, pod *corev1.Pod, container, shell, session string) (bool, error) {
+	command := []string{shell}
+	if session != "" {
+		command = []string{shell, "-c", persistentScript, "kdev", session, shell}
+	}
+	out := &outputSeen{w: os.Stdout}
+	err := execInPod(ctx, pod.Name, container, withRemoteEnv(pod, container, command), out, true, true)
+	return out.seen.Load(), err
+}
+
+// attachSession attaches to the pod of environment name. With reconnect, a
+// session that ends because the connection broke, rather than because the
+// shell exited, is replaced by a new shell in whatever pod then backs the
+// environment; kdev gives up when none is ready within timeout. container
+// is the -c flag and is resolved again for each pod. An empty shell means
+// the environment's shell or, failing that, the first of fallbackShells
+// the container has. With a persistent session, reconnecting resumes it
+// as long as the pod is the same.
+func attachSession(ctx context.Context, name string, pod *corev1.Pod, container, shell, session string, reconnect bool, timeout time.Duration) error {
+	for {
+		target, err := podContainer(pod, container)
+		if err != nil {
+			return err
+		}
+		if shell == "" {
+			if shell, err = detectShell(ctx, pod.Name, target, podShell(pod)); err != nil {
+				return err
+			}
+		}
+		started, err := attachShell(ctx, pod, target, shell, session)
+		if !reconnect || !started || !sessionDropped(ctx, err) {
+			return err
+		}
+		progress.Warnf("connection to %s lost: %v; reconnecting", name, err)
+		previous := pod.UID
+		if pod, err = reconnectPod(ctx, name, timeout); err != nil {
+			return err
+		}
+		if session != "" && pod.UID == previous {
+			progress.Infof("Reconnected to pod %s; resuming session %s", pod.Name, session)
+		} else {
+			progress.Infof("Reconnected to pod %s; this is a new shell", pod.Name)
+		}
+	}
+}
+
+// detectShell returns preferred if it can be run in container of podName,
+// and otherwise the first of fallbackShells that can, saying so.
+func detectShell(ctx context.Context, podName, container, preferred string) (string, error) {
+	candidates := []string{preferred}
+	for _, sh := range fallbackShells {
+		if sh != preferred {
+			candidates = append(candidates, sh)
+		}
+	}
+	var err error
+	for _, sh := range candidates {
+		err = execStream(ctx, podName, container, []string{sh, "-c", "exit 0"}, remotecommand.StreamOptions{Stdout: io.Discard})
+		if err == nil {
+			if sh != preferred {
+				progress.Infof("%s is not available in container %s, using %s", preferred, container, sh)
+			}
+			return sh, nil
+		}
+	}
+	return "", fmt.Errorf("failed to find a shell in container %s (tried %s), pass --shell: %w", container, strings.Join(candidates, ", "), err)
+}
+
+// sessionDropped reports whether an attach session ended with a broken
+// connection. The shell exiting, with any status, is a normal end.
+func sessionDropped(ctx context.Context, err error) bool {
+	var exit *exitCodeError
+	return err != nil && ctx.Err() == nil && !errors.As(err, &exit)
+}
+
+// reconnectPod waits with backoff until environment name has a ready pod.
+func reconnectPod(ctx context.Context, name string, timeout time.Duration) (*corev1.Pod, error) {
+	deadline := time.Now().Add(timeout)
+	backoff := time.Second
+	for {
+		pod, err := resolvePod(ctx, flagNamespace, name)
+		if err == nil && podReady(pod) {
+			return pod, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("pod %s is %s", pod.Name, podStatus(pod))
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return nil, fmt.Errorf("failed to reconnect to %s within %s: %w", name, timeout, err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxAttachBackoff)
+	}
+}
+
+// outputSeen passes writes through and notes that there were any.
+type outputSeen struct {
+	w    io.Writer
+	seen atomic.Bool
+}
+
+func (o *outputSeen) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		o.seen.Store(true)
+	}
+	return o.w.Write(p)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/noopduck/kdev/internal/devcontainer"
+	"github.com/noopduck/kdev/internal/progress"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/portforward"
+)
+
+// forwardPortsAnnotation stores the devcontainer.json forwardPorts of an
+// environment, with their attributes, on its pod.
+const forwardPortsAnnotation = "kdev/forward-ports"
+
+// autoForwardWait bounds how long attach waits for the automatic port
+// forwards before opening the shell.
+const autoForwardWait = 10 * time.Second
+
+// setForwardPorts records ports on pod. A "host:port" entry must name a
+// compose service: those run as containers of the pod and share its network,
+// so their ports are forwarded from the pod like the dev container's. Other
+// hosts cannot be reached that way and are rejected.
+func setForwardPorts(pod *corev1.Pod, ports []devcontainer.ForwardPort) error {
+	if len(ports) == 0 {
+		return nil
+	}
+	local := map[string]bool{"": true, "localhost": true, "127.0.0.1": true}
+	for _, a := range pod.Spec.HostAliases {
+		if a.IP == "127.0.0.1" {
+			for _, h := range a.Hostnames {
+				local[h] = true
+			}
+		}
+	}
+	for _, c := range pod.Spec.Containers {
+		local[c.Name] = true
+	}
+	for _, p := range ports {
+		if !local[p.Host] {
+			return fmt.Errorf("forwardPorts entry %s:%d: %s is not a compose service of the environment; kdev can only forward ports of its pod", p.Host, p.Port, p.Host)
+		}
+	}
+	data, err := json.Marshal(ports)
+	if err != nil {
+		return err
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[forwardPortsAnnotation] = string(data)
+	return nil
+}
+
+// podForwardPorts returns the ports recorded on pod.
+func podForwardPorts(pod *corev1.Pod) ([]devcontainer.ForwardPort, error) {
+	data := pod.Annotations[forwardPortsAnnotation]
+	if data == "" {
+		return nil, nil
+	}
+	var ports []devcontainer.ForwardPort
+	if err := json.Unmarshal([]byte(data), &ports); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation on pod %s: %w", forwardPortsAnnotation, pod.Name, err)
+	}
+	return ports, nil
+}
+
+// startAutoForwards forwards the recorded ports of environment name to the
+// same local ports, or free ones when those are taken, until stop is
+// called. It returns once the forwards listen, so their announcements do
+// not land in the shell. Problems are warnings: attach goes on without the
+// ports.
+func startAutoForwards(ctx context.Context, name string, pod *corev1.Pod) (stop func()) {
+	ports, err := podForwardPorts(pod)
+	if err != nil {
+		progress.Warnf("%v", err)
+		return func() {}
+	}
+	var forwarded []devcontainer.ForwardPort
+	var mappings []string
+	for _, p := range ports {
+		if p.OnAutoForward == "ignore" {
+			continue
+		}
+		local := p.Port
+		if !localPortFree(local) {
+			if p.RequireLocalPort {
+				progress.Warnf("local port %d is in use; not forwarding %s", local, portLabel(p))
+				continue
+			}
+			local = 0
+		}
+		forwarded = append(forwarded, p)
+		mappings = append(mappings, fmt.Sprintf("%d:%d", local, p.Port))
+	}
+	if len(mappings) == 0 {
+		return func() {}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	ready, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		err := forwardEnvPorts(ctx, name, []string{"localhost"}, mappings, func(fwd []portforward.ForwardedPort) {
+			for i, f := range fwd {
+				announcePort(forwarded[i], int(f.Local))
+			}
+			close(ready)
+		})
+		if err != nil && ctx.Err() == nil {
+			progress.Warnf("failed to forward the devcontainer ports: %v", err)
+		}
+	}()
+	select {
+	case <-ready:
+	case <-done:
+	case <-time.After(autoForwardWait):
+		progress.Warnf("the devcontainer ports are not forwarded yet; continuing without waiting")
+	}
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// localPortFree reports whether port can be listened on locally.
+func localPortFree(port int) bool {
+	l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		return false
+	}
+	l.Close()
+	return true
+}
+
+func portLabel(p devcontainer.ForwardPort) string {
+	if p.Label != "" {
+		return fmt.Sprintf("%s (port %d)", p.Label, p.Port)
+	}
+	return fmt.Sprintf("port %d", p.Port)
+}
+
+// announcePort tells about a forwarded port as its onAutoForward asks:
+// notify (the default) prints it, openBrowser and openPreview also open it
+// in the browser, silent says nothing.
+func announcePort(p devcontainer.ForwardPort, local int) {
+	scheme := p.Protocol
+	if scheme == "" {
+		scheme = "http"
+	}
+	url := fmt.Sprintf("%s://localhost:%d", scheme, local)
+	switch p.OnAutoForward {
+	case "silent":
+	case "openBrowser", "openPreview":
+		progress.Infof("Forwarding %s to %s", portLabel(p), url)
+		if err := openURL(url); err != nil {
+			progress.Warnf("failed to open %s: %v", url, err)
+		}
+	default:
+		progress.Infof("Forwarding %s to %s", portLabel(p), url)
+	}
+}
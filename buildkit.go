@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/noopduck/kdev/internal/devcontainer"
+	"github.com/noopduck/kdev/internal/progress"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	// buildkitImage runs buildkitd without privileges; it ships buildctl.
+	buildkitImage = "moby/buildkit:v0.16.0-rootless"
+	// buildkitName names the Deployment and the cache PVC kdev runs.
+	buildkitName      = "kdev-buildkitd"
+	buildkitCacheSize = "50Gi"
+	buildkitCacheDir  = "/home/user/.local/share/buildkit"
+	buildkitUID       = 1000
+)
+
+// buildWithBuildKit runs b with buildctl in a long-running buildkitd in the
+// namespace, which keeps its cache on a PVC between builds: kdev's own,
+// started on first use, or the Deployment named buildkit in the user
+// config. The context is uploaded into the buildkitd pod for the build and
+// removed after it.
+func buildWithBuildKit(ctx context.Context, b devcontainer.RemoteBuild) error {
+	if err := connectBuildCluster(); err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	for _, c := range append(slices.Clone(b.CacheFrom), b.CacheTo...) {
+		if c.Type != "registry" {
+			return fmt.Errorf("buildkitd cannot reach the local cache %s; it keeps its own cache on its PVC", c)
+		}
+	}
+
+	name := userConfig.BuildKit
+	if name == "" {
+		name = buildkitName
+		if err := ensureBuildKit(ctx); err != nil {
+			return err
+		}
+	}
+	dep, err := kubeClient.AppsV1().Deployments(flagNamespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get buildkitd Deployment %s: %w", name, err)
+	}
+	selector, err := metav1.LabelSelectorAsSelector(dep.Spec.Selector)
+	if err != nil {
+		return fmt.Errorf("invalid selector of Deployment %s: %w", name, err)
+	}
+	sp := progress.Start(fmt.Sprintf("Waiting for buildkitd %s", name))
+	pod, err := waitForPods(ctx, flagNamespace, "buildkitd "+name, metav1.ListOptions{LabelSelector: selector.String()}, true, inClusterBuildTimeout)
+	sp.Done(err)
+	if err != nil {
+		return err
+	}
+	container := pod.Spec.Containers[0].Name
+
+	archive, err := packBuildContext(b)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+	dockerConfig, err := pushDockerConfig(b.Destination)
+	if err != nil {
+		return err
+	}
+
+	// Each build gets its own directory, so builds can share buildkitd.
+	dir := fmt.Sprintf("/tmp/kdev-build-%d", time.Now().UnixNano())
+	run := func(stdin io.Reader, stdout io.Writer, command ...string) error {
+		var stderr bytes.Buffer
+		opts := remotecommand.StreamOptions{Stdin: stdin, Stdout: stdout, Stderr: &stderr}
+		if stdout != nil {
+			opts.Stderr = stdout
+		}
+		err := execStream(ctx, pod.Name, container, command, opts)
+		if msg := strings.TrimSpace(stderr.String()); err != nil && msg != "" {
+			return fmt.Errorf("%v: %s", err, msg)
+		}
+		return err
+	}
+	defer func() {
+		// ctx may already be cancelled by Ctrl-C.
+		ctx = context.Background()
+		_ = run(nil, nil, "rm", "-rf", dir)
+	}()
+
+	sp = progress.Start("Uploading build context")
+	err = run(archive, nil, "sh", "-c", fmt.Sprintf("umask 077 && mkdir -p %[1]s/context %[1]s/docker && tar -xzf - -C %[1]s/context", dir))
+	if err == nil && dockerConfig != nil {
+		err = run(bytes.NewReader(dockerConfig), nil, "sh", "-c", fmt.Sprintf("cat > %s/docker/config.json", dir))
+	}
+	sp.Done(err)
+	if err != nil {
+		return fmt.Errorf("failed to upload build context: %v", err)
+	}
+
+	dockerfileDir, dockerfileName := path.Split(devcontainer.ContextDockerfile)
+	command := []string{
+		"env", "DOCKER_CONFIG=" + dir + "/docker",
+		"buildctl", "build", "--progress=plain",
+		"--frontend=dockerfile.v0",
+		"--local", "context=" + dir + "/context",
+		"--local", "dockerfile=" + path.Join(dir, "context", dockerfileDir),
+		"--opt", "filename=" + dockerfileName,
+		"--output", "type=image,name=" + b.Destination + ",push=true",
+	}
+	if b.Platform != "" {
+		command = append(command, "--opt", "platform="+b.Platform)
+	}
+	for _, c := range b.CacheFrom {
+		command = append(command, "--import-cache", c.String())
+	}
+	for _, c := range b.CacheTo {
+		command = append(command, "--export-cache", c.String())
+	}
+	for _, k := range sortedKeys(b.Args) {
+		command = append(command, "--opt", "build-arg:"+k+"="+b.Args[k])
+	}
+	for _, k := range sortedKeys(b.Labels) {
+		command = append(command, "--opt", "label:"+k+"="+b.Labels[k])
+	}
+	return progress.Stream(fmt.Sprintf("Building %s in the cluster with BuildKit", b.Destination), func(w io.Writer) error {
+		if err := run(nil, w, command...); err != nil {
+			return fmt.Errorf("buildkit build failed: %v", err)
+		}
+		return nil
+	})
+}
+
+// ensureBuildKit starts kdev's buildkitd and its cache PVC unless they
+// exist. They are kept after the build so the next one reuses the cache.
+func ensureBuildKit(ctx context.Context) error {
+	deployments := kubeClient.AppsV1().Deployments(flagNamespace)
+	existing, err := deployments.Get(ctx, buildkitName, metav1.GetOptions{})
+	if err == nil {
+		if existing.Labels[buildLabel] != "buildkitd" {
+			return fmt.Errorf("Deployment %s already exists and is not managed by kdev (missing %s=buildkitd label)", buildkitName, buildLabel)
+		}
+		return nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to look up Deployment %s: %w", buildkitName, err)
+	}
+
+	pvc, dep := buildkitManifests(flagNamespace)
+	if err := resolvePodImages(&dep.Spec.Template.Spec); err != nil {
+		return err
+	}
+	progress.Infof("Starting buildkitd %s with a %s cache in namespace %s", buildkitName, buildkitCacheSize, flagNamespace)
+	if _, err := kubeClient.CoreV1().PersistentVolumeClaims(flagNamespace).Create(ctx, pvc, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create PVC %s: %w", pvc.Name, err)
+	}
+	if _, err := deployments.Create(ctx, dep, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create Deployment %s: %w", dep.Name, err)
+	}
+	return nil
+}
+
+// buildkitManifests returns the cache PVC and the Deployment of a rootless
+// buildkitd. Rootless BuildKit needs seccomp and AppArmor unconfined to
+// create its user namespaces, but no privileges.
+func buildkitManifests(namespace string) (*corev1.PersistentVolumeClaim, *appsv1.Deployment) {
+	// Not app=kdev: buildkitd is no environment, and kdev rm must not
+	// take it for one.
+	labels := map[string]string{buildLabel: "buildkitd"}
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: buildkitName, Namespace: namespace, Labels: labels},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(buildkitCacheSize)},
+			},
+		},
+	}
+	unconfined := corev1.SecurityContext{
+		RunAsUser:       ptr.To[int64](buildkitUID),
+		RunAsGroup:      ptr.To[int64](buildkitUID),
+		SeccompProfile:  &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeUnconfined},
+		AppArmorProfile: &corev1.AppArmorProfile{Type: corev1.AppArmorProfileTypeUnconfined},
+	}
+	dep := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: buildkitName, Namespace: namespace, Labels: labels},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: ptr.To[int32](1),
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			// The cache PVC can only be mounted by one pod.
+			Strategy: appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					AutomountServiceAccountToken: ptr.To(false),
+					SecurityContext:              &corev1.PodSecurityContext{FSGroup: ptr.To[int64](buildkitUID)},
+					Containers: []corev1.Container{{
+						Name:            "buildkitd",
+						Image:           buildkitImage,
+						Args:            []string{"--oci-worker-no-process-sandbox"},
+						SecurityContext: &unconfined,
+						ReadinessProbe: &corev1.Probe{
+							ProbeHandler:        corev1.ProbeHandler{Exec: &corev1.ExecAction{Command: []string{"buildctl", "debug", "workers"}}},
+							InitialDelaySeconds: 2,
+							PeriodSeconds:       5,
+						},
+						VolumeMounts: []corev1.VolumeMount{{Name: "cache", MountPath: buildkitCacheDir}},
+					}},
+					Volumes: []corev1.Volume{{Name: "cache", VolumeSource: corev1.VolumeSource{
+						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: buildkitName},
+					}}},
+				},
+			},
+		},
+	}
+	return pvc, dep
+}
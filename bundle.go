@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/noopduck/kdev/internal/spec"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	bundleAPIVersion = "kdev/v1alpha1"
+	bundleKind       = "Bundle"
+)
+
+// bundle is the portable form of one environment written by kdev export and
+// read by kdev import.
+type bundle struct {
+	APIVersion     string                         `json:"apiVersion"`
+	Kind           string                         `json:"kind"`
+	Metadata       bundleMeta                     `json:"metadata"`
+	Spec           spec.Spec                      `json:"spec"`
+	ServiceAccount *corev1.ServiceAccount         `json:"serviceAccount,omitempty"`
+	PVCs           []corev1.PersistentVolumeClaim `json:"persistentVolumeClaims,omitempty"`
+	Pod            *corev1.Pod                    `json:"pod"`
+	Services       []corev1.Service               `json:"services,omitempty"`
+	// SSHSecret holds the authorized keys of the SSH sidecar. The web IDE
+	// password is not exported; import generates a new one.
+	SSHSecret     *corev1.Secret        `json:"sshSecret,omitempty"`
+	WebIDEIngress *networkingv1.Ingress `json:"webIDEIngress,omitempty"`
+}
+
+// bundleMeta records where and when a bundle was exported.
+type bundleMeta struct {
+	Name        string    `json:"name"`
+	Namespace   string    `json:"namespace"`
+	Context     string    `json:"context,omitempty"`
+	ExportedAt  time.Time `json:"exportedAt"`
+	KdevVersion string    `json:"kdevVersion"`
+}
+
+// cleanMeta keeps only the metadata a user could have written, dropping
+// everything the API server assigns.
+func cleanMeta(m metav1.ObjectMeta) metav1.ObjectMeta {
+	out := metav1.ObjectMeta{
+		Name:      m.Name,
+		Namespace: m.Namespace,
+		Labels:    m.Labels,
+	}
+	for k, v := range m.Annotations {
+		if isServerAnnotation(k) {
+			continue
+		}
+		if out.Annotations == nil {
+			out.Annotations = map[string]string{}
+		}
+		out.Annotations[k] = v
+	}
+	return out
+}
+
+func isServerAnnotation(key string) bool {
+	return strings.HasPrefix(key, "pv.kubernetes.io/") ||
+		strings.HasPrefix(key, "volume.kubernetes.io/") ||
+		strings.HasPrefix(key, "volume.beta.kubernetes.io/") ||
+		key == "kubectl.kubernetes.io/last-applied-configuration"
+}
+
+func exportablePod(live *corev1.Pod) *corev1.Pod {
+	pod := recreatablePod(live)
+	pod.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"}
+	pod.ObjectMeta = cleanMeta(pod.ObjectMeta)
+	return pod
+}
+
+func exportablePVC(live *corev1.PersistentVolumeClaim) corev1.PersistentVolumeClaim {
+	pvc := corev1.PersistentVolumeClaim{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "PersistentVolumeClaim"},
+		ObjectMeta: cleanMeta(live.ObjectMeta),
+		Spec:       *live.Spec.DeepCopy(),
+	}
+	// The bound volume is cluster specific.
+	pvc.Spec.VolumeName = ""
+	return pvc
+}
+
+func exportableServiceAccount(live *corev1.ServiceAccount) *corev1.ServiceAccount {
+	return &corev1.ServiceAccount{
+		TypeMeta:         metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+		ObjectMeta:       cleanMeta(live.ObjectMeta),
+		ImagePullSecrets: live.ImagePullSecrets,
+	}
+}
+
+func exportableService(live *corev1.Service) corev1.Service {
+	svc := corev1.Service{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+		ObjectMeta: cleanMeta(live.ObjectMeta),
+		Spec:       *live.Spec.DeepCopy(),
+	}
+	// Allocated addresses do not carry over; headless stays headless.
+	if svc.Spec.ClusterIP != corev1.ClusterIPNone {
+		svc.Spec.ClusterIP = ""
+		svc.Spec.ClusterIPs = nil
+	}
+	for i := range svc.Spec.Ports {
+		svc.Spec.Ports[i].NodePort = 0
+	}
+	return svc
+}
+
+func exportableSecret(live *corev1.Secret) *corev1.Secret {
+	return &corev1.Secret{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: cleanMeta(live.ObjectMeta),
+		Type:       live.Type,
+		Data:       live.Data,
+	}
+}
+
+func exportableIngress(live *networkingv1.Ingress) *networkingv1.Ingress {
+	return &networkingv1.Ingress{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "networking.k8s.io/v1", Kind: "Ingress"},
+		ObjectMeta: cleanMeta(live.ObjectMeta),
+		Spec:       *live.Spec.DeepCopy(),
+	}
+}
+
+// readBundle loads a bundle from path, or from stdin when path is "-".
+func readBundle(path string) (*bundle, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle: %w", err)
+	}
+	var b bundle
+	if err := yaml.UnmarshalStrict(data, &b); err != nil {
+		return nil, fmt.Errorf("failed to parse bundle %s: %w", path, err)
+	}
+	if b.APIVersion != bundleAPIVersion || b.Kind != bundleKind {
+		return nil, fmt.Errorf("%s is not a kdev bundle (want apiVersion %s, kind %s)", path, bundleAPIVersion, bundleKind)
+	}
+	if b.Pod == nil {
+		return nil, fmt.Errorf("bundle %s has no pod", path)
+	}
+	return &b, nil
+}
+
+// retarget moves every object of the bundle to namespace and renames the
+// environment to name. Objects named after the old environment follow the
+// rename, and ownership is taken over by the importing user.
+func (b *bundle) retarget(namespace, name string) {
+	old := b.Metadata.Name
+	rename := func(n string) string {
+		switch {
+		case name == old:
+		case n == old || strings.HasPrefix(n, old+"-"):
+			return name + strings.TrimPrefix(n, old)
+		case n == sshSecretName(old):
+			return sshSecretName(name)
+		case n == webIDEName(old):
+			return webIDEName(name)
+		}
+		return n
+	}
+	relabel := func(m *metav1.ObjectMeta) {
+		m.Namespace = namespace
+		if m.Labels["app"] != "kdev" {
+			return
+		}
+		if m.Labels["kdev/name"] == old {
+			m.Labels["kdev/name"] = name
+		}
+		if owner := currentOwner(); owner != "" {
+			m.Labels["kdev/owner"] = owner
+		}
+	}
+
+	b.Metadata.Name = name
+	b.Metadata.Namespace = namespace
+	b.Spec.Name = name
+	b.Spec.PVC = rename(b.Spec.PVC)
+
+	relabel(&b.Pod.ObjectMeta)
+	b.Pod.Name = name
+	for i := range b.Pod.Spec.Volumes {
+		if c := b.Pod.Spec.Volumes[i].PersistentVolumeClaim; c != nil {
+			c.ClaimName = rename(c.ClaimName)
+		}
+		if sec := b.Pod.Spec.Volumes[i].Secret; sec != nil {
+			sec.SecretName = rename(sec.SecretName)
+		}
+	}
+	if c := findContainer(b.Pod, webIDEContainer); c != nil {
+		for i := range c.Env {
+			if ref := c.Env[i].ValueFrom; ref != nil && ref.SecretKeyRef != nil {
+				ref.SecretKeyRef.Name = rename(ref.SecretKeyRef.Name)
+			}
+		}
+	}
+	if b.SSHSecret != nil {
+		relabel(&b.SSHSecret.ObjectMeta)
+		b.SSHSecret.Name = rename(b.SSHSecret.Name)
+	}
+	if ing := b.WebIDEIngress; ing != nil {
+		relabel(&ing.ObjectMeta)
+		ing.Name = rename(ing.Name)
+		for _, rule := range ing.Spec.Rules {
+			if rule.HTTP == nil {
+				continue
+			}
+			for i := range rule.HTTP.Paths {
+				if svc := rule.HTTP.Paths[i].Backend.Service; svc != nil {
+					svc.Name = rename(svc.Name)
+				}
+			}
+		}
+	}
+	for i := range b.PVCs {
+		relabel(&b.PVCs[i].ObjectMeta)
+		b.PVCs[i].Name = rename(b.PVCs[i].Name)
+	}
+	if sa := b.Pod.Spec.ServiceAccountName; b.ServiceAccount == nil && sa != "" && sa != "default" {
+		// The exporter could not read it; up would have created it too.
+		b.ServiceAccount = &corev1.ServiceAccount{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+			ObjectMeta: metav1.ObjectMeta{Name: sa},
+		}
+	}
+	if b.ServiceAccount != nil {
+		b.ServiceAccount.Namespace = namespace
+	}
+	for i := range b.Services {
+		svc := &b.Services[i]
+		relabel(&svc.ObjectMeta)
+		svc.Name = rename(svc.Name)
+		if svc.Spec.Selector["kdev/name"] == old {
+			svc.Spec.Selector["kdev/name"] = name
+		}
+	}
+	if b.Pod.Spec.Subdomain != "" {
+		b.Pod.Spec.Subdomain = rename(b.Pod.Spec.Subdomain)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/noopduck/kdev/internal/progress"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Ways kdev code connects VS Code to an environment.
+const (
+	codeViaAuto      = "auto"
+	codeViaSSH       = "ssh"
+	codeViaContainer = "container"
+)
+
+func cmdCode() *cobra.Command {
+	var (
+		via     string
+		folder  string
+		editor  string
+		timeout time.Duration
+	)
+
+	c := &cobra.Command{
+		Use:   "code [NAME]",
+		Short: "Open VS Code on the environment's workspace",
+		Long: `Code makes sure the environment is running, starting or waking it when it
+was stopped or hibernated, and opens VS Code on its working directory.
+
+Environments created with up --ssh are opened through Remote-SSH: code writes
+the environment's entry to ~/.ssh/config, as kdev ssh-config does, and opens
+the folder on that host. Other environments are opened by attaching to the
+container, which needs the Kubernetes and Dev Containers extensions and uses
+the current kubeconfig context. --via picks one explicitly.
+
+--editor runs another VS Code build, such as code-insiders or codium.`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeEnvNameArg,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+			name := ""
+			if len(args) > 0 {
+				name = args[0]
+			}
+			name, err := envNameOrPick(ctx, name)
+			if err != nil {
+				return err
+			}
+			pod, err := runningEnvPod(ctx, name, timeout)
+			if err != nil {
+				return err
+			}
+			if folder == "" {
+				folder = podWorkdir(pod)
+			}
+
+			switch via {
+			case codeViaAuto:
+				via = codeViaContainer
+				if findContainer(pod, sshContainer) != nil {
+					via = codeViaSSH
+				}
+			case codeViaSSH, codeViaContainer:
+			default:
+				return fmt.Errorf("invalid --via %q: must be %s, %s or %s", via, codeViaAuto, codeViaSSH, codeViaContainer)
+			}
+
+			var uri string
+			if via == codeViaSSH {
+				alias, err := writeSSHConfigEntry(ctx, name)
+				if err != nil {
+					return err
+				}
+				uri = "vscode-remote://ssh-remote+" + alias + folder
+			} else {
+				if uri, err = containerFolderURI(pod, folder); err != nil {
+					return err
+				}
+			}
+
+			progress.Infof("Opening %s in %s", folder, editor)
+			code := exec.CommandContext(ctx, editor, "--folder-uri", uri)
+			code.Stdout, code.Stderr = os.Stdout, os.Stderr
+			err = code.Run()
+			if errors.Is(err, exec.ErrNotFound) {
+				return fmt.Errorf("%s not found on your PATH; in VS Code run 'Shell Command: Install code command in PATH', or pass --editor", editor)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to run %s: %w", editor, err)
+			}
+			return nil
+		},
+	}
+
+	c.Flags().StringVar(&via, "via", codeViaAuto, "How VS Code connects: ssh (Remote-SSH), container (attach to the pod) or auto (ssh when the environment has an SSH server)")
+	c.Flags().StringVar(&folder, "folder", "", "Folder to open in the environment (default: its working directory)")
+	c.Flags().StringVar(&editor, "editor", "code", "VS Code command to run")
+	c.Flags().DurationVar(&timeout, "timeout", 5*time.Minute, "How long to wait for the environment to be ready")
+	_ = c.RegisterFlagCompletionFunc("via", cobra.FixedCompletions([]string{codeViaAuto, codeViaSSH, codeViaContainer}, cobra.ShellCompDirectiveNoFileComp))
+	return c
+}
+
+// podWorkdir returns the working directory of the dev container of pod.
+func podWorkdir(pod *corev1.Pod) string {
+	if c := findContainer(pod, devContainer); c != nil && c.WorkingDir != "" {
+		return c.WorkingDir
+	}
+	return "/"
+}
+
+// writeSSHConfigEntry adds or updates the ~/.ssh/config entry of
+// environment name, as kdev ssh-config does, and returns its Host alias.
+func writeSSHConfigEntry(ctx context.Context, name string) (string, error) {
+	file, err := defaultSSHConfigFile()
+	if err != nil {
+		return "", err
+	}
+	h, err := sshConfigHost(ctx, name, "", false)
+	if err != nil {
+		return "", err
+	}
+	if err := saveSSHHosts(file, []sshHost{h}, false); err != nil {
+		return "", err
+	}
+	return h.alias, nil
+}
+
+// containerFolderURI returns the URI under which VS Code's Kubernetes
+// extension attaches to the dev container of pod.
+func containerFolderURI(pod *corev1.Pod, folder string) (string, error) {
+	kubeContext, err := currentKubeContext()
+	if err != nil {
+		return "", err
+	}
+	container, err := podContainer(pod, "")
+	if err != nil {
+		return "", err
+	}
+	parts := []string{
+		"context=" + kubeContext,
+		"podname=" + pod.Name,
+		"namespace=" + pod.Namespace,
+		"name=" + container,
+	}
+	return "vscode-remote://k8s-container+" + strings.Join(parts, "+") + folder, nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/noopduck/kdev/internal/config"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// completionClient lazily connects for shell completion, which skips the
+// regular preflight so a slow or broken cluster never blocks the shell.
+func completionClient() bool {
+	if kubeClient != nil {
+		return true
+	}
+	if err := initKubeClient(); err != nil {
+		return false
+	}
+	flagNamespace, _ = resolveNamespace()
+	return true
+}
+
+func completionContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 3*time.Second)
+}
+
+// completeNamespaces lists the cluster's namespaces.
+func completeNamespaces(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if !completionClient() {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	ctx, cancel := completionContext()
+	defer cancel()
+	list, err := kubeClient.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var names []string
+	for _, ns := range list.Items {
+		if strings.HasPrefix(ns.Name, toComplete) {
+			names = append(names, ns.Name)
+		}
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeContexts lists the contexts of the kubeconfig with their cluster.
+func completeContexts(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	raw, err := loadKubeconfig().RawConfig()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var names []string
+	for _, name := range slices.Sorted(maps.Keys(raw.Contexts)) {
+		if strings.HasPrefix(name, toComplete) {
+			names = append(names, name+"\t"+raw.Contexts[name].Cluster)
+		}
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeEnvNames lists kdev environments in the selected namespace along
+// with their status.
+func completeEnvNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if !completionClient() {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	ctx, cancel := completionContext()
+	defer cancel()
+	pods, err := kubeClient.CoreV1().Pods(flagNamespace).List(ctx, metav1.ListOptions{LabelSelector: "app=kdev"})
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var names []string
+	for _, pod := range pods.Items {
+		if name := envName(&pod); strings.HasPrefix(name, toComplete) {
+			names = append(names, name+"\t"+podStatus(&pod))
+		}
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeEnvNameArg completes the NAME argument of commands that take the
+// environment as their first positional argument.
+func completeEnvNameArg(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return completeEnvNames(cmd, args, toComplete)
+}
+
+// completeContainers lists the containers of the environment named by the
+// first argument or --name.
+func completeContainers(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	name, _ := nameArg(args, "")
+	if f := cmd.Flags().Lookup("name"); name == "" && f != nil {
+		name = f.Value.String()
+	}
+	if name == "" || !completionClient() {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	ctx, cancel := completionContext()
+	defer cancel()
+	pod, err := resolvePod(ctx, flagNamespace, name)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var names []string
+	for _, c := range pod.Spec.Containers {
+		if strings.HasPrefix(c.Name, toComplete) {
+			names = append(names, c.Name+"\t"+c.Image)
+		}
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeHibernatedNames lists environments that kdev wake can restore.
+func completeHibernatedNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if !completionClient() {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	ctx, cancel := completionContext()
+	defer cancel()
+	cms, err := hibernatedEnvs(ctx, flagNamespace)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var names []string
+	for _, cm := range cms {
+		if name := cm.Labels["kdev/name"]; strings.HasPrefix(name, toComplete) {
+			names = append(names, name+"\tHibernated")
+		}
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeEnvFromRefs lists Secrets and ConfigMaps as --env-from values.
+func completeEnvFromRefs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if !completionClient() {
+		return []string{"secret/", "configmap/"}, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+	}
+	ctx, cancel := completionContext()
+	defer cancel()
+	var refs []string
+	if secrets, err := kubeClient.CoreV1().Secrets(flagNamespace).List(ctx, metav1.ListOptions{}); err == nil {
+		for _, s := range secrets.Items {
+			refs = append(refs, "secret/"+s.Name)
+		}
+	}
+	if cms, err := kubeClient.CoreV1().ConfigMaps(flagNamespace).List(ctx, metav1.ListOptions{}); err == nil {
+		for _, cm := range cms.Items {
+			refs = append(refs, "configmap/"+cm.Name)
+		}
+	}
+	return refs, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeSizes lists --size presets with their resources.
+func completeSizes(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	var shared *config.Shared
+	if completionClient() {
+		ctx, cancel := completionContext()
+		defer cancel()
+		shared, _, _ = loadSharedConfig(ctx, flagNamespace)
+	}
+	sizes := availableSizes(shared)
+	var names []string
+	for _, name := range sizeNames(sizes) {
+		names = append(names, name+"\t"+describeSize(sizes[name]))
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveKeepOrder
+}
+
+// completeStorageClasses lists StorageClasses plus the "default" keyword.
+func completeStorageClasses(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	names := []string{clusterDefaultStorageClass + "\tcluster default class"}
+	if !completionClient() {
+		return names, cobra.ShellCompDirectiveNoFileComp
+	}
+	ctx, cancel := completionContext()
+	defer cancel()
+	classes, err := kubeClient.StorageV1().StorageClasses().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return names, cobra.ShellCompDirectiveNoFileComp
+	}
+	for _, sc := range classes.Items {
+		names = append(names, sc.Name+"\t"+sc.Provisioner)
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeYAMLFiles restricts file completion to YAML templates.
+func completeYAMLFiles(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return []string{"yaml", "yml"}, cobra.ShellCompDirectiveFilterFileExt
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/noopduck/kdev/internal/devcontainer"
+	"github.com/noopduck/kdev/internal/progress"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/utils/ptr"
+)
+
+var invalidContainerChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// composeServices returns the services of comp that run next to the dev
+// service: runServices if given, otherwise all of them, sorted.
+func composeServices(comp *devcontainer.Compose, dc *devcontainer.DevContainerConfig) ([]string, error) {
+	names := dc.RunServices
+	if names == nil {
+		for name := range comp.Services {
+			names = append(names, name)
+		}
+	}
+	var services []string
+	for _, name := range names {
+		if _, ok := comp.Services[name]; !ok {
+			return nil, fmt.Errorf("runServices names %q, which is not a compose service", name)
+		}
+		if name != dc.Service && !slices.Contains(services, name) {
+			services = append(services, name)
+		}
+	}
+	sort.Strings(services)
+	return services, nil
+}
+
+// applyCompose adds the other services of a docker-compose devcontainer to
+// the pod of m as containers. Sharing the pod's network, they reach each
+// other on localhost; host aliases keep the compose service names working
+// as host names. The dev service itself is the dev container: its volumes
+// are mounted there, its image and environment were taken by up.
+func applyCompose(m *manifests, comp *devcontainer.Compose, dc *devcontainer.DevContainerConfig) error {
+	project := m.Pod.Name
+	devMounts, err := comp.Services[dc.Service].Mounts(project)
+	if err != nil {
+		return fmt.Errorf("compose service %s: %w", dc.Service, err)
+	}
+	// The source tree bound into the dev service is the workspace PVC here.
+	devMounts = slices.DeleteFunc(devMounts, func(mt devcontainer.Mount) bool {
+		return mt.Type == "bind" && (mt.Target == dc.WorkspaceFolder || strings.HasPrefix(dc.WorkspaceFolder, strings.TrimSuffix(mt.Target, "/")+"/"))
+	})
+	if err := applyMounts(m, devContainer, devMounts, true); err != nil {
+		return err
+	}
+
+	services, err := composeServices(comp, dc)
+	if err != nil {
+		return err
+	}
+	dev := findContainer(m.Pod, devContainer)
+	hosts := []string{dc.Service}
+	listening := map[int]string{}
+	for _, name := range services {
+		svc := comp.Services[name]
+		if svc.Image == "" {
+			return fmt.Errorf("compose service %s builds its own image, which kdev cannot do; push it and set its image", name)
+		}
+		c := corev1.Container{
+			Name:            strings.Trim(invalidContainerChars.ReplaceAllString(strings.ToLower(name), "-"), "-"),
+			Image:           svc.Image,
+			Command:         svc.Entrypoint,
+			Args:            svc.Command,
+			WorkingDir:      svc.WorkingDir,
+			SecurityContext: dev.SecurityContext.DeepCopy(),
+		}
+		if findContainer(m.Pod, c.Name) != nil {
+			return fmt.Errorf("compose service %s clashes with container %s of the pod", name, c.Name)
+		}
+		if svc.User != "" {
+			uid, gid, err := parseUser(svc.User)
+			switch {
+			case err != nil:
+				progress.Warnf("compose service %s: %v; it runs as the pod's user", name, err)
+			case c.SecurityContext == nil:
+				c.SecurityContext = &corev1.SecurityContext{RunAsUser: ptr.To(uid), RunAsGroup: ptr.To(gid)}
+			default:
+				c.SecurityContext.RunAsUser, c.SecurityContext.RunAsGroup = ptr.To(uid), ptr.To(gid)
+			}
+		}
+		keys := make([]string, 0, len(svc.Environment))
+		for k := range svc.Environment {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			c.Env = append(c.Env, corev1.EnvVar{Name: k, Value: svc.Environment[k]})
+		}
+		ports, err := svc.ContainerPorts()
+		if err != nil {
+			return fmt.Errorf("compose service %s: %w", name, err)
+		}
+		for _, p := range ports {
+			if other, ok := listening[p]; ok && other != name {
+				progress.Warnf("compose services %s and %s both use port %d, but share the pod's network", other, name, p)
+			}
+			listening[p] = name
+			c.Ports = append(c.Ports, corev1.ContainerPort{ContainerPort: int32(p), Protocol: corev1.ProtocolTCP})
+		}
+		m.Pod.Spec.Containers = append(m.Pod.Spec.Containers, c)
+
+		mounts, err := svc.Mounts(project)
+		if err != nil {
+			return fmt.Errorf("compose service %s: %w", name, err)
+		}
+		if err := applyMounts(m, c.Name, mounts, true); err != nil {
+			return err
+		}
+		hosts = append(hosts, name)
+	}
+	hosts = slices.DeleteFunc(hosts, func(host string) bool {
+		if len(validation.IsDNS1123Subdomain(host)) == 0 {
+			return false
+		}
+		progress.Warnf("compose service %s is not a valid host name; reach it on localhost", host)
+		return true
+	})
+	if len(hosts) > 0 {
+		m.Pod.Spec.HostAliases = append(m.Pod.Spec.HostAliases, corev1.HostAlias{IP: "127.0.0.1", Hostnames: hosts})
+	}
+	return validatePod(m.Pod)
+}
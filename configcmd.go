@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/noopduck/kdev/internal/config"
+	"github.com/spf13/cobra"
+)
+
+func cmdConfig() *cobra.Command {
+	c := &cobra.Command{
+		Use:         "config",
+		Short:       "Check kdev config files",
+		Annotations: map[string]string{annotationNoCluster: "true"},
+		// Skip loading the user config: lint must work when it is broken.
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error { return applyEnvFlags(cmd) },
+	}
+	c.AddCommand(cmdConfigLint(), cmdConfigSchema())
+	return c
+}
+
+func cmdConfigLint() *cobra.Command {
+	var kind string
+
+	c := &cobra.Command{
+		Use:   "lint [FILE...]",
+		Short: "Validate config files against the kdev schema",
+		Long: `Lint reports unknown fields, wrong types and YAML syntax errors with their
+field path and line, so typos like "memroy:" do not silently fall back to
+defaults. Without arguments the user config file is checked.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			doc, ok := config.DocumentKind(kind)
+			if !ok {
+				return fmt.Errorf("unknown config kind %q (use %s)", kind, documentKinds())
+			}
+			files := args
+			if len(files) == 0 {
+				path, err := config.Path()
+				if err != nil {
+					return err
+				}
+				if !fileExists(path) {
+					fmt.Printf("%s: not found, nothing to lint\n", path)
+					return nil
+				}
+				files = []string{path}
+			}
+
+			failed := 0
+			for _, file := range files {
+				data, err := os.ReadFile(file)
+				if err != nil {
+					return fmt.Errorf("failed to read %s: %w", file, err)
+				}
+				err = config.Validate(file, data, doc.New())
+				var invalid *config.ValidationError
+				switch {
+				case errors.As(err, &invalid):
+					failed++
+					for _, p := range invalid.Problems {
+						fmt.Printf("%s:%s\n", file, p)
+					}
+				case err != nil:
+					return err
+				default:
+					fmt.Printf("%s: ok\n", file)
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d config files are invalid", failed, len(files))
+			}
+			return nil
+		},
+	}
+
+	c.Flags().StringVar(&kind, "kind", "user", "Config kind of the files ("+documentKinds()+")")
+	_ = c.RegisterFlagCompletionFunc("kind", completeDocumentKinds)
+	return c
+}
+
+func cmdConfigSchema() *cobra.Command {
+	var kind string
+
+	c := &cobra.Command{
+		Use:   "schema",
+		Short: "Print the JSON Schema of a config file kind",
+		Long: `Schema prints the JSON Schema kdev validates against. Point your editor at it
+for completion and inline errors, e.g. with a yaml-language-server modeline.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			doc, ok := config.DocumentKind(kind)
+			if !ok {
+				return fmt.Errorf("unknown config kind %q (use %s)", kind, documentKinds())
+			}
+			out, err := json.MarshalIndent(config.Schema(doc.Title, doc.New()), "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+			return nil
+		},
+	}
+
+	c.Flags().StringVar(&kind, "kind", "user", "Config kind ("+documentKinds()+")")
+	_ = c.RegisterFlagCompletionFunc("kind", completeDocumentKinds)
+	return c
+}
+
+func documentKinds() string {
+	var kinds []string
+	for _, d := range config.Documents {
+		kinds = append(kinds, d.Kind)
+	}
+	return strings.Join(kinds, "|")
+}
+
+func completeDocumentKinds(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return strings.Split(documentKinds(), "|"), cobra.ShellCompDirectiveNoFileComp
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/utils/ptr"
+)
+
+// Values of up --controller: what owns the environment's pod.
+const (
+	controllerPod         = "pod"
+	controllerStatefulSet = "statefulset"
+	controllerDeployment  = "deployment"
+)
+
+var controllerKinds = []string{controllerPod, controllerStatefulSet, controllerDeployment}
+
+// envSelector matches every object belonging to environment name.
+func envSelector(name string) map[string]string {
+	return map[string]string{"app": "kdev", "kdev/name": name}
+}
+
+// buildStatefulSet wraps the dev pod in a single-replica StatefulSet. The
+// workspace PVC becomes a volumeClaimTemplate; the StatefulSet controller
+// creates the claim (named work-<name>-0) instead of kdev.
+func buildStatefulSet(m *manifests) *appsv1.StatefulSet {
+	pod := m.Pod
+	serviceName := pod.Name
+	if pod.Spec.Subdomain != "" {
+		// The StatefulSet controller sets the pod's subdomain to this.
+		serviceName = pod.Spec.Subdomain
+	}
+	sts := &appsv1.StatefulSet{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "StatefulSet"},
+		ObjectMeta: workloadMeta(pod),
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:    ptr.To[int32](1),
+			ServiceName: serviceName,
+			Selector:    &metav1.LabelSelector{MatchLabels: envSelector(pod.Name)},
+			Template:    podTemplate(pod),
+		},
+	}
+
+	var volumes []corev1.Volume
+	for _, v := range sts.Spec.Template.Spec.Volumes {
+		if v.PersistentVolumeClaim != nil && v.PersistentVolumeClaim.ClaimName == m.PVC.Name {
+			sts.Spec.VolumeClaimTemplates = append(sts.Spec.VolumeClaimTemplates, corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: v.Name, Labels: m.PVC.Labels},
+				Spec:       m.PVC.Spec,
+			})
+			continue
+		}
+		volumes = append(volumes, v)
+	}
+	sts.Spec.Template.Spec.Volumes = volumes
+	return sts
+}
+
+// buildDeployment wraps the dev pod in a single-replica Deployment that
+// mounts the regular workspace PVC. Recreate avoids two pods fighting over
+// a ReadWriteOnce volume during rollouts.
+func buildDeployment(m *manifests) *appsv1.Deployment {
+	pod := m.Pod
+	return &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: workloadMeta(pod),
+		Spec: appsv1.DeploymentSpec{
+			Replicas: ptr.To[int32](1),
+			Selector: &metav1.LabelSelector{MatchLabels: envSelector(pod.Name)},
+			Strategy: appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType},
+			Template: podTemplate(pod),
+		},
+	}
+}
+
+func workloadMeta(pod *corev1.Pod) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:        pod.Name,
+		Namespace:   pod.Namespace,
+		Labels:      pod.Labels,
+		Annotations: pod.Annotations,
+	}
+}
+
+func podTemplate(pod *corev1.Pod) corev1.PodTemplateSpec {
+	return corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: pod.Labels, Annotations: pod.Annotations},
+		Spec:       *pod.Spec.DeepCopy(),
+	}
+}
+
+// resolvePod returns the pod currently backing environment name: the pod of
+// that name, or else the newest live pod labelled with it, as created by a
+// controller.
+func resolvePod(ctx context.Context, namespace, name string) (*corev1.Pod, error) {
+	pods := kubeClient.CoreV1().Pods(namespace)
+	pod, err := pods.Get(ctx, name, metav1.GetOptions{})
+	if err == nil {
+		return pod, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to get pod: %w", err)
+	}
+
+	list, lerr := pods.List(ctx, metav1.ListOptions{LabelSelector: labels.Set(envSelector(name)).String()})
+	if lerr != nil {
+		return nil, fmt.Errorf("failed to list pods of %s: %w", name, lerr)
+	}
+	var live []corev1.Pod
+	for _, p := range list.Items {
+		if p.DeletionTimestamp == nil {
+			live = append(live, p)
+		}
+	}
+	if len(live) == 0 {
+		if state := envStatusWithoutPod(ctx, namespace, name); state != "" {
+			return nil, fmt.Errorf("environment %s is %s; use 'kdev start %s' to start it: %w", name, strings.ToLower(state), name, err)
+		}
+		return nil, fmt.Errorf("failed to get pod: %w", err)
+	}
+	sort.Slice(live, func(i, j int) bool {
+		if podReady(&live[i]) != podReady(&live[j]) {
+			return podReady(&live[i])
+		}
+		return live[j].CreationTimestamp.Before(&live[i].CreationTimestamp)
+	})
+	return &live[0], nil
+}
+
+// envName is the environment a pod belongs to, which differs from the pod
+// name for controller-managed environments.
+func envName(pod *corev1.Pod) string {
+	if name := pod.Labels["kdev/name"]; name != "" {
+		return name
+	}
+	return pod.Name
+}
+
+// controlledByWorkload reports whether pod is owned by a StatefulSet or,
+// through its ReplicaSet, a Deployment.
+func controlledByWorkload(pod *corev1.Pod) bool {
+	ref := metav1.GetControllerOfNoCopy(pod)
+	return ref != nil && (ref.Kind == "StatefulSet" || ref.Kind == "ReplicaSet")
+}
+
+// envWorkload looks up the StatefulSet or Deployment of environment name.
+// It returns a nil meta and empty kind for plain pod environments.
+func envWorkload(ctx context.Context, namespace, name string) (string, *metav1.ObjectMeta, error) {
+	sts, err := kubeClient.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err == nil {
+		return controllerStatefulSet, &sts.ObjectMeta, nil
+	}
+	if !apierrors.IsNotFound(err) && !apierrors.IsForbidden(err) {
+		return "", nil, fmt.Errorf("failed to get StatefulSet: %w", err)
+	}
+	deploy, err := kubeClient.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err == nil {
+		return controllerDeployment, &deploy.ObjectMeta, nil
+	}
+	if !apierrors.IsNotFound(err) && !apierrors.IsForbidden(err) {
+		return "", nil, fmt.Errorf("failed to get Deployment: %w", err)
+	}
+	return "", nil, nil
+}
+
+// deleteWorkload deletes the controller of kind, letting the garbage
+// collector remove its pods.
+func deleteWorkload(ctx context.Context, namespace, kind, name string) error {
+	opts := metav1.DeleteOptions{PropagationPolicy: ptr.To(metav1.DeletePropagationBackground)}
+	var err error
+	switch kind {
+	case controllerStatefulSet:
+		err = kubeClient.AppsV1().StatefulSets(namespace).Delete(ctx, name, opts)
+	case controllerDeployment:
+		err = kubeClient.AppsV1().Deployments(namespace).Delete(ctx, name, opts)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete %s: %w", workloadKindName(kind), err)
+	}
+	return nil
+}
+
+// workloadKindName returns the API kind for a --controller value.
+func workloadKindName(kind string) string {
+	switch kind {
+	case controllerStatefulSet:
+		return "StatefulSet"
+	case controllerDeployment:
+		return "Deployment"
+	}
+	return kind
+}
+
+// statefulSetClaimName is the PVC the StatefulSet controller creates for the
+// workspace volume of environment name.
+func statefulSetClaimName(name string) string {
+	return "work-" + name + "-0"
+}
+
+// rmWorkload is rm for a controller-managed environment. It returns the
+// name of the PVC it deleted, if any.
+func rmWorkload(ctx context.Context, kind string, meta *metav1.ObjectMeta, deletePVC, noGuard bool) (string, error) {
+	name := meta.Name
+	display := workloadKindName(kind)
+	if !noGuard {
+		if err := guardManaged(display, meta); err != nil {
+			return "", err
+		}
+	}
+	// The workspace is the claim the workload mounts, which --pvc may have
+	// named differently from the environment.
+	var claim string
+	if deletePVC {
+		switch kind {
+		case controllerStatefulSet:
+			claim = statefulSetClaimName(name)
+		case controllerDeployment:
+			d, err := kubeClient.AppsV1().Deployments(flagNamespace).Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return "", fmt.Errorf("failed to get Deployment: %w", err)
+			}
+			_, claim = podImageAndClaim(&d.Spec.Template.Spec)
+		}
+	}
+	claims := kubeClient.CoreV1().PersistentVolumeClaims(flagNamespace)
+	if claim != "" && !noGuard {
+		pvc, err := claims.Get(ctx, claim, metav1.GetOptions{})
+		switch {
+		case apierrors.IsNotFound(err):
+			claim = ""
+		case err != nil:
+			return "", fmt.Errorf("failed to get PVC: %w", err)
+		default:
+			if err := guardManaged("PVC", &pvc.ObjectMeta); err != nil {
+				return "", err
+			}
+		}
+	}
+	if err := deleteWorkload(ctx, flagNamespace, kind, name); err != nil {
+		return "", err
+	}
+	fmt.Printf("%s %s deleted in namespace %s\n", display, name, flagNamespace)
+
+	if claim == "" {
+		return "", nil
+	}
+	err := claims.Delete(ctx, claim, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to delete PVC: %w", err)
+	}
+	fmt.Printf("PVC %s deleted in namespace %s\n", claim, flagNamespace)
+	return claim, nil
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/noopduck/kdev/internal/progress"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+func cmdCP() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "cp SRC DEST",
+		Short: "Copy files between the local machine and an environment",
+		Long: `Cp copies a file or directory into or out of the dev container, streaming a
+tar archive over exec like kubectl cp. One side is local, the other is
+NAME:PATH. The dev image needs tar.
+
+When DEST is an existing directory the source is copied into it under its
+own name; otherwise it is copied to DEST. A source ending in /. (or .)
+copies the contents of the directory rather than the directory itself.
+
+  kdev cp ./src mydev:/workspaces/app
+  kdev cp mydev:/workspaces/app/bin ./bin`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer stop()
+
+			srcEnv, srcPath, srcRemote := splitRemotePath(args[0])
+			dstEnv, dstPath, dstRemote := splitRemotePath(args[1])
+			switch {
+			case srcRemote && dstRemote:
+				return errors.New("copying between two environments is not supported; one side must be local")
+			case !srcRemote && !dstRemote:
+				return errors.New("one of SRC and DEST must be NAME:PATH")
+			case srcRemote:
+				return copyFromEnv(ctx, srcEnv, srcPath, dstPath)
+			default:
+				return copyToEnv(ctx, srcPath, dstEnv, dstPath)
+			}
+		},
+	}
+	return c
+}
+
+// splitRemotePath splits NAME:PATH. Local paths may contain colons too, so
+// arguments starting with a path element, and drive letters on Windows, are
+// always local.
+func splitRemotePath(arg string) (env, p string, remote bool) {
+	if strings.HasPrefix(arg, "/") || strings.HasPrefix(arg, ".") || filepath.IsAbs(arg) {
+		return "", arg, false
+	}
+	name, rest, ok := strings.Cut(arg, ":")
+	if !ok || name == "" || strings.ContainsAny(name, `/\`) {
+		return "", arg, false
+	}
+	if runtime.GOOS == "windows" && len(name) == 1 {
+		return "", arg, false
+	}
+	if rest == "" {
+		rest = "."
+	}
+	return name, rest, true
+}
+
+// readyEnvPod returns the ready pod of environment name.
+func readyEnvPod(ctx context.Context, name string) (string, error) {
+	pod, err := resolvePod(ctx, flagNamespace, name)
+	if err != nil {
+		return "", err
+	}
+	if !podReady(pod) {
+		return "", fmt.Errorf("environment %s is not ready (%s)", name, podStatus(pod))
+	}
+	return pod.Name, nil
+}
+
+// copyToEnv uploads the local file or directory src to dst in environment
+// env.
+func copyToEnv(ctx context.Context, src, env, dst string) error {
+	if _, err := os.Lstat(src); err != nil {
+		return err
+	}
+	pod, err := readyEnvPod(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	dir, name := path.Dir(dst), path.Base(dst)
+	if remoteIsDir(ctx, pod, dst) {
+		dir, name = dst, filepath.Base(filepath.Clean(src))
+	}
+
+	sp := progress.Start(fmt.Sprintf("Copying %s to %s:%s", src, env, path.Join(dir, name)))
+	r, w := io.Pipe()
+	archived := make(chan error, 1)
+	go func() {
+		err := writeTar(w, src, name)
+		w.CloseWithError(err)
+		archived <- err
+	}()
+	var stderr bytes.Buffer
+	err = execStream(ctx, pod, devContainer, []string{"tar", "-xmf", "-", "-C", dir}, remotecommand.StreamOptions{
+		Stdin:  r,
+		Stdout: io.Discard,
+		Stderr: &stderr,
+	})
+	r.Close()
+	// A local read error truncates the archive; report it, not what tar
+	// made of the truncated stream.
+	if werr := <-archived; werr != nil {
+		err = fmt.Errorf("failed to archive %s: %w", src, werr)
+	} else {
+		err = remoteTarError(err, &stderr)
+	}
+	sp.Done(err)
+	return err
+}
+
+// copyFromEnv downloads src from environment env to the local path dst.
+func copyFromEnv(ctx context.Context, env, src, dst string) error {
+	pod, err := readyEnvPod(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	// Like kubectl cp, DIR/. copies the contents of DIR rather than DIR.
+	contentsOf := src == "." || strings.HasSuffix(src, "/.")
+	src = path.Clean(src)
+	dir, name := path.Dir(src), path.Base(src)
+	target := dst
+	if contentsOf {
+		dir, name = src, "."
+	} else if fi, err := os.Stat(dst); err == nil && fi.IsDir() {
+		target = filepath.Join(dst, name)
+	}
+
+	sp := progress.Start(fmt.Sprintf("Copying %s:%s to %s", env, src, target))
+	r, w := io.Pipe()
+	var stderr bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		err := execStream(ctx, pod, devContainer, []string{"tar", "-cf", "-", "-C", dir, name}, remotecommand.StreamOptions{
+			Stdout: w,
+			Stderr: &stderr,
+		})
+		w.CloseWithError(err)
+		done <- err
+	}()
+	err = readTar(r, name, target)
+	r.CloseWithError(err)
+	// A failing remote tar also fails the local read; report the cause.
+	if rerr := <-done; rerr != nil && (err == nil || errors.Is(err, rerr)) {
+		err = remoteTarError(rerr, &stderr)
+	}
+	sp.Done(err)
+	return err
+}
+
+// remoteIsDir reports whether p is a directory in the dev container.
+func remoteIsDir(ctx context.Context, pod, p string) bool {
+	return execStream(ctx, pod, devContainer, []string{"test", "-d", p}, remotecommand.StreamOptions{Stdout: io.Discard}) == nil
+}
+
+// remoteTarError adds what tar printed to a failed remote tar.
+func remoteTarError(err error, stderr *bytes.Buffer) error {
+	if err == nil {
+		return nil
+	}
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return fmt.Errorf("remote tar failed: %s", msg)
+	}
+	var exit *exitCodeError
+	if errors.As(err, &exit) && exit.code == 127 {
+		return errors.New("remote tar failed: tar is not installed in the dev image")
+	}
+	return fmt.Errorf("remote tar failed: %w", err)
+}
+
+// writeTar archives src with its root renamed to name.
+func writeTar(w io.Writer, src, name string) error {
+	tw := tar.NewWriter(w)
+	src = filepath.Clean(src)
+	err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		link := ""
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = path.Join(name, filepath.ToSlash(rel))
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// readTar extracts an archive whose entries are rooted at name into target,
+// which takes the place of name; with name "." every entry is. Entries
+// that would land outside target, and links pointing out of it, are
+// skipped. An archive with nothing to extract is an error.
+func readTar(r io.Reader, name, target string) error {
+	tr := tar.NewReader(r)
+	extracted := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			if extracted == 0 {
+				return fmt.Errorf("nothing was copied: the archive has no entries under %s", name)
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		rel, ok := tarEntryPath(hdr.Name, name)
+		if !ok {
+			continue
+		}
+		if rel != "" && !filepath.IsLocal(filepath.FromSlash(rel)) {
+			progress.Warnf("skipping %s: path leaves the destination", hdr.Name)
+			continue
+		}
+		dest := filepath.Join(target, filepath.FromSlash(rel))
+		extracted++
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(dest, hdr.FileInfo().Mode().Perm()|0o700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, hdr.FileInfo().Mode().Perm())
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			resolved := path.Join(path.Dir(rel), hdr.Linkname)
+			if path.IsAbs(hdr.Linkname) || !filepath.IsLocal(filepath.FromSlash(resolved)) {
+				progress.Warnf("skipping symlink %s -> %s: it points outside the destination", hdr.Name, hdr.Linkname)
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+				return err
+			}
+			_ = os.Remove(dest)
+			if err := os.Symlink(hdr.Linkname, dest); err != nil {
+				return err
+			}
+		default:
+			progress.Warnf("skipping %s: unsupported file type", hdr.Name)
+		}
+	}
+}
+
+// tarEntryPath returns the path of entry relative to the archive root name,
+// stripping a leading / or ./ as tar implementations differ in writing
+// them; "" is the root itself.
+func tarEntryPath(entry, name string) (string, bool) {
+	entry = path.Clean(strings.TrimLeft(entry, "/"))
+	if name == "." {
+		if entry == "." {
+			return "", true
+		}
+		return entry, true
+	}
+	rel, ok := strings.CutPrefix(entry, path.Clean(strings.TrimLeft(name, "/")))
+	if !ok || (rel != "" && !strings.HasPrefix(rel, "/")) {
+		return "", false
+	}
+	return strings.TrimPrefix(rel, "/"), true
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/noopduck/kdev/internal/devcontainer"
+	"github.com/noopduck/kdev/internal/progress"
+	"github.com/spf13/cobra"
+)
+
+func cmdDev() *cobra.Command {
+	var (
+		build    imageFlags
+		size     string
+		envs     []string
+		profile  string
+		noAttach bool
+		timeout  time.Duration
+	)
+
+	c := &cobra.Command{
+		Use:   "dev NAME",
+		Short: "Build and push the devcontainer image, bring the environment up with it and attach",
+		Long: `Dev runs the inner loop in one go: it builds the image of
+.devcontainer/devcontainer.json and pushes it, as kdev devcontainer build
+--push does, then creates environment NAME from the devcontainer with that
+image or updates it to the image, as kdev up --from-devcontainer does, waits
+until it is ready, runs the devcontainer lifecycle commands and attaches a
+shell.
+
+A devcontainer.json with a prebuilt image and no features has nothing to
+build, and the environment uses its image. Without --tag the image is tagged
+from git, so each commit gets its own image; the environment runs it by
+digest, so rebuilding a reused tag rolls out too.`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeEnvNameArg,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, err := nameArg(args, "")
+			if err != nil {
+				return err
+			}
+			if name == "" {
+				return errNameRequired
+			}
+			ctx := cmd.Context()
+			dc, err := devcontainer.Load(devcontainer.DefaultPath)
+			if err != nil {
+				return err
+			}
+
+			upArgs := []string{name, "--from-devcontainer", "--timeout", timeout.String()}
+			if dc.Prebuilt() && len(dc.Features) == 0 {
+				progress.Infof("devcontainer.json uses the prebuilt image %s; nothing to build", dc.Image)
+			} else {
+				imageName, err := build.build(ctx, dc)
+				if err != nil {
+					return err
+				}
+				// The tag is reused across rebuilds of a dirty tree; the
+				// digest changes the pod spec so the new image rolls out.
+				pinned, err := pinImage(ctx, imageName)
+				if err != nil {
+					progress.Warnf("%v; the environment may keep running the previous build of %s", err, imageName)
+					pinned = imageName
+				}
+				upArgs = append(upArgs, "--image", pinned)
+			}
+
+			if size != "" {
+				upArgs = append(upArgs, "--size", size)
+			}
+			if profile != "" {
+				upArgs = append(upArgs, "--profile", profile)
+			}
+			for _, e := range envs {
+				upArgs = append(upArgs, "--env", e)
+			}
+			if noAttach {
+				upArgs = append(upArgs, "--wait")
+			} else {
+				upArgs = append(upArgs, "--open")
+			}
+			return runCommand(ctx, cmdUp(), upArgs...)
+		},
+	}
+
+	build.register(c)
+	c.Flags().StringVar(&size, "size", "", "Resource preset: s (small), m (medium), l (large), xl or one defined in the shared or user config")
+	c.Flags().StringVar(&profile, "profile", "", "Settings bundle from profiles in the user config, e.g. gpu")
+	c.Flags().StringArrayVar(&envs, "env", nil, "Env vars KEY=VALUE (repeatable)")
+	c.Flags().BoolVar(&noAttach, "no-attach", false, "Stop once the environment is ready and its lifecycle commands ran")
+	c.Flags().DurationVar(&timeout, "timeout", 5*time.Minute, "How long to wait for the pod")
+	_ = c.RegisterFlagCompletionFunc("size", completeSizes)
+	_ = c.RegisterFlagCompletionFunc("profile", completeProfiles)
+	return c
+}
+
+// imageFlags are the flags of commands that build and push the devcontainer
+// image on the way.
+type imageFlags struct {
+	image     string
+	registry  string
+	tag       string
+	builder   string
+	inCluster string
+}
+
+func (f *imageFlags) register(c *cobra.Command) {
+	c.Flags().StringVar(&f.image, "image", "", "Image name (can include registry and tag; without a tag it comes from git)")
+	c.Flags().StringVar(&f.registry, "registry", "", "Container registry to push to when --image is not set (default: registry in the user config)")
+	c.Flags().StringVar(&f.tag, "tag", "", "Image tag (default: from git, <branch>-<commit>[-dirty])")
+	c.Flags().StringVar(&f.builder, "builder", "", "Tool that builds the image: "+devcontainer.BuilderNames()+" (default: builder in the user config, then the first installed)")
+	c.Flags().StringVar(&f.inCluster, "in-cluster", "", "Build in the cluster instead of locally: "+strings.Join(slices.Sorted(maps.Keys(inClusterBuilders)), ", "))
+	_ = c.RegisterFlagCompletionFunc("in-cluster", cobra.FixedCompletions(slices.Sorted(maps.Keys(inClusterBuilders)), cobra.ShellCompDirectiveNoFileComp))
+}
+
+// build builds and pushes the image of dc with kdev devcontainer build and
+// returns its name.
+func (f *imageFlags) build(ctx context.Context, dc *devcontainer.DevContainerConfig) (string, error) {
+	imageName, err := devcontainer.ImageName(ctx, dc, f.image, cmp.Or(f.registry, userConfig.Registry), f.tag)
+	if err != nil {
+		return "", err
+	}
+	args := []string{"build", "--image", imageName, "--push"}
+	if f.builder != "" {
+		args = append(args, "--builder", f.builder)
+	}
+	if f.inCluster != "" {
+		args = append(args, "--in-cluster", f.inCluster)
+	}
+	build := devcontainer.CmdDevContainer(inClusterBuilders)
+	build.PersistentFlags().Bool("offline", flagOffline, "")
+	if err := runCommand(ctx, build, args...); err != nil {
+		return "", err
+	}
+	return imageName, nil
+}
+
+// runCommand runs a fresh instance of one of kdev's commands with args, as
+// if they were given on the command line, so a workflow runs each step
+// exactly as the command of its own would.
+func runCommand(ctx context.Context, c *cobra.Command, args ...string) error {
+	c.SetArgs(args)
+	c.SilenceErrors, c.SilenceUsage = true, true
+	return c.ExecuteContext(ctx)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/noopduck/kdev/internal/devcontainer"
+	"github.com/noopduck/kdev/internal/spec"
+	"github.com/spf13/cobra"
+)
+
+// loadDevcontainer reads the devcontainer.json at path and its compose
+// files, and fills user with its image, keepalive and environment. Flags
+// already in user win. It returns the spec fields the devcontainer set.
+func loadDevcontainer(path string, user *spec.Spec, template string) (*devcontainer.DevContainerConfig, *devcontainer.Compose, []string, error) {
+	dc, err := devcontainer.Load(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	comp, err := dc.LoadCompose()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var fromDC []string
+	switch {
+	case user.Image != "":
+	case dc.Prebuilt():
+		user.Image = dc.Image
+		fromDC = append(fromDC, "image")
+	case comp != nil && comp.Services[dc.Service].Image != "":
+		user.Image = comp.Services[dc.Service].Image
+		fromDC = append(fromDC, "image")
+	case comp != nil && template == "":
+		return nil, nil, nil, fmt.Errorf("compose service %s builds its image: build and push it, then pass it with --image", dc.Service)
+	case template == "":
+		return nil, nil, nil, fmt.Errorf("%s builds its image from a Dockerfile: build it with kdev devcontainer build and pass it with --image", path)
+	}
+	// overrideCommand: false runs the image's own ENTRYPOINT and CMD.
+	if dc.OverrideCommand != nil && !*dc.OverrideCommand && user.Keepalive == "" {
+		user.Keepalive = keepaliveEntrypoint
+		fromDC = append(fromDC, "keepalive")
+	}
+	// Flags and env files win over containerEnv, which wins over the
+	// environment of the compose service. env is reported as the
+	// devcontainer's only when no flag set any of it.
+	if len(user.Env) == 0 && (len(dc.ContainerEnv) > 0 || comp != nil && len(comp.Services[dc.Service].Environment) > 0) {
+		fromDC = append(fromDC, "env")
+	}
+	env := []map[string]string{dc.ContainerEnv}
+	if comp != nil {
+		env = append(env, comp.Services[dc.Service].Environment)
+	}
+	for _, layer := range env {
+		for k, v := range layer {
+			if _, ok := user.Env[k]; ok {
+				continue
+			}
+			if user.Env == nil {
+				user.Env = map[string]string{}
+			}
+			user.Env[k] = v
+		}
+	}
+	return dc, comp, fromDC, nil
+}
+
+// applyDevcontainer applies what a devcontainer.json sets beyond the spec
+// to the manifests built from user: its user, lifecycle commands, remoteEnv,
+// mounts, compose services and forwarded ports.
+func applyDevcontainer(ctx context.Context, m *manifests, dc *devcontainer.DevContainerConfig, comp *devcontainer.Compose, user spec.Spec) error {
+	// A pod has a single user, so the one tools run as wins.
+	if u := cmp.Or(dc.RemoteUser, dc.ContainerUser); u != "" && user.User == "" {
+		resolved, err := resolveImageUser(ctx, findContainer(m.Pod, devContainer).Image, u)
+		if err != nil {
+			return err
+		}
+		if err := applySpec(m.Pod, spec.Spec{User: resolved}); err != nil {
+			return err
+		}
+		m.Sources["user"] = sourceDevcontainer
+	}
+	if err := setLifecycle(m.Pod, dc.Lifecycle); err != nil {
+		return err
+	}
+	if err := setRemoteEnv(m.Pod, dc.RemoteEnv); err != nil {
+		return err
+	}
+	if err := applyMounts(m, devContainer, dc.Mounts, false); err != nil {
+		return err
+	}
+	if comp != nil {
+		if err := applyCompose(m, comp, dc); err != nil {
+			return err
+		}
+	}
+	ports, err := dc.Ports()
+	if err != nil {
+		return err
+	}
+	return setForwardPorts(m.Pod, ports)
+}
+
+func cmdDevContainerConvert() *cobra.Command {
+	var (
+		user   spec.Spec
+		dcPath string
+		ctrl   string
+		noProj bool
+	)
+
+	c := &cobra.Command{
+		Use:   "convert [NAME]",
+		Short: "Render devcontainer.json as the Kubernetes manifests kdev up would create",
+		Long: `Convert prints the ServiceAccount, PVCs and pod (or StatefulSet or
+Deployment) that kdev up --from-devcontainer would create, for review or to
+commit to a GitOps repository. It does not contact the cluster: the
+namespace comes from --namespace, the kubeconfig or the user config, and the
+StorageClass is written as given rather than checked. The kdev.yaml of the
+project applies as it does for kdev up. NAME defaults to the name in
+kdev.yaml, then the devcontainer name.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			namespace, _ := resolveNamespace()
+			flagNamespace = namespace
+
+			proj := &project{}
+			if !noProj {
+				var err error
+				if proj, err = findAndLoadProject(cmd.Context()); err != nil {
+					return err
+				}
+			}
+			// The image of kdev.yaml wins over the devcontainer's, as
+			// --image does.
+			imageFromProj := false
+			if user.Image == "" && proj.Image != "" {
+				user.Image, imageFromProj = proj.Image, true
+			}
+			dc, comp, fromDC, err := loadDevcontainer(dcPath, &user, "")
+			if err != nil {
+				return err
+			}
+			if len(args) > 0 {
+				user.Name = args[0]
+			} else {
+				user.Name = cmp.Or(proj.Name, strings.Trim(invalidContainerChars.ReplaceAllString(strings.ToLower(dc.Name), "-"), "-"))
+			}
+			if user.Name == "" {
+				return errNameRequired
+			}
+			if cmd.Flags().Changed("storage-class") && user.StorageClass == "" {
+				user.StorageClass = clusterDefaultStorageClass
+			}
+			if !slices.Contains(controllerKinds, ctrl) {
+				return fmt.Errorf("invalid --controller %q (want one of %s)", ctrl, strings.Join(controllerKinds, ", "))
+			}
+			m, err := buildManifests(namespace, spec.Spec{}, user, proj.Spec, "")
+			if err != nil {
+				return err
+			}
+			for _, f := range fromDC {
+				m.Sources[f] = sourceDevcontainer
+			}
+			if imageFromProj {
+				m.Sources["image"] = sourceProject
+			}
+			if err := applyDevcontainer(cmd.Context(), m, dc, comp, user); err != nil {
+				return err
+			}
+			if err := applyProject(m, proj); err != nil {
+				return err
+			}
+			// finishManifests without the cluster lookups.
+			if sc := ptrValue(m.PVC.Spec.StorageClassName); sc == "" || sc == clusterDefaultStorageClass {
+				m.PVC.Spec.StorageClassName = nil
+			}
+			for _, pvc := range m.Volumes {
+				pvc.Spec.StorageClassName = m.PVC.Spec.StorageClassName
+			}
+			if err := resolvePodImages(&m.Pod.Spec); err != nil {
+				return err
+			}
+			switch ctrl {
+			case controllerStatefulSet:
+				m.StatefulSet = buildStatefulSet(m)
+			case controllerDeployment:
+				m.Deployment = buildDeployment(m)
+			}
+
+			return printManifests(os.Stdout, m, "")
+		},
+	}
+
+	c.Flags().StringVar(&dcPath, "from", devcontainer.DefaultPath, "devcontainer.json to convert")
+	c.Flags().StringVar(&user.Image, "image", "", "Container image (required when devcontainer.json builds one)")
+	c.Flags().StringVar(&user.StorageClass, "storage-class", "", "StorageClass for the PVCs (default: storageClass in the user config, then local-path; \"\" or \"default\" uses the cluster default)")
+	c.Flags().StringVar(&user.StorageSize, "storage", "", "PVC storage size (default: storageSize in the user config, then 20Gi)")
+	c.Flags().StringVar(&user.CPU, "cpu", "", "CPU request/limit, e.g. 500m")
+	c.Flags().StringVar(&user.Memory, "memory", "", "Memory request/limit, e.g. 1Gi")
+	c.Flags().StringVar(&user.User, "user", "", "UID[:GID] the pod runs as (default: the remoteUser/containerUser of devcontainer.json, resolved with the local container engine when it is a name)")
+	c.Flags().BoolVar(&noProj, "no-project", false, "Ignore the kdev.yaml of the project")
+	c.Flags().StringVar(&ctrl, "controller", controllerPod, "What manages the pod: pod, statefulset or deployment")
+	return c
+}
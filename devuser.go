@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/noopduck/kdev/internal/devcontainer"
+)
+
+// idScript prints the UID and GID of the user named by $1.
+const idScript = `id -u "$1" && id -g "$1"`
+
+// resolveImageUser turns the remoteUser or containerUser of a
+// devcontainer.json into "UID:GID" for the pod's security context. Numeric
+// users are taken as is; names are looked up by running the image with the
+// configured builder, as the image's own /etc/passwd is the only place they
+// are defined.
+func resolveImageUser(ctx context.Context, image, user string) (string, error) {
+	if _, _, err := parseUser(user); err == nil {
+		return user, nil
+	}
+	if image == "" {
+		return "", fmt.Errorf("cannot look up user %q without an image; pass --user UID[:GID]", user)
+	}
+	builder, err := devcontainer.ResolveBuilder(configuredBuilder())
+	if err != nil {
+		return "", fmt.Errorf("%v; pass --user UID[:GID] instead of looking up user %q in %s", err, user, image)
+	}
+	cmd, err := builder.RunCmd(ctx, image, "/bin/sh", "-c", idScript, "kdev", user)
+	if err != nil {
+		return "", fmt.Errorf("%v; pass --user UID[:GID] instead of looking up user %q in %s", err, user, image)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to look up user %q in %s: %v: %s", user, image, err, strings.TrimSpace(stderr.String()))
+	}
+	ids := strings.Fields(stdout.String())
+	if len(ids) != 2 {
+		return "", fmt.Errorf("failed to look up user %q in %s: unexpected output %q", user, image, stdout.String())
+	}
+	resolved := ids[0] + ":" + ids[1]
+	if _, _, err := parseUser(resolved); err != nil {
+		return "", fmt.Errorf("user %q of %s: %w", user, image, err)
+	}
+	return resolved, nil
+}
+
+// configuredBuilder is the builder of the user config, "" to detect one.
+func configuredBuilder() string {
+	if userConfig == nil {
+		return ""
+	}
+	return userConfig.Builder
+}
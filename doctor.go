@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/noopduck/kdev/internal/devcontainer"
+	"github.com/spf13/cobra"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// checkStatus is the outcome of a single doctor check.
+type checkStatus int
+
+const (
+	checkPass checkStatus = iota
+	checkWarn
+	checkFail
+	checkSkip
+)
+
+func (s checkStatus) symbol() string {
+	switch s {
+	case checkPass:
+		return "✓"
+	case checkWarn:
+		return "!"
+	case checkFail:
+		return "✗"
+	default:
+		return "-"
+	}
+}
+
+// checkResult is what a doctor check reports: what it found and, when it did
+// not pass, how to fix it.
+type checkResult struct {
+	status checkStatus
+	detail string
+	fix    string
+}
+
+func pass(format string, args ...interface{}) checkResult {
+	return checkResult{status: checkPass, detail: fmt.Sprintf(format, args...)}
+}
+
+func warn(detail, fix string) checkResult {
+	return checkResult{status: checkWarn, detail: detail, fix: fix}
+}
+
+func fail(detail, fix string) checkResult {
+	return checkResult{status: checkFail, detail: detail, fix: fix}
+}
+
+// failFromError turns an error into a failed check, reusing the hint of a
+// diagnosed kubeError as remediation.
+func failFromError(err error, fix string) checkResult {
+	var ke *kubeError
+	if errors.As(diagnoseKubeError(err), &ke) {
+		return fail(ke.err.Error(), ke.hint)
+	}
+	return fail(err.Error(), fix)
+}
+
+// doctorCheck is a named check. Checks needing the cluster are skipped when
+// no client could be created.
+type doctorCheck struct {
+	name         string
+	needsCluster bool
+	run          func(ctx context.Context) checkResult
+}
+
+func cmdDoctor() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check kubeconfig, cluster access, permissions and local tools",
+		Long: `Doctor runs a series of checks and prints pass/fail with a remediation for
+each. Please include its output when reporting bugs.`,
+		Annotations: map[string]string{annotationNoCluster: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			failed := 0
+			clusterOK := false
+			for _, chk := range doctorChecks(&clusterOK) {
+				var res checkResult
+				if chk.needsCluster && !clusterOK {
+					res = checkResult{status: checkSkip, detail: "skipped, cluster not reachable"}
+				} else {
+					res = chk.run(ctx)
+				}
+				fmt.Printf("%s %-22s %s\n", res.status.symbol(), chk.name, res.detail)
+				if res.fix != "" {
+					fmt.Printf("  %-22s fix: %s\n", "", res.fix)
+				}
+				if res.status == checkFail {
+					failed++
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d check(s) failed", failed)
+			}
+			return nil
+		},
+	}
+	return c
+}
+
+func doctorChecks(clusterOK *bool) []doctorCheck {
+	return []doctorCheck{
+		{name: "kubeconfig", run: func(ctx context.Context) checkResult {
+			if err := initKubeClient(); err != nil {
+				return failFromError(err, "fix the kubeconfig file or point KUBECONFIG at a valid one")
+			}
+			if _, err := kubeConfig.RawConfig(); err != nil {
+				return failFromError(err, "fix the kubeconfig file")
+			}
+			flagNamespace, _ = resolveNamespace()
+			name, _ := currentContext()
+			return pass("context %q, server %s", name, kubeRestConfig.Host)
+		}},
+		{name: "cluster reachable", run: func(ctx context.Context) checkResult {
+			if kubeClient == nil {
+				return checkResult{status: checkSkip, detail: "skipped, no kubeconfig"}
+			}
+			if err := preflight(); err != nil {
+				return failFromError(err, "")
+			}
+			*clusterOK = true
+			return pass("Kubernetes v%s", serverVersion)
+		}},
+		{name: "version skew", needsCluster: true, run: func(ctx context.Context) checkResult {
+			if msg := versionSkew(serverVersion); msg != "" {
+				return warn(msg, "keep kdev within one minor release of the cluster")
+			}
+			var missing []string
+			for _, f := range []clusterFeature{featureEphemeralContainers, featureVolumeSnapshots} {
+				if requireFeature(f) != nil {
+					missing = append(missing, f.name)
+				}
+			}
+			if len(missing) > 0 {
+				return warn("cluster lacks "+strings.Join(missing, ", "), "ask your cluster admin to enable them; kdev itself works without them")
+			}
+			return pass("client v%s, cluster v%s", clientKubeVersion(), serverVersion)
+		}},
+		{name: "namespace", needsCluster: true, run: func(ctx context.Context) checkResult {
+			_, err := kubeClient.CoreV1().Namespaces().Get(ctx, flagNamespace, metav1.GetOptions{})
+			switch {
+			case err == nil:
+				return pass("%s exists", flagNamespace)
+			case apierrors.IsNotFound(err):
+				return fail(fmt.Sprintf("%s does not exist", flagNamespace), "kubectl create namespace "+flagNamespace+" (or pass -n)")
+			case apierrors.IsForbidden(err):
+				return warn(fmt.Sprintf("cannot verify %s (no permission to read namespaces)", flagNamespace), "")
+			default:
+				return failFromError(err, "")
+			}
+		}},
+		{name: "permissions", needsCluster: true, run: checkPermissions},
+		{name: "storage class", needsCluster: true, run: func(ctx context.Context) checkResult {
+			classes, err := kubeClient.StorageV1().StorageClasses().List(ctx, metav1.ListOptions{})
+			if apierrors.IsForbidden(err) {
+				return warn("cannot list StorageClasses", "pass an existing class with --storage-class")
+			}
+			if err != nil {
+				return failFromError(err, "")
+			}
+			def := defaultStorageClass(classes.Items)
+			want := "local-path"
+			if userConfig != nil && userConfig.StorageClass != "" {
+				want = userConfig.StorageClass
+			}
+			if want == "default" && def != "" {
+				return pass("cluster default %s available (all: %s)", def, describeStorageClasses(classes.Items))
+			}
+			for _, sc := range classes.Items {
+				if sc.Name == want {
+					return pass("%s available (all: %s)", want, describeStorageClasses(classes.Items))
+				}
+			}
+			if def != "" {
+				return warn(fmt.Sprintf("kdev's default %s is missing; cluster default is %s", want, def), "set storageClass: default in the user config or pass --storage-class "+def)
+			}
+			return fail("no usable StorageClass (available: "+describeStorageClasses(classes.Items)+")", "install a provisioner or mark a StorageClass as default")
+		}},
+		{name: "metrics-server", needsCluster: true, run: func(ctx context.Context) checkResult {
+			groups, err := kubeClient.Discovery().ServerGroups()
+			if err != nil {
+				return failFromError(err, "")
+			}
+			for _, g := range groups.Groups {
+				if g.Name == "metrics.k8s.io" {
+					return pass("metrics.k8s.io served")
+				}
+			}
+			return warn("metrics.k8s.io not served; resource usage is unavailable", "install metrics-server")
+		}},
+		{name: "image builder", run: func(ctx context.Context) checkResult {
+			builder, err := devcontainer.ResolveBuilder(configuredBuilder())
+			if err != nil {
+				return warn(err.Error(), "needed by kdev devcontainer build; install Docker, Podman, nerdctl or Buildah, or use --image with a prebuilt image")
+			}
+			return checkTool(ctx, string(builder), "", "--version")
+		}},
+		{name: "devcontainer CLI", run: func(ctx context.Context) checkResult {
+			return checkTool(ctx, "devcontainer", "only needed with --use-devcontainers-cli: npm install -g @devcontainers/cli", "--version")
+		}},
+	}
+}
+
+// checkPermissions asks the API server whether the current user may perform
+// the operations kdev needs in the namespace.
+func checkPermissions(ctx context.Context) checkResult {
+	needed := []authorizationv1.ResourceAttributes{
+		{Verb: "create", Resource: "pods"},
+		{Verb: "list", Resource: "pods"},
+		{Verb: "delete", Resource: "pods"},
+		{Verb: "create", Resource: "pods", Subresource: "exec"},
+		{Verb: "create", Resource: "persistentvolumeclaims"},
+		{Verb: "delete", Resource: "persistentvolumeclaims"},
+		{Verb: "get", Resource: "serviceaccounts"},
+		{Verb: "create", Resource: "serviceaccounts"},
+	}
+	var denied []string
+	for _, attr := range needed {
+		attr.Namespace = flagNamespace
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{ResourceAttributes: &attr},
+		}
+		res, err := kubeClient.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+		if err != nil {
+			return failFromError(err, "")
+		}
+		if !res.Status.Allowed {
+			what := attr.Resource
+			if attr.Subresource != "" {
+				what += "/" + attr.Subresource
+			}
+			denied = append(denied, attr.Verb+" "+what)
+		}
+	}
+	if len(denied) > 0 {
+		return fail("denied in "+flagNamespace+": "+strings.Join(denied, ", "), "ask your cluster admin for a Role granting these verbs")
+	}
+	return pass("can manage pods, PVCs and ServiceAccounts in %s", flagNamespace)
+}
+
+// checkTool verifies that a local binary is installed and runs. Local tools
+// are only needed for some commands, so problems are warnings.
+func checkTool(ctx context.Context, name, fix string, versionArgs ...string) checkResult {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return warn(name+" not found in PATH", fix)
+	}
+	out, err := exec.CommandContext(ctx, path, versionArgs...).Output()
+	if err != nil {
+		return warn(fmt.Sprintf("%s found at %s but failed to run: %v", name, path, err), fix)
+	}
+	return pass("%s (%s)", strings.TrimSpace(string(out)), path)
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/noopduck/kdev/internal/progress"
+	"github.com/noopduck/kdev/internal/spec"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const editHeader = `# Edit the kdev spec of %s. Lines starting with '#' are ignored.
+# Image and label changes are applied in place; other changes recreate the
+# pod. The workspace PVC is always kept. Save an unchanged file to abort.
+`
+
+func cmdEdit() *cobra.Command {
+	var (
+		name    string
+		yes     bool
+		timeout time.Duration
+	)
+
+	c := &cobra.Command{
+		Use:               "edit [NAME]",
+		Short:             "Edit a running environment's kdev spec in $EDITOR",
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeEnvNameArg,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+			name, err := nameArg(args, name)
+			if err != nil {
+				return err
+			}
+			if name, err = envNameOrPick(ctx, name); err != nil {
+				return err
+			}
+
+			pod, err := kubeClient.CoreV1().Pods(flagNamespace).Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return fmt.Errorf("failed to get pod: %w", err)
+			}
+			if err := guardManaged("pod", &pod.ObjectMeta); err != nil {
+				return err
+			}
+			var pvc *corev1.PersistentVolumeClaim
+			if claim := specFromPod(pod, nil).PVC; claim != "" {
+				pvc, err = kubeClient.CoreV1().PersistentVolumeClaims(flagNamespace).Get(ctx, claim, metav1.GetOptions{})
+				if err != nil {
+					return fmt.Errorf("failed to get PVC: %w", err)
+				}
+			}
+
+			cur := specFromPod(pod, pvc)
+			before, err := yaml.Marshal(cur)
+			if err != nil {
+				return err
+			}
+			edited, after, err := editSpec(name, before)
+			if err != nil {
+				return err
+			}
+			if after == string(before) {
+				fmt.Println("Edit cancelled, no changes made.")
+				return nil
+			}
+
+			printDiff(os.Stdout, string(before), after)
+			if !yes {
+				ok, err := confirm("Apply these changes?")
+				if err != nil {
+					return fmt.Errorf("%w; pass --yes to apply without asking", err)
+				}
+				if !ok {
+					fmt.Println("Edit cancelled, no changes made.")
+					return nil
+				}
+			}
+			return applySpecChange(ctx, pod, pvc, cur, edited, timeout)
+		},
+	}
+
+	c.Flags().StringVar(&name, "name", "", "Environment name (picked interactively when omitted)")
+	c.Flags().BoolVarP(&yes, "yes", "y", false, "Apply without asking for confirmation")
+	c.Flags().DurationVar(&timeout, "timeout", 2*time.Minute, "How long to wait for the old pod to terminate when recreating")
+	deprecateNameFlag(c)
+	return c
+}
+
+// editSpec opens the spec in the user's editor until it parses, returning
+// the parsed spec and its normalized YAML.
+func editSpec(name string, current []byte) (spec.Spec, string, error) {
+	f, err := os.CreateTemp("", "kdev-edit-*.yaml")
+	if err != nil {
+		return spec.Spec{}, "", err
+	}
+	defer os.Remove(f.Name())
+
+	content := append([]byte(fmt.Sprintf(editHeader, name)), current...)
+	for {
+		if err := os.WriteFile(f.Name(), content, 0o600); err != nil {
+			return spec.Spec{}, "", err
+		}
+		if err := runEditor(f.Name()); err != nil {
+			return spec.Spec{}, "", err
+		}
+		raw, err := os.ReadFile(f.Name())
+		if err != nil {
+			return spec.Spec{}, "", err
+		}
+		if bytes.Equal(raw, content) {
+			return spec.Spec{}, string(current), nil
+		}
+
+		var edited spec.Spec
+		perr := yaml.UnmarshalStrict(stripComments(raw), &edited)
+		if perr == nil {
+			normalized, err := yaml.Marshal(edited)
+			return edited, string(normalized), err
+		}
+		// Reopen with the error on top, like kubectl edit.
+		content = append([]byte(fmt.Sprintf("# ERROR: %v\n", perr)), stripComments(raw)...)
+		content = append([]byte(fmt.Sprintf(editHeader, name)), content...)
+	}
+}
+
+func runEditor(path string) error {
+	editor := os.Getenv("KUBE_EDITOR")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+		if runtime.GOOS == "windows" {
+			editor = "notepad"
+		}
+	}
+	// EDITOR may carry arguments, e.g. "code --wait".
+	parts := strings.Fields(editor)
+	cmd := exec.Command(parts[0], append(parts[1:], path)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editor %q failed: %w", editor, err)
+	}
+	return nil
+}
+
+func stripComments(raw []byte) []byte {
+	var out bytes.Buffer
+	for _, line := range strings.Split(string(raw), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
+
+// applySpecChange converges a live environment from spec cur to spec want.
+// Image, label and annotation changes are patched in place; anything else immutable on a
+// pod recreates it. The PVC is only ever grown, never replaced.
+func applySpecChange(ctx context.Context, pod *corev1.Pod, pvc *corev1.PersistentVolumeClaim, cur, want spec.Spec, timeout time.Duration) error {
+	switch {
+	case want.Name != cur.Name:
+		return errors.New("changing the name is not supported here; use kdev rename")
+	case want.PVC != cur.PVC:
+		return errors.New("changing the PVC is not supported; use kdev rename --clone-pvc")
+	case want.StorageClass != cur.StorageClass:
+		return errors.New("the StorageClass of an existing PVC cannot be changed")
+	}
+
+	if want.StorageSize != cur.StorageSize && pvc != nil {
+		if err := growPVC(ctx, pvc, want.StorageSize); err != nil {
+			return err
+		}
+	}
+
+	desired := recreatablePod(pod)
+	unsetRemoved(desired, cur, want)
+	desired.Spec.ImagePullSecrets = nil
+	if c := findContainer(desired, devContainer); c != nil {
+		c.EnvFrom = nil
+	}
+	if err := applySpec(desired, want); err != nil {
+		return err
+	}
+	if want.Timezone != cur.Timezone {
+		if err := syncZoneinfo(ctx, desired, want.Timezone); err != nil {
+			return err
+		}
+	}
+
+	// Only image, labels and annotations (which record the shell) are
+	// mutable on a running pod.
+	inPlace := cur
+	inPlace.Image, inPlace.Labels, inPlace.Annotations, inPlace.Shell = want.Image, want.Labels, want.Annotations, want.Shell
+	inPlace.StorageSize = want.StorageSize
+	pods := kubeClient.CoreV1().Pods(pod.Namespace)
+	if reflect.DeepEqual(inPlace, want) {
+		live := pod.DeepCopy()
+		live.Labels, live.Annotations = desired.Labels, desired.Annotations
+		if c := findContainer(live, devContainer); c != nil && want.Image != "" {
+			c.Image = want.Image
+		}
+		if _, err := pods.Update(ctx, live, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to update pod: %w", err)
+		}
+		fmt.Printf("Pod %s updated in place\n", pod.Name)
+		return nil
+	}
+
+	if err := validatePod(desired); err != nil {
+		return err
+	}
+	if err := pods.Delete(ctx, pod.Name, metav1.DeleteOptions{}); err != nil {
+		return fmt.Errorf("failed to delete pod: %w", err)
+	}
+	sp := progress.Start(fmt.Sprintf("Recreating pod %s (PVC kept)", pod.Name))
+	err := waitForPodDeleted(ctx, pod.Namespace, pod.Name, timeout)
+	sp.Done(err)
+	if err != nil {
+		return err
+	}
+	if desired.Spec.Subdomain != "" {
+		if err := ensureHeadlessService(ctx, headlessService(desired.Namespace, desired.Spec.Subdomain)); err != nil {
+			return err
+		}
+	}
+	if err := createPod(ctx, desired); err != nil {
+		return fmt.Errorf("failed to recreate pod: %w", err)
+	}
+	fmt.Printf("Pod %s recreated\n", pod.Name)
+	return nil
+}
+
+// unsetRemoved drops map entries and resources that were present in cur but
+// are gone from want, since applySpec only ever sets values.
+func unsetRemoved(pod *corev1.Pod, cur, want spec.Spec) {
+	for k := range cur.Labels {
+		if _, ok := want.Labels[k]; !ok {
+			delete(pod.Labels, k)
+		}
+	}
+	for k := range cur.Annotations {
+		if _, ok := want.Annotations[k]; !ok {
+			delete(pod.Annotations, k)
+		}
+	}
+	for k := range cur.NodeSelector {
+		if _, ok := want.NodeSelector[k]; !ok {
+			delete(pod.Spec.NodeSelector, k)
+		}
+	}
+	if cur.Hostname != "" && want.Hostname == "" {
+		pod.Spec.Hostname = ""
+	}
+	if cur.Shell != "" && want.Shell == "" {
+		delete(pod.Annotations, shellAnnotation)
+	}
+	if cur.Keepalive != "" && want.Keepalive == "" {
+		delete(pod.Annotations, keepaliveAnnotation)
+		if c := findContainer(pod, devContainer); c != nil {
+			applyKeepalive(pod, c, keepaliveAuto)
+		}
+	}
+	if cur.Subdomain != "" && want.Subdomain == "" {
+		pod.Spec.Subdomain = ""
+		delete(pod.Labels, subdomainLabel)
+	}
+	c := findContainer(pod, devContainer)
+	if c == nil {
+		return
+	}
+	var env []corev1.EnvVar
+	for _, e := range c.Env {
+		if _, was := cur.Env[e.Name]; was {
+			if _, still := want.Env[e.Name]; !still {
+				continue
+			}
+		}
+		if (e.Name == "TZ" && want.Timezone == "") || (e.Name == "LANG" && want.Locale == "") {
+			continue
+		}
+		env = append(env, e)
+	}
+	c.Env = env
+	if cur.CPU != "" && want.CPU == "" {
+		delete(c.Resources.Requests, corev1.ResourceCPU)
+		delete(c.Resources.Limits, corev1.ResourceCPU)
+	}
+	if cur.Memory != "" && want.Memory == "" {
+		delete(c.Resources.Requests, corev1.ResourceMemory)
+		delete(c.Resources.Limits, corev1.ResourceMemory)
+	}
+	for name := range cur.ExtraResources {
+		if _, ok := want.ExtraResources[name]; !ok {
+			delete(c.Resources.Requests, corev1.ResourceName(name))
+			delete(c.Resources.Limits, corev1.ResourceName(name))
+		}
+	}
+	for _, t := range cur.Tolerations {
+		if !slices.Contains(want.Tolerations, t) {
+			pod.Spec.Tolerations = slices.DeleteFunc(pod.Spec.Tolerations, func(o corev1.Toleration) bool { return o.TolerationSeconds == nil && formatToleration(o) == t })
+		}
+	}
+}
+
+// growPVC requests a larger volume. Kubernetes cannot shrink PVCs.
+func growPVC(ctx context.Context, pvc *corev1.PersistentVolumeClaim, size string) error {
+	want, err := resource.ParseQuantity(size)
+	if err != nil {
+		return fmt.Errorf("invalid storage size %q: %w", size, err)
+	}
+	have := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	if want.Cmp(have) < 0 {
+		return fmt.Errorf("PVC %s cannot shrink from %s to %s", pvc.Name, have.String(), size)
+	}
+	pvc = pvc.DeepCopy()
+	pvc.Spec.Resources.Requests[corev1.ResourceStorage] = want
+	if _, err := kubeClient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Update(ctx, pvc, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to resize PVC %s (does its StorageClass allow volume expansion?): %w", pvc.Name, err)
+	}
+	fmt.Printf("PVC %s resized to %s\n", pvc.Name, size)
+	return nil
+}
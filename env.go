@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// envPrefix starts the environment variables that set flags, e.g.
+// KDEV_NAMESPACE for --namespace.
+const envPrefix = "KDEV_"
+
+// envFlags are the flags that can be set from the environment: the global
+// ones and the common settings of CI pipelines and shell profiles. Flags
+// that select, delete or skip confirmations, like --all, --delete and
+// --yes, are deliberately not among them.
+var envFlags = []string{
+	"namespace", "context", "kubeconfig", "offline", "quiet",
+	"image", "registry", "builder", "in-cluster", "tag",
+	"service-account", "storage-class", "storage", "shell", "keepalive",
+	"size", "profile", "controller", "timeout",
+}
+
+// flagEnvVar returns the environment variable of the flag name.
+func flagEnvVar(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// applyEnvFlags sets the envFlags of cmd that are not on the command line
+// from their KDEV_* environment variables. Flags win over the environment,
+// which wins over the user config, as the flag itself does.
+func applyEnvFlags(cmd *cobra.Command) error {
+	var errs []error
+	for _, name := range envFlags {
+		f := cmd.Flags().Lookup(name)
+		if f == nil || f.Changed {
+			continue
+		}
+		v, ok := os.LookupEnv(flagEnvVar(name))
+		if !ok {
+			continue
+		}
+		if err := cmd.Flags().Set(name, v); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %w", flagEnvVar(name), err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// readEnvFiles parses dotenv files in order; later files override earlier
+// ones.
+func readEnvFiles(paths []string) (map[string]string, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	env := map[string]string{}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read env file: %w", err)
+		}
+		err = parseEnvFile(f, env)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s:%w", path, err)
+		}
+	}
+	return env, nil
+}
+
+// parseEnvFile reads KEY=VALUE lines into env. It accepts the common dotenv
+// dialect: blank lines, # comments, an optional "export " prefix, and
+// single-quoted (literal) or double-quoted (with \n, \t, \" and \\ escapes)
+// values. Unquoted values end at " #".
+func parseEnvFile(f *os.File, env map[string]string) error {
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	n := 0
+	for sc.Scan() {
+		n++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		k, v, ok := strings.Cut(line, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			return fmt.Errorf("%d: expected KEY=VALUE, got %q", n, line)
+		}
+		v, err := unquoteEnvValue(strings.TrimSpace(v))
+		if err != nil {
+			return fmt.Errorf("%d: %s: %w", n, k, err)
+		}
+		env[k] = v
+	}
+	return sc.Err()
+}
+
+func unquoteEnvValue(v string) (string, error) {
+	if v == "" {
+		return "", nil
+	}
+	switch q := v[0]; q {
+	case '\'', '"':
+		end := strings.LastIndexByte(v, q)
+		if end == 0 {
+			return "", fmt.Errorf("unterminated %c quote", q)
+		}
+		if rest := strings.TrimSpace(v[end+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", fmt.Errorf("unexpected %q after closing quote", rest)
+		}
+		body := v[1:end]
+		if q == '\'' {
+			return body, nil
+		}
+		return strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\"`, `"`, `\\`, `\`).Replace(body), nil
+	}
+	if i := strings.Index(v, " #"); i >= 0 {
+		v = strings.TrimSpace(v[:i])
+	}
+	return v, nil
+}
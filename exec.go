@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	kubeScheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+func cmdExec() *cobra.Command {
+	var (
+		tty       bool
+		stdin     bool
+		container string
+	)
+
+	c := &cobra.Command{
+		Use:   "exec NAME [--] COMMAND [ARGS...]",
+		Short: "Run a command in an environment's dev container",
+		Long: `Exec runs a single command in the running dev container and exits with its
+exit code. A TTY is allocated when stdin and stdout are terminals, so
+interactive tools work; piped input is forwarded to the command:
+
+  kdev exec mydev -- go test ./...
+  tar c . | kdev exec mydev -- tar x -C /workspaces/app`,
+		Args:              cobra.MinimumNArgs(2),
+		ValidArgsFunction: completeEnvNameArg,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if dash := cmd.ArgsLenAtDash(); dash > 1 {
+				return errors.New("expected exactly one environment name before --")
+			}
+			ctx := context.Background()
+			pod, err := resolvePod(ctx, flagNamespace, args[0])
+			if err != nil {
+				return err
+			}
+			if !podReady(pod) {
+				return fmt.Errorf("environment %s is not ready (%s)", args[0], podStatus(pod))
+			}
+
+			interactive := term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd()))
+			if !cmd.Flags().Changed("tty") {
+				tty = interactive
+			}
+			if !cmd.Flags().Changed("stdin") {
+				// A terminal is only read from with a TTY; anything else is input.
+				stdin = tty || !term.IsTerminal(int(os.Stdin.Fd()))
+			}
+			name, err := podContainer(pod, container)
+			if err != nil {
+				return err
+			}
+			return execInPod(ctx, pod.Name, name, withRemoteEnv(pod, name, args[1:]), os.Stdout, tty, stdin)
+		},
+	}
+
+	// Flags after NAME belong to the command, as with kubectl exec.
+	c.Flags().SetInterspersed(false)
+	c.Flags().BoolVarP(&tty, "tty", "t", false, "Allocate a TTY (default: when stdin and stdout are terminals)")
+	c.Flags().BoolVarP(&stdin, "stdin", "i", false, "Forward stdin to the command (default: with a TTY or when stdin is piped)")
+	c.Flags().StringVarP(&container, "container", "c", "", containerFlagUsage)
+	_ = c.RegisterFlagCompletionFunc("container", completeContainers)
+	return c
+}
+
+// defaultContainerAnnotation names the container kubectl uses when none is
+// given.
+const defaultContainerAnnotation = "kubectl.kubernetes.io/default-container"
+
+// containerFlagUsage describes -c for the commands that run something in a
+// container.
+const containerFlagUsage = "Container to use (default: dev, then the pod's kubectl.kubernetes.io/default-container, then the first container)"
+
+// podContainer checks that pod has the requested container or, when none
+// is requested, picks the dev container, the container named by the
+// default-container annotation, or the first one, in that order.
+func podContainer(pod *corev1.Pod, requested string) (string, error) {
+	var names []string
+	for _, c := range pod.Spec.Containers {
+		names = append(names, c.Name)
+	}
+	for _, c := range pod.Spec.EphemeralContainers {
+		names = append(names, c.Name)
+	}
+	if requested != "" {
+		if !slices.Contains(names, requested) {
+			return "", fmt.Errorf("pod %s has no container %q (containers: %s)", pod.Name, requested, strings.Join(names, ", "))
+		}
+		return requested, nil
+	}
+	if findContainer(pod, devContainer) != nil {
+		return devContainer, nil
+	}
+	if def := pod.Annotations[defaultContainerAnnotation]; def != "" && slices.Contains(names, def) {
+		return def, nil
+	}
+	if len(pod.Spec.Containers) == 0 {
+		return "", fmt.Errorf("pod %s has no containers", pod.Name)
+	}
+	return pod.Spec.Containers[0].Name, nil
+}
+
+// execInPod runs command in container of podName, connected to the
+// local stdin and stderr and to stdout. With tty the local terminal is
+// switched to raw mode and its size is passed on; without it stdout and
+// stderr stay separate. The session is recorded when the user config asks
+// for it.
+func execInPod(ctx context.Context, podName, container string, command []string, stdout io.Writer, tty, stdin bool) error {
+	opts := remotecommand.StreamOptions{Stdout: stdout, Tty: tty}
+	if stdin {
+		opts.Stdin = os.Stdin
+	}
+	if !tty {
+		opts.Stderr = os.Stderr
+	}
+	rec, err := startRecording(podName, container, command, tty)
+	if err != nil {
+		return err
+	}
+	if rec != nil {
+		defer finishRecording(rec)
+		opts.Stdout = io.MultiWriter(opts.Stdout, rec.Output())
+		if opts.Stderr != nil {
+			opts.Stderr = io.MultiWriter(opts.Stderr, rec.Output())
+		}
+		if opts.Stdin != nil && userConfig.Recording.Input {
+			opts.Stdin = io.TeeReader(opts.Stdin, rec.Input())
+		}
+	}
+	if tty {
+		local, err := rawTerminal()
+		if err != nil {
+			return fmt.Errorf("failed to set up terminal: %w", err)
+		}
+		if local != nil {
+			defer local.Restore()
+			opts.TerminalSizeQueue = local
+		}
+	}
+	return execStream(ctx, podName, container, command, opts)
+}
+
+// execStream runs command in container of podName with the streams in
+// opts. A non-zero exit status is returned as an *exitCodeError. Like
+// kubectl it speaks WebSocket, whose heartbeat notices a dead connection,
+// and falls back to SPDY for API servers that do not support it.
+func execStream(ctx context.Context, podName, container string, command []string, opts remotecommand.StreamOptions) error {
+	req := kubeClient.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Name(podName).
+		Namespace(flagNamespace).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdin:     opts.Stdin != nil,
+			Stdout:    opts.Stdout != nil,
+			Stderr:    opts.Stderr != nil,
+			TTY:       opts.Tty,
+		}, kubeScheme.ParameterCodec)
+
+	ws, err := remotecommand.NewWebSocketExecutor(kubeRestConfig, "GET", req.URL().String())
+	if err != nil {
+		return fmt.Errorf("failed to create executor: %w", err)
+	}
+	legacy, err := remotecommand.NewSPDYExecutor(kubeRestConfig, "POST", req.URL())
+	if err != nil {
+		return fmt.Errorf("failed to create executor: %w", err)
+	}
+	exec, err := remotecommand.NewFallbackExecutor(ws, legacy, func(err error) bool {
+		return httpstream.IsUpgradeFailure(err) || httpstream.IsHTTPSProxyError(err)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create executor: %w", err)
+	}
+	err = exec.StreamWithContext(ctx, opts)
+	var exit utilexec.CodeExitError
+	if errors.As(err, &exit) {
+		return &exitCodeError{code: exit.Code}
+	}
+	return err
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+func cmdExport() *cobra.Command {
+	var (
+		name   string
+		output string
+	)
+
+	c := &cobra.Command{
+		Use:   "export [NAME]",
+		Short: "Export an environment's resources as a single YAML bundle",
+		Long: `Export writes the pod, PVCs, ServiceAccount, Services and the kdev spec of an
+environment to one YAML document, with the SSH Secret and web IDE Ingress of
+environments that have them. Server-assigned fields are removed so the
+bundle can be reviewed, archived or recreated elsewhere with kdev import.
+Only resource definitions are exported, not the data on the volumes, nor
+the web IDE password: import generates a new one. Hibernated environments
+export the pod they stored; StatefulSet and Deployment environments cannot
+be exported.`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeEnvNameArg,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, err := nameArg(args, name)
+			if err != nil {
+				return err
+			}
+			if name, err = envNameOrPick(context.Background(), name); err != nil {
+				return err
+			}
+			b, err := exportBundle(context.Background(), name)
+			if err != nil {
+				return err
+			}
+			out, err := yaml.Marshal(b)
+			if err != nil {
+				return err
+			}
+
+			var w io.Writer = os.Stdout
+			if output != "" && output != "-" {
+				f, err := os.Create(output)
+				if err != nil {
+					return fmt.Errorf("failed to create %s: %w", output, err)
+				}
+				defer f.Close()
+				w = f
+			}
+			if _, err := w.Write(out); err != nil {
+				return err
+			}
+			if w != os.Stdout {
+				fmt.Fprintf(os.Stderr, "Environment %s exported to %s\n", name, output)
+			}
+			return nil
+		},
+	}
+
+	c.Flags().StringVar(&name, "name", "", "Environment name (picked interactively when omitted)")
+	c.Flags().StringVarP(&output, "file", "f", "", "Write the bundle to this file instead of stdout")
+	deprecateNameFlag(c)
+	return c
+}
+
+// exportBundle collects the environment's objects from the cluster. A
+// hibernated environment exports the pod it stored.
+func exportBundle(ctx context.Context, name string) (*bundle, error) {
+	kind, workload, err := envWorkload(ctx, flagNamespace, name)
+	if err != nil {
+		return nil, err
+	}
+	if workload != nil {
+		return nil, fmt.Errorf("environment %s is a %s; export supports plain pod environments only", name, workloadKindName(kind))
+	}
+	core := kubeClient.CoreV1()
+	pod, err := core.Pods(flagNamespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm, cerr := core.ConfigMaps(flagNamespace).Get(ctx, hibernateConfigMapName(name), metav1.GetOptions{})
+		if cerr == nil {
+			pod, err = &corev1.Pod{}, nil
+			if err := json.Unmarshal([]byte(cm.Data[hibernatePodKey]), pod); err != nil {
+				return nil, fmt.Errorf("stored pod spec in ConfigMap %s is corrupt: %w", cm.Name, err)
+			}
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pod: %w", err)
+	}
+	if err := guardManaged("pod", &pod.ObjectMeta); err != nil {
+		return nil, err
+	}
+
+	b := &bundle{
+		APIVersion: bundleAPIVersion,
+		Kind:       bundleKind,
+		Metadata: bundleMeta{
+			Name:        name,
+			Namespace:   flagNamespace,
+			ExportedAt:  time.Now().UTC().Truncate(time.Second),
+			KdevVersion: buildVersion,
+		},
+		Pod: exportablePod(pod),
+	}
+	b.Metadata.Context, _ = currentContext()
+
+	var workPVC *corev1.PersistentVolumeClaim
+	for _, v := range pod.Spec.Volumes {
+		if v.PersistentVolumeClaim == nil {
+			continue
+		}
+		claim, err := core.PersistentVolumeClaims(flagNamespace).Get(ctx, v.PersistentVolumeClaim.ClaimName, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get PVC %s: %w", v.PersistentVolumeClaim.ClaimName, err)
+		}
+		b.PVCs = append(b.PVCs, exportablePVC(claim))
+		if v.Name == "work" {
+			workPVC = claim
+		}
+	}
+	b.Spec = specFromPod(pod, workPVC)
+
+	if saName := pod.Spec.ServiceAccountName; saName != "" && saName != "default" {
+		sa, err := core.ServiceAccounts(flagNamespace).Get(ctx, saName, metav1.GetOptions{})
+		switch {
+		case err == nil:
+			b.ServiceAccount = exportableServiceAccount(sa)
+		case !apierrors.IsNotFound(err) && !apierrors.IsForbidden(err):
+			return nil, fmt.Errorf("failed to get ServiceAccount: %w", err)
+		}
+	}
+
+	svcs, err := core.Services(flagNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.Set{"app": "kdev", "kdev/name": name}.String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list services: %w", err)
+	}
+	for i := range svcs.Items {
+		b.Services = append(b.Services, exportableService(&svcs.Items[i]))
+	}
+
+	// Import needs the authorized keys to recreate the SSH Secret; users who
+	// may not read Secrets get the importer's keys instead.
+	if findContainer(pod, sshContainer) != nil {
+		secret, err := core.Secrets(flagNamespace).Get(ctx, sshSecretName(name), metav1.GetOptions{})
+		switch {
+		case err == nil:
+			b.SSHSecret = exportableSecret(secret)
+		case !apierrors.IsNotFound(err) && !apierrors.IsForbidden(err):
+			return nil, fmt.Errorf("failed to get Secret %s: %w", sshSecretName(name), err)
+		}
+	}
+	if findContainer(pod, webIDEContainer) != nil {
+		ing, err := kubeClient.NetworkingV1().Ingresses(flagNamespace).Get(ctx, webIDEName(name), metav1.GetOptions{})
+		switch {
+		case err == nil:
+			b.WebIDEIngress = exportableIngress(ing)
+		case !apierrors.IsNotFound(err) && !apierrors.IsForbidden(err):
+			return nil, fmt.Errorf("failed to get Ingress %s: %w", webIDEName(name), err)
+		}
+	}
+	return b, nil
+}
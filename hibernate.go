@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/noopduck/kdev/internal/progress"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Hibernated environments keep their pod in a ConfigMap next to the
+// workspace PVC, stripped to what is needed to create it again (see
+// recreatablePod): no status, node, token volume or server-assigned metadata.
+const (
+	hibernateStateLabel = "kdev/state"
+	hibernatedState     = "hibernated"
+	hibernatePodKey     = "pod.json"
+)
+
+func hibernateConfigMapName(name string) string {
+	return "kdev-hibernate-" + name
+}
+
+func cmdHibernate() *cobra.Command {
+	var (
+		name    string
+		timeout time.Duration
+	)
+
+	c := &cobra.Command{
+		Use:   "hibernate [NAME]",
+		Short: "Store an environment's pod spec and delete the pod",
+		Long: `Hibernate saves the complete pod definition in a ConfigMap and deletes the
+pod, freeing its CPU and memory. The workspace PVC is kept. kdev wake creates
+the identical pod again, independent of the kdev version that stored it.`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeEnvNameArg,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, err := nameArg(args, name)
+			if err != nil {
+				return err
+			}
+			if name, err = envNameOrPick(context.Background(), name); err != nil {
+				return err
+			}
+			if err := hibernateEnv(context.Background(), name, timeout); err != nil {
+				return err
+			}
+			fmt.Printf("Environment %s hibernated in namespace %s. Use 'kdev wake %s' to restore it.\n", name, flagNamespace, name)
+			return nil
+		},
+	}
+
+	c.Flags().StringVar(&name, "name", "", "Environment name (picked interactively when omitted)")
+	c.Flags().DurationVar(&timeout, "timeout", 2*time.Minute, "How long to wait for the pod to terminate")
+	deprecateNameFlag(c)
+	return c
+}
+
+func cmdWake() *cobra.Command {
+	var (
+		name    string
+		image   string
+		wait    bool
+		timeout time.Duration
+	)
+
+	c := &cobra.Command{
+		Use:   "wake NAME",
+		Short: "Recreate a hibernated environment from its stored pod spec",
+		Args:  cobra.MaximumNArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) > 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+			return completeHibernatedNames(cmd, args, toComplete)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, err := nameArg(args, name)
+			if err != nil {
+				return err
+			}
+			if name == "" {
+				return errNameRequired
+			}
+			if err := wakeEnv(context.Background(), name, image, wait, timeout); err != nil {
+				return err
+			}
+			fmt.Printf("Environment %s restored in namespace %s\n", name, flagNamespace)
+			return nil
+		},
+	}
+
+	c.Flags().StringVar(&name, "name", "", "Environment name")
+	c.Flags().StringVar(&image, "image", "", "Restore with this image instead of the stored one")
+	c.Flags().BoolVar(&wait, "wait", false, "Wait until the pod is ready")
+	c.Flags().DurationVar(&timeout, "timeout", 5*time.Minute, "How long --wait waits for the pod")
+	deprecateNameFlag(c)
+	return c
+}
+
+// hibernateEnv stores the pod of environment name in a ConfigMap and
+// deletes it, keeping the workspace PVC.
+func hibernateEnv(ctx context.Context, name string, timeout time.Duration) error {
+	pods := kubeClient.CoreV1().Pods(flagNamespace)
+
+	live, err := pods.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get pod: %w", err)
+	}
+	if err := guardManaged("pod", &live.ObjectMeta); err != nil {
+		return err
+	}
+	data, err := json.Marshal(recreatablePod(live))
+	if err != nil {
+		return err
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      hibernateConfigMapName(name),
+			Namespace: flagNamespace,
+			Labels: map[string]string{
+				"app":               "kdev",
+				"kdev/name":         name,
+				hibernateStateLabel: hibernatedState,
+			},
+			Annotations: map[string]string{"kdev/hibernated-at": time.Now().UTC().Format(time.RFC3339)},
+		},
+		Data: map[string]string{hibernatePodKey: string(data)},
+	}
+	// Carry the pod's labels so selectors like rm -l team=payments still
+	// find the environment while it hibernates.
+	for k, v := range live.Labels {
+		if _, ok := cm.Labels[k]; !ok {
+			cm.Labels[k] = v
+		}
+	}
+	if _, err := kubeClient.CoreV1().ConfigMaps(flagNamespace).Create(ctx, cm, metav1.CreateOptions{}); err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("ConfigMap %s already exists; wake the environment or delete it first", cm.Name)
+		}
+		return fmt.Errorf("failed to store pod spec: %w", err)
+	}
+
+	if err := pods.Delete(ctx, name, metav1.DeleteOptions{}); err != nil {
+		return fmt.Errorf("failed to delete pod: %w", err)
+	}
+	sp := progress.Start(fmt.Sprintf("Waiting for pod %s to terminate", name))
+	err = waitForPodDeleted(ctx, flagNamespace, name, timeout)
+	sp.Done(err)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// wakeEnv recreates the pod of a hibernated environment from its ConfigMap,
+// optionally with another image.
+func wakeEnv(ctx context.Context, name, image string, wait bool, timeout time.Duration) error {
+	cms := kubeClient.CoreV1().ConfigMaps(flagNamespace)
+
+	cm, err := cms.Get(ctx, hibernateConfigMapName(name), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("environment %s is not hibernated in namespace %s", name, flagNamespace)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get stored pod spec: %w", err)
+	}
+	var pod corev1.Pod
+	if err := json.Unmarshal([]byte(cm.Data[hibernatePodKey]), &pod); err != nil {
+		return fmt.Errorf("stored pod spec in ConfigMap %s is corrupt: %w", cm.Name, err)
+	}
+	if image != "" {
+		c := findContainer(&pod, devContainer)
+		if c == nil {
+			return fmt.Errorf("stored pod has no %q container", devContainer)
+		}
+		if c.Image, err = resolveImage(image); err != nil {
+			return err
+		}
+	}
+
+	if err := createPod(ctx, &pod); err != nil {
+		return fmt.Errorf("failed to create pod: %w", err)
+	}
+	if err := cms.Delete(ctx, cm.Name, metav1.DeleteOptions{}); err != nil {
+		return fmt.Errorf("pod restored but failed to delete ConfigMap %s: %w", cm.Name, err)
+	}
+
+	if wait {
+		sp := progress.Start(fmt.Sprintf("Waiting for pod %s to become ready", name))
+		_, err := waitForPod(ctx, flagNamespace, name, timeout)
+		sp.Done(err)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hibernatedEnvs lists the stored pod specs in namespace.
+func hibernatedEnvs(ctx context.Context, namespace string) ([]corev1.ConfigMap, error) {
+	list, err := kubeClient.CoreV1().ConfigMaps(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: "app=kdev," + hibernateStateLabel + "=" + hibernatedState,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list hibernated environments: %w", err)
+	}
+	return list.Items, nil
+}
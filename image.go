@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/noopduck/kdev/internal/devcontainer"
+	"github.com/noopduck/kdev/internal/progress"
+	"github.com/noopduck/kdev/internal/registry"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/yaml"
+)
+
+func cmdImage() *cobra.Command {
+	c := &cobra.Command{
+		Use:         "image",
+		Short:       "Inspect dev images in the registry",
+		Annotations: map[string]string{annotationNoCluster: "true"},
+	}
+	c.AddCommand(cmdImageLS())
+	return c
+}
+
+// imageInfo is one row of kdev image ls.
+type imageInfo struct {
+	Image        string   `json:"image"`
+	Digest       string   `json:"digest"`
+	Size         int64    `json:"size"`
+	Created      string   `json:"created,omitempty"`
+	Devcontainer string   `json:"devcontainer,omitempty"`
+	Platforms    []string `json:"platforms,omitempty"`
+}
+
+func cmdImageLS() *cobra.Command {
+	var (
+		reg    string
+		all    bool
+		output string
+	)
+
+	c := &cobra.Command{
+		Use:   "ls [REPOSITORY...]",
+		Short: "List dev images built by kdev with tags, digests, sizes and build dates",
+		Long: `Without arguments, ls lists the repository named after the local
+devcontainer.json plus every repository in the registry catalog holding images
+built by kdev devcontainer build (labelled ` + devcontainer.ImageLabel + `).
+Named repositories are listed in full. Credentials are found as for pushes:
+the credHelpers or credsStore of ~/.docker/config.json, else its logins, else
+containers/auth.json.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if reg == "" && userConfig != nil {
+				reg = userConfig.Registry
+			}
+			if reg == "" {
+				return errors.New("--registry is required (or set registry in the user config)")
+			}
+			switch output {
+			case "", "yaml":
+			default:
+				return fmt.Errorf("unsupported output %q (use yaml)", output)
+			}
+			host, prefix, _ := strings.Cut(reg, "/")
+			if registry.IsPublicHost(host) {
+				if err := requireOnline("listing images on " + host); err != nil {
+					return err
+				}
+			}
+			client, err := registry.New(host, "kdev/"+buildVersion)
+			if err != nil {
+				return err
+			}
+			ctx := context.Background()
+
+			// Explicit repositories are listed in full; discovered ones
+			// only show images kdev built.
+			explicit := map[string]bool{}
+			for _, r := range args {
+				explicit[joinRepo(prefix, r)] = true
+			}
+			if len(args) == 0 {
+				if r := devcontainer.DefaultRepository(); r != "" {
+					explicit[joinRepo(prefix, r)] = true
+				}
+			}
+			repos := slices.Sorted(maps.Keys(explicit))
+			if len(args) == 0 {
+				catalog, err := client.Catalog(ctx)
+				if err != nil && len(repos) == 0 {
+					return fmt.Errorf("%w; name the repositories to list", err)
+				}
+				for _, r := range catalog {
+					if !explicit[r] && (prefix == "" || strings.HasPrefix(r, prefix+"/")) {
+						repos = append(repos, r)
+					}
+				}
+			}
+
+			sp := progress.Start(fmt.Sprintf("Reading %d repositories from %s", len(repos), host))
+			var images []imageInfo
+			for _, repo := range repos {
+				sp.Detail(repo)
+				found, err := listRepoImages(ctx, client, repo, all || explicit[repo])
+				if err != nil {
+					sp.Done(err)
+					return err
+				}
+				images = append(images, found...)
+			}
+			sp.Done(nil)
+
+			if output == "yaml" {
+				out, err := yaml.Marshal(images)
+				if err != nil {
+					return err
+				}
+				fmt.Print(string(out))
+				return nil
+			}
+			if len(images) == 0 {
+				fmt.Println("No dev images found")
+				return nil
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "IMAGE\tDIGEST\tSIZE\tCREATED\tPLATFORMS")
+			for _, img := range images {
+				fmt.Fprintf(w, "%s/%s\t%s\t%s\t%s\t%s\n", host, img.Image, shortDigest(img.Digest),
+					resource.NewQuantity(img.Size, resource.BinarySI).String(), imageAge(img.Created), strings.Join(img.Platforms, ","))
+			}
+			return w.Flush()
+		},
+	}
+
+	c.Flags().StringVar(&reg, "registry", "", "Registry (and optional path prefix) to search, e.g. harbor.example.com/team (default: registry from the user config)")
+	c.Flags().BoolVar(&all, "all", false, "Also list images not built by kdev in discovered repositories")
+	c.Flags().StringVarP(&output, "output", "o", "", "Output format (yaml)")
+	return c
+}
+
+// listRepoImages returns the tags of repo, newest first. Unless all is set,
+// only images carrying the kdev devcontainer label are returned.
+func listRepoImages(ctx context.Context, client *registry.Client, repo string, all bool) ([]imageInfo, error) {
+	tags, err := client.Tags(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	var images []imageInfo
+	for _, tag := range tags {
+		img, err := client.Image(ctx, repo, tag)
+		if err != nil {
+			return nil, err
+		}
+		name, labelled := img.Labels[devcontainer.ImageLabel]
+		if !labelled && !all {
+			continue
+		}
+		images = append(images, imageInfo{
+			Image:        repo + ":" + tag,
+			Digest:       img.Digest,
+			Size:         img.Size,
+			Created:      img.Created,
+			Devcontainer: name,
+			Platforms:    img.Platforms,
+		})
+	}
+	sort.SliceStable(images, func(i, j int) bool { return images[i].Created > images[j].Created })
+	return images, nil
+}
+
+func joinRepo(prefix, repo string) string {
+	if prefix == "" || strings.HasPrefix(repo, prefix+"/") {
+		return repo
+	}
+	return prefix + "/" + repo
+}
+
+func shortDigest(d string) string {
+	d = strings.TrimPrefix(d, "sha256:")
+	if len(d) > 12 {
+		d = d[:12]
+	}
+	return d
+}
+
+func imageAge(created string) string {
+	t, err := time.Parse(time.RFC3339Nano, created)
+	if err != nil || t.IsZero() {
+		return "-"
+	}
+	switch d := time.Since(t); {
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+	}
+}
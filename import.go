@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/noopduck/kdev/internal/progress"
+	"github.com/spf13/cobra"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+func cmdImport() *cobra.Command {
+	var (
+		file         string
+		name         string
+		storageClass string
+		wait         bool
+		timeout      time.Duration
+		dryRun       bool
+	)
+
+	c := &cobra.Command{
+		Use:   "import",
+		Short: "Recreate an environment from a bundle written by kdev export",
+		Long: `Import reads a bundle written by kdev export and creates its ServiceAccount,
+PVCs, Services, SSH and web IDE objects and pod in the target namespace (-n). Use --name to import the
+environment under a different name and --storage-class when the source
+cluster's StorageClass does not exist here. Existing PVCs are reused.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			b, err := readBundle(file)
+			if err != nil {
+				return err
+			}
+			if name == "" {
+				name = b.Metadata.Name
+			}
+			if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+				return fmt.Errorf("invalid --name %q: %s", name, errs[0])
+			}
+			b.retarget(flagNamespace, name)
+
+			ctx := context.Background()
+			if cmd.Flags().Changed("storage-class") && storageClass == "" {
+				storageClass = clusterDefaultStorageClass
+			}
+			for i := range b.PVCs {
+				requested := storageClass
+				if !cmd.Flags().Changed("storage-class") {
+					requested = ptrValue(b.PVCs[i].Spec.StorageClassName)
+				}
+				sc, err := resolveStorageClass(ctx, requested)
+				if err != nil {
+					return fmt.Errorf("PVC %s: %w", b.PVCs[i].Name, err)
+				}
+				b.PVCs[i].Spec.StorageClassName = sc
+			}
+			if err := resolvePodImages(&b.Pod.Spec); err != nil {
+				return err
+			}
+			if err := validatePod(b.Pod); err != nil {
+				return err
+			}
+
+			if dryRun {
+				return printBundleObjects(os.Stdout, b)
+			}
+			return importBundle(ctx, b, wait, timeout)
+		},
+	}
+
+	c.Flags().StringVarP(&file, "file", "f", "", "Bundle to import (- reads stdin) (required)")
+	c.Flags().StringVar(&name, "name", "", "Environment name to import as (default: the exported name)")
+	c.Flags().StringVar(&storageClass, "storage-class", "", "StorageClass for the PVCs instead of the exported one (\"\" or \"default\" uses the cluster default)")
+	c.Flags().BoolVar(&wait, "wait", false, "Wait until the pod is ready and explain image pull failures")
+	c.Flags().DurationVar(&timeout, "timeout", 5*time.Minute, "How long --wait waits for the pod")
+	c.Flags().BoolVar(&dryRun, "dry-run", false, "Print the objects that would be created, without creating anything")
+	_ = c.MarkFlagRequired("file")
+	_ = c.RegisterFlagCompletionFunc("file", completeYAMLFiles)
+	_ = c.RegisterFlagCompletionFunc("storage-class", completeStorageClasses)
+	return c
+}
+
+// importBundle creates the bundle's objects in dependency order, the same
+// way up does.
+func importBundle(ctx context.Context, b *bundle, wait bool, timeout time.Duration) error {
+	core := kubeClient.CoreV1()
+	ns, name := b.Metadata.Namespace, b.Metadata.Name
+
+	if b.ServiceAccount != nil {
+		if err := ensureServiceAccount(ctx, b.ServiceAccount); err != nil {
+			return err
+		}
+	}
+	for i := range b.PVCs {
+		pvc := &b.PVCs[i]
+		_, err := core.PersistentVolumeClaims(ns).Create(ctx, pvc, metav1.CreateOptions{})
+		switch {
+		case apierrors.IsAlreadyExists(err):
+			progress.Infof("PVC %s already exists, reusing it", pvc.Name)
+		case err != nil:
+			return fmt.Errorf("failed to create PVC %s: %w", pvc.Name, err)
+		}
+	}
+	for i := range b.Services {
+		svc := &b.Services[i]
+		if _, err := core.Services(ns).Create(ctx, svc, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create Service %s: %w", svc.Name, err)
+		}
+	}
+	// The sidecars mount Secrets the pod cannot start without.
+	m := &manifests{Pod: b.Pod, SSHSecret: b.SSHSecret, WebIDEIngress: b.WebIDEIngress}
+	if err := ensureSSHSecret(ctx, m); err != nil {
+		return err
+	}
+	if err := ensureWebIDE(ctx, m); err != nil {
+		return err
+	}
+	if err := createPod(ctx, b.Pod); err != nil {
+		return fmt.Errorf("failed to create Pod: %w", err)
+	}
+
+	if wait {
+		sp := progress.Start(fmt.Sprintf("Waiting for pod %s to become ready", name))
+		_, err := waitForPod(ctx, ns, name, timeout)
+		sp.Done(err)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf("\nPod %s imported into ns/%s. Use 'kdev attach %s -n %s' to enter.\n", name, ns, name, ns)
+	return nil
+}
+
+// printBundleObjects writes the objects import would create as a
+// multi-document YAML stream.
+func printBundleObjects(w io.Writer, b *bundle) error {
+	var objs []interface{}
+	if b.ServiceAccount != nil {
+		objs = append(objs, b.ServiceAccount)
+	}
+	for i := range b.PVCs {
+		objs = append(objs, &b.PVCs[i])
+	}
+	for i := range b.Services {
+		objs = append(objs, &b.Services[i])
+	}
+	if b.SSHSecret != nil {
+		objs = append(objs, b.SSHSecret)
+	}
+	if b.WebIDEIngress != nil {
+		objs = append(objs, b.WebIDEIngress)
+	}
+	objs = append(objs, b.Pod)
+	for _, obj := range objs {
+		out, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "---\n%s", out)
+	}
+	return nil
+}
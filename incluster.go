@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/noopduck/kdev/internal/devcontainer"
+	"github.com/noopduck/kdev/internal/progress"
+	"github.com/noopduck/kdev/internal/registry"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// inClusterBuilders are the backends of kdev devcontainer build --in-cluster.
+var inClusterBuilders = map[string]devcontainer.RemoteBuilder{
+	"kaniko":   buildWithKaniko,
+	"buildkit": buildWithBuildKit,
+}
+
+// buildLabel marks the objects of in-cluster builds with the backend.
+const buildLabel = "kdev/build"
+
+// inClusterBuildTimeout bounds an in-cluster build including scheduling.
+const inClusterBuildTimeout = time.Hour
+
+// connectBuildCluster connects to the cluster for commands that only need
+// it for in-cluster builds, such as kdev devcontainer build.
+func connectBuildCluster() error {
+	if kubeClient != nil {
+		return nil
+	}
+	if err := initKubeClient(); err != nil {
+		return fmt.Errorf("failed to initialize kubernetes client: %w", err)
+	}
+	flagNamespace, _ = resolveNamespace()
+	return preflight()
+}
+
+// packBuildContext writes the context and Dockerfile of b to a gzipped tar
+// in a temporary file, which the caller removes.
+func packBuildContext(b devcontainer.RemoteBuild) (*os.File, error) {
+	f, err := os.CreateTemp("", "kdev-context-*.tar.gz")
+	if err != nil {
+		return nil, err
+	}
+	sp := progress.Start(fmt.Sprintf("Packing build context %s", b.Context))
+	err = devcontainer.PackContext(f, b.Context, b.Dockerfile)
+	if err == nil {
+		_, err = f.Seek(0, 0)
+	}
+	sp.Done(err)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	if fi, err := f.Stat(); err == nil {
+		progress.Infof("Build context is %s", resource.NewQuantity(fi.Size(), resource.BinarySI))
+	}
+	return f, nil
+}
+
+// pushDockerConfig returns a docker config.json holding the local docker
+// login (or credential helper) for the registry of image, for a build in
+// the cluster to push with. It returns nil when there is no login, as for
+// registries open to the cluster.
+func pushDockerConfig(image string) ([]byte, error) {
+	host, _ := registry.Split(image)
+	cred, err := registry.LookupCredential(host)
+	if err != nil {
+		return nil, err
+	}
+	if cred == nil {
+		progress.Warnf("no docker login for %s; pushing from the cluster without credentials", host)
+		return nil, nil
+	}
+	// The docker config names Docker Hub by its legacy index URL.
+	key := host
+	if host == registry.DockerHub {
+		key = registry.DockerHubIndex
+	}
+	entry := map[string]string{"auth": base64.StdEncoding.EncodeToString([]byte(cred.Username + ":" + cred.Password))}
+	if cred.IdentityToken != "" {
+		entry = map[string]string{"identitytoken": cred.IdentityToken}
+	}
+	return json.Marshal(map[string]interface{}{
+		"auths": map[string]interface{}{key: entry},
+	})
+}
+
+// createPushSecret stores pushDockerConfig in a dockerconfigjson Secret. It
+// returns nil when there is no login.
+func createPushSecret(ctx context.Context, image string) (*corev1.Secret, error) {
+	data, err := pushDockerConfig(image)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "kdev-build-",
+			Namespace:    flagNamespace,
+			Labels:       map[string]string{"app": "kdev", "kdev/owner": currentOwner()},
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{corev1.DockerConfigJsonKey: data},
+	}
+	secret, err = kubeClient.CoreV1().Secrets(flagNamespace).Create(ctx, secret, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create push secret: %w", err)
+	}
+	return secret, nil
+}
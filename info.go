@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/noopduck/kdev/internal/config"
+	"github.com/noopduck/kdev/internal/spec"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+// setting is one resolved configuration value and where it came from.
+type setting struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+func cmdInfo() *cobra.Command {
+	var output string
+
+	c := &cobra.Command{
+		Use:         "info",
+		Short:       "Show the resolved configuration and where each value came from",
+		Annotations: map[string]string{annotationNoCluster: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			settings := resolvedSettings()
+			switch output {
+			case "yaml":
+				out, err := yaml.Marshal(settings)
+				if err != nil {
+					return err
+				}
+				fmt.Print(string(out))
+				return nil
+			case "":
+			default:
+				return fmt.Errorf("unsupported output %q (use yaml)", output)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "SETTING\tVALUE\tSOURCE")
+			for _, s := range settings {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", s.Name, s.Value, s.Source)
+			}
+			return w.Flush()
+		},
+	}
+	c.Flags().StringVarP(&output, "output", "o", "", "Output format (yaml)")
+	return c
+}
+
+// resolvedSettings collects the effective configuration. It never fails:
+// problems are reported as the value so info stays useful when debugging a
+// broken setup.
+func resolvedSettings() []setting {
+	settings := []setting{
+		{"kdev version", buildVersion, "build"},
+		{"kubernetes client", "v" + clientKubeVersion().String(), "build"},
+	}
+
+	cfgPath, err := config.Path()
+	switch {
+	case err != nil:
+		settings = append(settings, setting{"user config", err.Error(), "-"})
+	case fileExists(cfgPath):
+		settings = append(settings, setting{"user config", cfgPath, "loaded"})
+	default:
+		settings = append(settings, setting{"user config", cfgPath, "not found"})
+	}
+
+	kcPath, kcSource := kubeconfigPath()
+	kcErr := initKubeClient()
+	if kcErr != nil {
+		settings = append(settings, setting{"kubeconfig", kcPath, kcErr.Error()})
+	} else {
+		serverSource := "kubeconfig"
+		if inCluster {
+			kcSource, serverSource = "not found, using the in-cluster service account", "in-cluster"
+		}
+		settings = append(settings, setting{"kubeconfig", kcPath, kcSource})
+		name, source := currentContext()
+		settings = append(settings, setting{"context", name, source})
+		settings = append(settings, setting{"server", kubeRestConfig.Host, serverSource})
+	}
+
+	ns, source := resolveNamespace()
+	settings = append(settings, setting{"namespace", ns, source})
+
+	defaults, _ := specValues(spec.Defaults("<name>"))
+	configured, _ := specValues(configDefaults())
+	for _, f := range spec.Fields() {
+		if f == "name" || f == "pvc" {
+			continue
+		}
+		if v, ok := configured[f]; ok {
+			settings = append(settings, setting{"up." + f, v, "user config"})
+		} else if v, ok := defaults[f]; ok {
+			settings = append(settings, setting{"up." + f, v, "default"})
+		}
+	}
+	settings = append(settings, setting{"up.pvc", "<name>", "default"})
+	settings = append(settings, setting{"up.template", "(none)", "default"})
+	return settings
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return !errors.Is(err, os.ErrNotExist)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/noopduck/kdev/internal/devcontainer"
+	"github.com/noopduck/kdev/internal/progress"
+	"github.com/noopduck/kdev/internal/spec"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+// projectFile is the kdev.yaml kdev init writes to the project root.
+const projectFile = "kdev.yaml"
+
+// language is what kdev init generates for a kind of project.
+type language struct {
+	name string
+	// markers are files whose presence in the project root identify it.
+	markers    []string
+	image      string
+	remoteUser string
+	postCreate string
+	ports      []int
+	extensions []string
+	cpu        string
+	memory     string
+}
+
+// languages are tried in order; the last one matches any project.
+var languages = []language{
+	{name: "go", markers: []string{"go.mod"}, image: "mcr.microsoft.com/devcontainers/go:1", remoteUser: "vscode", postCreate: "go mod download", extensions: []string{"golang.go"}, cpu: "2", memory: "4Gi"},
+	{name: "rust", markers: []string{"Cargo.toml"}, image: "mcr.microsoft.com/devcontainers/rust:1", remoteUser: "vscode", postCreate: "cargo fetch", extensions: []string{"rust-lang.rust-analyzer"}, cpu: "4", memory: "8Gi"},
+	{name: "node", markers: []string{"package.json"}, image: "mcr.microsoft.com/devcontainers/javascript-node:22", remoteUser: "node", postCreate: "npm install", ports: []int{3000}, extensions: []string{"dbaeumer.vscode-eslint"}, cpu: "2", memory: "4Gi"},
+	{name: "python", markers: []string{"pyproject.toml", "requirements.txt", "setup.py"}, image: "mcr.microsoft.com/devcontainers/python:3.12", remoteUser: "vscode", postCreate: "if [ -f requirements.txt ]; then pip install -r requirements.txt; else pip install -e .; fi", ports: []int{8000}, extensions: []string{"ms-python.python"}, cpu: "1", memory: "2Gi"},
+	{name: "java", markers: []string{"pom.xml", "build.gradle", "build.gradle.kts"}, image: "mcr.microsoft.com/devcontainers/java:21", remoteUser: "vscode", ports: []int{8080}, extensions: []string{"vscjava.vscode-java-pack"}, cpu: "4", memory: "8Gi"},
+	{name: "dotnet", markers: []string{"*.csproj", "*.fsproj", "*.sln"}, image: "mcr.microsoft.com/devcontainers/dotnet:8.0", remoteUser: "vscode", postCreate: "dotnet restore", ports: []int{5000}, extensions: []string{"ms-dotnettools.csharp"}, cpu: "2", memory: "4Gi"},
+	{name: "ruby", markers: []string{"Gemfile"}, image: "mcr.microsoft.com/devcontainers/ruby:3.3", remoteUser: "vscode", postCreate: "bundle install", ports: []int{3000}, extensions: []string{"shopify.ruby-lsp"}, cpu: "1", memory: "2Gi"},
+	{name: "generic", image: "mcr.microsoft.com/devcontainers/base:ubuntu", remoteUser: "vscode", cpu: "1", memory: "2Gi"},
+}
+
+func languageNames() string {
+	names := make([]string, len(languages))
+	for i, l := range languages {
+		names[i] = l.name
+	}
+	return strings.Join(names, ", ")
+}
+
+// detectLanguage returns the first language with a marker file in dir.
+func detectLanguage(dir string) language {
+	for _, l := range languages {
+		for _, marker := range l.markers {
+			if matches, _ := filepath.Glob(filepath.Join(dir, marker)); len(matches) > 0 {
+				return l
+			}
+		}
+	}
+	return languages[len(languages)-1]
+}
+
+func cmdInit() *cobra.Command {
+	var (
+		name  string
+		lang  string
+		force bool
+	)
+
+	c := &cobra.Command{
+		Use:   "init [DIR]",
+		Short: "Scaffold a devcontainer.json, Dockerfile and kdev.yaml for a project",
+		Long: `Init detects the language of the project in DIR (default: the current
+directory) from files such as go.mod, package.json or pyproject.toml and
+writes a starter .devcontainer/devcontainer.json, .devcontainer/Dockerfile
+and kdev.yaml. Existing files are left alone unless --force is given.`,
+		Args:        cobra.MaximumNArgs(1),
+		Annotations: map[string]string{annotationNoCluster: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "."
+			if len(args) > 0 {
+				dir = args[0]
+			}
+			abs, err := filepath.Abs(dir)
+			if err != nil {
+				return err
+			}
+			l := detectLanguage(dir)
+			if lang != "" {
+				i := -1
+				for j := range languages {
+					if languages[j].name == lang {
+						i = j
+					}
+				}
+				if i < 0 {
+					return fmt.Errorf("unknown --language %q (want one of %s)", lang, languageNames())
+				}
+				l = languages[i]
+			}
+			if name == "" {
+				name = strings.Trim(invalidContainerChars.ReplaceAllString(strings.ToLower(filepath.Base(abs)), "-"), "-")
+			}
+			if name == "" {
+				return errNameRequired
+			}
+			progress.Infof("Detected a %s project", l.name)
+
+			dcJSON, err := initDevcontainer(name, l)
+			if err != nil {
+				return err
+			}
+			project, err := initProjectFile(name, l)
+			if err != nil {
+				return err
+			}
+			files := []struct {
+				path    string
+				content []byte
+			}{
+				{filepath.Join(dir, devcontainer.DefaultPath), dcJSON},
+				{filepath.Join(dir, filepath.Dir(devcontainer.DefaultPath), "Dockerfile"), initDockerfile(l)},
+				{filepath.Join(dir, projectFile), project},
+			}
+			for _, f := range files {
+				if fileExists(f.path) && !force {
+					progress.Warnf("%s exists; leaving it alone (use --force to overwrite)", f.path)
+					continue
+				}
+				if err := os.MkdirAll(filepath.Dir(f.path), 0o755); err != nil {
+					return err
+				}
+				if err := os.WriteFile(f.path, f.content, 0o644); err != nil {
+					return fmt.Errorf("failed to write %s: %w", f.path, err)
+				}
+				fmt.Printf("Wrote %s\n", f.path)
+			}
+			fmt.Printf("\nNext: kdev devcontainer build --registry REGISTRY --tag TAG --push, then kdev up %s --from-devcontainer --image IMAGE\n", name)
+			return nil
+		},
+	}
+
+	c.Flags().StringVar(&name, "name", "", "Environment name (default: the directory name)")
+	c.Flags().StringVar(&lang, "language", "", "Project language instead of detecting it ("+languageNames()+")")
+	c.Flags().BoolVar(&force, "force", false, "Overwrite existing files")
+	_ = c.RegisterFlagCompletionFunc("language", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return strings.Split(languageNames(), ", "), cobra.ShellCompDirectiveNoFileComp
+	})
+	return c
+}
+
+// initDevcontainer renders the devcontainer.json for l.
+func initDevcontainer(name string, l language) ([]byte, error) {
+	type vscode struct {
+		Extensions []string `json:"extensions,omitempty"`
+	}
+	doc := struct {
+		Name  string `json:"name"`
+		Build struct {
+			Dockerfile string `json:"dockerfile"`
+		} `json:"build"`
+		RemoteUser        string `json:"remoteUser,omitempty"`
+		ForwardPorts      []int  `json:"forwardPorts,omitempty"`
+		PostCreateCommand string `json:"postCreateCommand,omitempty"`
+		Customizations    struct {
+			VSCode vscode `json:"vscode"`
+		} `json:"customizations"`
+	}{Name: name, RemoteUser: l.remoteUser, ForwardPorts: l.ports, PostCreateCommand: l.postCreate}
+	doc.Build.Dockerfile = "Dockerfile"
+	doc.Customizations.VSCode.Extensions = l.extensions
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+// initDockerfile renders the Dockerfile for l.
+func initDockerfile(l language) []byte {
+	return []byte(fmt.Sprintf(`FROM %s
+
+# Add the tools the project needs, e.g.:
+# RUN apt-get update && apt-get install -y --no-install-recommends postgresql-client \
+#     && rm -rf /var/lib/apt/lists/*
+`, l.image))
+}
+
+// initProjectFile renders kdev.yaml: the kdev spec of the environment,
+// which kdev up reads.
+func initProjectFile(name string, l language) ([]byte, error) {
+	s := spec.Spec{
+		Name:        name,
+		CPU:         l.cpu,
+		Memory:      l.memory,
+		StorageSize: "20Gi",
+	}
+	out, err := yaml.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte("# The team-standard kdev environment of this project.\n"), out...), nil
+}
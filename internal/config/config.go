@@ -0,0 +1,199 @@
+// Package config loads the user's kdev configuration file.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/noopduck/kdev/internal/spec"
+	"sigs.k8s.io/yaml"
+)
+
+// Config holds user defaults read from ~/.config/kdev/config.yaml.
+type Config struct {
+	// Namespace is used when neither -n nor the kubeconfig context sets one.
+	Namespace string `json:"namespace,omitempty"`
+	// ServiceAccount, StorageClass, StorageSize and Shell are the defaults
+	// of the kdev up flags of the same name (--storage for StorageSize).
+	// Flags and templates override them.
+	ServiceAccount string `json:"serviceAccount,omitempty"`
+	StorageClass   string `json:"storageClass,omitempty"`
+	StorageSize    string `json:"storageSize,omitempty"`
+	Shell          string `json:"shell,omitempty"`
+	// VolumeStorageClass is the StorageClass of the PVCs of named
+	// devcontainer volumes, which several environments share. They request
+	// ReadWriteMany with it, so the class must support that; without it they
+	// use the workspace PVC's class and only work on a single node.
+	VolumeStorageClass string `json:"volumeStorageClass,omitempty"`
+	// Sizes adds or overrides --size presets for this user, over the
+	// built-in ones and those of the shared config.
+	Sizes map[string]Size `json:"sizes,omitempty"`
+	// Annotations are added to every pod and PVC kdev up creates, e.g. for
+	// cluster integrations; --annotation overrides them key by key.
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// SyncLocale copies the local timezone and locale into new pods. It
+	// defaults to true; --sync-locale overrides it.
+	SyncLocale *bool `json:"syncLocale,omitempty"`
+	// Registry is where devcontainer images are pushed when --image and
+	// --registry are not given, and where kdev image ls looks for them,
+	// e.g. harbor.example.com or harbor.example.com/team.
+	Registry string `json:"registry,omitempty"`
+	// Mirrors rewrites image references at build and up time, for clusters
+	// that cannot reach public registries. Keys are a registry host or a
+	// host/path prefix, values what replaces it:
+	//   docker.io: mirror.internal/dockerhub
+	Mirrors map[string]string `json:"mirrors,omitempty"`
+	// Builder builds devcontainer images: docker, podman,
+	// nerdctl, buildah or auto (the first installed); --builder overrides it.
+	Builder string `json:"builder,omitempty"`
+	// BuildKit is an existing buildkitd Deployment in the namespace that
+	// kdev devcontainer build --in-cluster=buildkit builds with, instead of
+	// the one kdev runs itself.
+	BuildKit string `json:"buildkit,omitempty"`
+	// Offline refuses operations that need the internet, like --offline.
+	Offline bool `json:"offline,omitempty"`
+	// Keepalive is the default main process of new dev containers (auto,
+	// sleep, pause, entrypoint or a shell command); --keepalive overrides it.
+	Keepalive string `json:"keepalive,omitempty"`
+	// Probes tunes the health checks of the dev container.
+	Probes *Probes `json:"probes,omitempty"`
+	// TelemetryEndpoint receives usage events once the user ran
+	// kdev telemetry on. Without it events stay in the local spool.
+	TelemetryEndpoint string `json:"telemetryEndpoint,omitempty"`
+	// Recording captures kdev attach and kdev exec sessions for auditing.
+	Recording *Recording `json:"recording,omitempty"`
+	// Signing configures how kdev devcontainer build --sign signs images.
+	Signing *Signing `json:"signing,omitempty"`
+	// Scan gates kdev devcontainer build on a vulnerability scan.
+	Scan *Scan `json:"scan,omitempty"`
+	// SBOM sets the defaults of kdev devcontainer build --sbom.
+	SBOM *SBOM `json:"sbom,omitempty"`
+	// Profiles are named bundles of kdev up settings, such as the image,
+	// resources, node selector and tolerations of a hardware class, picked
+	// with --profile NAME. Their fields are those of a kdev spec:
+	//   gpu:
+	//     image: harbor.example.com/team/cuda-dev:12
+	//     extraResources: {nvidia.com/gpu: "1"}
+	//     nodeSelector: {accelerator: nvidia}
+	//     tolerations: ["nvidia.com/gpu:NoSchedule"]
+	Profiles map[string]spec.Spec `json:"profiles,omitempty"`
+}
+
+// SBOM writes an SBOM of built devcontainer images.
+type SBOM struct {
+	// Format is spdx or cyclonedx; set, every build writes an SBOM, as if
+	// --sbom were given.
+	Format string `json:"format,omitempty"`
+	// Attach attaches the SBOM to pushed images, as if --attach-sbom were
+	// given.
+	Attach bool `json:"attach,omitempty"`
+}
+
+// Scan scans built devcontainer images with trivy.
+type Scan struct {
+	// Enabled scans every build, as if --scan were given.
+	Enabled bool `json:"enabled,omitempty"`
+	// Severity is the lowest severity that fails the build: LOW, MEDIUM,
+	// HIGH or CRITICAL (the default); --scan-severity overrides it.
+	Severity string `json:"severity,omitempty"`
+	// WarnOnly reports vulnerabilities without failing the build.
+	WarnOnly bool `json:"warnOnly,omitempty"`
+	// IgnoreUnfixed skips vulnerabilities without a fixed version.
+	IgnoreUnfixed bool `json:"ignoreUnfixed,omitempty"`
+}
+
+// Signing signs pushed devcontainer images with cosign.
+type Signing struct {
+	// Key is a cosign key: a file, or a KMS or Kubernetes URI such as
+	// awskms:///alias/dev-images or k8s://ns/secret. Without it images are
+	// signed keylessly with a Sigstore identity.
+	Key string `json:"key,omitempty"`
+	// Always signs every pushed image, as if --sign were given.
+	Always bool `json:"always,omitempty"`
+}
+
+// Recording writes sessions as asciicast v2 files, which asciinema play
+// replays.
+type Recording struct {
+	// Enabled turns recording on. A session whose recording cannot be
+	// started is refused rather than run unrecorded.
+	Enabled bool `json:"enabled,omitempty"`
+	// Dir receives the recordings; it defaults to recordings/ next to this
+	// file. With S3 set it only holds those not uploaded yet.
+	Dir string `json:"dir,omitempty"`
+	// Input also records keystrokes, including passwords typed at prompts
+	// that do not echo them.
+	Input bool `json:"input,omitempty"`
+	// S3 uploads each recording to a bucket when the session ends.
+	S3 *S3 `json:"s3,omitempty"`
+}
+
+// S3 is an S3 or S3-compatible bucket. Credentials are read from
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+type S3 struct {
+	Bucket string `json:"bucket"`
+	// Prefix is prepended to object keys, e.g. kdev/.
+	Prefix string `json:"prefix,omitempty"`
+	// Region defaults to AWS_REGION.
+	Region string `json:"region,omitempty"`
+	// Endpoint is set for S3-compatible stores such as MinIO, e.g.
+	// https://minio.example.com; objects are then addressed path-style.
+	Endpoint string `json:"endpoint,omitempty"`
+}
+
+// Probes configures the exec-based startup and liveness probes that get a
+// dead or wedged dev container restarted. Zero values keep kdev's defaults.
+type Probes struct {
+	// Disabled turns both probes off.
+	Disabled bool `json:"disabled,omitempty"`
+	// Command runs inside the dev container; exit code 0 means healthy,
+	// e.g. [pgrep, sshd] to also watch an SSH server.
+	Command []string `json:"command,omitempty"`
+	// PeriodSeconds is how often the liveness probe runs.
+	PeriodSeconds int32 `json:"periodSeconds,omitempty"`
+	// TimeoutSeconds bounds a single run of Command.
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+	// FailureThreshold is how many failed runs in a row restart the container.
+	FailureThreshold int32 `json:"failureThreshold,omitempty"`
+	// StartupSeconds is how long the container may take to pass Command for
+	// the first time before liveness checks begin.
+	StartupSeconds int32 `json:"startupSeconds,omitempty"`
+}
+
+// Path returns the location of the user config file, honoring XDG_CONFIG_HOME.
+func Path() (string, error) {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "kdev", "config.yaml"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve home directory: %w", err)
+	}
+	return filepath.Join(home, ".config", "kdev", "config.yaml"), nil
+}
+
+// Load reads the user config file. A missing file is not an error and yields
+// an empty Config.
+func Load() (*Config, error) {
+	path, err := Path()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	var cfg Config
+	if err := Validate(path, data, &cfg); err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return &cfg, nil
+}
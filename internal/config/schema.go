@@ -0,0 +1,64 @@
+package config
+
+import "reflect"
+
+// Schema returns a JSON Schema (draft 2020-12) for the config type of v,
+// derived from its fields so it cannot drift from what kdev accepts.
+func Schema(title string, v interface{}) map[string]interface{} {
+	s := schemaFor(reflect.TypeOf(v))
+	s["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	s["title"] = title
+	return s
+}
+
+func schemaFor(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		props := map[string]interface{}{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if name := jsonName(f); name != "-" && f.IsExported() {
+				props[name] = schemaFor(f.Type)
+			}
+		}
+		return map[string]interface{}{"type": "object", "properties": props, "additionalProperties": false}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaFor(t.Elem())}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	}
+	return map[string]interface{}{}
+}
+
+// Document is a kind of config file kdev reads.
+type Document struct {
+	Kind  string
+	Title string
+	// New returns a pointer to an empty value of the document's type.
+	New func() interface{}
+}
+
+// Documents lists every config file kind, for kdev config lint and schema.
+var Documents = []Document{
+	{Kind: "user", Title: "kdev user config (~/.config/kdev/config.yaml)", New: func() interface{} { return &Config{} }},
+	{Kind: "shared", Title: "kdev shared config (config.yaml of the kdev-config ConfigMap)", New: func() interface{} { return &Shared{} }},
+}
+
+// DocumentKind looks up a Document by kind.
+func DocumentKind(kind string) (Document, bool) {
+	for _, d := range Documents {
+		if d.Kind == kind {
+			return d, true
+		}
+	}
+	return Document{}, false
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"fmt"
+
+	"sigs.k8s.io/yaml"
+)
+
+// SharedConfigMap is the ConfigMap platform admins use to share kdev
+// settings. kdev reads it from the target namespace, then from kube-public.
+const SharedConfigMap = "kdev-config"
+
+// SharedConfigKey is the ConfigMap data key holding the YAML document.
+const SharedConfigKey = "config.yaml"
+
+// Shared holds the cluster-wide settings from the kdev-config ConfigMap.
+type Shared struct {
+	// Sizes adds or overrides --size presets.
+	Sizes map[string]Size `json:"sizes,omitempty"`
+}
+
+// Size is a named combination of resources for kdev up --size.
+type Size struct {
+	CPU     string `json:"cpu,omitempty"`
+	Memory  string `json:"memory,omitempty"`
+	Storage string `json:"storage,omitempty"`
+}
+
+// ParseShared decodes the shared config document. source names it in
+// errors.
+func ParseShared(data, source string) (*Shared, error) {
+	var s Shared
+	if err := Validate(source, []byte(data), &s); err != nil {
+		return nil, err
+	}
+	if err := yaml.UnmarshalStrict([]byte(data), &s); err != nil {
+		return nil, fmt.Errorf("invalid shared config %s: %w", source, err)
+	}
+	return &s, nil
+}
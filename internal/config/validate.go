@@ -0,0 +1,249 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	yaml "go.yaml.in/yaml/v3"
+)
+
+// Problem is one schema violation in a config file.
+type Problem struct {
+	Path   string // dotted field path, e.g. sizes.m.memroy
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (p Problem) String() string {
+	if p.Column == 0 {
+		return fmt.Sprintf("%d: %s: %s", p.Line, p.Path, p.Msg)
+	}
+	return fmt.Sprintf("%d:%d: %s: %s", p.Line, p.Column, p.Path, p.Msg)
+}
+
+// ValidationError lists every problem found in file.
+type ValidationError struct {
+	File     string
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "invalid config %s:", e.File)
+	for _, p := range e.Problems {
+		fmt.Fprintf(&b, "\n  %s:%s", e.File, p)
+	}
+	return b.String()
+}
+
+// Validate checks a YAML document against the schema of v, which must be a
+// pointer to a config struct. Unknown fields, wrong types and malformed YAML
+// are reported with their line and column; file names the document in the
+// returned *ValidationError.
+func Validate(file string, data []byte, v interface{}) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	var problems []Problem
+	for {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return &ValidationError{File: file, Problems: []Problem{yamlProblem(err)}}
+		}
+		if len(doc.Content) > 0 {
+			problems = append(problems, check(doc.Content[0], reflect.TypeOf(v).Elem(), "")...)
+		}
+	}
+	if len(problems) > 0 {
+		return &ValidationError{File: file, Problems: problems}
+	}
+	return nil
+}
+
+// yamlProblem turns a parser error ("yaml: line 3: ...") into a Problem.
+func yamlProblem(err error) Problem {
+	msg := strings.TrimPrefix(err.Error(), "yaml: ")
+	p := Problem{Path: "(document)", Msg: msg}
+	if n, _ := fmt.Sscanf(msg, "line %d:", &p.Line); n == 1 {
+		_, p.Msg, _ = strings.Cut(msg, ": ")
+	}
+	return p
+}
+
+func check(n *yaml.Node, t reflect.Type, path string) []Problem {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!null" {
+		return nil
+	}
+	bad := func(format string, args ...interface{}) []Problem {
+		return []Problem{{Path: displayPath(path), Line: n.Line, Column: n.Column, Msg: fmt.Sprintf(format, args...)}}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if n.Kind != yaml.MappingNode {
+			return bad("expected a mapping, got %s", describe(n))
+		}
+		fields := map[string]reflect.StructField{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if name := jsonName(f); name != "-" && f.IsExported() {
+				fields[name] = f
+			}
+		}
+		var problems []Problem
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, val := n.Content[i], n.Content[i+1]
+			f, ok := fields[key.Value]
+			if !ok {
+				msg := fmt.Sprintf("unknown field %q", key.Value)
+				if s := suggest(key.Value, fields); s != "" {
+					msg += fmt.Sprintf(" (did you mean %q?)", s)
+				}
+				problems = append(problems, Problem{Path: displayPath(join(path, key.Value)), Line: key.Line, Column: key.Column, Msg: msg})
+				continue
+			}
+			problems = append(problems, check(val, f.Type, join(path, key.Value))...)
+		}
+		return problems
+	case reflect.Map:
+		if n.Kind != yaml.MappingNode {
+			return bad("expected a mapping, got %s", describe(n))
+		}
+		var problems []Problem
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			problems = append(problems, check(n.Content[i+1], t.Elem(), join(path, n.Content[i].Value))...)
+		}
+		return problems
+	case reflect.Slice:
+		if n.Kind != yaml.SequenceNode {
+			return bad("expected a list, got %s", describe(n))
+		}
+		var problems []Problem
+		for i, item := range n.Content {
+			problems = append(problems, check(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return problems
+	case reflect.Bool:
+		if n.Kind != yaml.ScalarNode || (n.Tag != "!!bool" && !yaml11Bool(n.Value)) {
+			return bad("expected true or false, got %s", describe(n))
+		}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		if n.Kind != yaml.ScalarNode || n.Tag != "!!int" {
+			return bad("expected an integer, got %s", describe(n))
+		}
+	case reflect.String:
+		// Numbers and booleans must be quoted, as the schema says.
+		if n.Kind != yaml.ScalarNode {
+			return bad("expected a string, got %s", describe(n))
+		}
+		if n.Tag != "!!str" || (n.Style == 0 && yaml11Bool(n.Value)) {
+			return bad("expected a string, got %s; quote it", describe(n))
+		}
+	}
+	return nil
+}
+
+// yaml11Bool reports whether s is a YAML 1.1 boolean such as "yes", which the
+// loader still accepts.
+func yaml11Bool(s string) bool {
+	switch strings.ToLower(s) {
+	case "yes", "no", "on", "off", "y", "n":
+		return true
+	}
+	return false
+}
+
+func describe(n *yaml.Node) string {
+	switch n.Kind {
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.SequenceNode:
+		return "a list"
+	}
+	return fmt.Sprintf("%q", n.Value)
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func displayPath(path string) string {
+	if path == "" {
+		return "(root)"
+	}
+	return path
+}
+
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+// suggest returns the known field closest to key, if it is a plausible typo.
+func suggest(key string, fields map[string]reflect.StructField) string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return Suggest(key, names)
+}
+
+// Suggest returns the name closest to key, if key is a plausible typo of
+// it, for "did you mean" hints.
+func Suggest(key string, names []string) string {
+	best, bestDist := "", len(key)/2+2
+	for _, name := range names {
+		if d := editDistance(strings.ToLower(key), strings.ToLower(name)); d < bestDist {
+			best, bestDist = name, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Damerau-Levenshtein distance (adjacent swaps count
+// once), which catches typos like "memroy".
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
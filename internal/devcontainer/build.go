@@ -0,0 +1,380 @@
+package devcontainer
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/noopduck/kdev/internal/progress"
+	"github.com/noopduck/kdev/internal/registry"
+)
+
+// RemoteBuild is an image build that runs in the cluster instead of with a
+// local builder. The image is pushed to Destination from there.
+type RemoteBuild struct {
+	Context     string // local directory sent to the cluster
+	Dockerfile  string // local path, empty for Dockerfile in Context
+	Destination string
+	Platform    string
+	Labels      map[string]string
+	Args        map[string]string
+	CacheFrom   []Cache
+	CacheTo     []Cache
+}
+
+// RemoteBuilder runs a RemoteBuild. kdev devcontainer build --in-cluster
+// picks one by name; they live outside this package as they need the
+// cluster.
+type RemoteBuilder func(ctx context.Context, b RemoteBuild) error
+
+// imageBuild holds the settings of one kdev devcontainer build.
+type imageBuild struct {
+	builder  Builder
+	platform string
+	mirrors  map[string]string
+	offline  bool
+	// frozen installs features only as pinned in the lockfile.
+	frozen bool
+	// remote builds in the cluster instead of with builder, pushing each
+	// image as it is built.
+	remote RemoteBuilder
+	// cacheFrom and cacheTo are the build caches of the Dockerfile build;
+	// the features build uses variants of them.
+	cacheFrom, cacheTo []Cache
+	// scan gates each image on a vulnerability scan before it is pushed.
+	scan *scanPolicy
+	// sbom writes and attaches the SBOM of each image.
+	sbom *sbomPolicy
+	// labels are added to those of every image, such as its source commit.
+	labels map[string]string
+	// archives are the local images exported for trivy and pushing, by
+	// name, in tmpDir; close removes them.
+	archives map[string]string
+	tmpDir   string
+}
+
+// export writes the local image imageName to a docker-archive once and
+// returns its path.
+func (b *imageBuild) export(ctx context.Context, imageName string) (string, error) {
+	if archive, ok := b.archives[imageName]; ok {
+		return archive, nil
+	}
+	if b.tmpDir == "" {
+		dir, err := os.MkdirTemp("", "kdev-images-")
+		if err != nil {
+			return "", err
+		}
+		b.tmpDir, b.archives = dir, map[string]string{}
+	}
+	archive := filepath.Join(b.tmpDir, fmt.Sprintf("image-%d.tar", len(b.archives)))
+	if err := progress.Run(fmt.Sprintf("Exporting %s", imageName), b.builder.SaveCmd(ctx, imageName, archive)); err != nil {
+		return "", fmt.Errorf("%s save failed: %w", b.builder, err)
+	}
+	b.archives[imageName] = archive
+	return archive, nil
+}
+
+// close removes the exported images.
+func (b *imageBuild) close() {
+	if b.tmpDir != "" {
+		os.RemoveAll(b.tmpDir)
+	}
+}
+
+// build builds o locally with the builder or in the cluster.
+func (b *imageBuild) build(ctx context.Context, desc string, o BuildOptions) error {
+	if b.remote != nil {
+		return b.remote(ctx, RemoteBuild{
+			Context:     o.Context,
+			Dockerfile:  o.Dockerfile,
+			Destination: o.Tag,
+			Platform:    o.Platform,
+			Labels:      o.Labels,
+			Args:        o.Args,
+			CacheFrom:   o.CacheFrom,
+			CacheTo:     o.CacheTo,
+		})
+	}
+	if err := progress.Run(desc, b.builder.BuildCmd(ctx, o)); err != nil {
+		return fmt.Errorf("%s build failed: %w", b.builder, err)
+	}
+	return nil
+}
+
+// imageUser returns the user image runs as. Images built in the cluster
+// never reach the local machine, so their registry is asked instead.
+func (b *imageBuild) imageUser(ctx context.Context, image string) (string, error) {
+	if b.remote == nil {
+		return b.builder.ImageUser(ctx, image)
+	}
+	host, repo := registry.Split(image)
+	repo, tag := splitTag(repo)
+	client, err := registry.New(host, "kdev")
+	if err != nil {
+		return "", err
+	}
+	img, err := client.Image(ctx, repo, tag)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect %s: %w", image, err)
+	}
+	return img.User, nil
+}
+
+// image builds the image of cfg, with its features, into imageName for
+// b.platform.
+func (b *imageBuild) image(ctx context.Context, cfg *DevContainerConfig, imageName string) error {
+	base := imageName
+	if cfg.Prebuilt() {
+		var err error
+		if base, err = b.pullBase(ctx, cfg.Image); err != nil {
+			return err
+		}
+	} else if err := b.dockerfile(ctx, cfg, imageName); err != nil {
+		return err
+	}
+	if len(cfg.Features) > 0 {
+		return b.features(ctx, cfg, base, imageName)
+	}
+	return nil
+}
+
+// push pushes a locally built image with kdev's registry client, which
+// finds credentials as docker does and retries failed uploads. In-cluster
+// builds push themselves.
+func (b *imageBuild) push(ctx context.Context, imageName string) error {
+	if b.remote != nil {
+		return nil
+	}
+	if err := checkOfflinePush(imageName, b.offline); err != nil {
+		return err
+	}
+	archive, err := b.export(ctx, imageName)
+	if err != nil {
+		return err
+	}
+	host, path := registry.Split(imageName)
+	repo, tag := splitTag(path)
+	client, err := registry.New(host, "kdev")
+	if err != nil {
+		return err
+	}
+	s := progress.Start(fmt.Sprintf("Pushing %s", imageName))
+	digest, err := client.PushArchive(ctx, repo, tag, archive, s)
+	s.Done(err)
+	if err != nil {
+		return fmt.Errorf("failed to push %s: %w", imageName, err)
+	}
+	progress.Infof("Pushed %s as %s", imageName, digest)
+	return nil
+}
+
+// multiPlatform builds and pushes the image of cfg once per platform, each
+// under its own tag (see PlatformTag), and then pushes a manifest list
+// under imageName that points at them, so every node pulls its own.
+func (b *imageBuild) multiPlatform(ctx context.Context, cfg *DevContainerConfig, imageName string, platforms []string) error {
+	tags := map[string]string{}
+	cacheFrom, cacheTo := b.cacheFrom, b.cacheTo
+	defer func() { b.cacheFrom, b.cacheTo = cacheFrom, cacheTo }()
+	for _, p := range platforms {
+		b.platform = p
+		b.cacheFrom, b.cacheTo = cacheVariants(cacheFrom, p), cacheVariants(cacheTo, p)
+		tag := PlatformTag(imageName, p)
+		if err := b.image(ctx, cfg, tag); err != nil {
+			return err
+		}
+		if err := b.inspectImage(ctx, tag); err != nil {
+			return err
+		}
+		if err := b.push(ctx, tag); err != nil {
+			return err
+		}
+		if err := b.attachSBOM(ctx, tag); err != nil {
+			return err
+		}
+		_, path := registry.Split(tag)
+		_, tags[p] = splitTag(path)
+	}
+
+	if err := checkOfflinePush(imageName, b.offline); err != nil {
+		return err
+	}
+	host, path := registry.Split(imageName)
+	repo, tag := splitTag(path)
+	client, err := registry.New(host, "kdev")
+	if err != nil {
+		return err
+	}
+	s := progress.Start(fmt.Sprintf("Pushing manifest list %s for %s", imageName, strings.Join(platforms, ", ")))
+	digest, err := client.PushIndex(ctx, repo, tag, tags)
+	s.Done(err)
+	if err != nil {
+		return err
+	}
+	progress.Infof("Manifest list %s is %s", imageName, digest)
+	return nil
+}
+
+// PlatformTag is the tag the image of one platform of a multi-platform
+// build is pushed under: the tag of imageName with the platform appended,
+// e.g. harbor.example.com/dev:v1-linux-arm64.
+func PlatformTag(imageName, platform string) string {
+	suffix := "-" + strings.ReplaceAll(platform, "/", "-")
+	if i := strings.LastIndex(imageName, ":"); i > strings.LastIndex(imageName, "/") {
+		return imageName + suffix
+	}
+	return imageName + ":latest" + suffix
+}
+
+// dockerfile builds the Dockerfile of cfg into imageName.
+func (b *imageBuild) dockerfile(ctx context.Context, cfg *DevContainerConfig, imageName string) error {
+	dockerfile, contextDir := cfg.buildPaths()
+
+	// validate dockerfile exists
+	if _, err := os.Stat(dockerfile); err != nil {
+		return fmt.Errorf("dockerfile not found: %s", dockerfile)
+	}
+
+	// Apply registry mirrors to the base images
+	rewritten, err := rewriteDockerfile(dockerfile, b.mirrors, b.offline)
+	if err != nil {
+		return err
+	}
+	buildFile := dockerfile
+	if rewritten != "" {
+		defer os.Remove(rewritten)
+		buildFile = rewritten
+	}
+
+	args := map[string]string{}
+	for k, v := range cfg.Build.Args {
+		args[k] = v
+	}
+	// pass remoteUser as build-arg so Dockerfile can use it if desired
+	if cfg.RemoteUser != "" {
+		args["REMOTE_USER"] = cfg.RemoteUser
+	}
+
+	return b.build(ctx, fmt.Sprintf("Building %s from %s", imageName, dockerfile), BuildOptions{
+		Dockerfile: buildFile,
+		Context:    contextDir,
+		Tag:        imageName,
+		Platform:   b.platform,
+		Labels:     b.imageLabels(cfg),
+		Args:       args,
+		CacheFrom:  b.cacheFrom,
+		CacheTo:    b.cacheTo,
+	})
+}
+
+// pullBase pulls the prebuilt image of a devcontainer, through the mirrors,
+// so features can be installed on top of it. It returns the reference
+// pulled. In-cluster builds pull it themselves.
+func (b *imageBuild) pullBase(ctx context.Context, image string) (string, error) {
+	ref := registry.Rewrite(image, b.mirrors)
+	if b.offline && registry.IsPublic(ref) {
+		host, _ := registry.Split(ref)
+		return "", fmt.Errorf("image %s is on %s, which offline mode cannot reach; add a mirror for it to the user config", image, host)
+	}
+	if b.remote != nil {
+		return ref, nil
+	}
+	if err := progress.Run(fmt.Sprintf("Pulling %s", ref), b.builder.PullCmd(ctx, ref, b.platform)); err != nil {
+		return "", fmt.Errorf("%s pull failed: %w", b.builder, err)
+	}
+	return ref, nil
+}
+
+// features installs the features of cfg on top of base, tagging the result
+// imageName. Features are fetched and ordered natively; only the final
+// image build needs the builder. The lockfile next to devcontainer.json is
+// updated to what was fetched, or when frozen, is what gets fetched.
+func (b *imageBuild) features(ctx context.Context, cfg *DevContainerConfig, base, imageName string) error {
+	lock, err := readLockfile(cfg.dir)
+	if err != nil {
+		return err
+	}
+	if b.frozen && lock == nil {
+		// Local features have nothing to pin, so they need no lockfile.
+		for ref := range cfg.Features {
+			if !isLocalFeature(ref) {
+				return fmt.Errorf("--frozen needs a %s next to devcontainer.json; build without --frozen to create it", LockfileName)
+			}
+		}
+		lock = &Lockfile{Features: map[string]LockedFeature{}}
+	}
+
+	dir, err := os.MkdirTemp("", "kdev-features-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	fetcher := &featureFetcher{dir: dir, baseDir: cfg.dir, mirrors: b.mirrors, offline: b.offline, clients: map[string]*registry.Client{}}
+	if b.frozen {
+		fetcher.frozen = lock
+	}
+	s := progress.Start(fmt.Sprintf("Resolving %d features", len(cfg.Features)))
+	features, err := cfg.resolveFeatures(ctx, fetcher)
+	s.Done(err)
+	if err != nil {
+		return err
+	}
+	locked := lockFeatures(features)
+	if b.frozen {
+		if !locked.equal(lock) {
+			return fmt.Errorf("%s is out of date with devcontainer.json; build without --frozen to update it", LockfileName)
+		}
+	} else if written, err := locked.write(cfg.dir); err != nil {
+		return err
+	} else if written {
+		progress.Infof("Updated %s", filepath.Join(cfg.dir, LockfileName))
+	}
+	progress.Infof("Installing features in order: %s", featureIDs(features))
+	for _, feat := range features {
+		if feat.Meta.Privileged || len(feat.Meta.CapAdd) > 0 || feat.Meta.Entrypoint != "" {
+			progress.Warnf("feature %s asks for privileges, capabilities or an entrypoint at run time, which kdev's pods do not grant", feat.Meta.ID)
+		}
+	}
+
+	user, err := b.imageUser(ctx, base)
+	if err != nil {
+		return err
+	}
+	containerUser := firstNonEmpty(cfg.ContainerUser, user, "root")
+	remoteUser := firstNonEmpty(cfg.RemoteUser, containerUser)
+
+	buildDir := filepath.Join(dir, "context")
+	if err := os.Mkdir(buildDir, 0o755); err != nil {
+		return err
+	}
+	if err := featuresDockerfile(buildDir, base, user, remoteUser, containerUser, features); err != nil {
+		return err
+	}
+	return b.build(ctx, fmt.Sprintf("Installing features into %s", imageName), BuildOptions{
+		Context:   buildDir,
+		Tag:       imageName,
+		Platform:  b.platform,
+		Labels:    b.imageLabels(cfg),
+		CacheFrom: cacheVariants(b.cacheFrom, "features"),
+		CacheTo:   cacheVariants(b.cacheTo, "features"),
+	})
+}
+
+// imageLabels are the labels of the images of cfg.
+func (b *imageBuild) imageLabels(cfg *DevContainerConfig) map[string]string {
+	labels := map[string]string{ImageLabel: cfg.Name}
+	maps.Copy(labels, b.labels)
+	return labels
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
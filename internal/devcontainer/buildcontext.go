@@ -0,0 +1,202 @@
+package devcontainer
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ContextDockerfile is where PackContext puts the Dockerfile in the
+// archive, so it need not live inside the build context.
+const ContextDockerfile = ".kdev/Dockerfile"
+
+// ignoreRule is one line of a .dockerignore.
+type ignoreRule struct {
+	re      *regexp.Regexp
+	include bool // a !pattern exception
+}
+
+// readDockerignore parses the .dockerignore of the context dir, if any.
+func readDockerignore(dir string) ([]ignoreRule, error) {
+	f, err := os.Open(filepath.Join(dir, ".dockerignore"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var rules []ignoreRule
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		include := strings.HasPrefix(line, "!")
+		line = strings.TrimPrefix(line, "!")
+		line = strings.TrimPrefix(path.Clean(filepath.ToSlash(line)), "/")
+		re, err := ignoreRegexp(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid .dockerignore pattern %q: %w", line, err)
+		}
+		rules = append(rules, ignoreRule{re: re, include: include})
+	}
+	return rules, sc.Err()
+}
+
+// ignoreRegexp translates a .dockerignore pattern: filepath.Match syntax
+// plus ** for any number of directories.
+func ignoreRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if !strings.HasPrefix(pattern[i:], "**") {
+				b.WriteString("[^/]*")
+			} else if strings.HasPrefix(pattern[i:], "**/") {
+				b.WriteString("(.*/)?")
+				i += 2
+			} else {
+				b.WriteString(".*")
+				i++
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// ignored applies rules to the slash-separated path rel like docker: the
+// last rule matching rel or one of its parent directories wins.
+func ignored(rules []ignoreRule, rel string) bool {
+	excluded := false
+	for _, r := range rules {
+		for p := rel; ; {
+			if r.re.MatchString(p) {
+				excluded = !r.include
+				break
+			}
+			i := strings.LastIndexByte(p, '/')
+			if i < 0 {
+				break
+			}
+			p = p[:i]
+		}
+	}
+	return excluded
+}
+
+// PackContext writes the build context dir as a gzipped tar to w, leaving
+// out what its .dockerignore excludes, and adds dockerfile (default: the
+// context's Dockerfile) as ContextDockerfile.
+func PackContext(w io.Writer, dir, dockerfile string) error {
+	if dockerfile == "" {
+		dockerfile = filepath.Join(dir, "Dockerfile")
+	}
+	rules, err := readDockerignore(dir)
+	if err != nil {
+		return err
+	}
+	// With exceptions, an excluded directory may still hold included files.
+	exceptions := false
+	for _, r := range rules {
+		exceptions = exceptions || r.include
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if ignored(rules, rel) {
+			if d.IsDir() && !exceptions {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		return addToTar(tw, p, rel)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to pack build context %s: %w", dir, err)
+	}
+	if err := addToTar(tw, dockerfile, ContextDockerfile); err != nil {
+		return fmt.Errorf("failed to pack %s: %w", dockerfile, err)
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// addToTar writes the file, directory or symlink at p to tw as name.
+func addToTar(tw *tar.Writer, p, name string) error {
+	info, err := os.Lstat(p)
+	if err != nil {
+		return err
+	}
+	link := ""
+	if info.Mode()&fs.ModeSymlink != 0 {
+		if link, err = os.Readlink(p); err != nil {
+			return err
+		}
+	}
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
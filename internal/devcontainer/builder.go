@@ -0,0 +1,155 @@
+package devcontainer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Builder is the container tool kdev builds and pulls images with.
+type Builder string
+
+const (
+	BuilderDocker  Builder = "docker"
+	BuilderPodman  Builder = "podman"
+	BuilderNerdctl Builder = "nerdctl"
+	BuilderBuildah Builder = "buildah"
+)
+
+// Builders lists the supported builders in the order they are detected.
+var Builders = []Builder{BuilderDocker, BuilderPodman, BuilderNerdctl, BuilderBuildah}
+
+// BuilderNames lists the supported builders for help texts.
+func BuilderNames() string {
+	names := make([]string, len(Builders))
+	for i, b := range Builders {
+		names[i] = string(b)
+	}
+	return strings.Join(names, ", ")
+}
+
+// ResolveBuilder returns the builder called name, which must be installed,
+// or for "" and "auto" the first supported one that is.
+func ResolveBuilder(name string) (Builder, error) {
+	if name == "" || name == "auto" {
+		for _, b := range Builders {
+			if _, err := exec.LookPath(string(b)); err == nil {
+				return b, nil
+			}
+		}
+		return "", fmt.Errorf("no image builder found in PATH; install one of %s", BuilderNames())
+	}
+	for _, b := range Builders {
+		if string(b) == name {
+			if _, err := exec.LookPath(name); err != nil {
+				return "", fmt.Errorf("builder %s not found in PATH", name)
+			}
+			return b, nil
+		}
+	}
+	return "", fmt.Errorf("unknown builder %q (want one of %s)", name, BuilderNames())
+}
+
+// BuildOptions describes an image build from a Dockerfile.
+type BuildOptions struct {
+	Dockerfile string // empty for Dockerfile in Context
+	Context    string
+	Tag        string
+	Platform   string
+	Labels     map[string]string
+	Args       map[string]string
+	CacheFrom  []Cache
+	CacheTo    []Cache
+}
+
+// BuildCmd returns the command building o. The four tools share the
+// docker build flags; buildah only needs --layers to cache like the others.
+func (b Builder) BuildCmd(ctx context.Context, o BuildOptions) *exec.Cmd {
+	args := []string{"build"}
+	if b == BuilderBuildah {
+		args = append(args, "--layers")
+	}
+	if o.Platform != "" {
+		args = append(args, "--platform", o.Platform)
+	}
+	if o.Dockerfile != "" {
+		args = append(args, "-f", o.Dockerfile)
+	}
+	args = append(args, "-t", o.Tag)
+	for _, k := range sortedKeys(o.Labels) {
+		args = append(args, "--label", k+"="+o.Labels[k])
+	}
+	for _, k := range sortedKeys(o.Args) {
+		args = append(args, "--build-arg", k+"="+o.Args[k])
+	}
+	for _, c := range o.CacheFrom {
+		args = append(args, "--cache-from", b.cacheArg(c))
+	}
+	for _, c := range o.CacheTo {
+		args = append(args, "--cache-to", b.cacheArg(c))
+	}
+	return exec.CommandContext(ctx, string(b), append(args, o.Context)...)
+}
+
+// cacheArg formats c for --cache-from and --cache-to: docker and nerdctl
+// take buildx syntax, podman and buildah a repository.
+func (b Builder) cacheArg(c Cache) string {
+	if b == BuilderPodman || b == BuilderBuildah {
+		return c.Ref()
+	}
+	return c.String()
+}
+
+// CheckCache reports caches b cannot use.
+func (b Builder) CheckCache(caches []Cache) error {
+	for _, c := range caches {
+		if c.Type != "registry" && (b == BuilderPodman || b == BuilderBuildah) {
+			return fmt.Errorf("%s caches in a registry only; use --cache-from and --cache-to with an image repository", b)
+		}
+	}
+	return nil
+}
+
+// PullCmd returns the command pulling ref.
+func (b Builder) PullCmd(ctx context.Context, ref, platform string) *exec.Cmd {
+	args := []string{"pull"}
+	if platform != "" {
+		args = append(args, "--platform", platform)
+	}
+	return exec.CommandContext(ctx, string(b), append(args, ref)...)
+}
+
+// SaveCmd returns the command writing the local image ref to a
+// docker-archive file, which kdev pushes and trivy reads.
+func (b Builder) SaveCmd(ctx context.Context, ref, file string) *exec.Cmd {
+	if b == BuilderBuildah {
+		return exec.CommandContext(ctx, string(b), "push", ref, "docker-archive:"+file+":"+ref)
+	}
+	return exec.CommandContext(ctx, string(b), "save", "-o", file, ref)
+}
+
+// ImageUser returns the user a local image runs as, "" for root.
+func (b Builder) ImageUser(ctx context.Context, image string) (string, error) {
+	args := []string{"image", "inspect", "--format", "{{.Config.User}}", image}
+	if b == BuilderBuildah {
+		args = []string{"inspect", "--type", "image", "--format", "{{.OCIv1.Config.User}}", image}
+	}
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, string(b), args...)
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to inspect %s: %w", image, err)
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// RunCmd returns the command running args with entrypoint in a throwaway
+// container of image. buildah runs no containers from images directly.
+func (b Builder) RunCmd(ctx context.Context, image, entrypoint string, args ...string) (*exec.Cmd, error) {
+	if b == BuilderBuildah {
+		return nil, fmt.Errorf("buildah cannot run containers")
+	}
+	return exec.CommandContext(ctx, string(b), append([]string{"run", "--rm", "--entrypoint", entrypoint, image}, args...)...), nil
+}
@@ -0,0 +1,90 @@
+package devcontainer
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Cache is a build cache location in buildx syntax: type=registry,ref=REF
+// or type=local,src=DIR (dest=DIR to export), plus attributes such as
+// mode=max.
+type Cache struct {
+	Type  string
+	Attrs map[string]string
+}
+
+// ParseCache parses a --cache-from (export false) or --cache-to value. A
+// value without attributes is short for type=registry,ref=VALUE.
+func ParseCache(s string, export bool) (Cache, error) {
+	c := Cache{Type: "registry", Attrs: map[string]string{}}
+	if !strings.Contains(s, "=") {
+		c.Attrs["ref"] = s
+		return c, nil
+	}
+	c.Type = ""
+	for _, field := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(field, "=")
+		if !ok {
+			return Cache{}, fmt.Errorf("invalid cache %q: %q is not KEY=VALUE", s, field)
+		}
+		if k == "type" {
+			c.Type = v
+		} else {
+			c.Attrs[k] = v
+		}
+	}
+	dir := "src"
+	if export {
+		dir = "dest"
+	}
+	switch {
+	case c.Type == "registry" && c.Attrs["ref"] == "":
+		return Cache{}, fmt.Errorf("invalid cache %q: type=registry needs ref=IMAGE", s)
+	case c.Type == "local" && c.Attrs[dir] == "":
+		return Cache{}, fmt.Errorf("invalid cache %q: type=local needs %s=DIR", s, dir)
+	case c.Type != "registry" && c.Type != "local":
+		return Cache{}, fmt.Errorf("invalid cache %q: type must be registry or local", s)
+	}
+	return c, nil
+}
+
+// String returns c in buildx syntax.
+func (c Cache) String() string {
+	fields := []string{"type=" + c.Type}
+	for _, k := range sortedKeys(c.Attrs) {
+		fields = append(fields, k+"="+c.Attrs[k])
+	}
+	return strings.Join(fields, ",")
+}
+
+// Ref is the image reference of a registry cache.
+func (c Cache) Ref() string {
+	return c.Attrs["ref"]
+}
+
+// variant returns a cache of its own for part of a build, such as one
+// platform or the features, so they do not overwrite each other: the
+// reference or directory gets the part appended.
+func (c Cache) variant(part string) Cache {
+	part = strings.ReplaceAll(part, "/", "-")
+	v := Cache{Type: c.Type, Attrs: map[string]string{}}
+	for k, val := range c.Attrs {
+		switch k {
+		case "ref":
+			val += "-" + part
+		case "src", "dest":
+			val = filepath.Join(val, part)
+		}
+		v.Attrs[k] = val
+	}
+	return v
+}
+
+func cacheVariants(caches []Cache, part string) []Cache {
+	var out []Cache
+	for _, c := range caches {
+		out = append(out, c.variant(part))
+	}
+	return out
+}
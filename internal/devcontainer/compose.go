@@ -0,0 +1,345 @@
+package devcontainer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Compose is the part of a docker-compose file kdev understands.
+type Compose struct {
+	Services map[string]ComposeService `json:"services"`
+}
+
+// ComposeService is a service of a docker-compose file.
+type ComposeService struct {
+	Image string `json:"image,omitempty"`
+	// Build is set when the service builds its own image.
+	Build       interface{}   `json:"build,omitempty"`
+	Entrypoint  ShellCommand  `json:"entrypoint,omitempty"`
+	Command     ShellCommand  `json:"command,omitempty"`
+	Environment Environment   `json:"environment,omitempty"`
+	Ports       []interface{} `json:"ports,omitempty"`
+	Expose      []interface{} `json:"expose,omitempty"`
+	Volumes     []interface{} `json:"volumes,omitempty"`
+	User        string        `json:"user,omitempty"`
+	WorkingDir  string        `json:"working_dir,omitempty"`
+}
+
+// ShellCommand is a compose command or entrypoint: a list, or a string
+// split into words like a shell would.
+type ShellCommand []string
+
+func (c *ShellCommand) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		words, err := splitWords(s)
+		*c = words
+		return err
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return errors.New("a command must be a string or a list of strings")
+	}
+	*c = list
+	return nil
+}
+
+// Environment is a compose environment, given as a map or as a list of
+// KEY=VALUE. Variables without a value are taken from the local
+// environment, as compose does.
+type Environment map[string]string
+
+func (e *Environment) UnmarshalJSON(data []byte) error {
+	*e = Environment{}
+	var list []string
+	if err := json.Unmarshal(data, &list); err == nil {
+		for _, kv := range list {
+			k, v, ok := strings.Cut(kv, "=")
+			if !ok {
+				v, ok = os.LookupEnv(k)
+				if !ok {
+					continue
+				}
+			}
+			(*e)[k] = v
+		}
+		return nil
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return errors.New("environment must be a map or a list of KEY=VALUE")
+	}
+	for k, v := range m {
+		switch v := v.(type) {
+		case nil:
+			if s, ok := os.LookupEnv(k); ok {
+				(*e)[k] = s
+			}
+		case string:
+			(*e)[k] = v
+		default:
+			(*e)[k] = fmt.Sprint(v)
+		}
+	}
+	return nil
+}
+
+// ComposeFiles returns the dockerComposeFile paths, relative to the
+// devcontainer.json, as paths usable from the working directory.
+func (c *DevContainerConfig) ComposeFiles() ([]string, error) {
+	var files []string
+	switch v := c.DockerComposeFile.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		files = []string{v}
+	case []interface{}:
+		for _, f := range v {
+			s, ok := f.(string)
+			if !ok {
+				return nil, errors.New("dockerComposeFile must be a string or a list of strings")
+			}
+			files = append(files, s)
+		}
+	default:
+		return nil, errors.New("dockerComposeFile must be a string or a list of strings")
+	}
+	for i, f := range files {
+		if !filepath.IsAbs(f) {
+			files[i] = filepath.Join(c.dir, f)
+		}
+	}
+	return files, nil
+}
+
+// LoadCompose reads and merges the compose files of c, later files
+// overriding earlier ones as with docker compose -f a -f b. It returns nil
+// when c does not use docker-compose.
+func (c *DevContainerConfig) LoadCompose() (*Compose, error) {
+	files, err := c.ComposeFiles()
+	if err != nil || len(files) == 0 {
+		return nil, err
+	}
+	if c.Service == "" {
+		return nil, errors.New("devcontainer.json sets dockerComposeFile but no service")
+	}
+	merged := map[string]interface{}{}
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", f, err)
+		}
+		var doc map[string]interface{}
+		if err := yaml.Unmarshal([]byte(interpolate(string(data))), &doc); err != nil {
+			return nil, fmt.Errorf("invalid compose file %s: %w", f, err)
+		}
+		normalizeEnvironment(doc)
+		mergeMaps(merged, doc)
+	}
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	var comp Compose
+	if err := json.Unmarshal(data, &comp); err != nil {
+		return nil, fmt.Errorf("invalid compose file: %w", err)
+	}
+	if _, ok := comp.Services[c.Service]; !ok {
+		return nil, fmt.Errorf("service %q of devcontainer.json is not in %s", c.Service, strings.Join(files, ", "))
+	}
+	return &comp, nil
+}
+
+// normalizeEnvironment turns environment lists into maps, so environments
+// merge key by key across files.
+func normalizeEnvironment(doc map[string]interface{}) {
+	services, _ := doc["services"].(map[string]interface{})
+	for _, svc := range services {
+		svc, _ := svc.(map[string]interface{})
+		list, ok := svc["environment"].([]interface{})
+		if !ok {
+			continue
+		}
+		env := map[string]interface{}{}
+		for _, kv := range list {
+			k, v, hasValue := strings.Cut(fmt.Sprint(kv), "=")
+			if hasValue {
+				env[k] = v
+			} else {
+				env[k] = nil
+			}
+		}
+		svc["environment"] = env
+	}
+}
+
+// mergeMaps merges src into dst: maps key by key, anything else replaced.
+func mergeMaps(dst, src map[string]interface{}) {
+	for k, v := range src {
+		sv, ok1 := v.(map[string]interface{})
+		dv, ok2 := dst[k].(map[string]interface{})
+		if ok1 && ok2 {
+			mergeMaps(dv, sv)
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// composeVar matches $$, $VAR, ${VAR}, ${VAR:-default} and ${VAR-default}.
+var composeVar = regexp.MustCompile(`\$\$|\$([A-Za-z_][A-Za-z0-9_]*)|\$\{([A-Za-z_][A-Za-z0-9_]*)(?:(:?-)([^}]*))?\}`)
+
+// interpolate substitutes the local environment into a compose file.
+func interpolate(s string) string {
+	return composeVar.ReplaceAllStringFunc(s, func(ref string) string {
+		if ref == "$$" {
+			return "$"
+		}
+		m := composeVar.FindStringSubmatch(ref)
+		name := m[1] + m[2]
+		v, ok := os.LookupEnv(name)
+		switch m[3] {
+		case ":-":
+			if v == "" {
+				return m[4]
+			}
+		case "-":
+			if !ok {
+				return m[4]
+			}
+		}
+		return v
+	})
+}
+
+// ContainerPorts returns the container side of the ports and expose
+// entries of s.
+func (s ComposeService) ContainerPorts() ([]int, error) {
+	var ports []int
+	for _, raw := range append(append([]interface{}{}, s.Ports...), s.Expose...) {
+		var spec string
+		switch v := raw.(type) {
+		case float64:
+			spec = strconv.Itoa(int(v))
+		case string:
+			spec = v
+		case map[string]interface{}:
+			spec = fmt.Sprint(v["target"])
+		default:
+			return nil, fmt.Errorf("invalid port %v", raw)
+		}
+		// [[ip:]published:]target[/protocol]; only the target matters.
+		spec, _, _ = strings.Cut(spec, "/")
+		spec = spec[strings.LastIndex(spec, ":")+1:]
+		lo, hi, isRange := strings.Cut(spec, "-")
+		first, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %v", raw)
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.Atoi(hi); err != nil {
+				return nil, fmt.Errorf("invalid port %v", raw)
+			}
+		}
+		for p := first; p <= last; p++ {
+			ports = append(ports, p)
+		}
+	}
+	return ports, nil
+}
+
+// Mounts returns the volumes of s. Named volumes are prefixed with project,
+// as compose scopes them to the project.
+func (s ComposeService) Mounts(project string) ([]Mount, error) {
+	var mounts []Mount
+	for _, raw := range s.Volumes {
+		var m Mount
+		switch v := raw.(type) {
+		case string:
+			parts := strings.Split(v, ":")
+			switch len(parts) {
+			case 1:
+				m.Target = parts[0]
+			case 2, 3:
+				m.Source, m.Target = parts[0], parts[1]
+				m.ReadOnly = len(parts) == 3 && slices.Contains(strings.Split(parts[2], ","), "ro")
+			default:
+				return nil, fmt.Errorf("invalid volume %q", v)
+			}
+			m.Type = "volume"
+			if strings.HasPrefix(m.Source, ".") || strings.HasPrefix(m.Source, "/") || strings.HasPrefix(m.Source, "~") {
+				m.Type = "bind"
+			}
+		case map[string]interface{}:
+			m.Type, _ = v["type"].(string)
+			m.Source, _ = v["source"].(string)
+			m.Target, _ = v["target"].(string)
+			m.ReadOnly, _ = v["read_only"].(bool)
+		default:
+			return nil, fmt.Errorf("invalid volume %v", raw)
+		}
+		if err := m.validate(); err != nil {
+			return nil, fmt.Errorf("invalid volume %v: %w", raw, err)
+		}
+		if m.Type == "volume" && m.Source != "" {
+			m.Source = project + "_" + m.Source
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, nil
+}
+
+// splitWords splits s into words, honouring single and double quotes and
+// backslash escapes.
+func splitWords(s string) ([]string, error) {
+	var (
+		words   []string
+		word    strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range s {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote in %q", s)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
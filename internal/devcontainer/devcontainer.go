@@ -1,32 +1,95 @@
 package devcontainer
 
 import (
+	"cmp"
+	"context"
 	"encoding/json"
 	"fmt"
-	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strings"
 
+	"github.com/noopduck/kdev/internal/config"
+	"github.com/noopduck/kdev/internal/progress"
 	"github.com/spf13/cobra"
 )
 
+// ImageLabel is set on images built by kdev to the devcontainer name, so
+// kdev image ls can find them in a registry.
+const ImageLabel = "dev.kdev.devcontainer"
+
+// DefaultPath is where kdev looks for devcontainer.json.
+const DefaultPath = ".devcontainer/devcontainer.json"
+
 // Minimal struktur av devcontainer.json
 type DevContainerConfig struct {
-	Name  string `json:"name"`
-	Build struct {
+	Name string `json:"name"`
+	// Image is a prebuilt image used instead of building Build.
+	Image string `json:"image,omitempty"`
+	// DockerComposeFile is a path or list of paths, relative to the
+	// devcontainer.json; see LoadCompose.
+	DockerComposeFile interface{} `json:"dockerComposeFile,omitempty"`
+	Service           string      `json:"service,omitempty"`
+	RunServices       []string    `json:"runServices,omitempty"`
+	Build             struct {
 		Dockerfile string            `json:"dockerfile"`
 		Context    string            `json:"context"`
 		Args       map[string]string `json:"args"`
 	} `json:"build"`
-	Features       map[string]interface{} `json:"features,omitempty"`
-	Customizations struct {
+	Features map[string]interface{} `json:"features,omitempty"`
+	// OverrideFeatureInstallOrder lists features to install first, in order.
+	OverrideFeatureInstallOrder []string `json:"overrideFeatureInstallOrder,omitempty"`
+	Customizations              struct {
 		VSCode struct {
 			Extensions []string `json:"extensions,omitempty"`
 		} `json:"vscode,omitempty"`
 	} `json:"customizations,omitempty"`
-	RemoteUser string `json:"remoteUser,omitempty"`
+	RemoteUser    string `json:"remoteUser,omitempty"`
+	ContainerUser string `json:"containerUser,omitempty"`
+	// ContainerEnv is set on the container itself.
+	ContainerEnv map[string]string `json:"containerEnv,omitempty"`
+	// RemoteEnv only applies to the processes kdev starts in the container;
+	// a null value unsets the variable.
+	RemoteEnv       map[string]*string `json:"remoteEnv,omitempty"`
+	WorkspaceFolder string             `json:"workspaceFolder,omitempty"`
+	// ForwardPorts holds port numbers and "host:port" strings; see Ports.
+	ForwardPorts         []interface{}             `json:"forwardPorts,omitempty"`
+	PortsAttributes      map[string]PortAttributes `json:"portsAttributes,omitempty"`
+	OtherPortsAttributes *PortAttributes           `json:"otherPortsAttributes,omitempty"`
+	Mounts               []Mount                   `json:"mounts,omitempty"`
+	// OverrideCommand false keeps the image's ENTRYPOINT and CMD running
+	// instead of kdev's keepalive.
+	OverrideCommand *bool `json:"overrideCommand,omitempty"`
+	Lifecycle
+
+	// dir is the directory of the devcontainer.json.
+	dir string
+}
+
+// Prebuilt reports whether the devcontainer uses its image field rather than
+// a Dockerfile.
+func (c *DevContainerConfig) Prebuilt() bool {
+	return c.Image != "" && c.Build.Dockerfile == ""
+}
+
+// buildPaths returns the Dockerfile and build context of c, which are
+// relative to its devcontainer.json.
+func (c *DevContainerConfig) buildPaths() (dockerfile, contextDir string) {
+	dockerfile, contextDir = c.Build.Dockerfile, c.Build.Context
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+	if contextDir == "" {
+		contextDir = "."
+	}
+	if !filepath.IsAbs(dockerfile) {
+		dockerfile = filepath.Join(c.dir, dockerfile)
+	}
+	if !filepath.IsAbs(contextDir) {
+		contextDir = filepath.Join(c.dir, contextDir)
+	}
+	return dockerfile, contextDir
 }
 
 func sanitizeImageNamePart(s string) string {
@@ -41,14 +104,28 @@ func sanitizeImageNamePart(s string) string {
 }
 
 // Ny kommando: `kdev devcontainer build`
-func CmdDevContainer() *cobra.Command {
+// inCluster are the backends of build --in-cluster, by name.
+func CmdDevContainer(inCluster map[string]RemoteBuilder) *cobra.Command {
 	var (
 		push             bool
 		imageName        string
 		registry         string
 		tag              string
-		platform         string
+		platforms        []string
 		useDevcontainers bool
+		frozen           bool
+		builderName      string
+		inClusterMode    string
+		cacheFrom        []string
+		cacheTo          []string
+		sign             bool
+		signKey          string
+		scan             bool
+		scanSeverity     string
+		scanWarnOnly     bool
+		sbomFormat       string
+		sbomDir          string
+		attachSBOM       bool
 	)
 
 	c := &cobra.Command{
@@ -60,92 +137,202 @@ func CmdDevContainer() *cobra.Command {
 		Use:   "build",
 		Short: "Build a .devcontainer image based on devcontainer.json",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			cfg, err := readDevContainerConfig(".devcontainer/devcontainer.json")
+			cfg, err := readDevContainerConfig(DefaultPath)
 			if err != nil {
 				return err
 			}
+			userCfg, err := config.Load()
+			if err != nil {
+				return err
+			}
+			offline, _ := cmd.Flags().GetBool("offline")
+			offline = offline || userCfg.Offline
 
-			// Default fallbacks
-			if cfg.Build.Dockerfile == "" {
-				cfg.Build.Dockerfile = "Dockerfile"
+			var signing config.Signing
+			if userCfg.Signing != nil {
+				signing = *userCfg.Signing
 			}
-			if cfg.Build.Context == "" {
-				cfg.Build.Context = "."
+			var scanCfg config.Scan
+			if userCfg.Scan != nil {
+				scanCfg = *userCfg.Scan
 			}
-
-			// If features are present and user requested it, use the devcontainers CLI to build
-			if len(cfg.Features) > 0 && useDevcontainers {
-				if imageName == "" {
-					if registry == "" || tag == "" {
-						return fmt.Errorf("either --image or both --registry and --tag must be provided (required when using devcontainers CLI)")
-					}
-					imageName = fmt.Sprintf("%s/%s:%s", registry, sanitizeImageNamePart(cfg.Name), tag)
+			var policy *scanPolicy
+			if scan || scanCfg.Enabled {
+				if !cmd.Flags().Changed("scan-warn-only") {
+					scanWarnOnly = scanCfg.WarnOnly
 				}
-				fmt.Printf("🚧 Building with devcontainers CLI (features detected)...\n")
-				cmdArgs := []string{"build", "--workspace-folder", ".", "--image-name", imageName}
-				if platform != "" {
-					cmdArgs = append(cmdArgs, "--platform", platform)
+				if policy, err = newScanPolicy(cmp.Or(scanSeverity, scanCfg.Severity), scanWarnOnly, scanCfg.IgnoreUnfixed); err != nil {
+					return err
 				}
-				dc := exec.Command("devcontainer", cmdArgs...)
-				dc.Stdout = os.Stdout
-				dc.Stderr = os.Stderr
-				return dc.Run()
 			}
-
-			// Resolve image name:
-			// - If --image provided, use that (may include registry and tag)
-			// - Otherwise require both --registry and --tag
-			if imageName == "" {
-				if registry == "" || tag == "" {
-					return fmt.Errorf("either --image or both --registry and --tag must be provided")
+			if sign && !push && inClusterMode == "" {
+				return fmt.Errorf("--sign signs the image in the registry; add --push")
+			}
+			var sbomCfg config.SBOM
+			if userCfg.SBOM != nil {
+				sbomCfg = *userCfg.SBOM
+			}
+			if !cmd.Flags().Changed("attach-sbom") {
+				attachSBOM = sbomCfg.Attach
+			}
+			var sbom *sbomPolicy
+			if sbomFormat = cmp.Or(sbomFormat, sbomCfg.Format); sbomFormat != "" {
+				if sbom, err = newSBOMPolicy(sbomFormat, sbomDir, attachSBOM); err != nil {
+					return err
 				}
-				imageName = fmt.Sprintf("%s/%s:%s", registry, sanitizeImageNamePart(cfg.Name), tag)
+				if attachSBOM && !push && inClusterMode == "" {
+					return fmt.Errorf("--attach-sbom attaches the SBOM to the image in the registry; add --push")
+				}
+			} else if attachSBOM {
+				return fmt.Errorf("--attach-sbom needs an SBOM; add --sbom spdx or --sbom cyclonedx")
 			}
 
-			dockerfile := filepath.Join(".devcontainer", cfg.Build.Dockerfile)
-			context := cfg.Build.Context
-
-			// validate dockerfile exists
-			if _, err := os.Stat(dockerfile); err != nil {
-				return fmt.Errorf("dockerfile not found: %s", dockerfile)
+			if cfg.DockerComposeFile != nil {
+				return fmt.Errorf("devcontainer.json uses docker-compose: build and push its images with docker compose build and docker compose push")
+			}
+			hasFeatures := len(cfg.Features) > 0
+			// A prebuilt image needs no build, unless features go on top.
+			if cfg.Prebuilt() && !hasFeatures {
+				progress.Infof("devcontainer.json uses the prebuilt image %s; nothing to build", cfg.Image)
+				fmt.Printf("✅ Devcontainer image ready: %s\n", cfg.Image)
+				return nil
 			}
 
-			// Build args (handle nil map)
-			var buildArgs []string
-			if cfg.Build.Args != nil {
-				for k, v := range cfg.Build.Args {
-					buildArgs = append(buildArgs, "--build-arg", fmt.Sprintf("%s=%s", k, v))
+			if imageName, err = ImageName(cmd.Context(), cfg, imageName, cmp.Or(registry, userCfg.Registry), tag); err != nil {
+				return err
+			}
+			src := readGitSource(cmd.Context(), ".")
+			var labels map[string]string
+			if src != nil {
+				labels = src.Labels()
+				progress.Infof("Image %s is built from commit %s", imageName, src.Commit)
+			}
+			var remote RemoteBuilder
+			if inClusterMode != "" {
+				if remote = inCluster[inClusterMode]; remote == nil {
+					return fmt.Errorf("unknown --in-cluster %q (want one of %s)", inClusterMode, strings.Join(sortedKeys(inCluster), ", "))
+				}
+				if useDevcontainers {
+					return fmt.Errorf("--in-cluster cannot build with the devcontainers CLI; build without --use-devcontainers-cli")
+				}
+				// The image is pushed from the cluster as it is built.
+				if err := checkOfflinePush(imageName, offline); err != nil {
+					return err
+				}
+			}
+			var builder Builder
+			if remote == nil {
+				if builder, err = ResolveBuilder(cmp.Or(builderName, userCfg.Builder)); err != nil {
+					return err
+				}
+			}
+			var from, to []Cache
+			for _, v := range cacheFrom {
+				c, err := ParseCache(v, false)
+				if err != nil {
+					return err
+				}
+				from = append(from, c)
+			}
+			for _, v := range cacheTo {
+				c, err := ParseCache(v, true)
+				if err != nil {
+					return err
 				}
+				to = append(to, c)
 			}
-			// pass remoteUser as build-arg so Dockerfile can use it if desired
-			if cfg.RemoteUser != "" {
-				buildArgs = append(buildArgs, "--build-arg", fmt.Sprintf("REMOTE_USER=%s", cfg.RemoteUser))
+			if remote == nil {
+				if err := builder.CheckCache(append(from, to...)); err != nil {
+					return err
+				}
 			}
 
-			// Compose docker build args
-			base := []string{"build"}
-			if platform != "" {
-				base = append(base, "--platform", platform)
+			// If features are present and user requested it, use the devcontainers CLI to build
+			if hasFeatures && useDevcontainers {
+				if offline {
+					return fmt.Errorf("devcontainer features are downloaded from their registries, which offline mode forbids; build without --use-devcontainers-cli")
+				}
+				if sbom != nil && sbom.attach {
+					return fmt.Errorf("--attach-sbom needs kdev to push the image; build without --use-devcontainers-cli")
+				}
+				if sign {
+					return fmt.Errorf("--sign needs kdev to push the image; build without --use-devcontainers-cli")
+				}
+				if signing.Always && push {
+					return fmt.Errorf("signing.always in the user config signs pushed images, which needs kdev to push them; build without --use-devcontainers-cli")
+				}
+				cmdArgs := []string{"build", "--workspace-folder", ".", "--image-name", imageName}
+				if len(platforms) > 1 {
+					return fmt.Errorf("the devcontainers CLI builds one platform at a time; build without --use-devcontainers-cli")
+				}
+				if len(platforms) > 0 {
+					cmdArgs = append(cmdArgs, "--platform", platforms[0])
+				}
+				if frozen {
+					cmdArgs = append(cmdArgs, "--experimental-frozen-lockfile")
+				}
+				for _, k := range sortedKeys(labels) {
+					cmdArgs = append(cmdArgs, "--label", k+"="+labels[k])
+				}
+				for _, c := range from {
+					cmdArgs = append(cmdArgs, "--cache-from", c.String())
+				}
+				for _, c := range to {
+					cmdArgs = append(cmdArgs, "--cache-to", c.String())
+				}
+				switch builder {
+				case BuilderDocker:
+				case BuilderPodman:
+					cmdArgs = append(cmdArgs, "--docker-path", string(builder))
+				default:
+					return fmt.Errorf("the devcontainers CLI cannot build with %s; use docker or podman, or build without --use-devcontainers-cli", builder)
+				}
+				dc := exec.Command("devcontainer", cmdArgs...)
+				if err := progress.Run(fmt.Sprintf("Building %s with devcontainers CLI (features detected)", imageName), dc); err != nil {
+					return fmt.Errorf("devcontainer build failed: %w", err)
+				}
+				inspected := &imageBuild{builder: builder, offline: offline, scan: policy, sbom: sbom}
+				defer inspected.close()
+				if err := inspected.inspectImage(cmd.Context(), imageName); err != nil {
+					return err
+				}
+				fmt.Printf("✅ Devcontainer image ready: %s\n", imageName)
+				return nil
 			}
-			base = append(base, "-f", dockerfile, "-t", imageName)
-			argsList := append(base, buildArgs...)
-			argsList = append(argsList, context)
 
-			fmt.Printf("🚧 Building %s from %s\n", imageName, dockerfile)
-			build := exec.Command("docker", argsList...)
-			build.Stdout = os.Stdout
-			build.Stderr = os.Stderr
-			if err := build.Run(); err != nil {
-				return fmt.Errorf("docker build failed: %w", err)
+			b := &imageBuild{builder: builder, mirrors: userCfg.Mirrors, offline: offline, frozen: frozen, remote: remote, cacheFrom: from, cacheTo: to, scan: policy, sbom: sbom, labels: labels}
+			defer b.close()
+			if len(platforms) > 1 {
+				// The manifest list is assembled in the registry.
+				if !push && remote == nil {
+					return fmt.Errorf("building for several platforms pushes a manifest list; add --push")
+				}
+				if err := b.multiPlatform(cmd.Context(), cfg, imageName, platforms); err != nil {
+					return err
+				}
+			} else {
+				if len(platforms) > 0 {
+					b.platform = platforms[0]
+				}
+				if err := b.image(cmd.Context(), cfg, imageName); err != nil {
+					return err
+				}
+				if err := b.inspectImage(cmd.Context(), imageName); err != nil {
+					return err
+				}
+				if push {
+					if err := b.push(cmd.Context(), imageName); err != nil {
+						return err
+					}
+				}
+				if err := b.attachSBOM(cmd.Context(), imageName); err != nil {
+					return err
+				}
 			}
 
-			if push {
-				fmt.Printf("📦 Pushing %s...\n", imageName)
-				pushCmd := exec.Command("docker", "push", imageName)
-				pushCmd.Stdout = os.Stdout
-				pushCmd.Stderr = os.Stderr
-				if err := pushCmd.Run(); err != nil {
-					return fmt.Errorf("docker push failed: %w", err)
+			if sign || (signing.Always && (push || remote != nil)) {
+				if err := signImage(cmd.Context(), imageName, cmp.Or(signKey, signing.Key), len(platforms) > 1, offline); err != nil {
+					return err
 				}
 			}
 
@@ -155,25 +342,138 @@ func CmdDevContainer() *cobra.Command {
 	}
 
 	buildCmd.Flags().BoolVar(&push, "push", false, "Push the built image to registry")
-	buildCmd.Flags().StringVar(&imageName, "image", "", "Override image name (can include registry and tag)")
-	buildCmd.Flags().StringVar(&registry, "registry", "", "Container registry (e.g. harbor.example.com) — required if --image not set")
-	buildCmd.Flags().StringVar(&tag, "tag", "", "Image tag (required if --image not set)")
-	buildCmd.Flags().StringVar(&platform, "platform", "", "Target platform (e.g. linux/arm64)")
-	buildCmd.Flags().BoolVar(&useDevcontainers, "use-devcontainers-cli", false, "If features are present, invoke the devcontainers CLI to build the image")
+	buildCmd.Flags().StringVar(&imageName, "image", "", "Override image name (can include registry and tag; without a tag it comes from git)")
+	buildCmd.Flags().StringVar(&registry, "registry", "", "Container registry (e.g. harbor.example.com) — required if --image not set (default: registry in the user config)")
+	buildCmd.Flags().StringVar(&tag, "tag", "", "Image tag (default: from git, <branch>-<commit>[-dirty])")
+	buildCmd.Flags().StringSliceVar(&platforms, "platform", nil, "Target platform (e.g. linux/arm64); repeat or comma-separate for a multi-platform image")
+	buildCmd.Flags().BoolVar(&useDevcontainers, "use-devcontainers-cli", false, "Install features with the devcontainers CLI instead of kdev's own implementation")
+	buildCmd.Flags().StringVar(&builderName, "builder", "", "Tool that builds and pushes the image: "+BuilderNames()+" or auto (default: builder in the user config, then the first installed)")
+	buildCmd.Flags().StringVar(&inClusterMode, "in-cluster", "", "Build and push in the cluster instead of locally: "+strings.Join(sortedKeys(inCluster), ", "))
+	buildCmd.Flags().StringArrayVar(&cacheFrom, "cache-from", nil, "Import build cache: type=registry,ref=IMAGE (or just IMAGE) or type=local,src=DIR (repeatable)")
+	buildCmd.Flags().StringArrayVar(&cacheTo, "cache-to", nil, "Export build cache: type=registry,ref=IMAGE[,mode=max] (or just IMAGE) or type=local,dest=DIR (repeatable)")
+	buildCmd.Flags().BoolVar(&sign, "sign", false, "Sign the pushed image with cosign (keyless unless a key is set)")
+	buildCmd.Flags().StringVar(&signKey, "sign-key", "", "cosign key to sign with: a file or KMS URI (default: signing.key in the user config)")
+	buildCmd.Flags().BoolVar(&scan, "scan", false, "Scan the image for vulnerabilities with trivy before pushing it")
+	buildCmd.Flags().StringVar(&scanSeverity, "scan-severity", "", "Lowest severity that fails the scan: LOW, MEDIUM, HIGH or CRITICAL (default CRITICAL)")
+	buildCmd.Flags().BoolVar(&scanWarnOnly, "scan-warn-only", false, "Report vulnerabilities without failing the build")
+	buildCmd.Flags().StringVar(&sbomFormat, "sbom", "", "Write an SBOM of the image with trivy: spdx or cyclonedx")
+	buildCmd.Flags().StringVar(&sbomDir, "sbom-dir", ".", "Directory to write the SBOM to")
+	buildCmd.Flags().BoolVar(&attachSBOM, "attach-sbom", false, "Attach the SBOM to the pushed image as an OCI referrer")
+	buildCmd.Flags().BoolVar(&frozen, "frozen", false, "Install features exactly as pinned in devcontainer-lock.json; fail if it is missing or out of date")
+	_ = buildCmd.RegisterFlagCompletionFunc("builder", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return append(strings.Split(BuilderNames(), ", "), "auto"), cobra.ShellCompDirectiveNoFileComp
+	})
+	_ = buildCmd.RegisterFlagCompletionFunc("in-cluster", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return sortedKeys(inCluster), cobra.ShellCompDirectiveNoFileComp
+	})
 	c.AddCommand(buildCmd)
 
+	validateCmd := &cobra.Command{
+		Use:   "validate [PATH]",
+		Short: "Check devcontainer.json against the schema and what kdev supports",
+		Long: `Validate reports unknown or misspelled properties and wrong types, checks
+that the Dockerfile, build context, compose files and local features it
+references exist, and warns about properties kdev cannot honor in
+Kubernetes. PATH defaults to ` + DefaultPath + `.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := DefaultPath
+			if len(args) > 0 {
+				path = args[0]
+			}
+			problems, err := Validate(path)
+			if err != nil {
+				return err
+			}
+			errs := 0
+			for _, p := range problems {
+				if !p.Warning {
+					errs++
+				}
+				fmt.Printf("%s: %s\n", path, p)
+			}
+			if errs > 0 {
+				return fmt.Errorf("%s has %d errors", path, errs)
+			}
+			if len(problems) == 0 {
+				fmt.Printf("%s: ok\n", path)
+			}
+			return nil
+		},
+	}
+	c.AddCommand(validateCmd)
+
 	return c
 }
 
+// DefaultRepository is the repository devcontainer build pushes to when
+// given --registry: the sanitized devcontainer name. It returns "" when
+// there is no devcontainer.json in the current directory.
+func DefaultRepository() string {
+	cfg, err := readDevContainerConfig(DefaultPath)
+	if err != nil {
+		return ""
+	}
+	return sanitizeImageNamePart(cfg.Name)
+}
+
+// Load reads the devcontainer.json at path, layered over the configs it
+// extends and under its devcontainer.local.json, with its variables
+// substituted.
+func Load(path string) (*DevContainerConfig, error) {
+	return readDevContainerConfig(path)
+}
+
+// ImageName resolves the name kdev devcontainer build gives the image of
+// cfg: image if given, else <registry>/<name>:<tag>. A tag in image wins
+// over tag; without either, the tag is derived from the git checkout of
+// the current directory.
+func ImageName(ctx context.Context, cfg *DevContainerConfig, image, registry, tag string) (string, error) {
+	src := readGitSource(ctx, ".")
+	if image != "" {
+		if hasTag(image) {
+			return image, nil
+		}
+		if tag != "" {
+			return image + ":" + tag, nil
+		}
+		if src == nil {
+			return "", fmt.Errorf("--image %s has no tag, and the directory is no git checkout to derive one from", image)
+		}
+		return image + ":" + src.Tag(), nil
+	}
+	if registry == "" {
+		return "", fmt.Errorf("either --image or --registry must be provided")
+	}
+	if tag == "" {
+		if src == nil {
+			return "", fmt.Errorf("--tag must be provided outside a git checkout")
+		}
+		tag = src.Tag()
+	}
+	return fmt.Sprintf("%s/%s:%s", registry, sanitizeImageNamePart(cfg.Name), tag), nil
+}
+
 func readDevContainerConfig(path string) (*DevContainerConfig, error) {
-	f, err := os.ReadFile(path)
+	raw, err := loadLayered(path)
+	if err != nil {
+		return nil, err
+	}
+	workspaceFolder, _ := raw["workspaceFolder"].(string)
+	vars, err := newVariables(path, workspaceFolder)
+	if err != nil {
+		return nil, err
+	}
+	expanded, err := json.Marshal(vars.expandAll(raw))
 	if err != nil {
-		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		return nil, err
 	}
 	var cfg DevContainerConfig
-	if err := json.Unmarshal(f, &cfg); err != nil {
-		return nil, fmt.Errorf("invalid JSON: %w", err)
+	if err := json.Unmarshal(expanded, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid devcontainer.json %s: %w", path, err)
 	}
+	cfg.WorkspaceFolder = vars.containerWorkspaceFolder
+	cfg.dir = filepath.Dir(path)
 	if cfg.Name == "" {
 		cfg.Name = "devcontainer"
 	}
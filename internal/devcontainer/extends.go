@@ -0,0 +1,174 @@
+package devcontainer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// LocalOverrideName is the personal devcontainer.json layered over the
+// shared one in the same directory, meant to stay out of version control.
+const LocalOverrideName = "devcontainer.local.json"
+
+// unionKeys are the arrays that accumulate across layers instead of being
+// replaced.
+var unionKeys = map[string]bool{
+	"forwardPorts": true,
+	"mounts":       true,
+	"runServices":  true,
+	"extensions":   true,
+}
+
+// loadLayered reads the devcontainer.json at path with the configs it
+// extends underneath and its local override on top, merged into one raw
+// document.
+func loadLayered(path string) (map[string]interface{}, error) {
+	raw, err := loadExtends(path, filepath.Dir(path), nil)
+	if err != nil {
+		return nil, err
+	}
+	local := filepath.Join(filepath.Dir(path), LocalOverrideName)
+	if filepath.Base(path) == LocalOverrideName {
+		return raw, nil
+	}
+	if _, err := os.Stat(local); err == nil {
+		override, err := loadExtends(local, filepath.Dir(path), nil)
+		if err != nil {
+			return nil, err
+		}
+		mergeLayer(raw, override)
+	}
+	return raw, nil
+}
+
+// loadExtends reads path and the configs named by its "extends", a path or
+// list of paths relative to it, merged in order below it. Relative file
+// references of every layer are rebased onto mainDir, the directory of the
+// config being loaded. stack holds the files being read, to catch cycles.
+func loadExtends(path, mainDir string, stack []string) (map[string]interface{}, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(stack, abs) {
+		return nil, fmt.Errorf("devcontainer.json extends itself: %s", strings.Join(append(stack, abs), " -> "))
+	}
+	stack = append(stack, abs)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	var raw map[string]interface{}
+	if err := unmarshalJSONC(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid JSON in %s: %w", path, err)
+	}
+	rebasePaths(raw, filepath.Dir(path), mainDir)
+
+	var bases []string
+	switch v := raw["extends"].(type) {
+	case nil:
+	case string:
+		bases = []string{v}
+	case []interface{}:
+		for _, b := range v {
+			s, ok := b.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: extends must be a path or a list of paths", path)
+			}
+			bases = append(bases, s)
+		}
+	default:
+		return nil, fmt.Errorf("%s: extends must be a path or a list of paths", path)
+	}
+	delete(raw, "extends")
+	if len(bases) == 0 {
+		return raw, nil
+	}
+
+	merged := map[string]interface{}{}
+	for _, base := range bases {
+		if !filepath.IsAbs(base) {
+			base = filepath.Join(filepath.Dir(path), base)
+		}
+		layer, err := loadExtends(base, mainDir, stack)
+		if err != nil {
+			return nil, err
+		}
+		mergeLayer(merged, layer)
+	}
+	mergeLayer(merged, raw)
+	return merged, nil
+}
+
+// rebasePaths makes the Dockerfile, build context and compose file
+// references of a config in dir relative to mainDir, where they are resolved.
+func rebasePaths(raw map[string]interface{}, dir, mainDir string) {
+	if dir == mainDir {
+		return
+	}
+	rebase := func(p string) string {
+		if filepath.IsAbs(p) {
+			return p
+		}
+		if rel, err := filepath.Rel(mainDir, filepath.Join(dir, p)); err == nil {
+			return rel
+		}
+		return p
+	}
+	if build, ok := raw["build"].(map[string]interface{}); ok {
+		for _, k := range []string{"dockerfile", "context"} {
+			if p, ok := build[k].(string); ok {
+				build[k] = rebase(p)
+			}
+		}
+	}
+	switch v := raw["dockerComposeFile"].(type) {
+	case string:
+		raw["dockerComposeFile"] = rebase(v)
+	case []interface{}:
+		for i, f := range v {
+			if s, ok := f.(string); ok {
+				v[i] = rebase(s)
+			}
+		}
+	}
+}
+
+// mergeLayer merges src over dst: objects key by key, the arrays of
+// unionKeys as a union keeping order, anything else replaced. A null
+// removes the key, except in remoteEnv, where it unsets the variable.
+func mergeLayer(dst, src map[string]interface{}) {
+	mergeObject(dst, src, false)
+}
+
+func mergeObject(dst, src map[string]interface{}, keepNull bool) {
+	for k, v := range src {
+		switch sv := v.(type) {
+		case nil:
+			if !keepNull {
+				delete(dst, k)
+				continue
+			}
+		case map[string]interface{}:
+			if dv, ok := dst[k].(map[string]interface{}); ok {
+				mergeObject(dv, sv, k == "remoteEnv")
+				continue
+			}
+		case []interface{}:
+			if dv, ok := dst[k].([]interface{}); ok && unionKeys[k] {
+				for _, item := range sv {
+					if !slices.ContainsFunc(dv, func(x interface{}) bool { return reflect.DeepEqual(x, item) }) {
+						dv = append(dv, item)
+					}
+				}
+				dst[k] = dv
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
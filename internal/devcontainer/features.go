@@ -0,0 +1,477 @@
+package devcontainer
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/noopduck/kdev/internal/registry"
+)
+
+// featureLayerType is the media type of the tar layer of a feature artifact.
+const featureLayerType = "application/vnd.devcontainers.layer.v1+tar"
+
+// Feature is a devcontainer feature resolved for installation.
+type Feature struct {
+	// Ref is the reference as written in devcontainer.json or dependsOn.
+	Ref string
+	// Options are the options given for it.
+	Options map[string]interface{}
+	// Digest pins an OCI feature to the manifest that was fetched, and a
+	// tarball to the sha256 of its content.
+	Digest string
+	// Dir holds the extracted feature.
+	Dir  string
+	Meta FeatureMeta
+}
+
+// FeatureMeta is the devcontainer-feature.json of a feature.
+type FeatureMeta struct {
+	ID            string                            `json:"id"`
+	Version       string                            `json:"version,omitempty"`
+	Name          string                            `json:"name,omitempty"`
+	Options       map[string]FeatureOption          `json:"options,omitempty"`
+	DependsOn     map[string]map[string]interface{} `json:"dependsOn,omitempty"`
+	InstallsAfter []string                          `json:"installsAfter,omitempty"`
+	ContainerEnv  map[string]string                 `json:"containerEnv,omitempty"`
+	Privileged    bool                              `json:"privileged,omitempty"`
+	CapAdd        []string                          `json:"capAdd,omitempty"`
+	Entrypoint    string                            `json:"entrypoint,omitempty"`
+}
+
+// FeatureOption is an option a feature declares.
+type FeatureOption struct {
+	Type    string      `json:"type,omitempty"`
+	Default interface{} `json:"default,omitempty"`
+}
+
+// featureResource returns ref without its tag or digest, which identifies
+// a feature across versions, as installsAfter and
+// overrideFeatureInstallOrder name them.
+func featureResource(ref string) string {
+	if isLocalFeature(ref) || isTarballFeature(ref) {
+		return ref
+	}
+	if i := strings.Index(ref, "@"); i >= 0 {
+		return ref[:i]
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[:i]
+	}
+	return ref
+}
+
+func isLocalFeature(ref string) bool {
+	return strings.HasPrefix(ref, "./") || strings.HasPrefix(ref, "../")
+}
+
+func isTarballFeature(ref string) bool {
+	return strings.HasPrefix(ref, "https://") || strings.HasPrefix(ref, "http://")
+}
+
+// featureOptions turns the value of a features entry into options: an
+// object of options, or a string that is short for the version option.
+func featureOptions(v interface{}) map[string]interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return v
+	case string:
+		return map[string]interface{}{"version": v}
+	}
+	return map[string]interface{}{}
+}
+
+// featureFetcher downloads features into dir.
+type featureFetcher struct {
+	dir     string
+	baseDir string // of devcontainer.json, for local features
+	mirrors map[string]string
+	offline bool
+	clients map[string]*registry.Client
+	// frozen, if set, is the lockfile features must be installed from.
+	frozen *Lockfile
+}
+
+// fetch downloads ref into a new directory and reads its metadata.
+func (f *featureFetcher) fetch(ctx context.Context, ref string, options map[string]interface{}) (*Feature, error) {
+	feat := &Feature{Ref: ref, Options: options}
+	dir, err := os.MkdirTemp(f.dir, "feature-")
+	if err != nil {
+		return nil, err
+	}
+	feat.Dir = dir
+	locked, ok := LockedFeature{}, false
+	if f.frozen != nil && !isLocalFeature(ref) {
+		if locked, ok = f.frozen.Features[ref]; !ok {
+			return nil, fmt.Errorf("feature %s is not in %s; build without --frozen to update it", ref, LockfileName)
+		}
+	}
+	switch {
+	case isLocalFeature(ref):
+		if err := os.CopyFS(dir, os.DirFS(filepath.Join(f.baseDir, ref))); err != nil {
+			return nil, fmt.Errorf("failed to copy feature %s: %w", ref, err)
+		}
+	case isTarballFeature(ref):
+		if f.offline {
+			return nil, fmt.Errorf("feature %s is downloaded from the internet, which offline mode forbids", ref)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, ref, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download feature %s: %w", ref, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to download feature %s: %s", ref, resp.Status)
+		}
+		hash := sha256.New()
+		body := io.TeeReader(resp.Body, hash)
+		if err := extractTar(body, dir); err != nil {
+			return nil, fmt.Errorf("failed to unpack feature %s: %w", ref, err)
+		}
+		if _, err := io.Copy(io.Discard, body); err != nil {
+			return nil, fmt.Errorf("failed to download feature %s: %w", ref, err)
+		}
+		feat.Digest = fmt.Sprintf("sha256:%x", hash.Sum(nil))
+	default:
+		if err := f.fetchOCI(ctx, feat, locked.Integrity); err != nil {
+			return nil, err
+		}
+	}
+	if ok && feat.Digest != locked.Integrity {
+		return nil, fmt.Errorf("feature %s is %s, but %s pins %s", ref, feat.Digest, LockfileName, locked.Integrity)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "devcontainer-feature.json"))
+	if err != nil {
+		return nil, fmt.Errorf("feature %s has no devcontainer-feature.json: %w", ref, err)
+	}
+	if err := unmarshalJSONC(data, &feat.Meta); err != nil {
+		return nil, fmt.Errorf("invalid devcontainer-feature.json in %s: %w", ref, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "install.sh")); err != nil {
+		return nil, fmt.Errorf("feature %s has no install.sh", ref)
+	}
+	return feat, nil
+}
+
+// fetchOCI pulls the feature artifact feat.Ref from its registry, through
+// the configured mirrors. A pinned digest is pulled instead of the tag.
+func (f *featureFetcher) fetchOCI(ctx context.Context, feat *Feature, pinned string) error {
+	ref := registry.Rewrite(feat.Ref, f.mirrors)
+	host, repo := registry.Split(ref)
+	if f.offline && registry.IsPublicHost(host) {
+		return fmt.Errorf("feature %s is on %s, which offline mode cannot reach; add a mirror for it to the user config", feat.Ref, host)
+	}
+	repo, tag := splitTag(repo)
+	if pinned != "" {
+		tag = pinned
+	}
+
+	client := f.clients[host]
+	if client == nil {
+		var err error
+		if client, err = registry.New(host, "kdev"); err != nil {
+			return err
+		}
+		f.clients[host] = client
+	}
+	layer, digest, err := client.Layer(ctx, repo, tag, featureLayerType)
+	if err != nil {
+		return fmt.Errorf("failed to pull feature %s: %w", feat.Ref, err)
+	}
+	defer layer.Close()
+	if digest == "" && strings.HasPrefix(tag, "sha256:") {
+		digest = tag
+	}
+	feat.Digest = digest
+	if err := extractTar(layer, feat.Dir); err != nil {
+		return fmt.Errorf("failed to unpack feature %s: %w", feat.Ref, err)
+	}
+	return nil
+}
+
+// splitTag splits the repository path of a reference from its tag or
+// digest, which defaults to latest.
+func splitTag(repo string) (string, string) {
+	if i := strings.Index(repo, "@"); i >= 0 {
+		return repo[:i], repo[i+1:]
+	}
+	if i := strings.LastIndex(repo, ":"); i >= 0 {
+		return repo[:i], repo[i+1:]
+	}
+	return repo, "latest"
+}
+
+// hasTag reports whether the image reference names a tag or digest.
+func hasTag(image string) bool {
+	_, path := registry.Split(image)
+	return strings.ContainsAny(path[strings.LastIndex(path, "/")+1:], ":@")
+}
+
+// extractTar unpacks a tar stream, gzipped or not, into dir.
+func extractTar(r io.Reader, dir string) error {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean("/" + h.Name)[1:]
+		if name == "" {
+			continue
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		switch h.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(h.Mode)&0o777|0o600)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(out, tr)
+			out.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// resolveFeatures fetches the features of c and those they depend on, and
+// returns them in installation order.
+func (c *DevContainerConfig) resolveFeatures(ctx context.Context, f *featureFetcher) ([]*Feature, error) {
+	refs := make([]string, 0, len(c.Features))
+	for ref := range c.Features {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+
+	var features []*Feature
+	byResource := map[string]*Feature{}
+	type pending struct {
+		ref     string
+		options map[string]interface{}
+	}
+	queue := make([]pending, 0, len(refs))
+	for _, ref := range refs {
+		queue = append(queue, pending{ref, featureOptions(c.Features[ref])})
+	}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		if byResource[featureResource(p.ref)] != nil {
+			continue
+		}
+		feat, err := f.fetch(ctx, p.ref, p.options)
+		if err != nil {
+			return nil, err
+		}
+		features = append(features, feat)
+		byResource[featureResource(p.ref)] = feat
+		deps := make([]string, 0, len(feat.Meta.DependsOn))
+		for dep := range feat.Meta.DependsOn {
+			deps = append(deps, dep)
+		}
+		sort.Strings(deps)
+		for _, dep := range deps {
+			queue = append(queue, pending{dep, feat.Meta.DependsOn[dep]})
+		}
+	}
+	return orderFeatures(features, c.OverrideFeatureInstallOrder)
+}
+
+// orderFeatures sorts features so each comes after those it depends on and
+// those it installs after, if present. Among the features free to go next,
+// those in override come first, in its order, then the rest by reference.
+func orderFeatures(features []*Feature, override []string) ([]*Feature, error) {
+	find := func(name string) *Feature {
+		for _, feat := range features {
+			if featureResource(feat.Ref) == featureResource(name) || feat.Meta.ID == name {
+				return feat
+			}
+		}
+		return nil
+	}
+	after := map[*Feature][]*Feature{}
+	for _, feat := range features {
+		var names []string
+		for dep := range feat.Meta.DependsOn {
+			names = append(names, dep)
+		}
+		names = append(names, feat.Meta.InstallsAfter...)
+		for _, name := range names {
+			if dep := find(name); dep != nil && dep != feat && !slices.Contains(after[feat], dep) {
+				after[feat] = append(after[feat], dep)
+			}
+		}
+	}
+	rank := func(feat *Feature) int {
+		for i, name := range override {
+			if find(name) == feat {
+				return i
+			}
+		}
+		return len(override)
+	}
+
+	var ordered []*Feature
+	done := map[*Feature]bool{}
+	for len(ordered) < len(features) {
+		var next *Feature
+		for _, feat := range features {
+			if done[feat] || slices.ContainsFunc(after[feat], func(dep *Feature) bool { return !done[dep] }) {
+				continue
+			}
+			if next == nil || rank(feat) < rank(next) || rank(feat) == rank(next) && feat.Ref < next.Ref {
+				next = feat
+			}
+		}
+		if next == nil {
+			var stuck []string
+			for _, feat := range features {
+				if !done[feat] {
+					stuck = append(stuck, feat.Ref)
+				}
+			}
+			return nil, fmt.Errorf("features depend on each other in a cycle: %s", strings.Join(stuck, ", "))
+		}
+		done[next] = true
+		ordered = append(ordered, next)
+	}
+	return ordered, nil
+}
+
+var invalidEnvChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// optionEnvName is the variable an option is passed to install.sh in.
+func optionEnvName(option string) string {
+	name := strings.ToUpper(invalidEnvChars.ReplaceAllString(option, "_"))
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// envFile renders the options of feat, defaults filled in, as shell
+// assignments.
+func (feat *Feature) envFile() string {
+	values := map[string]interface{}{}
+	for name, opt := range feat.Meta.Options {
+		if opt.Default != nil {
+			values[name] = opt.Default
+		}
+	}
+	for name, v := range feat.Options {
+		values[name] = v
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s=%s\n", optionEnvName(name), shellSingleQuote(fmt.Sprint(values[name])))
+	}
+	return b.String()
+}
+
+func shellSingleQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// dockerfileQuote renders s as a double-quoted Dockerfile ENV value; $
+// references still expand, as feature containerEnv values expect.
+func dockerfileQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// featuresDockerfile writes into dir the build context that installs
+// features on top of base: each feature with its options, the built-in
+// user variables, and a Dockerfile running the install scripts as root in
+// order before switching back to user.
+func featuresDockerfile(dir, base, user, remoteUser, containerUser string, features []*Feature) error {
+	var df strings.Builder
+	fmt.Fprintf(&df, "FROM %s\nUSER root\n", base)
+	builtin := fmt.Sprintf(`_CONTAINER_USER=%s
+_REMOTE_USER=%s
+_CONTAINER_USER_HOME="$(awk -F: -v u="$_CONTAINER_USER" '$1 == u { print $6 }' /etc/passwd)"
+_REMOTE_USER_HOME="$(awk -F: -v u="$_REMOTE_USER" '$1 == u { print $6 }' /etc/passwd)"
+`, shellSingleQuote(containerUser), shellSingleQuote(remoteUser))
+	if err := os.WriteFile(filepath.Join(dir, "builtin.env"), []byte(builtin), 0o644); err != nil {
+		return err
+	}
+	for i, feat := range features {
+		name := fmt.Sprintf("%d_%s", i, invalidEnvChars.ReplaceAllString(feat.Meta.ID, "-"))
+		if err := os.Rename(feat.Dir, filepath.Join(dir, name)); err != nil {
+			return err
+		}
+		feat.Dir = filepath.Join(dir, name)
+		if err := os.WriteFile(filepath.Join(feat.Dir, "devcontainer-features.env"), []byte(feat.envFile()), 0o644); err != nil {
+			return err
+		}
+		fmt.Fprintf(&df, "COPY %s /tmp/dev-container-features/%s\n", name, name)
+		if i == 0 {
+			df.WriteString("COPY builtin.env /tmp/dev-container-features/builtin.env\n")
+		}
+		fmt.Fprintf(&df, "RUN cd /tmp/dev-container-features/%s && set -a && . ../builtin.env && . ./devcontainer-features.env && set +a && chmod +x install.sh && ./install.sh\n", name)
+		keys := make([]string, 0, len(feat.Meta.ContainerEnv))
+		for k := range feat.Meta.ContainerEnv {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&df, "ENV %s=%s\n", k, dockerfileQuote(feat.Meta.ContainerEnv[k]))
+		}
+	}
+	df.WriteString("RUN rm -rf /tmp/dev-container-features\n")
+	if user != "" {
+		fmt.Fprintf(&df, "USER %s\n", user)
+	}
+	return os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte(df.String()), 0o644)
+}
+
+// featureIDs lists the ids of features, for messages.
+func featureIDs(features []*Feature) string {
+	ids := make([]string, len(features))
+	for i, feat := range features {
+		ids[i] = feat.Meta.ID
+	}
+	return strings.Join(ids, ", ")
+}
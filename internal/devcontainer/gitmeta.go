@@ -0,0 +1,96 @@
+package devcontainer
+
+import (
+	"context"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// OCI labels recording the source of an image.
+const (
+	labelRevision = "org.opencontainers.image.revision"
+	labelSource   = "org.opencontainers.image.source"
+)
+
+// gitSource is the git checkout an image is built from.
+type gitSource struct {
+	Commit string
+	Branch string // "" on a detached HEAD
+	Dirty  bool   // the tree has uncommitted changes
+	Remote string // URL of origin, without credentials
+}
+
+// readGitSource describes the checkout dir is in, or returns nil if it is
+// in none or git is not installed.
+func readGitSource(ctx context.Context, dir string) *gitSource {
+	git := func(args ...string) (string, error) {
+		cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...)
+		out, err := cmd.Output()
+		return strings.TrimSpace(string(out)), err
+	}
+	commit, err := git("rev-parse", "HEAD")
+	if err != nil {
+		return nil
+	}
+	src := &gitSource{Commit: commit}
+	if branch, err := git("symbolic-ref", "--short", "-q", "HEAD"); err == nil {
+		src.Branch = branch
+	}
+	if status, err := git("status", "--porcelain", "--untracked-files=no"); err == nil {
+		src.Dirty = status != ""
+	}
+	if remote, err := git("config", "--get", "remote.origin.url"); err == nil {
+		src.Remote = stripURLCredentials(remote)
+	}
+	return src
+}
+
+// invalidTagChars are those an image tag cannot hold.
+var invalidTagChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// Tag derives an image tag from the checkout: the branch and the short
+// commit, with -dirty for uncommitted changes, e.g. feature-login-1a2b3c4
+// or 1a2b3c4-dirty on a detached HEAD.
+func (g *gitSource) Tag() string {
+	tag := g.Commit
+	if len(tag) > 7 {
+		tag = tag[:7]
+	}
+	if g.Branch != "" {
+		branch := strings.Trim(invalidTagChars.ReplaceAllString(g.Branch, "-"), ".-")
+		// Tags have at most 128 characters; the commit is what must fit.
+		if max := 128 - len(tag) - len("--dirty"); len(branch) > max {
+			branch = branch[:max]
+		}
+		if branch != "" {
+			tag = branch + "-" + tag
+		}
+	}
+	if g.Dirty {
+		tag += "-dirty"
+	}
+	return tag
+}
+
+// Labels are the OCI labels recording the source of the image.
+func (g *gitSource) Labels() map[string]string {
+	labels := map[string]string{labelRevision: g.Commit}
+	if g.Remote != "" {
+		labels[labelSource] = g.Remote
+	}
+	return labels
+}
+
+// stripURLCredentials drops a user and password from a remote URL, which
+// must not end up in an image. scp-like remotes such as
+// git@github.com:org/repo.git are kept as they are.
+func stripURLCredentials(remote string) string {
+	u, err := url.Parse(remote)
+	if err != nil || u.User == nil || u.Scheme == "" {
+		return remote
+	}
+	u.User = nil
+	return u.String()
+}
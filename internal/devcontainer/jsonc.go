@@ -0,0 +1,86 @@
+package devcontainer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// standardizeJSONC turns JSON with comments and trailing commas, as VS Code
+// writes devcontainer.json, into plain JSON. Comments and trailing commas
+// are blanked out rather than removed, so offsets in decode errors still
+// point at the original text.
+func standardizeJSONC(data []byte) ([]byte, error) {
+	out := bytes.Clone(data)
+	// lastComma is the position of a comma that may turn out to be trailing.
+	lastComma := -1
+	for i := 0; i < len(out); i++ {
+		switch c := out[i]; {
+		case c == '"':
+			lastComma = -1
+			for i++; i < len(out) && out[i] != '"'; i++ {
+				if out[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(out) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+		case c == '/' && i+1 < len(out) && out[i+1] == '/':
+			for ; i < len(out) && out[i] != '\n'; i++ {
+				out[i] = ' '
+			}
+		case c == '/' && i+1 < len(out) && out[i+1] == '*':
+			end := bytes.Index(out[i+2:], []byte("*/"))
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated comment")
+			}
+			for j := i; j < i+2+end+2; j++ {
+				if out[j] != '\n' {
+					out[j] = ' '
+				}
+			}
+			i += 2 + end + 1
+		case c == ',':
+			lastComma = i
+		case c == '}' || c == ']':
+			if lastComma >= 0 {
+				out[lastComma] = ' '
+			}
+			lastComma = -1
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+		default:
+			lastComma = -1
+		}
+	}
+	return out, nil
+}
+
+// unmarshalJSONC decodes JSONC data into v, reporting syntax errors with
+// their line and column.
+func unmarshalJSONC(data []byte, v interface{}) error {
+	std, err := standardizeJSONC(data)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(std, v)
+	var syntax *json.SyntaxError
+	if errors.As(err, &syntax) {
+		// Offset counts the bytes read, including the offending one.
+		line, col := lineCol(data, max(syntax.Offset-1, 0))
+		return fmt.Errorf("line %d, column %d: %w", line, col, err)
+	}
+	return err
+}
+
+// lineCol converts a byte offset in data to a 1-based line and column.
+func lineCol(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := len(before) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
@@ -0,0 +1,116 @@
+package devcontainer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Lifecycle holds the devcontainer.json commands run at points in a
+// container's life, in the order they run.
+type Lifecycle struct {
+	OnCreateCommand      *Command `json:"onCreateCommand,omitempty"`
+	UpdateContentCommand *Command `json:"updateContentCommand,omitempty"`
+	PostCreateCommand    *Command `json:"postCreateCommand,omitempty"`
+	PostStartCommand     *Command `json:"postStartCommand,omitempty"`
+	PostAttachCommand    *Command `json:"postAttachCommand,omitempty"`
+}
+
+// Empty reports whether no lifecycle command is set.
+func (l Lifecycle) Empty() bool {
+	return l == Lifecycle{}
+}
+
+// Command is a lifecycle command: a string run by /bin/sh, an argument
+// list run as is, or an object of named commands of either form that run in
+// parallel.
+type Command struct {
+	Shell    string
+	Args     []string
+	Parallel map[string]Command
+}
+
+// Step is a single process of a Command.
+type Step struct {
+	// Name is the key of a parallel command, empty otherwise.
+	Name string
+	Args []string
+}
+
+// Steps returns the processes of c. A parallel command has one step per
+// name, sorted by name.
+func (c Command) Steps() []Step {
+	switch {
+	case c.Parallel != nil:
+		names := make([]string, 0, len(c.Parallel))
+		for n := range c.Parallel {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		var steps []Step
+		for _, n := range names {
+			for _, s := range c.Parallel[n].Steps() {
+				steps = append(steps, Step{Name: n, Args: s.Args})
+			}
+		}
+		return steps
+	case c.Args != nil:
+		return []Step{{Args: c.Args}}
+	case c.Shell != "":
+		return []Step{{Args: []string{"/bin/sh", "-c", c.Shell}}}
+	}
+	return nil
+}
+
+func (c *Command) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case string:
+		*c = Command{Shell: v}
+	case []interface{}:
+		args := make([]string, len(v))
+		for i, a := range v {
+			s, ok := a.(string)
+			if !ok {
+				return errors.New("command arguments must be strings")
+			}
+			args[i] = s
+		}
+		*c = Command{Args: args}
+	case map[string]interface{}:
+		var named map[string]json.RawMessage
+		if err := json.Unmarshal(data, &named); err != nil {
+			return err
+		}
+		*c = Command{Parallel: map[string]Command{}}
+		for n, raw := range named {
+			var sub Command
+			if err := json.Unmarshal(raw, &sub); err != nil {
+				return fmt.Errorf("%s: %w", n, err)
+			}
+			if sub.Parallel != nil {
+				return fmt.Errorf("%s: parallel commands cannot be nested", n)
+			}
+			c.Parallel[n] = sub
+		}
+	case nil:
+		*c = Command{}
+	default:
+		return errors.New("a command must be a string, an array of strings or an object")
+	}
+	return nil
+}
+
+func (c Command) MarshalJSON() ([]byte, error) {
+	switch {
+	case c.Parallel != nil:
+		return json.Marshal(c.Parallel)
+	case c.Args != nil:
+		return json.Marshal(c.Args)
+	}
+	return json.Marshal(c.Shell)
+}
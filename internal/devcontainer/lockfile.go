@@ -0,0 +1,89 @@
+package devcontainer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+)
+
+// LockfileName is the file next to devcontainer.json pinning its features,
+// in the format of the devcontainers CLI.
+const LockfileName = "devcontainer-lock.json"
+
+// Lockfile pins every feature reference to the content that was installed.
+type Lockfile struct {
+	Features map[string]LockedFeature `json:"features"`
+}
+
+// LockedFeature is the pin of one feature.
+type LockedFeature struct {
+	Version string `json:"version,omitempty"`
+	// Resolved is the OCI reference by digest, or the tarball URL.
+	Resolved string `json:"resolved"`
+	// Integrity is the digest of the manifest or of the tarball.
+	Integrity string   `json:"integrity"`
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
+
+// readLockfile reads the lockfile in dir; it returns nil if there is none.
+func readLockfile(dir string) (*Lockfile, error) {
+	path := filepath.Join(dir, LockfileName)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	var lock Lockfile
+	if err := unmarshalJSONC(data, &lock); err != nil {
+		return nil, fmt.Errorf("invalid JSON in %s: %w", path, err)
+	}
+	return &lock, nil
+}
+
+// lockFeatures pins features. Local features live in the repository and
+// need no pin.
+func lockFeatures(features []*Feature) *Lockfile {
+	lock := &Lockfile{Features: map[string]LockedFeature{}}
+	for _, feat := range features {
+		if isLocalFeature(feat.Ref) {
+			continue
+		}
+		locked := LockedFeature{Version: feat.Meta.Version, Resolved: feat.Ref, Integrity: feat.Digest}
+		if !isTarballFeature(feat.Ref) {
+			locked.Resolved = featureResource(feat.Ref) + "@" + feat.Digest
+		}
+		for dep := range feat.Meta.DependsOn {
+			locked.DependsOn = append(locked.DependsOn, dep)
+		}
+		slices.Sort(locked.DependsOn)
+		lock.Features[feat.Ref] = locked
+	}
+	return lock
+}
+
+// equal reports whether l and other pin the same features alike.
+func (l *Lockfile) equal(other *Lockfile) bool {
+	return l != nil && other != nil && reflect.DeepEqual(l.Features, other.Features)
+}
+
+// write saves l in dir, if it differs from what is there.
+func (l *Lockfile) write(dir string) (bool, error) {
+	if existing, err := readLockfile(dir); err == nil && l.equal(existing) {
+		return false, nil
+	}
+	data, err := json.MarshalIndent(l, "", "\t")
+	if err != nil {
+		return false, err
+	}
+	path := filepath.Join(dir, LockfileName)
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return false, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,76 @@
+package devcontainer
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/noopduck/kdev/internal/registry"
+)
+
+// rewriteDockerfile applies mirror rules to the base images in the FROM
+// lines of dockerfile. When anything changed it writes the result to a
+// temporary file and returns its path; the caller removes it. In offline
+// mode base images left on a public registry are an error.
+func rewriteDockerfile(dockerfile string, mirrors map[string]string, offline bool) (string, error) {
+	raw, err := os.ReadFile(dockerfile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", dockerfile, err)
+	}
+	lines := strings.Split(string(raw), "\n")
+	stages := map[string]bool{"scratch": true}
+	changed := false
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "FROM") {
+			continue
+		}
+		// FROM [--platform=...] image [AS name]
+		img := 1
+		for img < len(fields) && strings.HasPrefix(fields[img], "--") {
+			img++
+		}
+		if img >= len(fields) {
+			continue
+		}
+		ref := fields[img]
+		// Earlier stages and ARG-templated images cannot be rewritten.
+		if !stages[strings.ToLower(ref)] && !strings.Contains(ref, "$") {
+			out := registry.Rewrite(ref, mirrors)
+			if offline && registry.IsPublic(out) {
+				host, _ := registry.Split(out)
+				return "", fmt.Errorf("%s:%d: base image %s is on %s, which offline mode cannot reach; add a mirror for it to the user config", dockerfile, i+1, ref, host)
+			}
+			if out != ref {
+				fields[img] = out
+				lines[i] = strings.Join(fields, " ")
+				changed = true
+			}
+		}
+		if img+2 < len(fields) && strings.EqualFold(fields[img+1], "AS") {
+			stages[strings.ToLower(fields[img+2])] = true
+		}
+	}
+	if !changed {
+		return "", nil
+	}
+
+	f, err := os.CreateTemp("", "kdev-Dockerfile-*")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(strings.Join(lines, "\n")); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// checkOfflinePush refuses to push to a public registry in offline mode.
+func checkOfflinePush(image string, offline bool) error {
+	if offline && registry.IsPublic(image) {
+		return fmt.Errorf("pushing %s needs internet access, which offline mode forbids", image)
+	}
+	return nil
+}
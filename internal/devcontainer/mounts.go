@@ -0,0 +1,75 @@
+package devcontainer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Mount is an entry of mounts, given either as a Docker --mount string
+// such as "source=cache,target=/cache,type=volume" or as an object.
+type Mount struct {
+	Type     string `json:"type,omitempty"`
+	Source   string `json:"source,omitempty"`
+	Target   string `json:"target"`
+	ReadOnly bool   `json:"readonly,omitempty"`
+}
+
+func (m *Mount) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		return m.parse(s)
+	}
+	type mount Mount
+	var v mount
+	if err := json.Unmarshal(data, &v); err != nil {
+		return errors.New("a mount must be a string or an object")
+	}
+	*m = Mount(v)
+	return m.validate()
+}
+
+// parse reads the Docker --mount syntax.
+func (m *Mount) parse(s string) error {
+	*m = Mount{}
+	for _, field := range strings.Split(s, ",") {
+		key, value, hasValue := strings.Cut(strings.TrimSpace(field), "=")
+		switch strings.ToLower(key) {
+		case "type":
+			m.Type = value
+		case "source", "src":
+			m.Source = value
+		case "target", "destination", "dst":
+			m.Target = value
+		case "readonly", "ro":
+			m.ReadOnly = !hasValue || value == "true" || value == "1"
+		case "":
+		default:
+			// consistency, bind-propagation and the like have no meaning
+			// in a pod.
+		}
+	}
+	if err := m.validate(); err != nil {
+		return fmt.Errorf("invalid mount %q: %w", s, err)
+	}
+	return nil
+}
+
+func (m *Mount) validate() error {
+	if m.Type == "" {
+		m.Type = "volume"
+	}
+	switch m.Type {
+	case "volume", "tmpfs", "bind":
+	default:
+		return fmt.Errorf("unsupported mount type %q (want volume, tmpfs or bind)", m.Type)
+	}
+	if m.Target == "" {
+		return errors.New("a mount needs a target")
+	}
+	if m.Type == "bind" && m.Source == "" {
+		return errors.New("a bind mount needs a source")
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package devcontainer
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PortAttributes are the portsAttributes of a forwarded port.
+type PortAttributes struct {
+	Label            string `json:"label,omitempty"`
+	Protocol         string `json:"protocol,omitempty"`
+	OnAutoForward    string `json:"onAutoForward,omitempty"`
+	RequireLocalPort bool   `json:"requireLocalPort,omitempty"`
+	ElevateIfNeeded  bool   `json:"elevateIfNeeded,omitempty"`
+}
+
+// ForwardPort is an entry of forwardPorts with its attributes.
+type ForwardPort struct {
+	// Host is the service of a "host:port" entry, empty for plain ports.
+	Host string `json:"host,omitempty"`
+	Port int    `json:"port"`
+	PortAttributes
+}
+
+// Ports resolves forwardPorts against portsAttributes and
+// otherPortsAttributes.
+func (c *DevContainerConfig) Ports() ([]ForwardPort, error) {
+	var ports []ForwardPort
+	for _, raw := range c.ForwardPorts {
+		var p ForwardPort
+		switch v := raw.(type) {
+		case float64:
+			p.Port = int(v)
+		case string:
+			host, port, ok := strings.Cut(v, ":")
+			if !ok {
+				host, port = "", v
+			}
+			n, err := strconv.Atoi(port)
+			if err != nil {
+				return nil, fmt.Errorf("invalid forwardPorts entry %q: want a port or \"host:port\"", v)
+			}
+			p.Host, p.Port = host, n
+		default:
+			return nil, fmt.Errorf("invalid forwardPorts entry %v: want a port or \"host:port\"", raw)
+		}
+		if p.Port < 1 || p.Port > 65535 {
+			return nil, fmt.Errorf("invalid forwardPorts entry %v: port out of range", raw)
+		}
+		p.PortAttributes = c.portAttributes(p)
+		ports = append(ports, p)
+	}
+	return ports, nil
+}
+
+// portAttributes returns the attributes of p: those of its port or
+// "host:port" in portsAttributes, else of the first range such as
+// "3000-3010" or regular expression that matches, in key order, else
+// otherPortsAttributes.
+func (c *DevContainerConfig) portAttributes(p ForwardPort) PortAttributes {
+	port := strconv.Itoa(p.Port)
+	if a, ok := c.PortsAttributes[port]; ok {
+		return a
+	}
+	if a, ok := c.PortsAttributes[p.Host+":"+port]; ok && p.Host != "" {
+		return a
+	}
+	keys := make([]string, 0, len(c.PortsAttributes))
+	for key := range c.PortsAttributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		a := c.PortsAttributes[key]
+		if lo, hi, ok := strings.Cut(key, "-"); ok {
+			l, err1 := strconv.Atoi(lo)
+			h, err2 := strconv.Atoi(hi)
+			if err1 == nil && err2 == nil {
+				if l <= p.Port && p.Port <= h {
+					return a
+				}
+				continue
+			}
+		}
+		if re, err := regexp.Compile("^(?:" + key + ")$"); err == nil && re.MatchString(port) {
+			return a
+		}
+	}
+	if c.OtherPortsAttributes != nil {
+		return *c.OtherPortsAttributes
+	}
+	return PortAttributes{}
+}
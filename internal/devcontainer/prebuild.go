@@ -0,0 +1,141 @@
+package devcontainer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// GitBuild is the Dockerfile build of a devcontainer from its git remote
+// rather than the local checkout, for builds the cluster runs on its own
+// such as kdev prebuild.
+type GitBuild struct {
+	// Repository is the remote as host/path, without scheme or
+	// credentials, e.g. github.com/org/app.git.
+	Repository string
+	Branch     string
+	// ContextDir is the build context relative to the repository root,
+	// "" for the root.
+	ContextDir string
+	// Dockerfile is relative to ContextDir.
+	Dockerfile string
+	Args       map[string]string
+	Labels     map[string]string
+}
+
+// GitBuildOf describes building cfg from branch of the origin remote of
+// the current checkout; an empty branch is the one checked out. Only the
+// Dockerfile is built: features are installed by kdev itself, which the
+// cluster cannot run.
+func GitBuildOf(ctx context.Context, cfg *DevContainerConfig, branch string) (*GitBuild, error) {
+	if cfg.Prebuilt() {
+		return nil, fmt.Errorf("devcontainer.json uses the prebuilt image %s; there is nothing to build", cfg.Image)
+	}
+	if len(cfg.Features) > 0 {
+		return nil, errors.New("devcontainer.json has features, which kdev installs itself and a build in the cluster cannot; move them into the Dockerfile")
+	}
+	src := readGitSource(ctx, ".")
+	if src == nil {
+		return nil, errors.New("the current directory is no git checkout")
+	}
+	if src.Remote == "" {
+		return nil, errors.New("the git checkout has no origin remote to build from")
+	}
+	repo, err := gitRepository(src.Remote)
+	if err != nil {
+		return nil, err
+	}
+	if branch == "" {
+		if src.Branch == "" {
+			return nil, errors.New("HEAD is detached; name the branch to build")
+		}
+		branch = src.Branch
+	}
+	out, err := exec.CommandContext(ctx, "git", "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the root of the git checkout: %w", err)
+	}
+	root := strings.TrimSpace(string(out))
+
+	dockerfile, contextDir := cfg.buildPaths()
+	absContext, err := filepath.Abs(contextDir)
+	if err != nil {
+		return nil, err
+	}
+	absDockerfile, err := filepath.Abs(dockerfile)
+	if err != nil {
+		return nil, err
+	}
+	relContext, err := filepath.Rel(root, absContext)
+	if err != nil || strings.HasPrefix(relContext, "..") {
+		return nil, fmt.Errorf("build context %s is outside the git checkout %s", contextDir, root)
+	}
+	relDockerfile, err := filepath.Rel(absContext, absDockerfile)
+	if err != nil || strings.HasPrefix(relDockerfile, "..") {
+		return nil, fmt.Errorf("%s is outside the build context %s", dockerfile, contextDir)
+	}
+	if relContext == "." {
+		relContext = ""
+	}
+
+	args := map[string]string{}
+	for k, v := range cfg.Build.Args {
+		args[k] = v
+	}
+	if cfg.RemoteUser != "" {
+		args["REMOTE_USER"] = cfg.RemoteUser
+	}
+	labels := map[string]string{ImageLabel: cfg.Name}
+	for k, v := range src.Labels() {
+		labels[k] = v
+	}
+	// The commit is only known when the build runs.
+	delete(labels, labelRevision)
+	return &GitBuild{
+		Repository: repo,
+		Branch:     branch,
+		ContextDir: filepath.ToSlash(relContext),
+		Dockerfile: filepath.ToSlash(relDockerfile),
+		Args:       args,
+		Labels:     labels,
+	}, nil
+}
+
+// Tag is the image tag of builds of the branch, e.g. feature-login.
+func (g *GitBuild) Tag() string {
+	tag := strings.Trim(invalidTagChars.ReplaceAllString(g.Branch, "-"), ".-")
+	if len(tag) > 128 {
+		tag = tag[:128]
+	}
+	return tag
+}
+
+// gitRepository turns an origin URL into host/path. scp-like remotes such
+// as git@github.com:org/app.git are cloned over HTTPS instead.
+func gitRepository(remote string) (string, error) {
+	if !strings.Contains(remote, "://") {
+		userHost, path, ok := strings.Cut(remote, ":")
+		if !ok {
+			return "", fmt.Errorf("origin %s is a local path, which the cluster cannot clone", remote)
+		}
+		_, host, found := strings.Cut(userHost, "@")
+		if !found {
+			host = userHost
+		}
+		return host + "/" + strings.TrimPrefix(path, "/"), nil
+	}
+	u, err := url.Parse(remote)
+	if err != nil {
+		return "", fmt.Errorf("invalid origin %s: %w", remote, err)
+	}
+	switch u.Scheme {
+	case "https", "http", "ssh", "git":
+	default:
+		return "", fmt.Errorf("origin %s cannot be cloned from the cluster", remote)
+	}
+	return u.Hostname() + u.Path, nil
+}
@@ -0,0 +1,97 @@
+package devcontainer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/noopduck/kdev/internal/progress"
+	"github.com/noopduck/kdev/internal/registry"
+)
+
+// sbomFormat is an SBOM format trivy writes.
+type sbomFormat struct {
+	trivy     string
+	mediaType string
+	ext       string
+}
+
+// sbomFormats are the formats of --sbom.
+var sbomFormats = map[string]sbomFormat{
+	"spdx":      {trivy: "spdx-json", mediaType: "application/spdx+json", ext: ".spdx.json"},
+	"cyclonedx": {trivy: "cyclonedx", mediaType: "application/vnd.cyclonedx+json", ext: ".cdx.json"},
+}
+
+// sbomPolicy writes the SBOM of each built image to dir and, with attach,
+// attaches it to the pushed image.
+type sbomPolicy struct {
+	format sbomFormat
+	dir    string
+	attach bool
+}
+
+func newSBOMPolicy(format, dir string, attach bool) (*sbomPolicy, error) {
+	f, ok := sbomFormats[strings.ToLower(format)]
+	if !ok {
+		return nil, fmt.Errorf("invalid SBOM format %q (want one of %s)", format, strings.Join(sortedKeys(sbomFormats), ", "))
+	}
+	if err := lookTrivy(); err != nil {
+		return nil, err
+	}
+	return &sbomPolicy{format: f, dir: dir, attach: attach}, nil
+}
+
+// file names the SBOM of imageName after its repository and tag, e.g.
+// dev-v2.spdx.json.
+func (p *sbomPolicy) file(imageName string) string {
+	_, ref := registry.Split(imageName)
+	repo, tag := splitTag(ref)
+	return filepath.Join(p.dir, sanitizeImageNamePart(path.Base(repo)+"-"+tag)+p.format.ext)
+}
+
+// writeSBOM writes the SBOM of imageName, read by trivy from source.
+func (b *imageBuild) writeSBOM(ctx context.Context, imageName string, source []string) error {
+	file := b.sbom.file(imageName)
+	if err := os.MkdirAll(b.sbom.dir, 0o755); err != nil {
+		return err
+	}
+	s := progress.Start(fmt.Sprintf("Writing SBOM of %s to %s", imageName, file))
+	out, err := b.trivy(ctx, []string{"--format", b.sbom.format.trivy, "--output", file}, source)
+	s.Done(err)
+	if err != nil {
+		os.Stderr.Write(out)
+		return fmt.Errorf("trivy failed: %w", err)
+	}
+	return nil
+}
+
+// attachSBOM attaches the SBOM of the pushed imageName to it as an OCI
+// referrer, so it travels with the image.
+func (b *imageBuild) attachSBOM(ctx context.Context, imageName string) error {
+	if b.sbom == nil || !b.sbom.attach {
+		return nil
+	}
+	data, err := os.ReadFile(b.sbom.file(imageName))
+	if err != nil {
+		return fmt.Errorf("failed to read SBOM: %w", err)
+	}
+	host, ref := registry.Split(imageName)
+	repo, tag := splitTag(ref)
+	client, err := registry.New(host, "kdev")
+	if err != nil {
+		return err
+	}
+	annotations := map[string]string{"org.opencontainers.image.created": time.Now().UTC().Format(time.RFC3339)}
+	s := progress.Start(fmt.Sprintf("Attaching SBOM to %s", imageName))
+	digest, err := client.Attach(ctx, repo, tag, b.sbom.format.mediaType, data, annotations)
+	s.Done(err)
+	if err != nil {
+		return fmt.Errorf("failed to attach SBOM to %s: %w", imageName, err)
+	}
+	progress.Infof("SBOM of %s is %s@%s", imageName, strings.TrimSuffix(imageName, ":"+tag), digest)
+	return nil
+}
@@ -0,0 +1,129 @@
+package devcontainer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/noopduck/kdev/internal/progress"
+)
+
+// severities are trivy's severities from lowest to highest.
+var severities = []string{"UNKNOWN", "LOW", "MEDIUM", "HIGH", "CRITICAL"}
+
+// trivyFoundExitCode is what trivy exits with when it finds
+// vulnerabilities, to tell them from trivy's own errors.
+const trivyFoundExitCode = 13
+
+// scanPolicy gates a build on a trivy vulnerability scan.
+type scanPolicy struct {
+	// severity is the lowest severity that fails the build.
+	severity      string
+	warnOnly      bool
+	ignoreUnfixed bool
+}
+
+func lookTrivy() error {
+	if _, err := exec.LookPath("trivy"); err != nil {
+		return fmt.Errorf("scanning and SBOMs need trivy in PATH; see https://trivy.dev/latest/getting-started/installation/")
+	}
+	return nil
+}
+
+// newScanPolicy checks severity, "" for CRITICAL.
+func newScanPolicy(severity string, warnOnly, ignoreUnfixed bool) (*scanPolicy, error) {
+	if severity == "" {
+		severity = "CRITICAL"
+	}
+	severity = strings.ToUpper(severity)
+	if !slices.Contains(severities, severity) {
+		return nil, fmt.Errorf("invalid scan severity %q (want one of %s)", severity, strings.Join(severities[1:], ", "))
+	}
+	if err := lookTrivy(); err != nil {
+		return nil, err
+	}
+	return &scanPolicy{severity: severity, warnOnly: warnOnly, ignoreUnfixed: ignoreUnfixed}, nil
+}
+
+// inspectImage writes the SBOM of imageName and scans it, as requested,
+// with trivy. Local images are read from their export (see export); images
+// built in the cluster are read from the registry they were pushed to.
+func (b *imageBuild) inspectImage(ctx context.Context, imageName string) error {
+	if b.scan == nil && b.sbom == nil {
+		return nil
+	}
+	var source []string
+	if b.remote != nil {
+		source = []string{"--image-src", "remote"}
+		if b.platform != "" {
+			source = append(source, "--platform", b.platform)
+		}
+		source = append(source, imageName)
+	} else {
+		archive, err := b.export(ctx, imageName)
+		if err != nil {
+			return err
+		}
+		source = []string{"--input", archive}
+	}
+	if b.sbom != nil {
+		if err := b.writeSBOM(ctx, imageName, source); err != nil {
+			return err
+		}
+	}
+	if b.scan != nil {
+		return b.scanImage(ctx, imageName, source)
+	}
+	return nil
+}
+
+// trivy runs trivy image with args and the image source, returning its
+// output.
+func (b *imageBuild) trivy(ctx context.Context, args, source []string) ([]byte, error) {
+	args = append([]string{"image", "--quiet"}, args...)
+	// trivy downloads its database from public registries; offline it has
+	// to make do with its cache or TRIVY_DB_REPOSITORY.
+	if b.offline && os.Getenv("TRIVY_DB_REPOSITORY") == "" {
+		args = append(args, "--skip-db-update", "--skip-java-db-update", "--offline-scan")
+	}
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, "trivy", append(args, source...)...)
+	cmd.Stdout, cmd.Stderr = &out, &out
+	err := cmd.Run()
+	return out.Bytes(), err
+}
+
+// scanImage fails when imageName has vulnerabilities of the policy's
+// severity or higher.
+func (b *imageBuild) scanImage(ctx context.Context, imageName string, source []string) error {
+	i := slices.Index(severities, b.scan.severity)
+	args := []string{"--scanners", "vuln", "--severity", strings.Join(severities[i:], ","),
+		"--exit-code", fmt.Sprint(trivyFoundExitCode), "--format", "table"}
+	if b.scan.ignoreUnfixed {
+		args = append(args, "--ignore-unfixed")
+	}
+	s := progress.Start(fmt.Sprintf("Scanning %s for %s or higher vulnerabilities", imageName, b.scan.severity))
+	out, err := b.trivy(ctx, args, source)
+	s.Done(err)
+	var exit *exec.ExitError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &exit) && exit.ExitCode() == trivyFoundExitCode:
+		os.Stderr.Write(out)
+		found := fmt.Errorf("%s has vulnerabilities of severity %s or higher", imageName, b.scan.severity)
+		if b.scan.warnOnly {
+			progress.Warnf("%v", found)
+			return nil
+		}
+		return found
+	default:
+		os.Stderr.Write(out)
+		return fmt.Errorf("trivy failed: %w", err)
+	}
+}
@@ -0,0 +1,58 @@
+package devcontainer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/noopduck/kdev/internal/progress"
+	"github.com/noopduck/kdev/internal/registry"
+)
+
+// signImage signs the pushed imageName with cosign, by digest so the
+// signature covers exactly what was pushed. key is a cosign key reference;
+// without one the signature is keyless, with the Sigstore identity cosign
+// finds (SIGSTORE_ID_TOKEN, a CI provider, or a browser login). recursive
+// also signs each platform of a manifest list.
+func signImage(ctx context.Context, imageName, key string, recursive, offline bool) error {
+	if _, err := exec.LookPath("cosign"); err != nil {
+		return fmt.Errorf("--sign needs cosign in PATH; see https://docs.sigstore.dev/cosign/system_config/installation/")
+	}
+	host, path := registry.Split(imageName)
+	repo, tag := splitTag(path)
+	client, err := registry.New(host, "kdev")
+	if err != nil {
+		return err
+	}
+	digest, err := client.Digest(ctx, repo, tag)
+	if err != nil {
+		return fmt.Errorf("failed to look up the digest of %s: %w", imageName, err)
+	}
+	ref := strings.TrimSuffix(imageName, ":"+tag) + "@" + digest
+
+	args := []string{"sign", "--yes"}
+	switch {
+	case key != "":
+		args = append(args, "--key", key)
+		// The transparency log is public.
+		if offline {
+			args = append(args, "--tlog-upload=false")
+		}
+	case offline:
+		return fmt.Errorf("keyless signing needs the public Sigstore services, which offline mode forbids; set a cosign key with --sign-key or signing.key in the user config")
+	}
+	if recursive {
+		args = append(args, "--recursive")
+	}
+	// cosign may prompt for the key's password (unless COSIGN_PASSWORD is
+	// set) or print a login URL for keyless signing, so it gets the terminal.
+	progress.Infof("Signing %s", ref)
+	cmd := exec.CommandContext(ctx, "cosign", append(args, ref)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stderr, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("cosign sign failed: %w", err)
+	}
+	return nil
+}
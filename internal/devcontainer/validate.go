@@ -0,0 +1,267 @@
+package devcontainer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/noopduck/kdev/internal/config"
+)
+
+// jsonKind is a set of JSON types a property accepts.
+type jsonKind int
+
+const (
+	kindString jsonKind = 1 << iota
+	kindBool
+	kindNumber
+	kindObject
+	kindArray
+
+	kindCommand = kindString | kindArray | kindObject
+)
+
+func (k jsonKind) String() string {
+	var names []string
+	for _, n := range []struct {
+		kind jsonKind
+		name string
+	}{{kindString, "a string"}, {kindBool, "a boolean"}, {kindNumber, "a number"}, {kindObject, "an object"}, {kindArray, "a list"}} {
+		if k&n.kind != 0 {
+			names = append(names, n.name)
+		}
+	}
+	return strings.Join(names, " or ")
+}
+
+// schemaProperties are the top-level properties of the published
+// devcontainer.json schema (image, Dockerfile and compose variants), plus
+// extends, which kdev adds.
+var schemaProperties = map[string]jsonKind{
+	"$schema":                     kindString,
+	"name":                        kindString,
+	"extends":                     kindString | kindArray,
+	"image":                       kindString,
+	"build":                       kindObject,
+	"dockerFile":                  kindString,
+	"context":                     kindString,
+	"dockerComposeFile":           kindString | kindArray,
+	"service":                     kindString,
+	"runServices":                 kindArray,
+	"workspaceFolder":             kindString,
+	"workspaceMount":              kindString,
+	"forwardPorts":                kindArray,
+	"portsAttributes":             kindObject,
+	"otherPortsAttributes":        kindObject,
+	"appPort":                     kindNumber | kindString | kindArray,
+	"containerEnv":                kindObject,
+	"remoteEnv":                   kindObject,
+	"containerUser":               kindString,
+	"remoteUser":                  kindString,
+	"updateRemoteUserUID":         kindBool,
+	"userEnvProbe":                kindString,
+	"mounts":                      kindArray,
+	"runArgs":                     kindArray,
+	"init":                        kindBool,
+	"privileged":                  kindBool,
+	"capAdd":                      kindArray,
+	"securityOpt":                 kindArray,
+	"overrideCommand":             kindBool,
+	"shutdownAction":              kindString,
+	"features":                    kindObject,
+	"overrideFeatureInstallOrder": kindArray,
+	"initializeCommand":           kindCommand,
+	"onCreateCommand":             kindCommand,
+	"updateContentCommand":        kindCommand,
+	"postCreateCommand":           kindCommand,
+	"postStartCommand":            kindCommand,
+	"postAttachCommand":           kindCommand,
+	"waitFor":                     kindString,
+	"hostRequirements":            kindObject,
+	"customizations":              kindObject,
+	"secrets":                     kindObject,
+	"extensions":                  kindArray,
+	"settings":                    kindObject,
+	"devPort":                     kindNumber,
+}
+
+// buildProperties are the properties of the build object.
+var buildProperties = map[string]jsonKind{
+	"dockerfile": kindString,
+	"context":    kindString,
+	"args":       kindObject,
+	"target":     kindString,
+	"cacheFrom":  kindString | kindArray,
+	"options":    kindArray,
+}
+
+// unsupported are the properties kdev cannot honor in Kubernetes, with
+// what to do instead.
+var unsupported = map[string]string{
+	"appPort":             "pods publish no host ports; use forwardPorts",
+	"runArgs":             "docker run arguments have no pod equivalent",
+	"init":                "pods have no docker init process",
+	"privileged":          "kdev's pods run unprivileged",
+	"capAdd":              "kdev's pods run without added capabilities",
+	"securityOpt":         "docker security options have no pod equivalent",
+	"workspaceMount":      "the workspace is a PVC mounted at workspaceFolder",
+	"shutdownAction":      "the pod keeps running until kdev stop or kdev rm",
+	"hostRequirements":    "use kdev up --size, --cpu and --memory",
+	"initializeCommand":   "kdev does not run commands on the local machine",
+	"waitFor":             "kdev runs every lifecycle command before attaching",
+	"userEnvProbe":        "kdev does not probe the user's shell environment",
+	"updateRemoteUserUID": "kdev runs the pod as the remoteUser's UID instead",
+	"secrets":             "pass secrets with kdev up --env-from secret/NAME",
+	"extensions":          "deprecated; use customizations.vscode.extensions",
+	"settings":            "deprecated; use customizations.vscode.settings",
+	"devPort":             "deprecated and unused",
+	"dockerFile":          "deprecated; use build.dockerfile",
+	"context":             "deprecated; use build.context",
+}
+
+// Problem is something validation found in a devcontainer.json.
+type Problem struct {
+	Path    string // property path, e.g. build.dockerfile
+	Warning bool   // kdev can still use the file
+	Msg     string
+}
+
+func (p Problem) String() string {
+	level := "error"
+	if p.Warning {
+		level = "warning"
+	}
+	return fmt.Sprintf("%s: %s: %s", level, p.Path, p.Msg)
+}
+
+// Validate checks the devcontainer.json at path, with the configs it
+// extends and its local override, against the devcontainer.json schema,
+// checks that the files it references exist and warns about properties kdev
+// cannot honor. The error is for a file that cannot be read at all.
+func Validate(path string) ([]Problem, error) {
+	raw, err := loadLayered(path)
+	if err != nil {
+		return nil, err
+	}
+	var problems []Problem
+	add := func(warning bool, path, format string, args ...interface{}) {
+		problems = append(problems, Problem{Path: path, Warning: warning, Msg: fmt.Sprintf(format, args...)})
+	}
+
+	checkProperties(raw, "", schemaProperties, add)
+	if build, ok := raw["build"].(map[string]interface{}); ok {
+		checkProperties(build, "build.", buildProperties, add)
+		for _, key := range []string{"target", "cacheFrom", "options"} {
+			if _, ok := build[key]; ok {
+				add(true, "build."+key, "kdev devcontainer build ignores it")
+			}
+		}
+	}
+	for _, key := range sortedKeys(raw) {
+		if reason, ok := unsupported[key]; ok {
+			add(true, key, "kdev ignores it: %s", reason)
+		}
+	}
+
+	cfg, err := readDevContainerConfig(path)
+	if err != nil {
+		// Usually a type error reported above already.
+		if !slices.ContainsFunc(problems, func(p Problem) bool { return !p.Warning }) {
+			add(false, "(document)", "%v", err)
+		}
+		return problems, nil
+	}
+	switch {
+	case cfg.DockerComposeFile != nil:
+		if cfg.Service == "" {
+			add(false, "service", "dockerComposeFile needs the service kdev attaches to")
+		}
+		files, err := cfg.ComposeFiles()
+		if err != nil {
+			add(false, "dockerComposeFile", "%v", err)
+		}
+		for _, file := range files {
+			if _, err := os.Stat(file); err != nil {
+				add(false, "dockerComposeFile", "%s not found", file)
+			}
+		}
+	case cfg.Build.Dockerfile != "":
+		// Resolved as the build resolves them.
+		dockerfile, contextDir := cfg.buildPaths()
+		if _, err := os.Stat(dockerfile); err != nil {
+			add(false, "build.dockerfile", "%s not found", dockerfile)
+		}
+		if fi, err := os.Stat(contextDir); err != nil || !fi.IsDir() {
+			add(false, "build.context", "directory %s not found", contextDir)
+		}
+	case cfg.Image == "":
+		add(false, "(root)", "set image, build.dockerfile or dockerComposeFile")
+	}
+	for _, ref := range sortedKeys(cfg.Features) {
+		if !isLocalFeature(ref) {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(cfg.dir, ref, "devcontainer-feature.json")); err != nil {
+			add(false, fmt.Sprintf("features[%q]", ref), "%s has no devcontainer-feature.json", filepath.Join(cfg.dir, ref))
+		}
+	}
+	for i, mt := range cfg.Mounts {
+		if mt.Type == "bind" {
+			add(true, fmt.Sprintf("mounts[%d]", i), "kdev skips bind mounts: a pod cannot mount local files")
+		}
+	}
+	return problems, nil
+}
+
+// checkProperties reports the keys of obj missing from known and values of
+// the wrong type.
+func checkProperties(obj map[string]interface{}, prefix string, known map[string]jsonKind, add func(bool, string, string, ...interface{})) {
+	names := make([]string, 0, len(known))
+	for name := range known {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, key := range sortedKeys(obj) {
+		want, ok := known[key]
+		if !ok {
+			msg := fmt.Sprintf("unknown property %q", key)
+			if s := config.Suggest(key, names); s != "" {
+				msg += fmt.Sprintf(" (did you mean %q?)", s)
+			}
+			add(false, prefix+key, "%s", msg)
+			continue
+		}
+		if got := kindOf(obj[key]); got != 0 && want&got == 0 {
+			add(false, prefix+key, "expected %s, got %s", want, got)
+		}
+	}
+}
+
+// kindOf returns the JSON type of a decoded value, or 0 for null.
+func kindOf(v interface{}) jsonKind {
+	switch v.(type) {
+	case string:
+		return kindString
+	case bool:
+		return kindBool
+	case float64:
+		return kindNumber
+	case map[string]interface{}:
+		return kindObject
+	case []interface{}:
+		return kindArray
+	}
+	return 0
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
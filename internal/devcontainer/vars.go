@@ -0,0 +1,106 @@
+package devcontainer
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// varRef matches ${name} and ${name:arg...} references in devcontainer.json.
+var varRef = regexp.MustCompile(`\$\{([^}:]+)(?::([^}]*))?\}`)
+
+// variables holds what devcontainer.json variables resolve to.
+type variables struct {
+	localWorkspaceFolder     string
+	containerWorkspaceFolder string
+	devcontainerID           string
+}
+
+// newVariables returns the variables of the devcontainer.json at
+// configPath, whose workspace is the folder containing .devcontainer.
+// workspaceFolder is the config's own workspaceFolder, if any.
+func newVariables(configPath, workspaceFolder string) (*variables, error) {
+	configPath, err := filepath.Abs(configPath)
+	if err != nil {
+		return nil, err
+	}
+	local := filepath.Dir(configPath)
+	if filepath.Base(local) == ".devcontainer" {
+		local = filepath.Dir(local)
+	}
+	v := &variables{
+		localWorkspaceFolder: local,
+		devcontainerID:       devcontainerID(local, configPath),
+	}
+	v.containerWorkspaceFolder = "/workspaces/" + filepath.Base(local)
+	if workspaceFolder != "" {
+		v.containerWorkspaceFolder = v.expand(workspaceFolder)
+	}
+	return v, nil
+}
+
+// devcontainerID is the stable id the reference implementation derives
+// from the local folder and config file: the SHA-256 of their id labels as
+// JSON, in base 32, padded to 52 digits.
+func devcontainerID(localFolder, configFile string) string {
+	labels, _ := json.Marshal(map[string]string{
+		"devcontainer.config_file":  configFile,
+		"devcontainer.local_folder": localFolder,
+	})
+	sum := sha256.Sum256(labels)
+	id := new(big.Int).SetBytes(sum[:]).Text(32)
+	return strings.Repeat("0", max(52-len(id), 0)) + id
+}
+
+// expand substitutes the variables in s. References it does not know,
+// such as ${containerEnv:PATH} which only the container can resolve, are
+// left as they are.
+func (v *variables) expand(s string) string {
+	return varRef.ReplaceAllStringFunc(s, func(ref string) string {
+		m := varRef.FindStringSubmatch(ref)
+		switch m[1] {
+		case "localEnv", "env":
+			name, def, _ := strings.Cut(m[2], ":")
+			if val, ok := os.LookupEnv(name); ok {
+				return val
+			}
+			return def
+		case "localWorkspaceFolder":
+			return v.localWorkspaceFolder
+		case "localWorkspaceFolderBasename":
+			return filepath.Base(v.localWorkspaceFolder)
+		case "containerWorkspaceFolder":
+			return v.containerWorkspaceFolder
+		case "containerWorkspaceFolderBasename":
+			return path.Base(v.containerWorkspaceFolder)
+		case "devcontainerId":
+			return v.devcontainerID
+		}
+		return ref
+	})
+}
+
+// expandAll substitutes the variables in every string value of a decoded
+// JSON value.
+func (v *variables) expandAll(x interface{}) interface{} {
+	switch x := x.(type) {
+	case string:
+		return v.expand(x)
+	case []interface{}:
+		for i := range x {
+			x[i] = v.expandAll(x[i])
+		}
+		return x
+	case map[string]interface{}:
+		for k, val := range x {
+			x[k] = v.expandAll(val)
+		}
+		return x
+	}
+	return x
+}
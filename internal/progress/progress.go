@@ -0,0 +1,199 @@
+// Package progress reports long-running operations. On a terminal it shows
+// an animated spinner; otherwise it prints plain lines so logs stay readable.
+// With Quiet set only errors and final results reach the user.
+package progress
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// Quiet suppresses spinners, progress notes and warnings. It is set by the
+// global --quiet flag.
+var Quiet bool
+
+// Infof prints a progress note to stderr unless Quiet is set.
+func Infof(format string, args ...interface{}) {
+	if !Quiet {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+}
+
+// Warnf prints a warning to stderr unless Quiet is set.
+func Warnf(format string, args ...interface{}) {
+	if !Quiet {
+		fmt.Fprintf(os.Stderr, "warning: "+format+"\n", args...)
+	}
+}
+
+func isTerminal() bool {
+	return term.IsTerminal(int(os.Stderr.Fd()))
+}
+
+// Spinner shows progress for a long operation on stderr. On a terminal it
+// animates in place; otherwise it prints one line when starting and one when
+// done.
+type Spinner struct {
+	mu     sync.Mutex
+	msg    string
+	detail string
+	tty    bool
+	quiet  bool
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// Start begins reporting progress for msg.
+func Start(msg string) *Spinner {
+	s := &Spinner{msg: msg, tty: isTerminal(), quiet: Quiet, stop: make(chan struct{})}
+	switch {
+	case s.quiet:
+		return s
+	case !s.tty:
+		fmt.Fprintf(os.Stderr, "%s...\n", msg)
+		return s
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		frames := []rune("⠋⠙⠹⠸⠼⠴⠦⠧⠇⠏")
+		t := time.NewTicker(100 * time.Millisecond)
+		defer t.Stop()
+		for i := 0; ; i++ {
+			s.mu.Lock()
+			line := s.msg
+			if s.detail != "" {
+				line += ": " + s.detail
+			}
+			if w, _, err := term.GetSize(int(os.Stderr.Fd())); err == nil && w > 3 && len([]rune(line)) > w-3 {
+				line = string([]rune(line)[:w-3])
+			}
+			fmt.Fprintf(os.Stderr, "\r\033[K%c %s", frames[i%len(frames)], line)
+			s.mu.Unlock()
+			select {
+			case <-s.stop:
+				return
+			case <-t.C:
+			}
+		}
+	}()
+	return s
+}
+
+// Detail shows a short status next to the message on a terminal, such as
+// the current pod phase or the last line of a build.
+func (s *Spinner) Detail(detail string) {
+	s.mu.Lock()
+	s.detail = strings.TrimSpace(detail)
+	s.mu.Unlock()
+}
+
+// Logf prints an indented line above the spinner, for messages that should
+// stay in the scrollback such as warnings from the cluster.
+func (s *Spinner) Logf(format string, args ...interface{}) {
+	if s.quiet {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tty {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+	fmt.Fprintf(os.Stderr, "  "+format+"\n", args...)
+}
+
+// Done stops the spinner and prints a final status line.
+func (s *Spinner) Done(err error) {
+	if s.quiet {
+		return
+	}
+	if s.tty {
+		close(s.stop)
+		s.wg.Wait()
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "✗ %s\n", s.msg)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "✓ %s\n", s.msg)
+}
+
+// Run runs an external command such as docker build under a spinner. On a
+// terminal, and with Quiet, its output is collected and only shown when it
+// fails; elsewhere it is streamed as is.
+func Run(msg string, cmd *exec.Cmd) error {
+	if !Quiet && !isTerminal() {
+		fmt.Fprintf(os.Stderr, "%s...\n", msg)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		err := cmd.Run()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "✗ %s\n", msg)
+		} else {
+			fmt.Fprintf(os.Stderr, "✓ %s\n", msg)
+		}
+		return err
+	}
+
+	s := Start(msg)
+	var out bytes.Buffer
+	w := &lastLineWriter{spinner: s}
+	cmd.Stdout = io.MultiWriter(&out, w)
+	cmd.Stderr = cmd.Stdout
+	err := cmd.Run()
+	s.Done(err)
+	if err != nil {
+		os.Stderr.Write(out.Bytes())
+	}
+	return err
+}
+
+// Stream is Run for output that does not come from a local command, such
+// as the logs of a pod: fn writes it to w.
+func Stream(msg string, fn func(w io.Writer) error) error {
+	if !Quiet && !isTerminal() {
+		fmt.Fprintf(os.Stderr, "%s...\n", msg)
+		err := fn(os.Stderr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "✗ %s\n", msg)
+		} else {
+			fmt.Fprintf(os.Stderr, "✓ %s\n", msg)
+		}
+		return err
+	}
+
+	s := Start(msg)
+	var out bytes.Buffer
+	err := fn(io.MultiWriter(&out, &lastLineWriter{spinner: s}))
+	s.Done(err)
+	if err != nil {
+		os.Stderr.Write(out.Bytes())
+	}
+	return err
+}
+
+// lastLineWriter feeds the last complete output line to a spinner.
+type lastLineWriter struct {
+	spinner *Spinner
+	partial []byte
+}
+
+func (w *lastLineWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	if i := bytes.LastIndexAny(w.partial, "\r\n"); i >= 0 {
+		lines := strings.FieldsFunc(string(w.partial[:i]), func(r rune) bool { return r == '\n' || r == '\r' })
+		if len(lines) > 0 {
+			w.spinner.Detail(lines[len(lines)-1])
+		}
+		w.partial = append([]byte(nil), w.partial[i+1:]...)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,155 @@
+// Package recording captures interactive sessions as asciicast v2 files,
+// the format asciinema plays back: a JSON header line followed by one
+// [seconds, type, data] line per chunk of output ("o") or input ("i").
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/noopduck/kdev/internal/config"
+)
+
+// Ext is the file extension of recordings.
+const Ext = ".cast"
+
+// partExt is appended to Ext while the session is still being recorded;
+// Close renames the file, so only closed recordings have Ext.
+const partExt = ".part"
+
+// Header is the first line of a recording.
+type Header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Command   string            `json:"command,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Recorder appends the events of one session to its file. Write errors do
+// not interrupt the session; the first one is returned by Close.
+type Recorder struct {
+	mu    sync.Mutex
+	f     *os.File
+	w     *bufio.Writer
+	path  string
+	start time.Time
+	err   error
+}
+
+// DefaultDir returns recordings/ next to the user config.
+func DefaultDir() (string, error) {
+	path, err := config.Path()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(path), "recordings"), nil
+}
+
+// Start creates dir/name.cast.part and writes h to it; Close renames it to
+// dir/name.cast. The file is only readable by the user, as recordings may
+// hold secrets.
+func Start(dir, name string, h Header) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	path := filepath.Join(dir, name+Ext)
+	f, err := os.OpenFile(path+partExt, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording: %w", err)
+	}
+	r := &Recorder{f: f, w: bufio.NewWriter(f), path: path, start: time.Now()}
+	h.Version = 2
+	h.Timestamp = r.start.Unix()
+	line, err := json.Marshal(h)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := r.w.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return r, nil
+}
+
+// Path is the file the session is recorded to once it is closed.
+func (r *Recorder) Path() string {
+	return r.path
+}
+
+// Output returns a writer recording what it is given as output events.
+func (r *Recorder) Output() io.Writer {
+	return &eventWriter{r: r, typ: "o"}
+}
+
+// Input returns a writer recording what it is given as input events.
+func (r *Recorder) Input() io.Writer {
+	return &eventWriter{r: r, typ: "i"}
+}
+
+// Close flushes and closes the file and gives it its final name, which
+// marks it complete for Upload.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err := errors.Join(r.err, r.w.Flush(), r.f.Close())
+	err = errors.Join(err, os.Rename(r.path+partExt, r.path))
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", r.path, err)
+	}
+	return nil
+}
+
+func (r *Recorder) event(typ string, data string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	at := time.Since(r.start).Seconds()
+	line, err := json.Marshal([]interface{}{float64(int64(at*1e6)) / 1e6, typ, data})
+	if err == nil {
+		_, err = r.w.Write(append(line, '\n'))
+	}
+	r.err = err
+}
+
+// eventWriter turns writes into events. A multi-byte character split
+// across writes is held back until it is complete, since events must be
+// valid UTF-8.
+type eventWriter struct {
+	r       *Recorder
+	typ     string
+	pending []byte
+}
+
+func (e *eventWriter) Write(p []byte) (int, error) {
+	buf := append(e.pending, p...)
+	cut := len(buf)
+	// Back up over an incomplete character at the end, at most 3 bytes.
+	for i := len(buf) - 1; i >= 0 && i >= len(buf)-utf8.UTFMax+1; i-- {
+		if utf8.RuneStart(buf[i]) {
+			if !utf8.FullRune(buf[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	e.pending = append([]byte(nil), buf[cut:]...)
+	if cut > 0 {
+		e.r.event(e.typ, strings.ToValidUTF8(string(buf[:cut]), "�"))
+	}
+	return len(p), nil
+}
@@ -0,0 +1,148 @@
+package recording
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/noopduck/kdev/internal/config"
+)
+
+// Upload puts every closed recording in dir into the bucket and deletes
+// the ones that were uploaded, so recordings left over by a failed upload
+// go along with the next one. Sessions still being recorded, by this or
+// another kdev, have no .cast file yet and are left alone.
+func Upload(ctx context.Context, dir string, bucket *config.S3) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+Ext))
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, path := range paths {
+		if err := uploadFile(ctx, path, bucket); err != nil {
+			errs = append(errs, fmt.Errorf("failed to upload %s: %w", path, err))
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func uploadFile(ctx context.Context, path string, bucket *config.S3) error {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	region := bucket.Region
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		return errors.New("no region: set recording.s3.region or AWS_REGION")
+	}
+	key, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if key == "" || secret == "" {
+		return errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+
+	object := bucket.Prefix + filepath.Base(path)
+	var u *url.URL
+	if bucket.Endpoint != "" {
+		if u, err = url.Parse(strings.TrimSuffix(bucket.Endpoint, "/") + "/" + bucket.Bucket + "/" + object); err != nil {
+			return fmt.Errorf("invalid endpoint %q: %w", bucket.Endpoint, err)
+		}
+	} else {
+		u = &url.URL{Scheme: "https", Host: fmt.Sprintf("%s.s3.%s.amazonaws.com", bucket.Bucket, region), Path: "/" + object}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-asciicast")
+	signV4(req, body, region, key, secret, os.Getenv("AWS_SESSION_TOKEN"), time.Now())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s returned %s", u.Host, resp.Status)
+	}
+	return nil
+}
+
+// signV4 adds an AWS Signature Version 4 for the s3 service to req.
+func signV4(req *http.Request, body []byte, region, key, secret, token string, now time.Time) {
+	stamp := now.UTC().Format("20060102T150405Z")
+	day := stamp[:8]
+	payload := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	if token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+
+	names := []string{"content-type", "host", "x-amz-content-sha256", "x-amz-date"}
+	if token != "" {
+		names = append(names, "x-amz-security-token")
+	}
+	var canonical strings.Builder
+	for _, n := range names {
+		v := req.Header.Get(n)
+		if n == "host" {
+			v = req.URL.Host
+		}
+		canonical.WriteString(n + ":" + strings.TrimSpace(v) + "\n")
+	}
+	signed := strings.Join(names, ";")
+	request := strings.Join([]string{req.Method, escapePath(req.URL.Path), req.URL.RawQuery, canonical.String(), signed, payload}, "\n")
+
+	scope := day + "/" + region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + sha256Hex([]byte(request))
+	k := hmacSHA256([]byte("AWS4"+secret), day)
+	for _, part := range []string{region, "s3", "aws4_request"} {
+		k = hmacSHA256(k, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		key, scope, signed, hex.EncodeToString(hmacSHA256(k, toSign))))
+}
+
+// escapePath encodes a path the way SigV4 canonicalizes it: everything but
+// unreserved characters and slashes is percent-encoded.
+func escapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if c == '/' || c == '-' || c == '.' || c == '_' || c == '~' ||
+			'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
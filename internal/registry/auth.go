@@ -0,0 +1,192 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Credential is a username and password, or an identity token, for a
+// registry.
+type Credential struct {
+	Username string
+	Password string
+	// IdentityToken is a refresh token, exchanged for an access token at
+	// the registry's token service, as Azure and Harbor OIDC logins store.
+	IdentityToken string
+}
+
+// dockerConfig is the part of ~/.docker/config.json kdev reads.
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+// dockerConfigPath honors DOCKER_CONFIG like the docker CLI.
+func dockerConfigPath() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".docker", "config.json"), nil
+}
+
+// containersAuthPaths are where podman, buildah and skopeo keep logins,
+// which use the auths format of docker's config.json.
+func containersAuthPaths() []string {
+	if f := os.Getenv("REGISTRY_AUTH_FILE"); f != "" {
+		return []string{f}
+	}
+	var paths []string
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		paths = append(paths, filepath.Join(dir, "containers", "auth.json"))
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		paths = append(paths, filepath.Join(dir, "containers", "auth.json"))
+	}
+	return paths
+}
+
+// LookupCredential returns the credential for host the way the docker CLI
+// finds it: from the credential helper configured for host in
+// ~/.docker/config.json, else from its credsStore, else from its auths.
+// Logins of podman and buildah in containers/auth.json come after those. It
+// returns nil if there is none.
+func LookupCredential(host string) (*Credential, error) {
+	if host == DockerHub {
+		host = dockerHubAPI
+	}
+	path, err := dockerConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := readDockerConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	for key, helper := range cfg.CredHelpers {
+		if registryHost(key) == host {
+			return helperCredential(helper, key)
+		}
+	}
+	if cfg.CredsStore != "" {
+		key := host
+		if host == dockerHubAPI {
+			key = DockerHubIndex
+		}
+		cred, err := helperCredential(cfg.CredsStore, key)
+		if cred != nil || err != nil {
+			return cred, err
+		}
+	}
+	if cred, err := authsCredential(cfg, path, host); cred != nil || err != nil {
+		return cred, err
+	}
+	for _, path := range containersAuthPaths() {
+		cfg, err := readDockerConfig(path)
+		if err != nil {
+			return nil, err
+		}
+		if cred, err := authsCredential(cfg, path, host); cred != nil || err != nil {
+			return cred, err
+		}
+	}
+	return nil, nil
+}
+
+// readDockerConfig reads a config.json or auth.json; a missing one is empty.
+func readDockerConfig(path string) (*dockerConfig, error) {
+	var cfg dockerConfig
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// authsCredential returns the credential stored inline for host in cfg.
+func authsCredential(cfg *dockerConfig, path, host string) (*Credential, error) {
+	for key, entry := range cfg.Auths {
+		if registryHost(key) != host || (entry.Auth == "" && entry.IdentityToken == "") {
+			continue
+		}
+		cred := &Credential{IdentityToken: entry.IdentityToken}
+		if entry.Auth != "" {
+			raw, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("invalid auth for %s in %s: %w", key, path, err)
+			}
+			cred.Username, cred.Password, _ = strings.Cut(string(raw), ":")
+		}
+		return cred, nil
+	}
+	return nil, nil
+}
+
+// helperCredential asks docker-credential-<helper> for the credential of
+// serverURL. It returns nil when the helper has none or is not installed.
+func helperCredential(helper, serverURL string) (*Credential, error) {
+	name := "docker-credential-" + helper
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(name, "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		// Helpers report a missing credential on stdout, e.g.
+		// "credentials not found in native keychain".
+		if msg := stdout.String() + stderr.String(); strings.Contains(strings.ToLower(msg), "not found") {
+			return nil, nil
+		}
+		// A helper of another machine, as in a shared config, has nothing
+		// for kdev; requests go without credentials.
+		if errors.Is(err, exec.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%s get %s failed: %v: %s", name, serverURL, err, strings.TrimSpace(stderr.String()+stdout.String()))
+	}
+	var resp struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("invalid response of %s: %w", name, err)
+	}
+	if resp.Secret == "" {
+		return nil, nil
+	}
+	// Helpers return identity tokens under the username <token>.
+	if resp.Username == "<token>" {
+		return &Credential{IdentityToken: resp.Secret}, nil
+	}
+	return &Credential{Username: resp.Username, Password: resp.Secret}, nil
+}
+
+// registryHost normalizes a config.json key such as
+// "https://index.docker.io/v1/" to its host.
+func registryHost(key string) string {
+	key = strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	key, _, _ = strings.Cut(key, "/")
+	if key == "index.docker.io" || key == "docker.io" {
+		return dockerHubAPI
+	}
+	return key
+}
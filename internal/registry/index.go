@@ -0,0 +1,116 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// PushIndex makes repo:tag a multi-platform image: an index of the images
+// at refs, a tag or digest per os/arch[/variant] platform, which must be in
+// repo already. It returns the digest of the index.
+func (c *Client) PushIndex(ctx context.Context, repo, tag string, refs map[string]string) (string, error) {
+	platforms := make([]string, 0, len(refs))
+	for p := range refs {
+		platforms = append(platforms, p)
+	}
+	sort.Strings(platforms)
+
+	index := struct {
+		SchemaVersion int          `json:"schemaVersion"`
+		MediaType     string       `json:"mediaType"`
+		Manifests     []descriptor `json:"manifests"`
+	}{SchemaVersion: 2, MediaType: mediaDockerList}
+	for _, p := range platforms {
+		d, err := c.platformManifest(ctx, repo, refs[p], p)
+		if err != nil {
+			return "", err
+		}
+		// A Docker list can only hold Docker manifests.
+		if d.MediaType != mediaDockerManifest {
+			index.MediaType = mediaOCIIndex
+		}
+		index.Manifests = append(index.Manifests, d)
+	}
+	data, err := json.Marshal(index)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.send(ctx, http.MethodPut, "/v2/"+repo+"/manifests/"+tag, pushScope(repo), "", index.MediaType, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to push manifest list %s:%s: %w", repo, tag, err)
+	}
+	resp.Body.Close()
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data)), nil
+}
+
+// platformManifest returns the descriptor of the image of platform at
+// repo:ref. Builders that push an index of their own, for instance with
+// attestations, have the platform's entry picked from it.
+func (c *Client) platformManifest(ctx context.Context, repo, ref, p string) (descriptor, error) {
+	data, d, err := c.fetchManifest(ctx, repo, ref)
+	if err != nil {
+		return descriptor{}, err
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return descriptor{}, fmt.Errorf("invalid manifest of %s:%s: %w", repo, ref, err)
+	}
+	if len(m.Manifests) > 0 {
+		for _, d := range m.Manifests {
+			if d.Platform != nil && d.Platform.String() == p {
+				return d, nil
+			}
+		}
+		return descriptor{}, fmt.Errorf("%s:%s has no image for %s", repo, ref, p)
+	}
+
+	parts := strings.SplitN(p, "/", 3)
+	if len(parts) < 2 {
+		return descriptor{}, fmt.Errorf("invalid platform %q (want os/arch[/variant])", p)
+	}
+	d.Platform = &platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		d.Platform.Variant = parts[2]
+	}
+	return d, nil
+}
+
+// fetchManifest returns the manifest or index at repo:ref and its
+// descriptor.
+func (c *Client) fetchManifest(ctx context.Context, repo, ref string) ([]byte, descriptor, error) {
+	resp, err := c.do(ctx, "/v2/"+repo+"/manifests/"+ref, pullScope(repo), acceptManifestHeader)
+	if err != nil {
+		return nil, descriptor{}, fmt.Errorf("failed to get manifest of %s:%s: %w", repo, ref, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, descriptor{}, fmt.Errorf("failed to read manifest of %s:%s: %w", repo, ref, err)
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, descriptor{}, fmt.Errorf("invalid manifest of %s:%s: %w", repo, ref, err)
+	}
+	d := descriptor{MediaType: m.MediaType, Digest: resp.Header.Get("Docker-Content-Digest"), Size: int64(len(data))}
+	if d.MediaType == "" {
+		d.MediaType, _, _ = mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	}
+	if d.Digest == "" {
+		d.Digest = fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	}
+	return data, d, nil
+}
+
+func pushScope(repo string) string {
+	return "repository:" + repo + ":pull,push"
+}
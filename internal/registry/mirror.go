@@ -0,0 +1,80 @@
+package registry
+
+import "strings"
+
+// DockerHub is the registry host of references without one, like "debian".
+const DockerHub = "docker.io"
+
+// publicRegistries are hosts on the internet that air-gapped clusters cannot
+// reach without a mirror.
+var publicRegistries = []string{
+	DockerHub, "ghcr.io", "quay.io", "gcr.io", "registry.k8s.io",
+	"mcr.microsoft.com", "public.ecr.aws", "registry.gitlab.com", "nvcr.io",
+}
+
+// Split separates an image reference into its registry host and the rest,
+// expanding Docker Hub short names: "debian" is docker.io, library/debian.
+func Split(ref string) (host, path string) {
+	first, rest, ok := strings.Cut(ref, "/")
+	if ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		switch first {
+		case "index.docker.io", "registry-1.docker.io":
+			first = DockerHub
+		}
+		return first, rest
+	}
+	if !ok {
+		return DockerHub, "library/" + ref
+	}
+	return DockerHub, ref
+}
+
+// Rewrite applies the longest matching mirror rule to ref. Rule keys are a
+// registry host or a host/path prefix; the matching prefix is replaced by
+// the value, e.g. docker.io: mirror.internal/dockerhub turns debian:12 into
+// mirror.internal/dockerhub/library/debian:12. Unmatched references are
+// returned unchanged.
+func Rewrite(ref string, mirrors map[string]string) string {
+	if ref == "" || len(mirrors) == 0 {
+		return ref
+	}
+	host, path := Split(ref)
+	full := host + "/" + path
+	best := ""
+	for prefix := range mirrors {
+		p := strings.TrimSuffix(prefix, "/")
+		if matchesPrefix(full, p) && len(p) > len(best) {
+			best = p
+		}
+	}
+	if best == "" {
+		return ref
+	}
+	return strings.TrimSuffix(mirrors[best], "/") + strings.TrimPrefix(full, best)
+}
+
+// matchesPrefix reports whether ref starts with the path components of p,
+// with or without a tag or digest after them.
+func matchesPrefix(ref, p string) bool {
+	if !strings.HasPrefix(ref, p) {
+		return false
+	}
+	rest := ref[len(p):]
+	return rest == "" || strings.ContainsAny(rest[:1], "/:@")
+}
+
+// IsPublic reports whether ref points at a well-known internet registry.
+func IsPublic(ref string) bool {
+	host, _ := Split(ref)
+	return IsPublicHost(host)
+}
+
+// IsPublicHost reports whether host is a well-known internet registry.
+func IsPublicHost(host string) bool {
+	for _, p := range publicRegistries {
+		if host == p {
+			return true
+		}
+	}
+	return false
+}
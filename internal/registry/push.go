@@ -0,0 +1,375 @@
+package registry
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// Media types of the images PushArchive pushes.
+const (
+	mediaOCIConfig   = "application/vnd.oci.image.config.v1+json"
+	mediaOCILayerGz  = "application/vnd.oci.image.layer.v1.tar+gzip"
+	mediaOCILayerZst = "application/vnd.oci.image.layer.v1.tar+zstd"
+)
+
+// pushAttempts bounds the attempts of each upload; they back off from one
+// second.
+const pushAttempts = 5
+
+// Reporter is told how a push is going; a progress spinner is one.
+type Reporter interface {
+	// Detail shows the current step.
+	Detail(detail string)
+	// Logf reports something worth keeping, such as a retry.
+	Logf(format string, args ...interface{})
+}
+
+// PushArchive pushes the image in a docker-archive tarball, as written by
+// docker save, podman save, nerdctl save or buildah push docker-archive:,
+// to repo:tag and returns the digest of its manifest. Layers are uploaded
+// compressed, and layers the registry already has are skipped. Failed
+// uploads are retried.
+func (c *Client) PushArchive(ctx context.Context, repo, tag, archive string, report Reporter) (string, error) {
+	if report == nil {
+		report = nopReporter{}
+	}
+	m, links, err := readArchiveManifest(archive)
+	if err != nil {
+		return "", err
+	}
+	resolve := func(name string) string {
+		for i := 0; i < 10; i++ {
+			target, ok := links[path.Clean(name)]
+			if !ok {
+				break
+			}
+			name = target
+		}
+		return path.Clean(name)
+	}
+	configName := resolve(m.Config)
+	layerIndex := map[string]int{}
+	for i, l := range m.Layers {
+		layerIndex[resolve(l)] = i
+	}
+
+	f, err := os.Open(archive)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	var config []byte
+	var diffIDs []string
+	layers := make([]descriptor, len(m.Layers))
+	cache := loadLayerCache()
+	defer cache.save()
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", archive, err)
+		}
+		name := path.Clean(hdr.Name)
+		if name == configName {
+			if config, err = io.ReadAll(tr); err != nil {
+				return "", fmt.Errorf("failed to read image config: %w", err)
+			}
+			var cfg struct {
+				RootFS struct {
+					DiffIDs []string `json:"diff_ids"`
+				} `json:"rootfs"`
+			}
+			if err := json.Unmarshal(config, &cfg); err != nil {
+				return "", fmt.Errorf("invalid image config: %w", err)
+			}
+			diffIDs = cfg.RootFS.DiffIDs
+			continue
+		}
+		i, ok := layerIndex[name]
+		if !ok || hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		report.Detail(fmt.Sprintf("layer %d/%d", i+1, len(layers)))
+		// Layers compress the same every time, so one pushed before under
+		// its diff ID is only looked up.
+		if i < len(diffIDs) {
+			if d, ok := cache.get(diffIDs[i]); ok && c.hasBlob(ctx, repo, d.Digest) {
+				layers[i] = d
+				continue
+			}
+		}
+		d, err := c.pushLayer(ctx, repo, tr, report)
+		if err != nil {
+			return "", fmt.Errorf("failed to push layer %d/%d: %w", i+1, len(layers), err)
+		}
+		if i < len(diffIDs) {
+			cache.put(diffIDs[i], d)
+		}
+		layers[i] = d
+	}
+	if config == nil {
+		return "", fmt.Errorf("%s has no image config %s", archive, m.Config)
+	}
+	for i, d := range layers {
+		if d.Digest == "" {
+			return "", fmt.Errorf("%s has no layer %s", archive, m.Layers[i])
+		}
+	}
+
+	report.Detail("config")
+	configDesc, err := c.pushBlob(ctx, repo, mediaOCIConfig, config)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(struct {
+		SchemaVersion int          `json:"schemaVersion"`
+		MediaType     string       `json:"mediaType"`
+		Config        descriptor   `json:"config"`
+		Layers        []descriptor `json:"layers"`
+	}{2, mediaOCIManifest, configDesc, layers})
+	if err != nil {
+		return "", err
+	}
+	report.Detail("manifest")
+	err = c.retry(ctx, report, func() error {
+		resp, err := c.send(ctx, http.MethodPut, "/v2/"+repo+"/manifests/"+tag, pushScope(repo), "", mediaOCIManifest, data)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to push manifest %s:%s: %w", repo, tag, err)
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data)), nil
+}
+
+// archiveManifest is an entry of the manifest.json of a docker-archive.
+type archiveManifest struct {
+	Config string   `json:"Config"`
+	Layers []string `json:"Layers"`
+}
+
+// readArchiveManifest reads the manifest.json of a docker-archive, which
+// names the config and layers, and its symlinks, by which newer docker
+// versions point legacy paths at OCI blobs.
+func readArchiveManifest(archive string) (*archiveManifest, map[string]string, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	var manifests []archiveManifest
+	links := map[string]string{}
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read %s: %w", archive, err)
+		}
+		name := path.Clean(hdr.Name)
+		switch {
+		case hdr.Typeflag == tar.TypeSymlink:
+			links[name] = path.Join(path.Dir(name), hdr.Linkname)
+		case name == "manifest.json":
+			if err := json.NewDecoder(tr).Decode(&manifests); err != nil {
+				return nil, nil, fmt.Errorf("invalid manifest.json in %s: %w", archive, err)
+			}
+		}
+	}
+	if len(manifests) != 1 {
+		return nil, nil, fmt.Errorf("%s holds %d images, not one", archive, len(manifests))
+	}
+	return &manifests[0], links, nil
+}
+
+// pushLayer compresses the layer tarball r with gzip, unless it is
+// compressed already, into a temporary file and uploads that.
+func (c *Client) pushLayer(ctx context.Context, repo string, r io.Reader, report Reporter) (descriptor, error) {
+	tmp, err := os.CreateTemp("", "kdev-layer-")
+	if err != nil {
+		return descriptor{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	d := descriptor{MediaType: mediaOCILayerGz}
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(4)
+	digest := sha256.New()
+	out := io.MultiWriter(tmp, digest)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		_, err = io.Copy(out, br)
+	case bytes.Equal(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		d.MediaType = mediaOCILayerZst
+		_, err = io.Copy(out, br)
+	default:
+		// Dev images are large; speed matters more than the last percent.
+		zw, _ := gzip.NewWriterLevel(out, gzip.BestSpeed)
+		if _, err = io.Copy(zw, br); err == nil {
+			err = zw.Close()
+		}
+	}
+	if err != nil {
+		return descriptor{}, fmt.Errorf("failed to compress layer: %w", err)
+	}
+	d.Digest = digestString(digest)
+	if d.Size, err = tmp.Seek(0, io.SeekCurrent); err != nil {
+		return descriptor{}, err
+	}
+	err = c.retry(ctx, report, func() error {
+		return c.uploadBlob(ctx, repo, d, tmp)
+	})
+	return d, err
+}
+
+// pushBlob uploads data to repo unless the registry has it already, and
+// returns its descriptor.
+func (c *Client) pushBlob(ctx context.Context, repo, mediaType string, data []byte) (descriptor, error) {
+	d := descriptor{MediaType: mediaType, Digest: fmt.Sprintf("sha256:%x", sha256.Sum256(data)), Size: int64(len(data))}
+	err := c.retry(ctx, nil, func() error {
+		return c.uploadBlob(ctx, repo, d, bytes.NewReader(data))
+	})
+	return d, err
+}
+
+// hasBlob reports whether repo has the blob digest.
+func (c *Client) hasBlob(ctx context.Context, repo, digest string) bool {
+	resp, err := c.send(ctx, http.MethodHead, "/v2/"+repo+"/blobs/"+digest, pushScope(repo), "", "", nil)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return true
+}
+
+// uploadBlob uploads the blob d from body in a single request, unless the
+// registry has it already.
+func (c *Client) uploadBlob(ctx context.Context, repo string, d descriptor, body io.ReadSeeker) error {
+	if c.hasBlob(ctx, repo, d.Digest) {
+		return nil
+	}
+	resp, err := c.send(ctx, http.MethodPost, "/v2/"+repo+"/blobs/uploads/", pushScope(repo), "", "", nil)
+	if err != nil {
+		return fmt.Errorf("failed to start upload to %s: %w", repo, err)
+	}
+	resp.Body.Close()
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.Header.Get("Location") == "" {
+		return fmt.Errorf("invalid upload location %q from %s", resp.Header.Get("Location"), c.Host)
+	}
+	q := loc.Query()
+	q.Set("digest", d.Digest)
+	resp, err = c.sendReader(ctx, http.MethodPut, loc.Path+"?"+q.Encode(), pushScope(repo), "", "application/octet-stream", body)
+	if err != nil {
+		return fmt.Errorf("failed to upload %s to %s: %w", d.Digest, repo, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// retry runs fn until it succeeds, fails for good or has had pushAttempts,
+// backing off exponentially. Network errors, server errors and rate limits
+// are worth another attempt; other responses of the registry are not.
+func (c *Client) retry(ctx context.Context, report Reporter, fn func() error) error {
+	wait := time.Second
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		var se *StatusError
+		switch {
+		case err == nil:
+			return nil
+		case ctx.Err() != nil || attempt == pushAttempts:
+			return err
+		case errors.As(err, &se) && se.StatusCode < 500 && se.StatusCode != http.StatusTooManyRequests && se.StatusCode != http.StatusRequestTimeout:
+			return err
+		}
+		if report != nil {
+			report.Logf("%v; retrying in %s (attempt %d of %d)", err, wait, attempt+1, pushAttempts)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+type nopReporter struct{}
+
+func (nopReporter) Detail(string)               {}
+func (nopReporter) Logf(string, ...interface{}) {}
+
+func digestString(h hash.Hash) string {
+	return fmt.Sprintf("sha256:%x", h.Sum(nil))
+}
+
+// layerCache remembers the compressed descriptor of each layer pushed
+// before by its diff ID, in the user's cache directory, so unchanged layers
+// are not compressed again on every push.
+type layerCache struct {
+	path    string
+	layers  map[string]descriptor
+	changed bool
+}
+
+func loadLayerCache() *layerCache {
+	c := &layerCache{layers: map[string]descriptor{}}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return c
+	}
+	c.path = filepath.Join(dir, "kdev", "layers.json")
+	if data, err := os.ReadFile(c.path); err == nil {
+		_ = json.Unmarshal(data, &c.layers)
+	}
+	return c
+}
+
+func (c *layerCache) get(diffID string) (descriptor, bool) {
+	d, ok := c.layers[diffID]
+	return d, ok
+}
+
+func (c *layerCache) put(diffID string, d descriptor) {
+	c.layers[diffID] = d
+	c.changed = true
+}
+
+// save writes the cache back; it is only an optimization, so failures are
+// ignored.
+func (c *layerCache) save() {
+	if c.path == "" || !c.changed {
+		return
+	}
+	data, err := json.Marshal(c.layers)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err == nil {
+		_ = os.WriteFile(c.path, data, 0o644)
+	}
+}
@@ -0,0 +1,101 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// mediaOCIEmpty is the config of artifacts, which have none.
+const mediaOCIEmpty = "application/vnd.oci.empty.v1+json"
+
+// Attach pushes data to repo as an artifact of mediaType that refers to the
+// image at repo:ref, as SBOMs are attached to images, and returns the digest
+// of the artifact. Registries with the referrers API list it for the image;
+// for others it is added to the index under the sha256-<digest> tag, which
+// clients such as oras and cosign look up instead.
+func (c *Client) Attach(ctx context.Context, repo, ref, mediaType string, data []byte, annotations map[string]string) (string, error) {
+	_, subject, err := c.fetchManifest(ctx, repo, ref)
+	if err != nil {
+		return "", err
+	}
+	config, err := c.pushBlob(ctx, repo, mediaOCIEmpty, []byte("{}"))
+	if err != nil {
+		return "", err
+	}
+	layer, err := c.pushBlob(ctx, repo, mediaType, data)
+	if err != nil {
+		return "", err
+	}
+	artifact := struct {
+		SchemaVersion int               `json:"schemaVersion"`
+		MediaType     string            `json:"mediaType"`
+		ArtifactType  string            `json:"artifactType"`
+		Config        descriptor        `json:"config"`
+		Layers        []descriptor      `json:"layers"`
+		Subject       descriptor        `json:"subject"`
+		Annotations   map[string]string `json:"annotations,omitempty"`
+	}{2, mediaOCIManifest, mediaType, config, []descriptor{layer}, subject, annotations}
+	manifestData, err := json.Marshal(artifact)
+	if err != nil {
+		return "", err
+	}
+	d := descriptor{
+		MediaType:    mediaOCIManifest,
+		ArtifactType: mediaType,
+		Digest:       fmt.Sprintf("sha256:%x", sha256.Sum256(manifestData)),
+		Size:         int64(len(manifestData)),
+		Annotations:  annotations,
+	}
+	resp, err := c.send(ctx, http.MethodPut, "/v2/"+repo+"/manifests/"+d.Digest, pushScope(repo), "", mediaOCIManifest, manifestData)
+	if err != nil {
+		return "", fmt.Errorf("failed to push artifact to %s: %w", repo, err)
+	}
+	resp.Body.Close()
+	// The registry confirms it indexed the subject; without the header it
+	// has no referrers API.
+	if resp.Header.Get("OCI-Subject") == "" {
+		if err := c.addReferrer(ctx, repo, subject.Digest, d); err != nil {
+			return "", err
+		}
+	}
+	return d.Digest, nil
+}
+
+// addReferrer adds d to the referrers index of subject in the tag schema
+// of registries without the referrers API.
+func (c *Client) addReferrer(ctx context.Context, repo, subject string, d descriptor) error {
+	tag := strings.Replace(subject, ":", "-", 1)
+	index := struct {
+		SchemaVersion int          `json:"schemaVersion"`
+		MediaType     string       `json:"mediaType"`
+		Manifests     []descriptor `json:"manifests"`
+	}{SchemaVersion: 2, MediaType: mediaOCIIndex}
+	data, _, err := c.fetchManifest(ctx, repo, tag)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &index); err != nil {
+			return fmt.Errorf("invalid referrers index %s:%s: %w", repo, tag, err)
+		}
+	case !isNotFound(err):
+		return err
+	}
+	for _, m := range index.Manifests {
+		if m.Digest == d.Digest {
+			return nil
+		}
+	}
+	index.Manifests = append(index.Manifests, d)
+	if data, err = json.Marshal(index); err != nil {
+		return err
+	}
+	resp, err := c.send(ctx, http.MethodPut, "/v2/"+repo+"/manifests/"+tag, pushScope(repo), "", mediaOCIIndex, data)
+	if err != nil {
+		return fmt.Errorf("failed to push referrers index %s:%s: %w", repo, tag, err)
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,474 @@
+// Package registry is a small client for the OCI distribution API, enough
+// to list repositories, tags and image metadata, to push images and
+// manifest lists and to attach artifacts such as SBOMs to images. It
+// authenticates with the credentials docker finds: credential helpers and
+// docker login's ~/.docker/config.json.
+package registry
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Media types of the manifests Image understands.
+const (
+	mediaOCIIndex        = "application/vnd.oci.image.index.v1+json"
+	mediaOCIManifest     = "application/vnd.oci.image.manifest.v1+json"
+	mediaDockerList      = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaDockerManifest  = "application/vnd.docker.distribution.manifest.v2+json"
+	acceptManifestHeader = mediaOCIIndex + ", " + mediaOCIManifest + ", " + mediaDockerList + ", " + mediaDockerManifest
+)
+
+// dockerHubAPI is the host serving the registry API of Docker Hub.
+const dockerHubAPI = "registry-1.docker.io"
+
+// DockerHubIndex is the key of Docker Hub in docker's config.json and
+// credential helpers.
+const DockerHubIndex = "https://index.docker.io/v1/"
+
+// Client talks to one registry host.
+type Client struct {
+	// Host is the registry, e.g. harbor.example.com or localhost:5000.
+	Host string
+	// UserAgent is sent with every request.
+	UserAgent string
+	// Insecure uses plain HTTP, as for a local registry.
+	Insecure bool
+
+	http  *http.Client
+	auth  *Credential
+	mu    sync.Mutex
+	token map[string]string // bearer token per scope
+}
+
+// New returns a client for host using the docker credentials for it, if any.
+func New(host, userAgent string) (*Client, error) {
+	// docker.io names Docker Hub in references; its API is elsewhere.
+	if host == DockerHub {
+		host = dockerHubAPI
+	}
+	cred, err := LookupCredential(host)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		Host:      host,
+		UserAgent: userAgent,
+		Insecure:  strings.HasPrefix(host, "localhost:") || strings.HasPrefix(host, "127.0.0.1:"),
+		http:      http.DefaultClient,
+		auth:      cred,
+		token:     map[string]string{},
+	}, nil
+}
+
+// Catalog lists the repositories of the registry. Not every registry
+// allows this.
+func (c *Client) Catalog(ctx context.Context) ([]string, error) {
+	var all []string
+	next := "/v2/_catalog?n=1000"
+	for next != "" {
+		var page struct {
+			Repositories []string `json:"repositories"`
+		}
+		link, err := c.getJSON(ctx, next, "registry:catalog:*", "", &page)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list repositories of %s: %w", c.Host, err)
+		}
+		all = append(all, page.Repositories...)
+		next = link
+	}
+	return all, nil
+}
+
+// Tags lists the tags of repo.
+func (c *Client) Tags(ctx context.Context, repo string) ([]string, error) {
+	var all []string
+	next := "/v2/" + repo + "/tags/list?n=1000"
+	for next != "" {
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		link, err := c.getJSON(ctx, next, pullScope(repo), "", &page)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tags of %s/%s: %w", c.Host, repo, err)
+		}
+		all = append(all, page.Tags...)
+		next = link
+	}
+	return all, nil
+}
+
+// Image describes one tag.
+type Image struct {
+	Repository string
+	Tag        string
+	// Digest of the tag's manifest or index.
+	Digest string
+	// Size is the compressed size of the layers (of the first platform for
+	// multi-platform images).
+	Size int64
+	// Platforms lists os/arch of multi-platform images.
+	Platforms []string
+	// Created, Labels and User come from the image config.
+	Created string
+	Labels  map[string]string
+	User    string
+}
+
+type descriptor struct {
+	MediaType    string            `json:"mediaType"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"`
+	Platform     *platform         `json:"platform,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+type platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// String returns os/arch[/variant].
+func (p *platform) String() string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+type manifest struct {
+	MediaType string       `json:"mediaType"`
+	Config    descriptor   `json:"config"`
+	Layers    []descriptor `json:"layers"`
+	Manifests []descriptor `json:"manifests"`
+}
+
+// Image fetches the manifest and config of repo:tag.
+func (c *Client) Image(ctx context.Context, repo, tag string) (*Image, error) {
+	img := &Image{Repository: repo, Tag: tag}
+	var m manifest
+	digest, err := c.getManifest(ctx, repo, tag, &m)
+	if err != nil {
+		return nil, err
+	}
+	img.Digest = digest
+
+	if len(m.Manifests) > 0 {
+		for _, d := range m.Manifests {
+			if d.Platform != nil && d.Platform.OS != "unknown" {
+				img.Platforms = append(img.Platforms, d.Platform.String())
+			}
+		}
+		first := m.Manifests[0]
+		m = manifest{}
+		if _, err := c.getManifest(ctx, repo, first.Digest, &m); err != nil {
+			return nil, err
+		}
+	}
+	for _, l := range m.Layers {
+		img.Size += l.Size
+	}
+
+	var cfg struct {
+		Created string `json:"created"`
+		Config  struct {
+			Labels map[string]string `json:"Labels"`
+			User   string            `json:"User"`
+		} `json:"config"`
+	}
+	if m.Config.Digest != "" {
+		if _, err := c.getJSON(ctx, "/v2/"+repo+"/blobs/"+m.Config.Digest, pullScope(repo), "", &cfg); err != nil {
+			return nil, fmt.Errorf("failed to read config of %s:%s: %w", repo, tag, err)
+		}
+	}
+	img.Created, img.Labels, img.User = cfg.Created, cfg.Config.Labels, cfg.Config.User
+	return img, nil
+}
+
+// Digest returns the digest of the manifest or index at repo:ref.
+func (c *Client) Digest(ctx context.Context, repo, ref string) (string, error) {
+	var m manifest
+	return c.getManifest(ctx, repo, ref, &m)
+}
+
+// Layer opens the first layer of repo at ref (a tag or digest) with the
+// given media type, as OCI artifacts such as devcontainer features store
+// their content. It also returns the digest of the manifest, which pins
+// what was fetched.
+func (c *Client) Layer(ctx context.Context, repo, ref, mediaType string) (io.ReadCloser, string, error) {
+	var m manifest
+	digest, err := c.getManifest(ctx, repo, ref, &m)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(m.Manifests) > 0 {
+		first := m.Manifests[0]
+		m = manifest{}
+		if _, err := c.getManifest(ctx, repo, first.Digest, &m); err != nil {
+			return nil, "", err
+		}
+	}
+	for _, l := range m.Layers {
+		if l.MediaType != mediaType {
+			continue
+		}
+		resp, err := c.do(ctx, "/v2/"+repo+"/blobs/"+l.Digest, pullScope(repo), "")
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to download %s@%s: %w", repo, l.Digest, err)
+		}
+		return resp.Body, digest, nil
+	}
+	return nil, "", fmt.Errorf("%s:%s has no layer of type %s", repo, ref, mediaType)
+}
+
+func (c *Client) getManifest(ctx context.Context, repo, ref string, into *manifest) (string, error) {
+	resp, err := c.do(ctx, "/v2/"+repo+"/manifests/"+ref, pullScope(repo), acceptManifestHeader)
+	if err != nil {
+		return "", fmt.Errorf("failed to get manifest of %s:%s: %w", repo, ref, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read manifest of %s:%s: %w", repo, ref, err)
+	}
+	if err := json.Unmarshal(data, into); err != nil {
+		return "", fmt.Errorf("invalid manifest of %s:%s: %w", repo, ref, err)
+	}
+	// Not every registry sends the digest; it is the hash of the manifest.
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data)), nil
+}
+
+// getJSON decodes the response of path into v and returns the path of the
+// next page from the Link header, if any.
+func (c *Client) getJSON(ctx context.Context, path, scope, accept string, v interface{}) (string, error) {
+	resp, err := c.do(ctx, path, scope, accept)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return "", err
+	}
+	return nextLink(resp.Header.Get("Link")), nil
+}
+
+// do performs an authenticated GET, answering a bearer challenge once.
+func (c *Client) do(ctx context.Context, path, scope, accept string) (*http.Response, error) {
+	return c.send(ctx, http.MethodGet, path, scope, accept, "", nil)
+}
+
+// send performs an authenticated request, answering a bearer challenge
+// once. Any 2xx status is success.
+func (c *Client) send(ctx context.Context, method, path, scope, accept, contentType string, body []byte) (*http.Response, error) {
+	return c.sendReader(ctx, method, path, scope, accept, contentType, bytes.NewReader(body))
+}
+
+// sendReader is send with a body that is read again for a second attempt,
+// such as a layer file.
+func (c *Client) sendReader(ctx context.Context, method, path, scope, accept, contentType string, body io.ReadSeeker) (*http.Response, error) {
+	resp, err := c.request(ctx, method, path, scope, accept, contentType, body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if err := c.authorize(ctx, challenge, scope); err != nil {
+			return nil, err
+		}
+		if resp, err = c.request(ctx, method, path, scope, accept, contentType, body); err != nil {
+			return nil, err
+		}
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, &StatusError{StatusCode: resp.StatusCode, Status: resp.Status, Body: strings.TrimSpace(string(body))}
+	}
+	return resp, nil
+}
+
+// StatusError is a response of the registry with a status other than 2xx.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Status, e.Body)
+}
+
+// isNotFound reports whether err is a 404 of the registry.
+func isNotFound(err error) bool {
+	var se *StatusError
+	return errors.As(err, &se) && se.StatusCode == http.StatusNotFound
+}
+
+func (c *Client) request(ctx context.Context, method, path, scope, accept, contentType string, body io.ReadSeeker) (*http.Response, error) {
+	scheme := "https"
+	if c.Insecure {
+		scheme = "http"
+	}
+	size, err := body.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = body.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, scheme+"://"+c.Host+path, io.NopCloser(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	req.GetBody = func() (io.ReadCloser, error) {
+		_, err := body.Seek(0, io.SeekStart)
+		return io.NopCloser(body), err
+	}
+	if size == 0 {
+		req.Body, req.GetBody = http.NoBody, nil
+	}
+	req.Header.Set("User-Agent", c.UserAgent)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	c.mu.Lock()
+	token := c.token[scope]
+	c.mu.Unlock()
+	switch {
+	case token != "":
+		req.Header.Set("Authorization", "Bearer "+token)
+	case c.auth != nil && c.auth.IdentityToken == "":
+		req.SetBasicAuth(c.auth.Username, c.auth.Password)
+	}
+	return c.http.Do(req)
+}
+
+// authorize answers a WWW-Authenticate challenge. Basic challenges are
+// handled by sending credentials on every request; bearer challenges need a
+// token from the realm.
+func (c *Client) authorize(ctx context.Context, challenge, scope string) error {
+	scheme, params := parseChallenge(challenge)
+	switch scheme {
+	case "basic":
+		if c.auth == nil {
+			return fmt.Errorf("%s requires credentials; run docker login %s", c.Host, c.Host)
+		}
+		return errors.New("credentials were rejected by " + c.Host)
+	case "bearer":
+	default:
+		return fmt.Errorf("unsupported authentication %q from %s", challenge, c.Host)
+	}
+
+	u, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return fmt.Errorf("invalid token realm %q from %s", params["realm"], c.Host)
+	}
+	q := u.Query()
+	if s := params["service"]; s != "" {
+		q.Set("service", s)
+	}
+	q.Set("scope", scope)
+	u.RawQuery = q.Encode()
+
+	var req *http.Request
+	if c.auth != nil && c.auth.IdentityToken != "" {
+		// An identity token is exchanged for an access token (OAuth2).
+		form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {c.auth.IdentityToken}, "service": {params["service"]}, "scope": {scope}, "client_id": {"kdev"}}
+		u.RawQuery = ""
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, u.String(), strings.NewReader(form.Encode()))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		if req, err = http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil); err != nil {
+			return err
+		}
+		if c.auth != nil {
+			req.SetBasicAuth(c.auth.Username, c.auth.Password)
+		}
+	}
+	req.Header.Set("User-Agent", c.UserAgent)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to get token from %s: %w", u.Host, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get token from %s: %s (run docker login %s)", u.Host, resp.Status, c.Host)
+	}
+	var tok struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return fmt.Errorf("invalid token response from %s: %w", u.Host, err)
+	}
+	if tok.Token == "" {
+		tok.Token = tok.AccessToken
+	}
+	c.mu.Lock()
+	c.token[scope] = tok.Token
+	c.mu.Unlock()
+	return nil
+}
+
+func pullScope(repo string) string {
+	return "repository:" + repo + ":pull"
+}
+
+// parseChallenge splits `Bearer realm="...",service="..."`.
+func parseChallenge(h string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(h), " ")
+	params := map[string]string{}
+	for rest != "" {
+		var kv string
+		rest = strings.TrimLeft(rest, " ,")
+		key, after, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		if strings.HasPrefix(after, `"`) {
+			end := strings.Index(after[1:], `"`)
+			if end < 0 {
+				break
+			}
+			kv, rest = after[1:end+1], after[end+2:]
+		} else {
+			kv, rest, _ = strings.Cut(after, ",")
+		}
+		params[strings.ToLower(strings.TrimSpace(key))] = kv
+	}
+	return strings.ToLower(scheme), params
+}
+
+// nextLink extracts the target of a `<...>; rel="next"` Link header.
+func nextLink(h string) string {
+	for _, part := range strings.Split(h, ",") {
+		target, rel, _ := strings.Cut(part, ";")
+		if strings.Contains(rel, `rel="next"`) {
+			return strings.Trim(strings.TrimSpace(target), "<>")
+		}
+	}
+	return ""
+}
@@ -0,0 +1,115 @@
+// Package spec describes a dev environment at the kdev level: the handful of
+// settings users choose (image, resources, env, ...) rather than the full
+// Kubernetes objects kdev renders from them.
+package spec
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Spec is the kdev-level description of a dev environment. Empty fields are
+// unset, which lets several layers (defaults, template, flags) be merged.
+// ExtraResources are extended resources such as nvidia.com/gpu, requested
+// and limited alike; Tolerations use kubectl taint syntax,
+// key[=value][:effect].
+type Spec struct {
+	Name             string            `json:"name,omitempty"`
+	Image            string            `json:"image,omitempty"`
+	ServiceAccount   string            `json:"serviceAccount,omitempty"`
+	PVC              string            `json:"pvc,omitempty"`
+	Workdir          string            `json:"workdir,omitempty"`
+	Shell            string            `json:"shell,omitempty"`
+	User             string            `json:"user,omitempty"`
+	Keepalive        string            `json:"keepalive,omitempty"`
+	Hostname         string            `json:"hostname,omitempty"`
+	Subdomain        string            `json:"subdomain,omitempty"`
+	Labels           map[string]string `json:"labels,omitempty"`
+	Annotations      map[string]string `json:"annotations,omitempty"`
+	Env              map[string]string `json:"env,omitempty"`
+	EnvFrom          []string          `json:"envFrom,omitempty"`
+	Timezone         string            `json:"timezone,omitempty"`
+	Locale           string            `json:"locale,omitempty"`
+	CPU              string            `json:"cpu,omitempty"`
+	Memory           string            `json:"memory,omitempty"`
+	ExtraResources   map[string]string `json:"extraResources,omitempty"`
+	NodeSelector     map[string]string `json:"nodeSelector,omitempty"`
+	Tolerations      []string          `json:"tolerations,omitempty"`
+	StorageClass     string            `json:"storageClass,omitempty"`
+	StorageSize      string            `json:"storageSize,omitempty"`
+	ImagePullSecrets []string          `json:"imagePullSecrets,omitempty"`
+	SSH              bool              `json:"ssh,omitempty"`
+	WebIDE           string            `json:"webIDE,omitempty"`
+}
+
+// Defaults returns the values kdev uses for anything not set elsewhere.
+func Defaults(name string) Spec {
+	return Spec{
+		Name:           name,
+		ServiceAccount: "dev-vscode",
+		PVC:            name,
+		Workdir:        "/workspaces",
+		Shell:          "/bin/bash",
+		User:           "1000:1000",
+		Keepalive:      "auto",
+		StorageClass:   "local-path",
+		StorageSize:    "20Gi",
+	}
+}
+
+// Merge overlays every set field of o onto s. Maps are merged key by key,
+// all other fields are replaced.
+func (s *Spec) Merge(o Spec) {
+	dst := reflect.ValueOf(s).Elem()
+	src := reflect.ValueOf(o)
+	for i := 0; i < src.NumField(); i++ {
+		f := src.Field(i)
+		if f.IsZero() {
+			continue
+		}
+		d := dst.Field(i)
+		if f.Kind() != reflect.Map {
+			d.Set(f)
+			continue
+		}
+		if d.IsNil() {
+			d.Set(reflect.MakeMap(f.Type()))
+		}
+		iter := f.MapRange()
+		for iter.Next() {
+			d.SetMapIndex(iter.Key(), iter.Value())
+		}
+	}
+}
+
+// SetFields returns the JSON names of the fields set in s, in declaration
+// order.
+func (s Spec) SetFields() []string {
+	v := reflect.ValueOf(s)
+	var fields []string
+	for i := 0; i < v.NumField(); i++ {
+		if !v.Field(i).IsZero() {
+			fields = append(fields, FieldName(v.Type().Field(i)))
+		}
+	}
+	return fields
+}
+
+// Fields returns the JSON names of all Spec fields in declaration order.
+func Fields() []string {
+	t := reflect.TypeOf(Spec{})
+	fields := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		fields = append(fields, FieldName(t.Field(i)))
+	}
+	return fields
+}
+
+// FieldName returns the JSON name of a struct field.
+func FieldName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
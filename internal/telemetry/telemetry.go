@@ -0,0 +1,319 @@
+// Package telemetry records anonymized command usage for users who opt in.
+//
+// Nothing is collected until kdev telemetry on. Events hold the command path,
+// the names (never the values) of the flags set, the duration and an error
+// category; no arguments, environment names, namespaces or hostnames. They are
+// written to a spool directory the user can read and sent in batches when an
+// endpoint is configured.
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/noopduck/kdev/internal/config"
+)
+
+// Batching: the spool is sent once it holds batchSize events or its oldest
+// event is older than batchAge, whichever comes first.
+const (
+	batchSize = 20
+	batchAge  = 24 * time.Hour
+)
+
+// staleClaim is how long claimed events stay with a sender before they are
+// assumed orphaned by a crash and spooled again.
+const staleClaim = time.Hour
+
+// Event is one recorded command run.
+type Event struct {
+	Time time.Time `json:"time"`
+	// Install is a random ID generated on opt-in, so events of one install
+	// can be grouped without identifying the user.
+	Install  string   `json:"install"`
+	Version  string   `json:"version"`
+	OS       string   `json:"os"`
+	Arch     string   `json:"arch"`
+	Command  string   `json:"command"`
+	Flags    []string `json:"flags,omitempty"`
+	Duration int64    `json:"durationMs"`
+	// Error is the category of the failure, empty on success.
+	Error string `json:"error,omitempty"`
+}
+
+// State is the opt-in decision, kept next to the user config.
+type State struct {
+	Enabled bool   `json:"enabled"`
+	Install string `json:"install,omitempty"`
+}
+
+// StatePath returns the file holding the opt-in state.
+func StatePath() (string, error) {
+	return nextToConfig("telemetry.json")
+}
+
+// SpoolPath returns the directory events are kept in before they are sent,
+// one file per event. Each file is created exclusively and renamed into
+// place, and a sender claims a file by renaming it, so concurrent kdev
+// processes never lose or double-send an event.
+func SpoolPath() (string, error) {
+	return nextToConfig("telemetry-spool")
+}
+
+// Spool file suffixes: complete events, events being written and events
+// claimed by a sender.
+const (
+	eventExt   = ".json"
+	partialExt = ".part"
+	sendingExt = ".sending"
+)
+
+func nextToConfig(name string) (string, error) {
+	path, err := config.Path()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(path), name), nil
+}
+
+// DoNotTrack reports whether the DO_NOT_TRACK convention disables telemetry
+// regardless of the opt-in state.
+func DoNotTrack() bool {
+	v := os.Getenv("DO_NOT_TRACK")
+	return v != "" && v != "0" && v != "false"
+}
+
+// LoadState reads the opt-in state; a missing file means opted out.
+func LoadState() (*State, error) {
+	path, err := StatePath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &State{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	var s State
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// Enable opts in, generating the install ID on first use.
+func Enable() (*State, error) {
+	s, err := LoadState()
+	if err != nil {
+		return nil, err
+	}
+	s.Enabled = true
+	if s.Install == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("failed to generate install ID: %w", err)
+		}
+		s.Install = hex.EncodeToString(b)
+	}
+	return s, saveState(s)
+}
+
+// Disable opts out, forgetting the install ID and deleting unsent events.
+func Disable() error {
+	if err := saveState(&State{}); err != nil {
+		return err
+	}
+	path, err := SpoolPath()
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(path); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", path, err)
+	}
+	return nil
+}
+
+func saveState(s *State) error {
+	path, err := StatePath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// Record adds e to the spool when the user opted in. It fills in the
+// install ID.
+func Record(e Event) error {
+	if DoNotTrack() {
+		return nil
+	}
+	s, err := LoadState()
+	if err != nil || !s.Enabled {
+		return err
+	}
+	e.Install = s.Install
+	path, err := SpoolPath()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	// Names sort by time, which keeps the spool in order.
+	name := filepath.Join(path, fmt.Sprintf("%020d-%s", e.Time.UnixNano(), hex.EncodeToString(id)))
+	f, err := os.OpenFile(name+partialExt, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(name + partialExt)
+		return err
+	}
+	return os.Rename(name+partialExt, name+eventExt)
+}
+
+// spooled lists the files of the spool with suffix ext, oldest first.
+func spooled(ext string) ([]string, error) {
+	path, err := SpoolPath()
+	if err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(path, "*"+ext))
+	sort.Strings(files)
+	return files, err
+}
+
+// readEvents reads the events in files. Files that vanished, or were cut
+// short by a crash, are skipped rather than blocking the spool.
+func readEvents(files []string) ([]Event, error) {
+	var events []Event
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var e Event
+		if json.Unmarshal(data, &e) == nil {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+// Pending returns the spooled events that have not been sent yet.
+func Pending() ([]Event, error) {
+	files, err := spooled(eventExt)
+	if err != nil {
+		return nil, err
+	}
+	return readEvents(files)
+}
+
+// Due reports whether events make a batch worth sending.
+func Due(events []Event) bool {
+	return len(events) >= batchSize || (len(events) > 0 && time.Since(events[0].Time) > batchAge)
+}
+
+// Send claims the spooled events, posts them as one JSON batch to endpoint
+// and deletes them on success. Events another kdev process claimed first are
+// left to it; on failure the claimed events go back to the spool.
+func Send(ctx context.Context, endpoint, userAgent string) error {
+	stale, err := spooled(sendingExt)
+	if err != nil {
+		return err
+	}
+	for _, file := range stale {
+		if fi, err := os.Stat(file); err == nil && time.Since(fi.ModTime()) > staleClaim {
+			_ = os.Rename(file, strings.TrimSuffix(file, sendingExt))
+		}
+	}
+	files, err := spooled(eventExt)
+	if err != nil {
+		return err
+	}
+	var claimed []string
+	now := time.Now()
+	for _, file := range files {
+		if os.Rename(file, file+sendingExt) == nil {
+			// Date the claim, not the event, for the stale check.
+			_ = os.Chtimes(file+sendingExt, now, now)
+			claimed = append(claimed, file+sendingExt)
+		}
+	}
+	if len(claimed) == 0 {
+		return nil
+	}
+	err = post(ctx, endpoint, userAgent, claimed)
+	for _, file := range claimed {
+		if err == nil {
+			_ = os.Remove(file)
+		} else {
+			_ = os.Rename(file, strings.TrimSuffix(file, sendingExt))
+		}
+	}
+	return err
+}
+
+func post(ctx context.Context, endpoint, userAgent string, files []string) error {
+	events, err := readEvents(files)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]interface{}{"events": events})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("telemetry endpoint returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/noopduck/kdev/internal/progress"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// jetbrainsIDEs are the product codes of the IDEs with a remote backend.
+var jetbrainsIDEs = []string{"GO", "IU", "PY", "PS", "WS", "RM", "CL", "RD", "RR"}
+
+// jetbrainsInstallScript downloads the latest backend of product $1 into
+// the JetBrains cache of the SSH user, unless it is there already, and
+// prints its path.
+const jetbrainsInstallScript = `set -e
+case "$(uname -m)" in
+aarch64|arm64) dist=linuxARM64 ;;
+*) dist=linux ;;
+esac
+dir="$HOME/.cache/JetBrains/RemoteDev/dist/kdev-$1"
+if [ ! -x "$dir/bin/remote-dev-server.sh" ]; then
+	url="https://download.jetbrains.com/product?code=$1&latest&distribution=$dist"
+	echo "Downloading the $1 backend to $dir" >&2
+	rm -rf "$dir.tmp" && mkdir -p "$dir.tmp"
+	if command -v curl >/dev/null; then curl -fsSL "$url"; else wget -qO- "$url"; fi | tar -xz -C "$dir.tmp" --strip-components=1
+	rm -rf "$dir" && mv "$dir.tmp" "$dir"
+fi
+echo "$dir"`
+
+func cmdJetBrains() *cobra.Command {
+	var (
+		ide     string
+		idePath string
+		folder  string
+		port    int
+		noOpen  bool
+		timeout time.Duration
+	)
+
+	c := &cobra.Command{
+		Use:   "jetbrains NAME",
+		Short: "Connect JetBrains Gateway to an environment created with up --ssh",
+		Long: `Jetbrains installs the remote backend of a JetBrains IDE in the SSH sidecar
+of environment NAME, forwards a local port to the sidecar and opens a
+JetBrains Gateway link that connects to it. It runs until interrupted; keep
+it running while you work.
+
+The backend is downloaded once into ~/.cache/JetBrains/RemoteDev of the SSH
+user, which needs curl or wget and tar in the image. Put that directory on the
+workspace volume to keep it across pods, or pass --ide-path to use a backend
+baked into the image.
+
+  kdev jetbrains mydev
+  kdev jetbrains mydev --ide IU --no-open`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeEnvNameArg,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer stop()
+
+			if _, err := runningEnvPod(ctx, name, timeout); err != nil {
+				return err
+			}
+			pod, err := sshPod(ctx, name)
+			if err != nil {
+				return err
+			}
+			user, err := sshUser(ctx, pod.Name)
+			if err != nil {
+				return err
+			}
+			if folder == "" {
+				folder = podWorkdir(pod)
+			}
+			if idePath == "" {
+				if !slices.Contains(jetbrainsIDEs, strings.ToUpper(ide)) {
+					return fmt.Errorf("unknown --ide %q: must be one of %s", ide, strings.Join(jetbrainsIDEs, ", "))
+				}
+				if idePath, err = installJetBrainsBackend(ctx, pod.Name, strings.ToUpper(ide)); err != nil {
+					return err
+				}
+			}
+			if port == 0 {
+				if port, err = freePort(); err != nil {
+					return err
+				}
+			}
+
+			done := make(chan error, 1)
+			go func() {
+				done <- forwardEnvPorts(ctx, name, []string{"127.0.0.1"}, []string{fmt.Sprintf("%d:%d", port, sshPort)}, nil)
+			}()
+			if err := waitForListener(ctx, port, done); err != nil {
+				return err
+			}
+
+			link := jetbrainsLink(user, port, idePath, folder)
+			fmt.Println(link)
+			if !noOpen {
+				if err := openURL(link); err != nil {
+					progress.Warnf("failed to open the link, open it in JetBrains Gateway yourself: %v", err)
+				}
+			}
+			progress.Infof("Press Ctrl-C to stop forwarding when you are done")
+			return <-done
+		},
+	}
+
+	c.Flags().StringVar(&ide, "ide", "GO", "Product code of the IDE backend to install: "+strings.Join(jetbrainsIDEs, ", "))
+	c.Flags().StringVar(&idePath, "ide-path", "", "Path of an IDE backend already installed in the image; skips the download")
+	c.Flags().StringVar(&folder, "folder", "", "Project folder to open (default: the environment's working directory)")
+	c.Flags().IntVar(&port, "port", 0, "Local port to forward to the SSH server (default: a free port)")
+	c.Flags().BoolVar(&noOpen, "no-open", false, "Only print the Gateway link")
+	c.Flags().DurationVar(&timeout, "timeout", 5*time.Minute, "How long to wait for the environment to be ready")
+	_ = c.RegisterFlagCompletionFunc("ide", cobra.FixedCompletions(jetbrainsIDEs, cobra.ShellCompDirectiveNoFileComp))
+	return c
+}
+
+// installJetBrainsBackend makes sure the backend of product is installed in
+// the SSH sidecar of pod and returns its path.
+func installJetBrainsBackend(ctx context.Context, pod, product string) (string, error) {
+	if err := requireOnline("downloading the JetBrains backend"); err != nil {
+		return "", fmt.Errorf("%w; pass --ide-path", err)
+	}
+	var stdout, stderr bytes.Buffer
+	sp := progress.Start("Installing the " + product + " backend")
+	err := execStream(ctx, pod, sshContainer, []string{"sh", "-c", jetbrainsInstallScript, "kdev", product}, remotecommand.StreamOptions{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	sp.Done(err)
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("failed to install the JetBrains backend: %w: %s", err, msg)
+		}
+		return "", fmt.Errorf("failed to install the JetBrains backend: %w", err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// jetbrainsLink returns the Gateway link that opens folder over SSH with the
+// backend installed at idePath.
+func jetbrainsLink(user string, port int, idePath, folder string) string {
+	v := url.Values{}
+	v.Set("type", "ssh")
+	v.Set("deploy", "false")
+	v.Set("host", "127.0.0.1")
+	v.Set("port", strconv.Itoa(port))
+	v.Set("user", user)
+	v.Set("idePath", idePath)
+	v.Set("projectPath", folder)
+	return "jetbrains-gateway://connect#" + v.Encode()
+}
+
+// freePort returns a local port that was free a moment ago.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, fmt.Errorf("failed to find a free port: %w", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// waitForListener waits until something accepts connections on the local
+// port, or the forward reports on done that it ended.
+func waitForListener(ctx context.Context, port int, done <-chan error) error {
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	for {
+		if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+			conn.Close()
+			return nil
+		}
+		select {
+		case err := <-done:
+			if err == nil {
+				err = errors.New("port forward ended")
+			}
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// openURL opens link with the desktop's default handler.
+func openURL(link string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", link)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", link)
+	default:
+		cmd = exec.Command("xdg-open", link)
+	}
+	return cmd.Run()
+}
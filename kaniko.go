@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/noopduck/kdev/internal/devcontainer"
+	"github.com/noopduck/kdev/internal/progress"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	// kanikoImage builds images in the cluster without a Docker daemon.
+	kanikoImage     = "gcr.io/kaniko-project/executor:v1.23.2"
+	kanikoContainer = "kaniko"
+	// contextContainer waits in the build pod for the uploaded context.
+	contextContainer = "context"
+	buildWorkspace   = "/workspace"
+	contextArchive   = buildWorkspace + "/context.tar.gz"
+	contextReady     = buildWorkspace + "/.ready"
+)
+
+// buildWithKaniko runs b as a Kaniko Job in the namespace: the context is
+// uploaded into the Job's pod, Kaniko's output is streamed and the image is
+// pushed from the cluster. The Job is deleted when kdev is done with it.
+func buildWithKaniko(ctx context.Context, b devcontainer.RemoteBuild) error {
+	if err := connectBuildCluster(); err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	archive, err := packBuildContext(b)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	secret, err := createPushSecret(ctx, b.Destination)
+	if err != nil {
+		return err
+	}
+	secrets := kubeClient.CoreV1().Secrets(flagNamespace)
+	if secret != nil {
+		// ctx may already be cancelled by Ctrl-C.
+		defer secrets.Delete(context.Background(), secret.Name, metav1.DeleteOptions{})
+	}
+
+	job, err := kanikoJob(b, secret)
+	if err != nil {
+		return err
+	}
+	jobs := kubeClient.BatchV1().Jobs(flagNamespace)
+	job, err = jobs.Create(ctx, job, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to create build job: %w", err)
+	}
+	defer jobs.Delete(context.Background(), job.Name, metav1.DeleteOptions{PropagationPolicy: ptr.To(metav1.DeletePropagationBackground)})
+	if secret != nil {
+		// Let the cluster collect the secret should kdev not get to it.
+		secret.OwnerReferences = []metav1.OwnerReference{{APIVersion: "batch/v1", Kind: "Job", Name: job.Name, UID: job.UID}}
+		if _, err := secrets.Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+			progress.Warnf("failed to hand secret %s to job %s: %v", secret.Name, job.Name, err)
+		}
+	}
+
+	sp := progress.Start(fmt.Sprintf("Starting build job %s", job.Name))
+	pod, err := waitForContextContainer(ctx, job.Name, inClusterBuildTimeout)
+	sp.Done(err)
+	if err != nil {
+		return err
+	}
+
+	sp = progress.Start("Uploading build context")
+	var stderr bytes.Buffer
+	err = execStream(ctx, pod.Name, contextContainer, []string{"sh", "-c", fmt.Sprintf("cat > %s && touch %s", contextArchive, contextReady)}, remotecommand.StreamOptions{Stdin: archive, Stderr: &stderr})
+	sp.Done(err)
+	if err != nil {
+		return fmt.Errorf("failed to upload build context: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return progress.Stream(fmt.Sprintf("Building %s in the cluster with Kaniko", b.Destination), func(w io.Writer) error {
+		if err := waitForPodStarted(ctx, pod, inClusterBuildTimeout); err != nil {
+			return err
+		}
+		logs, err := kubeClient.CoreV1().Pods(flagNamespace).GetLogs(pod.Name, &corev1.PodLogOptions{Container: kanikoContainer, Follow: true}).Stream(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to stream build output: %w", err)
+		}
+		_, err = io.Copy(w, logs)
+		logs.Close()
+		if err != nil && ctx.Err() == nil {
+			return fmt.Errorf("failed to stream build output: %w", err)
+		}
+		code, err := waitForExitCode(ctx, pod.Name, kanikoContainer, inClusterBuildTimeout)
+		if err != nil {
+			return err
+		}
+		if code != 0 {
+			return fmt.Errorf("kaniko build failed with exit code %d", code)
+		}
+		return nil
+	})
+}
+
+// kanikoJob builds the Job running Kaniko for b. Its pod waits in an init
+// container until the context has been uploaded to the shared workspace.
+func kanikoJob(b devcontainer.RemoteBuild, secret *corev1.Secret) (*batchv1.Job, error) {
+	args := []string{
+		"--context=tar://" + contextArchive,
+		"--dockerfile=" + devcontainer.ContextDockerfile,
+		"--destination=" + b.Destination,
+	}
+	if b.Platform != "" {
+		args = append(args, "--custom-platform="+b.Platform)
+	}
+	repo, err := kanikoCacheRepo(b)
+	if err != nil {
+		return nil, err
+	}
+	if repo != "" {
+		args = append(args, "--cache=true", "--cache-repo="+repo)
+	}
+	for _, k := range sortedKeys(b.Args) {
+		args = append(args, "--build-arg="+k+"="+b.Args[k])
+	}
+	for _, k := range sortedKeys(b.Labels) {
+		args = append(args, "--label="+k+"="+b.Labels[k])
+	}
+
+	workspace := corev1.VolumeMount{Name: "workspace", MountPath: buildWorkspace}
+	kaniko := corev1.Container{
+		Name:         kanikoContainer,
+		Image:        kanikoImage,
+		Args:         args,
+		VolumeMounts: []corev1.VolumeMount{workspace},
+	}
+	volumes := []corev1.Volume{{Name: "workspace", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}}
+	if secret != nil {
+		volumes = append(volumes, corev1.Volume{Name: "docker-config", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{
+			SecretName: secret.Name,
+			Items:      []corev1.KeyToPath{{Key: corev1.DockerConfigJsonKey, Path: "config.json"}},
+		}}})
+		kaniko.VolumeMounts = append(kaniko.VolumeMounts, corev1.VolumeMount{Name: "docker-config", MountPath: "/kaniko/.docker", ReadOnly: true})
+	}
+
+	spec := corev1.PodSpec{
+		RestartPolicy:                corev1.RestartPolicyNever,
+		AutomountServiceAccountToken: ptr.To(false),
+		InitContainers: []corev1.Container{{
+			Name:         contextContainer,
+			Image:        pauseImage,
+			Command:      []string{"sh", "-c", fmt.Sprintf("until [ -f %s ]; do sleep 1; done", contextReady)},
+			VolumeMounts: []corev1.VolumeMount{workspace},
+		}},
+		Containers: []corev1.Container{kaniko},
+		Volumes:    volumes,
+	}
+	// Kaniko runs the Dockerfile natively, so the pod has to land on a node
+	// of the target platform.
+	if b.Platform != "" {
+		osName, rest, _ := strings.Cut(b.Platform, "/")
+		arch, _, _ := strings.Cut(rest, "/")
+		spec.NodeSelector = map[string]string{corev1.LabelOSStable: osName, corev1.LabelArchStable: arch}
+	}
+	if err := resolvePodImages(&spec); err != nil {
+		return nil, err
+	}
+
+	// The pod is not labeled app=kdev: it is no environment.
+	labels := map[string]string{"kdev/owner": currentOwner(), buildLabel: "kaniko"}
+	jobLabels := map[string]string{"app": "kdev"}
+	maps.Copy(jobLabels, labels)
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "kdev-build-",
+			Namespace:    flagNamespace,
+			Labels:       jobLabels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            ptr.To[int32](0),
+			ActiveDeadlineSeconds:   ptr.To(int64(inClusterBuildTimeout.Seconds())),
+			TTLSecondsAfterFinished: ptr.To[int32](int32(time.Hour.Seconds())),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec:       spec,
+			},
+		},
+	}, nil
+}
+
+// waitForContextContainer waits until the pod of the build job jobName is
+// running the init container that receives the context.
+func waitForContextContainer(ctx context.Context, jobName string, timeout time.Duration) (*corev1.Pod, error) {
+	var pod *corev1.Pod
+	err := wait.PollUntilContextTimeout(ctx, time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		list, err := kubeClient.CoreV1().Pods(flagNamespace).List(ctx, metav1.ListOptions{LabelSelector: "job-name=" + jobName})
+		if err != nil {
+			return false, err
+		}
+		if len(list.Items) == 0 {
+			return false, nil
+		}
+		pod = &list.Items[0]
+		if err := diagnoseImagePull(ctx, pod); err != nil {
+			return false, err
+		}
+		if pod.Status.Phase == corev1.PodFailed {
+			return false, fmt.Errorf("build pod %s failed (%s)", pod.Name, podStatus(pod))
+		}
+		for _, s := range pod.Status.InitContainerStatuses {
+			if s.Name == contextContainer && s.State.Running != nil {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if wait.Interrupted(err) {
+		return nil, fmt.Errorf("timed out after %s waiting for build job %s to start", timeout, jobName)
+	}
+	return pod, err
+}
+
+// kanikoCacheRepo returns the repository Kaniko caches layers in. Kaniko
+// reads and writes the same one, so --cache-from and --cache-to must agree.
+func kanikoCacheRepo(b devcontainer.RemoteBuild) (string, error) {
+	repo := ""
+	for _, c := range append(slices.Clone(b.CacheFrom), b.CacheTo...) {
+		if c.Type != "registry" {
+			return "", fmt.Errorf("kaniko caches in a registry only, not in %s", c)
+		}
+		if repo != "" && c.Ref() != repo {
+			return "", fmt.Errorf("kaniko reads and writes one cache repository; give --cache-from and --cache-to the same image, not %s and %s", repo, c.Ref())
+		}
+		repo = c.Ref()
+	}
+	return repo, nil
+}
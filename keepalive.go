@@ -0,0 +1,114 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Keepalive strategies for the dev container's main process. Any other
+// value is run as a custom command with /bin/sh -c.
+const (
+	keepaliveAuto       = "auto"
+	keepaliveSleep      = "sleep"
+	keepalivePause      = "pause"
+	keepaliveEntrypoint = "entrypoint"
+)
+
+var keepaliveModes = []string{keepaliveAuto, keepaliveSleep, keepalivePause, keepaliveEntrypoint}
+
+// Annotations recording settings that cannot be read back from the pod spec.
+const (
+	shellAnnotation     = "kdev/shell"
+	keepaliveAnnotation = "kdev/keepalive"
+)
+
+// sleepScript idles in plain POSIX sh, so it works on images without bash
+// and does not source login profiles. The trap makes pod deletion fast, as
+// sh runs as PID 1 and would otherwise ignore SIGTERM. Old busybox and BSD
+// sleep lack "infinity", hence the loop fallback.
+const sleepScript = "trap 'exit 0' TERM INT; (sleep infinity 2>/dev/null || while :; do sleep 3600; done) & wait"
+
+// legacyKeepaliveScript is what kdev used to run as "<shell> -lc <script>";
+// it is still recognised on existing pods.
+const legacyKeepaliveScript = "while true; do sleep 3600; done"
+
+// pauseImage provides a static busybox for images without a shell. Its
+// binary is copied into an emptyDir by an init container.
+const pauseImage = "busybox:1.36-musl"
+
+const (
+	pauseInitContainer = "kdev-pause"
+	pauseVolume        = "kdev-bin"
+	pauseDir           = "/kdev/bin"
+)
+
+// shelllessImage matches images known to ship without /bin/sh.
+var shelllessImage = regexp.MustCompile(`distroless|chainguard/static|(^|/)scratch(:|$)`)
+
+// applyKeepalive sets the main process of the dev container c in pod.
+func applyKeepalive(pod *corev1.Pod, c *corev1.Container, mode string) {
+	removePause(pod, c)
+	if mode == keepaliveAuto {
+		mode = keepaliveSleep
+		if shelllessImage.MatchString(c.Image) {
+			mode = keepalivePause
+		}
+	}
+	c.Args = nil
+	switch mode {
+	case keepaliveSleep:
+		c.Command = []string{"/bin/sh", "-c", sleepScript}
+	case keepaliveEntrypoint:
+		// Keep the image's ENTRYPOINT and CMD; devcontainer.json's
+		// overrideCommand: false selects this mode. The image may have no
+		// shell, so busybox is added for the probes.
+		c.Command = nil
+		addPause(pod, c)
+	case keepalivePause:
+		addPause(pod, c)
+		busybox := pauseDir + "/busybox"
+		c.Command = []string{busybox, "sh", "-c", "trap 'exit 0' TERM INT; " + busybox + " sleep infinity & wait"}
+	default:
+		c.Command = []string{"/bin/sh", "-c", mode}
+	}
+	syncProbeCommand(pod, c)
+}
+
+// addPause wires the init container that provides busybox to c.
+func addPause(pod *corev1.Pod, c *corev1.Container) {
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name:         pauseVolume,
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	})
+	mount := corev1.VolumeMount{Name: pauseVolume, MountPath: pauseDir}
+	pod.Spec.InitContainers = append(pod.Spec.InitContainers, corev1.Container{
+		Name:            pauseInitContainer,
+		Image:           pauseImage,
+		Command:         []string{"cp", "/bin/busybox", pauseDir + "/busybox"},
+		SecurityContext: c.SecurityContext.DeepCopy(),
+		VolumeMounts:    []corev1.VolumeMount{mount},
+	})
+	mount.ReadOnly = true
+	c.VolumeMounts = append(c.VolumeMounts, mount)
+}
+
+// removePause undoes addPause, so switching strategies leaves no traces.
+func removePause(pod *corev1.Pod, c *corev1.Container) {
+	pod.Spec.InitContainers = slices.DeleteFunc(pod.Spec.InitContainers, func(ic corev1.Container) bool { return ic.Name == pauseInitContainer })
+	pod.Spec.Volumes = slices.DeleteFunc(pod.Spec.Volumes, func(v corev1.Volume) bool { return v.Name == pauseVolume })
+	c.VolumeMounts = slices.DeleteFunc(c.VolumeMounts, func(m corev1.VolumeMount) bool { return m.Name == pauseVolume })
+}
+
+// podShell returns the shell attach should start in pod: the one recorded
+// on it, else the user config's.
+func podShell(pod *corev1.Pod) string {
+	if shell := specFromPod(pod, nil).Shell; shell != "" {
+		return shell
+	}
+	if userConfig != nil && userConfig.Shell != "" {
+		return userConfig.Shell
+	}
+	return "/bin/bash"
+}
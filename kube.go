@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// minServerVersion is the oldest Kubernetes release kdev is tested against.
+var minServerVersion = version.MajorMinor(1, 23)
+
+// serverVersion is the API server version discovered during preflight.
+var serverVersion *version.Version
+
+// inCluster is set when kdev found no kubeconfig and uses the service account
+// of the pod it runs in.
+var inCluster bool
+
+// serviceAccountNamespaceFile holds the namespace of the pod's service
+// account when running in a cluster.
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+func initKubeClient() error {
+	kubeConfig = loadKubeconfig()
+	restConfig, err := inClusterConfig()
+	if restConfig == nil {
+		restConfig, err = kubeConfig.ClientConfig()
+	}
+	if err != nil && (isEmptyKubeconfig(err) || errors.Is(err, os.ErrNotExist)) {
+		return &kubeError{
+			reason: "kubeconfig missing",
+			err:    err,
+			hint:   "create ~/.kube/config (e.g. with your cloud provider's get-credentials command), point --kubeconfig or KUBECONFIG at an existing file, or run kdev in a pod with a service account token mounted",
+		}
+	}
+	if raw, rerr := kubeConfig.RawConfig(); err != nil && rerr == nil && flagContext != "" && raw.Contexts[flagContext] == nil {
+		return &kubeError{
+			reason: "unknown context",
+			err:    err,
+			hint:   "run kubectl config get-contexts to list the contexts of your kubeconfig",
+		}
+	}
+	if err != nil {
+		return diagnoseKubeError(fmt.Errorf("failed to build kubeconfig: %w", err))
+	}
+
+	// Create the clientset
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create kubernetes client: %w", err)
+	}
+
+	kubeClient = clientset
+	kubeRestConfig = restConfig
+	return nil
+}
+
+// inClusterConfig returns the service account config of the pod kdev runs in
+// when no kubeconfig exists and neither --kubeconfig nor --context asks for
+// one. It returns nil outside a cluster.
+func inClusterConfig() (*rest.Config, error) {
+	inCluster = false
+	if flagKubeconfig != "" || flagContext != "" {
+		return nil, nil
+	}
+	if raw, err := kubeConfig.RawConfig(); err != nil || len(raw.Contexts) > 0 {
+		return nil, nil
+	}
+	cfg, err := rest.InClusterConfig()
+	if errors.Is(err, rest.ErrNotInCluster) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load in-cluster config: %w", err)
+	}
+	inCluster = true
+	return cfg, nil
+}
+
+// loadKubeconfig reads the kubeconfig selected by --kubeconfig/KUBECONFIG,
+// using the --context context instead of current-context when it is set.
+func loadKubeconfig() clientcmd.ClientConfig {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = flagKubeconfig
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{CurrentContext: flagContext})
+}
+
+// currentContext returns the kubeconfig context in use and where it was
+// chosen.
+func currentContext() (name, source string) {
+	if flagContext != "" {
+		return flagContext, "--context"
+	}
+	if inCluster {
+		return "(in-cluster)", "service account"
+	}
+	if kubeConfig != nil {
+		if raw, err := kubeConfig.RawConfig(); err == nil {
+			return raw.CurrentContext, "kubeconfig current-context"
+		}
+	}
+	return "", ""
+}
+
+// kubeconfigPath returns the kubeconfig files kdev reads and where that
+// choice came from, following kubectl: --kubeconfig, then the KUBECONFIG
+// list (files are merged), then .kube/config in the home directory (HOME,
+// then USERPROFILE or HOMEDRIVE/HOMEPATH on Windows).
+func kubeconfigPath() (path, source string) {
+	if flagKubeconfig != "" {
+		return flagKubeconfig, "--kubeconfig"
+	}
+	if env := os.Getenv(clientcmd.RecommendedConfigPathEnvVar); env != "" {
+		return env, clientcmd.RecommendedConfigPathEnvVar
+	}
+	return clientcmd.RecommendedHomeFile, "default path"
+}
+
+// preflight checks that the cluster answers and runs a supported version, so
+// that connectivity problems surface once with a hint instead of as the first
+// failing API call.
+func preflight() error {
+	cfg := rest.CopyConfig(kubeRestConfig)
+	cfg.Timeout = 10 * time.Second
+	dc, err := discovery.NewDiscoveryClientForConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create discovery client: %w", err)
+	}
+	info, err := dc.ServerVersion()
+	if err != nil {
+		return diagnoseKubeError(fmt.Errorf("failed to reach cluster: %w", err))
+	}
+	v, err := version.ParseGeneric(info.GitVersion)
+	if err != nil {
+		return fmt.Errorf("failed to parse server version %q: %w", info.GitVersion, err)
+	}
+	serverVersion = v
+
+	if !v.AtLeast(minServerVersion) {
+		return &kubeError{
+			reason: "unsupported server version",
+			err:    fmt.Errorf("cluster runs %s, kdev needs at least v%s", info.GitVersion, minServerVersion),
+			hint:   "upgrade the cluster or use an older kdev release",
+		}
+	}
+	return nil
+}
+
+// requireServerVersion fails with an actionable error when the cluster is too
+// old for feature.
+func requireServerVersion(min *version.Version, feature string) error {
+	if serverVersion == nil || serverVersion.AtLeast(min) {
+		return nil
+	}
+	return &kubeError{
+		reason: "unsupported server version",
+		err:    fmt.Errorf("%s needs Kubernetes v%s or newer, cluster runs v%s", feature, min, serverVersion),
+		hint:   "ask your cluster admin to upgrade, or avoid " + feature,
+	}
+}
+
+// kubeError is a connection or API failure annotated with a remediation hint.
+type kubeError struct {
+	reason string
+	hint   string
+	err    error
+}
+
+func (e *kubeError) Error() string {
+	return fmt.Sprintf("%s: %v\nhint: %s", e.reason, e.err, e.hint)
+}
+
+func (e *kubeError) Unwrap() error { return e.err }
+
+// diagnoseKubeError classifies err into the common connection failure modes
+// (unreachable cluster, expired certificate, rejected credentials) and
+// attaches a hint. Errors it does not recognise are returned unchanged.
+func diagnoseKubeError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var ke *kubeError
+	if errors.As(err, &ke) {
+		return err
+	}
+
+	var (
+		certErr x509.CertificateInvalidError
+		authErr x509.UnknownAuthorityError
+		dnsErr  *net.DNSError
+		opErr   *net.OpError
+	)
+	switch {
+	case errors.As(err, &certErr) && certErr.Reason == x509.Expired:
+		return &kubeError{
+			reason: "certificate expired",
+			err:    err,
+			hint:   "refresh the credentials in your kubeconfig; client certificates usually need to be re-issued by your cluster admin or provider",
+		}
+	case errors.As(err, &authErr):
+		return &kubeError{
+			reason: "untrusted cluster certificate",
+			err:    err,
+			hint:   "the kubeconfig's certificate-authority-data does not match the API server; fetch a fresh kubeconfig",
+		}
+	case apierrors.IsUnauthorized(err):
+		return &kubeError{
+			reason: "credentials rejected",
+			err:    err,
+			hint:   "your token or client certificate is no longer valid; log in again and retry",
+		}
+	case errors.As(err, &dnsErr), errors.As(err, &opErr), isTimeout(err):
+		return &kubeError{
+			reason: "cluster unreachable",
+			err:    err,
+			hint:   "check your network/VPN and that the server address in the current kubeconfig context is correct",
+		}
+	}
+	return err
+}
+
+// isEmptyKubeconfig reports whether err (or anything it wraps) is clientcmd's
+// empty-config error, which it does not expose through errors.Is.
+func isEmptyKubeconfig(err error) bool {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if clientcmd.IsEmptyConfig(err) {
+			return true
+		}
+	}
+	return false
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded) || strings.Contains(err.Error(), "Client.Timeout exceeded")
+}
+
+// resolveNamespace picks the namespace the same way kubectl does: an explicit
+// -n wins, then the namespace of the selected kubeconfig context (or of the
+// service account when running in a cluster), then the user config, and
+// finally defaultNamespace. It also returns where the value came from, for
+// kdev info.
+func resolveNamespace() (string, string) {
+	if flagNamespace != "" {
+		return flagNamespace, "flag"
+	}
+	if inCluster {
+		if ns, err := os.ReadFile(serviceAccountNamespaceFile); err == nil && len(bytes.TrimSpace(ns)) > 0 {
+			return string(bytes.TrimSpace(ns)), "service account"
+		}
+	}
+	if kubeConfig != nil {
+		if raw, err := kubeConfig.RawConfig(); err == nil {
+			name, _ := currentContext()
+			if kctx, ok := raw.Contexts[name]; ok && kctx.Namespace != "" {
+				return kctx.Namespace, fmt.Sprintf("kubeconfig context %q", name)
+			}
+		}
+	}
+	if userConfig != nil && userConfig.Namespace != "" {
+		return userConfig.Namespace, "user config"
+	}
+	return defaultNamespace, "default"
+}
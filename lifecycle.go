@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/noopduck/kdev/internal/devcontainer"
+	"github.com/noopduck/kdev/internal/progress"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// lifecycleAnnotation stores the devcontainer.json lifecycle commands of an
+// environment on its pod, so attach can run them from anywhere.
+const lifecycleAnnotation = "kdev/lifecycle"
+
+// lifecycleCreatedAnnotation marks the workspace PVC once the create
+// commands (onCreateCommand, updateContentCommand, postCreateCommand) have
+// run, so they run once per environment however often its container is
+// replaced.
+const lifecycleCreatedAnnotation = "kdev/lifecycle-created"
+
+// lifecycleMarker is created in the dev container once its postStartCommand
+// has run. It lives outside the workspace volume, so a new container, after
+// a restart or on a new pod, runs it again.
+const lifecycleMarker = "/tmp/.kdev-lifecycle-done"
+
+// setLifecycle records the lifecycle commands of l on pod.
+func setLifecycle(pod *corev1.Pod, l devcontainer.Lifecycle) error {
+	if l.Empty() {
+		return nil
+	}
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[lifecycleAnnotation] = string(data)
+	return nil
+}
+
+// podLifecycle returns the lifecycle commands recorded on pod.
+func podLifecycle(pod *corev1.Pod) (devcontainer.Lifecycle, error) {
+	var l devcontainer.Lifecycle
+	data := pod.Annotations[lifecycleAnnotation]
+	if data == "" {
+		return l, nil
+	}
+	if err := json.Unmarshal([]byte(data), &l); err != nil {
+		return l, fmt.Errorf("invalid %s annotation on pod %s: %w", lifecycleAnnotation, pod.Name, err)
+	}
+	return l, nil
+}
+
+// runStartHooks runs onCreateCommand, updateContentCommand and
+// postCreateCommand in the dev container of pod unless they already ran for
+// its workspace, then postStartCommand unless it already ran in this
+// container. After a failure the remaining commands are skipped and tried
+// again next time.
+func runStartHooks(ctx context.Context, pod *corev1.Pod) error {
+	l, err := podLifecycle(pod)
+	if err != nil {
+		return err
+	}
+	type stage struct {
+		name string
+		cmd  *devcontainer.Command
+	}
+	create := []stage{
+		{"onCreateCommand", l.OnCreateCommand},
+		{"updateContentCommand", l.UpdateContentCommand},
+		{"postCreateCommand", l.PostCreateCommand},
+	}
+	hasCreate := slices.ContainsFunc(create, func(s stage) bool { return s.cmd != nil })
+	if !hasCreate && l.PostStartCommand == nil {
+		return nil
+	}
+	if execStream(ctx, pod.Name, devContainer, []string{"test", "-e", lifecycleMarker}, remotecommand.StreamOptions{Stdout: io.Discard}) == nil {
+		return nil
+	}
+	if hasCreate && !lifecycleCreated(ctx, pod) {
+		for _, s := range create {
+			if s.cmd == nil {
+				continue
+			}
+			if err := runLifecycleCommand(ctx, pod, s.name, *s.cmd); err != nil {
+				return err
+			}
+		}
+		markLifecycleCreated(ctx, pod)
+	}
+	if l.PostStartCommand != nil {
+		if err := runLifecycleCommand(ctx, pod, "postStartCommand", *l.PostStartCommand); err != nil {
+			return err
+		}
+	}
+	if err := execStream(ctx, pod.Name, devContainer, []string{"touch", lifecycleMarker}, remotecommand.StreamOptions{Stdout: io.Discard}); err != nil {
+		return fmt.Errorf("failed to record that the lifecycle commands ran: %v", err)
+	}
+	return nil
+}
+
+// lifecycleCreated reports whether the create commands already ran for the
+// workspace PVC of pod. Without a readable PVC they run with every new
+// container, as there is nowhere to remember them.
+func lifecycleCreated(ctx context.Context, pod *corev1.Pod) bool {
+	claim := specFromPod(pod, nil).PVC
+	if claim == "" {
+		return false
+	}
+	pvc, err := kubeClient.CoreV1().PersistentVolumeClaims(pod.Namespace).Get(ctx, claim, metav1.GetOptions{})
+	return err == nil && pvc.Annotations[lifecycleCreatedAnnotation] == "true"
+}
+
+// markLifecycleCreated records on the workspace PVC of pod that its create
+// commands ran. A failure only means they run again next time.
+func markLifecycleCreated(ctx context.Context, pod *corev1.Pod) {
+	claim := specFromPod(pod, nil).PVC
+	if claim == "" {
+		return
+	}
+	patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:"true"}}}`, lifecycleCreatedAnnotation)
+	if _, err := kubeClient.CoreV1().PersistentVolumeClaims(pod.Namespace).Patch(ctx, claim, types.MergePatchType, []byte(patch), metav1.PatchOptions{}); err != nil {
+		progress.Warnf("failed to record that the create commands ran; they run again with the next container: %v", err)
+	}
+}
+
+// runAttachHook runs the postAttachCommand of pod, if it has one.
+func runAttachHook(ctx context.Context, pod *corev1.Pod) error {
+	l, err := podLifecycle(pod)
+	if err != nil || l.PostAttachCommand == nil {
+		return err
+	}
+	return runLifecycleCommand(ctx, pod, "postAttachCommand", *l.PostAttachCommand)
+}
+
+// runLifecycleCommand runs cmd in the dev container of pod with its
+// remoteEnv, streaming its output. The named commands of a parallel command
+// run at the same time, their output lines prefixed with the name.
+func runLifecycleCommand(ctx context.Context, pod *corev1.Pod, stage string, cmd devcontainer.Command) error {
+	progress.Infof("Running %s", stage)
+	steps := cmd.Steps()
+	errs := make([]error, len(steps))
+	var wg sync.WaitGroup
+	for i, step := range steps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stdout, stderr := io.Writer(os.Stdout), io.Writer(os.Stderr)
+			if step.Name != "" {
+				out := &prefixWriter{w: os.Stdout, prefix: "[" + step.Name + "] "}
+				errOut := &prefixWriter{w: os.Stderr, prefix: out.prefix}
+				defer out.Flush()
+				defer errOut.Flush()
+				stdout, stderr = out, errOut
+			}
+			err := execStream(ctx, pod.Name, devContainer, withRemoteEnv(pod, devContainer, step.Args), remotecommand.StreamOptions{Stdout: stdout, Stderr: stderr})
+			if err != nil && step.Name != "" {
+				err = fmt.Errorf("%s: %v", step.Name, err)
+			}
+			errs[i] = err
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		// %v rather than %w: an exit code must not turn into kdev's own.
+		return fmt.Errorf("%s failed: %v", stage, err)
+	}
+	return nil
+}
+
+// prefixWriter writes complete lines to w, each starting with prefix.
+// Writers sharing w keep their lines whole.
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+var prefixWriterMu sync.Mutex
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		if err := p.writeLine(p.buf[:i+1]); err != nil {
+			return 0, err
+		}
+		p.buf = p.buf[i+1:]
+	}
+}
+
+// Flush writes a final line without a newline.
+func (p *prefixWriter) Flush() {
+	if len(p.buf) > 0 {
+		_ = p.writeLine(append(p.buf, '\n'))
+		p.buf = nil
+	}
+}
+
+func (p *prefixWriter) writeLine(line []byte) error {
+	prefixWriterMu.Lock()
+	defer prefixWriterMu.Unlock()
+	_, err := p.w.Write(append([]byte(p.prefix), line...))
+	return err
+}
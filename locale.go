@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/noopduck/kdev/internal/spec"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// zoneinfoConfigMap holds the zoneinfo files of the timezones used in a
+// namespace, one key per zone, so pods get a matching /etc/localtime
+// without hostPath mounts or tzdata in the image.
+const zoneinfoConfigMap = "kdev-zoneinfo"
+
+// localZoneinfoDir is where the local machine keeps its tz database.
+const localZoneinfoDir = "/usr/share/zoneinfo"
+
+// syncLocale fills in the local timezone and locale where s leaves them
+// unset.
+func syncLocale(s *spec.Spec) {
+	if s.Timezone == "" {
+		s.Timezone = localTimezone()
+	}
+	if s.Locale == "" {
+		s.Locale = localLocale()
+	}
+}
+
+// localTimezone returns the IANA name of the local timezone, or "" if it
+// cannot be determined.
+func localTimezone() string {
+	if tz := os.Getenv("TZ"); tz != "" {
+		return strings.TrimPrefix(tz, ":")
+	}
+	target, err := filepath.EvalSymlinks("/etc/localtime")
+	if err != nil {
+		return ""
+	}
+	if _, name, ok := strings.Cut(target, "zoneinfo/"); ok {
+		return name
+	}
+	return ""
+}
+
+// localLocale returns the local LANG setting, ignoring the C/POSIX default.
+func localLocale() string {
+	for _, key := range []string{"LC_ALL", "LANG"} {
+		if v := os.Getenv(key); v != "" {
+			if v == "C" || v == "POSIX" {
+				return ""
+			}
+			return v
+		}
+	}
+	return ""
+}
+
+// zoneinfoKey turns a timezone name into a valid ConfigMap key.
+func zoneinfoKey(tz string) string {
+	return strings.ReplaceAll(tz, "/", ".")
+}
+
+// readZoneinfo loads the local zoneinfo file for tz. ok is false when the
+// local machine has no tz database (e.g. Windows).
+func readZoneinfo(tz string) (data []byte, ok bool) {
+	if tz == "" || strings.Contains(tz, "..") {
+		return nil, false
+	}
+	data, err := os.ReadFile(filepath.Join(localZoneinfoDir, filepath.FromSlash(tz)))
+	return data, err == nil
+}
+
+// mountZoneinfo mounts the zoneinfo of tz at /etc/localtime in the dev
+// container.
+func mountZoneinfo(pod *corev1.Pod, tz string) {
+	c := findContainer(pod, devContainer)
+	if c == nil {
+		return
+	}
+	vol := corev1.Volume{
+		Name: "kdev-localtime",
+		VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+			LocalObjectReference: corev1.LocalObjectReference{Name: zoneinfoConfigMap},
+			Items:                []corev1.KeyToPath{{Key: zoneinfoKey(tz), Path: "localtime"}},
+		}},
+	}
+	replaced := false
+	for i := range pod.Spec.Volumes {
+		if pod.Spec.Volumes[i].Name == vol.Name {
+			pod.Spec.Volumes[i], replaced = vol, true
+		}
+	}
+	if !replaced {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, vol)
+		c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{
+			Name:      vol.Name,
+			MountPath: "/etc/localtime",
+			SubPath:   "localtime",
+			ReadOnly:  true,
+		})
+	}
+}
+
+// ensureZoneinfo adds the zoneinfo data for tz to the namespace's shared
+// ConfigMap.
+func ensureZoneinfo(ctx context.Context, namespace, tz string, data []byte) error {
+	cms := kubeClient.CoreV1().ConfigMaps(namespace)
+	key := zoneinfoKey(tz)
+	cm, err := cms.Get(ctx, zoneinfoConfigMap, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      zoneinfoConfigMap,
+				Namespace: namespace,
+				Labels:    map[string]string{"app": "kdev"},
+			},
+			BinaryData: map[string][]byte{key: data},
+		}
+		_, err = cms.Create(ctx, cm, metav1.CreateOptions{})
+		if err == nil || apierrors.IsAlreadyExists(err) {
+			return nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store zoneinfo for %s: %w", tz, err)
+	}
+	if _, ok := cm.BinaryData[key]; ok {
+		return nil
+	}
+	if cm.BinaryData == nil {
+		cm.BinaryData = map[string][]byte{}
+	}
+	cm.BinaryData[key] = data
+	if _, err := cms.Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to store zoneinfo for %s: %w", tz, err)
+	}
+	return nil
+}
+
+// syncZoneinfo points the /etc/localtime mount of pod at tz, removing it
+// when tz is empty or unknown locally.
+func syncZoneinfo(ctx context.Context, pod *corev1.Pod, tz string) error {
+	var volumes []corev1.Volume
+	for _, v := range pod.Spec.Volumes {
+		if v.Name != "kdev-localtime" {
+			volumes = append(volumes, v)
+		}
+	}
+	pod.Spec.Volumes = volumes
+	if c := findContainer(pod, devContainer); c != nil {
+		var mounts []corev1.VolumeMount
+		for _, m := range c.VolumeMounts {
+			if m.Name != "kdev-localtime" {
+				mounts = append(mounts, m)
+			}
+		}
+		c.VolumeMounts = mounts
+	}
+
+	data, ok := readZoneinfo(tz)
+	if !ok {
+		return nil
+	}
+	if err := ensureZoneinfo(ctx, pod.Namespace, tz, data); err != nil {
+		return err
+	}
+	mountZoneinfo(pod, tz)
+	return nil
+}
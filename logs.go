@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/utils/ptr"
+)
+
+func cmdLogs() *cobra.Command {
+	var (
+		follow     bool
+		tail       int64
+		since      time.Duration
+		timestamps bool
+	)
+
+	c := &cobra.Command{
+		Use:   "logs NAME",
+		Short: "Print the output of an environment's dev container",
+		Long: `Logs prints the output of the dev container of environment NAME. Pods are
+found by their kdev labels only, so the command never reads other workloads.
+When an environment has several pods, e.g. during a Deployment rollout, the
+output of each is printed with the pod name as prefix.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeEnvNameArg,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer stop()
+
+			opts := &corev1.PodLogOptions{
+				Container:  devContainer,
+				Follow:     follow,
+				Timestamps: timestamps,
+			}
+			if tail >= 0 {
+				opts.TailLines = ptr.To(tail)
+			}
+			if since > 0 {
+				opts.SinceSeconds = ptr.To(int64(since.Seconds()))
+			}
+			return streamEnvLogs(ctx, flagNamespace, args[0], opts)
+		},
+	}
+
+	c.Flags().BoolVarP(&follow, "follow", "f", false, "Keep streaming new output")
+	c.Flags().Int64Var(&tail, "tail", -1, "Number of recent lines to show (default: all)")
+	c.Flags().DurationVar(&since, "since", 0, "Only show output newer than this, e.g. 10m or 2h")
+	c.Flags().BoolVar(&timestamps, "timestamps", false, "Prefix each line with its timestamp")
+	return c
+}
+
+// streamEnvLogs copies the logs of the pods of environment name to stdout
+// until they end or ctx is cancelled.
+func streamEnvLogs(ctx context.Context, namespace, name string, opts *corev1.PodLogOptions) error {
+	pods := kubeClient.CoreV1().Pods(namespace)
+	list, err := pods.List(ctx, metav1.ListOptions{LabelSelector: labels.Set(envSelector(name)).String()})
+	if err != nil {
+		return fmt.Errorf("failed to list pods of %s: %w", name, err)
+	}
+	if len(list.Items) == 0 {
+		return fmt.Errorf("environment %s has no pods in namespace %s", name, namespace)
+	}
+	sort.Slice(list.Items, func(i, j int) bool {
+		return list.Items[i].CreationTimestamp.Before(&list.Items[j].CreationTimestamp)
+	})
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs = make([]error, len(list.Items))
+	)
+	for i := range list.Items {
+		pod := list.Items[i].Name
+		prefix := ""
+		if len(list.Items) > 1 {
+			prefix = "[" + pod + "] "
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			logs, err := pods.GetLogs(pod, opts).Stream(ctx)
+			if err != nil {
+				errs[i] = fmt.Errorf("failed to get logs of pod %s: %w", pod, err)
+				return
+			}
+			defer logs.Close()
+			if err := copyLines(logs, prefix, &mu); err != nil && ctx.Err() == nil {
+				errs[i] = fmt.Errorf("failed to stream logs of pod %s: %w", pod, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		// Interrupted while following.
+		return nil
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyLines writes r to stdout line by line, holding mu for each line so
+// the output of several pods does not interleave mid-line.
+func copyLines(r io.Reader, prefix string, mu *sync.Mutex) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			if prefix != "" && !strings.HasSuffix(line, "\n") {
+				line += "\n"
+			}
+			mu.Lock()
+			fmt.Print(prefix + line)
+			mu.Unlock()
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/noopduck/kdev/internal/progress"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// envSummary is one environment as listed by kdev ls. Its JSON form is the
+// stable output of ls -o json/yaml/go-template.
+type envSummary struct {
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	Status    string    `json:"status"`
+	Ready     string    `json:"ready"`
+	Node      string    `json:"node,omitempty"`
+	Age       string    `json:"age"`
+	Created   time.Time `json:"created"`
+	Image     string    `json:"image,omitempty"`
+	PVC       string    `json:"pvc,omitempty"`
+	Pod       string    `json:"pod,omitempty"`
+}
+
+func cmdLS() *cobra.Command {
+	var (
+		output        string
+		allNamespaces bool
+		watch         bool
+	)
+
+	c := &cobra.Command{
+		Use:   "ls",
+		Short: "List dev pods in the namespace",
+		Long: `Ls lists the environments in the namespace: running pods, hibernated
+environments and stopped StatefulSets/Deployments. With -A it lists every
+namespace; namespaces you may not read are skipped with a warning. With -w
+the listing stays open and follows pods as they start, become ready or go
+away.
+
+With -o json or -o yaml the list is printed as objects with the fields
+namespace, name, status, ready, node, age, created, image, pvc and pod.
+-o go-template=TEMPLATE executes a Go template over the same list, e.g.
+
+  kdev ls -o go-template='{{range .}}{{.name}} {{.image}}{{"\n"}}{{end}}'`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			tmpl, err := parseListOutput(output)
+			if err != nil {
+				return err
+			}
+			if watch {
+				if output != "" {
+					return errors.New("--watch cannot be combined with -o")
+				}
+				ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+				defer stop()
+				return watchEnvs(ctx, flagNamespace, allNamespaces)
+			}
+			ctx := context.Background()
+			var envs []envSummary
+			if allNamespaces {
+				envs, err = listAllEnvs(ctx)
+			} else {
+				envs, err = listEnvs(ctx, flagNamespace)
+			}
+			if err != nil {
+				return err
+			}
+			if output != "" {
+				return printEnvs(envs, output, tmpl)
+			}
+
+			if !allNamespaces {
+				fmt.Printf("Namespace: %s\n", flagNamespace)
+			}
+			printEnvTable(envs, allNamespaces)
+			return nil
+		},
+	}
+
+	c.Flags().BoolVarP(&allNamespaces, "all-namespaces", "A", false, "List environments in all namespaces")
+	c.Flags().BoolVarP(&watch, "watch", "w", false, "Keep the listing open and update it as environments change")
+	c.Flags().StringVarP(&output, "output", "o", "", "Output format: json, yaml or go-template=TEMPLATE")
+	_ = c.RegisterFlagCompletionFunc("output", cobra.FixedCompletions([]string{"json", "yaml", "go-template="}, cobra.ShellCompDirectiveNoSpace))
+	return c
+}
+
+// printEnvTable prints the ls table, with a NAMESPACE column when listing
+// all namespaces.
+func printEnvTable(envs []envSummary, allNamespaces bool) {
+	if len(envs) == 0 {
+		fmt.Println("No pods found")
+		return
+	}
+	printEnvHeader(allNamespaces)
+	for _, env := range envs {
+		printEnvRow(env, allNamespaces)
+	}
+}
+
+func printEnvHeader(allNamespaces bool) {
+	if allNamespaces {
+		fmt.Printf("%-20s ", "NAMESPACE")
+	}
+	fmt.Printf("%-30s %-15s %-18s %-20s %-15s\n", "NAME", "READY", "STATUS", "NODE", "AGE")
+}
+
+func printEnvRow(env envSummary, allNamespaces bool) {
+	if allNamespaces {
+		fmt.Printf("%-20s ", env.Namespace)
+	}
+	fmt.Printf("%-30s %-15s %-18s %-20s %-15s\n", env.Name, env.Ready, env.Status, env.Node, env.Age)
+}
+
+// parseListOutput validates -o and parses a go-template before anything is
+// fetched from the cluster.
+func parseListOutput(output string) (*template.Template, error) {
+	switch {
+	case output == "", output == "json", output == "yaml":
+		return nil, nil
+	case strings.HasPrefix(output, "go-template="):
+		tmpl, err := template.New("ls").Parse(strings.TrimPrefix(output, "go-template="))
+		if err != nil {
+			return nil, fmt.Errorf("invalid go-template: %w", err)
+		}
+		return tmpl, nil
+	}
+	return nil, fmt.Errorf("unsupported output %q (use json, yaml or go-template=TEMPLATE)", output)
+}
+
+// printEnvs prints envs in a machine-readable format. Templates see the
+// JSON field names, as with kubectl.
+func printEnvs(envs []envSummary, output string, tmpl *template.Template) error {
+	if envs == nil {
+		envs = []envSummary{}
+	}
+	data, err := json.MarshalIndent(envs, "", "  ")
+	if err != nil {
+		return err
+	}
+	switch {
+	case output == "json":
+		fmt.Println(string(data))
+	case output == "yaml":
+		out, err := yaml.JSONToYAML(data)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(out))
+	case tmpl != nil:
+		var generic interface{}
+		if err := json.Unmarshal(data, &generic); err != nil {
+			return err
+		}
+		var b bytes.Buffer
+		if err := tmpl.Execute(&b, generic); err != nil {
+			return fmt.Errorf("failed to execute go-template: %w", err)
+		}
+		fmt.Print(b.String())
+	}
+	return nil
+}
+
+// listEnvs collects the environments in namespace: pods first, then
+// hibernated and stopped environments.
+func listEnvs(ctx context.Context, namespace string) ([]envSummary, error) {
+	pods, err := kubeClient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: "app=kdev",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+	hibernated, err := hibernatedEnvs(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	stopped, err := stoppedWorkloads(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	return envSummaries(pods.Items, hibernated, stopped), nil
+}
+
+// envSummaries merges pods, hibernated and stopped environments into one
+// list.
+func envSummaries(pods []corev1.Pod, hibernated []corev1.ConfigMap, stopped []stoppedEnv) []envSummary {
+	down := map[string]bool{}
+	for _, cm := range hibernated {
+		down[cm.Namespace+"/"+cm.Labels["kdev/name"]] = true
+	}
+	for _, s := range stopped {
+		down[s.namespace+"/"+s.name] = true
+	}
+	var envs []envSummary
+	for i := range pods {
+		// A pod still terminating after stop or hibernate is shown as the
+		// stopped environment it belongs to.
+		if pods[i].DeletionTimestamp != nil && down[pods[i].Namespace+"/"+envName(&pods[i])] {
+			continue
+		}
+		envs = append(envs, podSummary(&pods[i]))
+	}
+	for _, cm := range hibernated {
+		env := envSummary{
+			Namespace: cm.Namespace,
+			Name:      cm.Labels["kdev/name"],
+			Status:    statusHibernated,
+			Ready:     "-",
+			Age:       age(cm.CreationTimestamp.Time),
+			Created:   cm.CreationTimestamp.Time.UTC(),
+		}
+		var pod corev1.Pod
+		if json.Unmarshal([]byte(cm.Data[hibernatePodKey]), &pod) == nil {
+			env.Image, env.PVC = podImageAndClaim(&pod.Spec)
+		}
+		envs = append(envs, env)
+	}
+	for _, s := range stopped {
+		envs = append(envs, envSummary{
+			Namespace: s.namespace,
+			Name:      s.name,
+			Status:    statusStopped,
+			Ready:     "-",
+			Age:       age(s.created),
+			Created:   s.created.UTC(),
+			Image:     s.image,
+			PVC:       s.pvc,
+		})
+	}
+	return envs
+}
+
+// listAllEnvs is listEnvs across the cluster. Users who may not list
+// cluster-wide get the namespaces they can read, the others are skipped.
+func listAllEnvs(ctx context.Context) ([]envSummary, error) {
+	envs, err := listEnvs(ctx, metav1.NamespaceAll)
+	if !apierrors.IsForbidden(err) {
+		sortEnvs(envs)
+		return envs, err
+	}
+	namespaces, nerr := kubeClient.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if nerr != nil {
+		return nil, &kubeError{
+			reason: "forbidden",
+			err:    err,
+			hint:   "you may neither list kdev resources cluster-wide nor list namespaces; use -n for a namespace you can access",
+		}
+	}
+	var denied []string
+	for _, ns := range namespaces.Items {
+		found, err := listEnvs(ctx, ns.Name)
+		if apierrors.IsForbidden(err) {
+			denied = append(denied, ns.Name)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		envs = append(envs, found...)
+	}
+	if len(denied) > 0 {
+		progress.Warnf("skipped %d namespaces you cannot list environments in: %s", len(denied), strings.Join(denied, ", "))
+	}
+	sortEnvs(envs)
+	return envs, nil
+}
+
+func sortEnvs(envs []envSummary) {
+	sort.SliceStable(envs, func(i, j int) bool {
+		if envs[i].Namespace != envs[j].Namespace {
+			return envs[i].Namespace < envs[j].Namespace
+		}
+		return envs[i].Name < envs[j].Name
+	})
+}
+
+// podSummary describes the environment pod.
+func podSummary(pod *corev1.Pod) envSummary {
+	ready := 0
+	for _, c := range pod.Status.ContainerStatuses {
+		if c.Ready {
+			ready++
+		}
+	}
+	env := envSummary{
+		Namespace: pod.Namespace,
+		Name:      envName(pod),
+		Status:    podStatus(pod),
+		Ready:     fmt.Sprintf("%d/%d", ready, len(pod.Spec.Containers)),
+		Node:      pod.Spec.NodeName,
+		Age:       age(pod.CreationTimestamp.Time),
+		Created:   pod.CreationTimestamp.Time.UTC(),
+		Pod:       pod.Name,
+	}
+	env.Image, env.PVC = podImageAndClaim(&pod.Spec)
+	return env
+}
+
+// podImageAndClaim returns the dev container's image and the workspace PVC
+// of a pod spec.
+func podImageAndClaim(spec *corev1.PodSpec) (image, pvc string) {
+	for _, c := range spec.Containers {
+		if c.Name == devContainer {
+			image = c.Image
+		}
+	}
+	for _, v := range spec.Volumes {
+		if v.Name == "work" && v.PersistentVolumeClaim != nil {
+			pvc = v.PersistentVolumeClaim.ClaimName
+		}
+	}
+	return image, pvc
+}
+
+func age(t time.Time) string {
+	return time.Since(t).Round(time.Second).String()
+}
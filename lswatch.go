@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/term"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// watchRedrawInterval refreshes the AGE column of a live listing when
+// nothing changes.
+const watchRedrawInterval = 5 * time.Second
+
+// watchEnvs keeps the ls listing up to date from shared informers until ctx
+// ends. On a terminal the table is redrawn in place; otherwise each changed
+// environment is printed as a new row, as kubectl get --watch does.
+func watchEnvs(ctx context.Context, namespace string, allNamespaces bool) error {
+	if allNamespaces {
+		namespace = metav1.NamespaceAll
+		// Informers retry forever on missing permissions; fail early instead.
+		if _, err := listEnvs(ctx, namespace); apierrors.IsForbidden(err) {
+			return fmt.Errorf("--watch with -A needs permission to list pods, ConfigMaps, StatefulSets and Deployments cluster-wide: %w", err)
+		}
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(kubeClient, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) { o.LabelSelector = "app=kdev" }),
+	)
+	pods := factory.Core().V1().Pods()
+	cms := factory.Core().V1().ConfigMaps()
+	sets := factory.Apps().V1().StatefulSets()
+	deploys := factory.Apps().V1().Deployments()
+
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { notify() },
+		UpdateFunc: func(interface{}, interface{}) { notify() },
+		DeleteFunc: func(interface{}) { notify() },
+	}
+	for _, inf := range []cache.SharedIndexInformer{pods.Informer(), cms.Informer(), sets.Informer(), deploys.Informer()} {
+		if _, err := inf.AddEventHandler(handler); err != nil {
+			return fmt.Errorf("failed to watch environments: %w", err)
+		}
+	}
+
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+	for typ, ok := range factory.WaitForCacheSync(ctx.Done()) {
+		if !ok {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to sync %v informer", typ)
+		}
+	}
+
+	snapshot := func() []envSummary {
+		podList, _ := pods.Lister().List(labels.Everything())
+		cmList, _ := cms.Lister().List(labels.SelectorFromSet(labels.Set{hibernateStateLabel: hibernatedState}))
+		setList, _ := sets.Lister().List(labels.Everything())
+		deployList, _ := deploys.Lister().List(labels.Everything())
+		envs := envSummaries(derefAll(podList), derefAll(cmList), stoppedEnvs(derefAll(setList), derefAll(deployList)))
+		sortEnvs(envs)
+		return envs
+	}
+
+	tty := term.IsTerminal(int(os.Stdout.Fd()))
+	var last map[string]envSummary
+	draw := func() {
+		envs := snapshot()
+		if tty {
+			// Home the cursor and clear the screen, then print the table.
+			fmt.Print("\x1b[H\x1b[2J")
+			if !allNamespaces {
+				fmt.Printf("Namespace: %s (watching, Ctrl-C to stop)\n", namespace)
+			}
+			printEnvTable(envs, allNamespaces)
+			return
+		}
+		last = printEnvChanges(last, envs, allNamespaces)
+	}
+
+	draw()
+	tick := time.NewTicker(watchRedrawInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-changed:
+			// Coalesce the burst of events a pod start produces.
+			time.Sleep(100 * time.Millisecond)
+			select {
+			case <-changed:
+			default:
+			}
+			draw()
+		case <-tick.C:
+			if tty {
+				draw()
+			}
+		}
+	}
+}
+
+// printEnvChanges prints the rows of envs that differ from last, and a
+// Deleted row for environments that are gone, and returns the new state.
+// The first call, with a nil last, prints the header and every row.
+func printEnvChanges(last map[string]envSummary, envs []envSummary, allNamespaces bool) map[string]envSummary {
+	if last == nil {
+		printEnvHeader(allNamespaces)
+		last = map[string]envSummary{}
+	}
+	next := map[string]envSummary{}
+	for _, env := range envs {
+		key := envKey(env)
+		next[key] = env
+		if prev, ok := last[key]; !ok || prev.Status != env.Status || prev.Ready != env.Ready || prev.Node != env.Node {
+			printEnvRow(env, allNamespaces)
+		}
+	}
+	for key, env := range last {
+		if _, ok := next[key]; !ok {
+			env.Status, env.Ready = "Deleted", "-"
+			env.Age = age(env.Created)
+			printEnvRow(env, allNamespaces)
+		}
+	}
+	return next
+}
+
+// envKey identifies a row: an environment can briefly have several pods.
+func envKey(env envSummary) string {
+	return env.Namespace + "/" + env.Name + "/" + env.Pod
+}
+
+// derefAll copies the objects a lister returns.
+func derefAll[T corev1.Pod | corev1.ConfigMap | appsv1.StatefulSet | appsv1.Deployment](ptrs []*T) []T {
+	out := make([]T, 0, len(ptrs))
+	for _, p := range ptrs {
+		out = append(out, *p)
+	}
+	return out
+}
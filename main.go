@@ -7,427 +7,245 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"path/filepath"
+	"os/user"
+	"regexp"
 	"strings"
 	"time"
 
+	"github.com/noopduck/kdev/internal/config"
 	"github.com/noopduck/kdev/internal/devcontainer"
+	"github.com/noopduck/kdev/internal/progress"
 	"github.com/spf13/cobra"
-	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
-	kubeScheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/tools/remotecommand"
-	"k8s.io/client-go/util/homedir"
-	"k8s.io/utils/ptr"
 )
 
+// defaultNamespace is used when neither -n, the kubeconfig context nor the
+// user config names a namespace.
+const defaultNamespace = "dev"
+
+// annotationNoCluster marks commands that must work without a reachable
+// cluster, such as local image builds.
+const annotationNoCluster = "kdev/no-cluster"
+
 var (
-	flagNamespace string
-	kubeClient    *kubernetes.Clientset
+	flagNamespace  string
+	flagKubeconfig string
+	flagContext    string
+	kubeClient     kubernetes.Interface
+	kubeConfig     clientcmd.ClientConfig
+	kubeRestConfig *rest.Config
+	userConfig     *config.Config
 )
 
-func initKubeClient() error {
-	// Use the current context from kubeconfig
-	config, err := clientcmd.BuildConfigFromFlags("", filepath.Join(homedir.HomeDir(), ".kube", "config"))
-	if err != nil {
-		return fmt.Errorf("failed to build kubeconfig: %w", err)
+// needsCluster reports whether cmd talks to the Kubernetes API.
+func needsCluster(cmd *cobra.Command) bool {
+	for c := cmd; c != nil; c = c.Parent() {
+		if c.Annotations[annotationNoCluster] == "true" {
+			return false
+		}
 	}
-
-	// Create the clientset
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return fmt.Errorf("failed to create kubernetes client: %w", err)
+	switch cmd.Name() {
+	case "completion", "help", cobra.ShellCompRequestCmd, cobra.ShellCompNoDescRequestCmd:
+		return false
 	}
-
-	kubeClient = clientset
-	return nil
+	return true
 }
 
 func main() {
+	setupConsole()
 	root := &cobra.Command{
-		Use:   "kdev",
-		Short: "Spin up, attach to, and clean up dev pods in Kubernetes",
+		Use:     "kdev",
+		Short:   "Spin up, attach to, and clean up dev pods in Kubernetes",
+		Version: buildVersion,
+		// Errors are printed by main after diagnoseKubeError adds hints.
+		SilenceErrors: true,
+		SilenceUsage:  true,
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-			if flagNamespace == "" {
-				flagNamespace = "dev"
+			if err := applyEnvFlags(cmd); err != nil {
+				return err
+			}
+			cfg, err := config.Load()
+			if err != nil {
+				return err
+			}
+			userConfig = cfg
+			if !needsCluster(cmd) {
+				return nil
 			}
 			if err := initKubeClient(); err != nil {
 				return fmt.Errorf("failed to initialize kubernetes client: %w", err)
 			}
+			flagNamespace, _ = resolveNamespace()
+			if err := preflight(); err != nil {
+				return err
+			}
+			warnVersionSkew()
 			return nil
 		},
 	}
 
-	root.PersistentFlags().StringVarP(&flagNamespace, "namespace", "n", "dev", "Kubernetes namespace")
-
-	root.AddCommand(cmdUp(), cmdAttach(), cmdLS(), cmdRM())
-
-	root.AddCommand(devcontainer.CmdDevContainer())
-
-	if err := root.Execute(); err != nil {
+	root.PersistentFlags().BoolVarP(&progress.Quiet, "quiet", "q", false, "Only print errors and final results")
+	root.PersistentFlags().BoolVar(&flagOffline, "offline", false, "Refuse operations that need the internet and require mirrors for public images (also offline: true in the user config)")
+	root.PersistentFlags().StringVar(&flagKubeconfig, "kubeconfig", "", "Path to the kubeconfig file (default: $KUBECONFIG, then ~/.kube/config)")
+	root.PersistentFlags().StringVar(&flagContext, "context", "", "Kubeconfig context to use instead of current-context")
+	_ = root.RegisterFlagCompletionFunc("context", completeContexts)
+	root.PersistentFlags().StringVarP(&flagNamespace, "namespace", "n", "", "Kubernetes namespace (default: kubeconfig context namespace, then config, then dev)")
+	_ = root.RegisterFlagCompletionFunc("namespace", completeNamespaces)
+
+	root.AddCommand(cmdUp(), cmdAttach(), cmdLS(), cmdRM(), cmdRename(), cmdDoctor(), cmdInfo(), cmdUpgrade(), cmdEdit(), cmdExport(), cmdImport(), cmdHibernate(), cmdWake(), cmdStop(), cmdStart(), cmdRun(), cmdExec(), cmdLogs(), cmdCP(), cmdPortForward(), cmdSSH(), cmdSSHConfig(), cmdCode(), cmdJetBrains(), cmdConfig(), cmdImage(), cmdTelemetry(), cmdInit(), cmdDev(), cmdRebuild(), cmdPrebuild(), cmdPrepull())
+
+	dc := devcontainer.CmdDevContainer(inClusterBuilders)
+	dc.AddCommand(cmdDevContainerConvert())
+	dc.Annotations = map[string]string{annotationNoCluster: "true"}
+	root.AddCommand(dc)
+
+	start := time.Now()
+	cmd, err := root.ExecuteC()
+	err = diagnoseKubeError(err)
+	recordUsage(cmd, err, time.Since(start))
+	if err != nil {
+		var exit *exitCodeError
+		if errors.As(err, &exit) {
+			os.Exit(exit.code)
+		}
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
 
-func cmdUp() *cobra.Command {
+func cmdAttach() *cobra.Command {
 	var (
-		name         string
-		template     string
-		image        string
-		sa           string
-		pvc          string
-		workdir      string
-		labels       []string
-		envs         []string
-		cpu          string
-		memory       string
-		nodeSel      []string
-		shell        string
-		storageClass string
-		storageSize  string
+		name        string
+		container   string
+		shell       string
+		noWait      bool
+		noReconnect bool
+		persistent  bool
+		session     string
+		create      bool
+		image       string
+		size        string
+		profile     string
+		timeout     time.Duration
 	)
 
 	c := &cobra.Command{
-		Use:   "up",
-		Short: "Create (or update) a dev pod from a template",
+		Use:   "attach [NAME]",
+		Short: "Attach an interactive shell to the dev pod",
+		Long: `Attach opens an interactive shell in the environment's pod. When the
+connection drops, for example on a network change or an API server restart,
+kdev opens a new shell in the environment's current pod, waiting up to
+--timeout for it to be ready. Leaving the shell with exit ends attach as usual.
+
+Without --shell, attach starts the environment's shell; when the image does
+not have it, the first of /bin/bash, /bin/zsh and /bin/sh that exists is used
+instead.
+
+With --persistent the shell runs in a tmux session named kdev (or --session)
+inside the pod, falling back to screen when the image has no tmux. Whatever
+runs in it keeps running when the connection drops or you detach (Ctrl-b d),
+and the next attach --persistent picks the session up again. The session
+ends with the pod.`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeEnvNameArg,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if name == "" {
-				return errors.New("--name is required")
-			}
-			if image == "" {
-				return errors.New("--image is required")
-			}
-			if sa == "" {
-				sa = "dev-vscode"
-			}
-			if pvc == "" {
-				pvc = name
-			}
-			if template == "" {
-				template = filepath.Join("templates", "pod.yaml")
-			}
-			if shell == "" {
-				shell = "/bin/bash"
-			}
-			if storageClass == "" {
-				storageClass = "local-path"
-			}
-			if storageSize == "" {
-				storageSize = "20Gi"
-			}
-
-			ctx := context.Background()
-
-			// Create PVC
-			pvcSpec := &corev1.PersistentVolumeClaim{
-				ObjectMeta: metav1.ObjectMeta{
-					Name:      pvc,
-					Namespace: flagNamespace,
-					Labels: map[string]string{
-						"app":       "kdev",
-						"kdev/name": name,
-					},
-				},
-				Spec: corev1.PersistentVolumeClaimSpec{
-					AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
-					Resources: corev1.VolumeResourceRequirements{
-						Requests: corev1.ResourceList{
-							corev1.ResourceStorage: resource.MustParse(storageSize),
-						},
-					},
-					StorageClassName: &storageClass,
-					VolumeMode:       &[]corev1.PersistentVolumeMode{corev1.PersistentVolumeFilesystem}[0],
-				},
-			}
-
-			// Create or update PVC
-			_, err := kubeClient.CoreV1().PersistentVolumeClaims(flagNamespace).Create(ctx, pvcSpec, metav1.CreateOptions{})
+			name, err := nameArg(args, name)
 			if err != nil {
-				return fmt.Errorf("failed to create PVC: %w", err)
+				return err
 			}
-
-			// Create ServiceAccount if it doesn't exist
-			saSpec := &corev1.ServiceAccount{
-				ObjectMeta: metav1.ObjectMeta{
-					Name:      sa,
-					Namespace: flagNamespace,
-				},
+			if name, err = envNameOrPick(context.Background(), name); err != nil {
+				return err
 			}
-
-			_, err = kubeClient.CoreV1().ServiceAccounts(flagNamespace).Create(ctx, saSpec, metav1.CreateOptions{})
-			if err != nil && !strings.Contains(err.Error(), "already exists") {
-				return fmt.Errorf("failed to create ServiceAccount: %w", err)
+			pod, err := resolvePod(context.Background(), flagNamespace, name)
+			if apierrors.IsNotFound(err) && create {
+				pod, err = createOnMissing(context.Background(), name, image, size, profile, shell, timeout)
 			}
-
-			// Create Pod
-			podLabels := map[string]string{
-				"app":       "kdev",
-				"kdev/name": name,
-			}
-
-			// Add custom labels
-			for _, label := range labels {
-				parts := strings.SplitN(label, "=", 2)
-				if len(parts) == 2 {
-					podLabels[parts[0]] = parts[1]
+			if err != nil {
+				return err
+			}
+			if !noWait && !podReady(pod) {
+				sp := progress.Start(fmt.Sprintf("Waiting for pod %s (%s)", pod.Name, podStatus(pod)))
+				if controlledByWorkload(pod) {
+					pod, err = waitForEnvPod(context.Background(), flagNamespace, name, timeout)
+				} else {
+					pod, err = waitForPod(context.Background(), flagNamespace, pod.Name, timeout)
 				}
-			}
-
-			// Parse node selector
-			nodeSelector := make(map[string]string)
-			for _, sel := range nodeSel {
-				parts := strings.SplitN(sel, "=", 2)
-				if len(parts) == 2 {
-					nodeSelector[parts[0]] = parts[1]
+				sp.Done(err)
+				if err != nil {
+					return err
 				}
 			}
-
-			// Parse environment variables
-			var envVars []corev1.EnvVar
-			for _, env := range envs {
-				parts := strings.SplitN(env, "=", 2)
-				if len(parts) == 2 {
-					envVars = append(envVars, corev1.EnvVar{
-						Name:  parts[0],
-						Value: parts[1],
-					})
-				}
+			if persistent && session == "" {
+				session = defaultSessionName
 			}
-
-			// Create resource requirements if specified
-			resources := corev1.ResourceRequirements{}
-			if cpu != "" || memory != "" {
-				resources.Requests = make(corev1.ResourceList)
-				resources.Limits = make(corev1.ResourceList)
-
-				if cpu != "" {
-					cpuResource := resource.MustParse(cpu)
-					resources.Requests[corev1.ResourceCPU] = cpuResource
-					resources.Limits[corev1.ResourceCPU] = cpuResource
+			if podReady(pod) {
+				if err := runStartHooks(context.Background(), pod); err != nil {
+					progress.Warnf("%v", err)
 				}
-				if memory != "" {
-					memResource := resource.MustParse(memory)
-					resources.Requests[corev1.ResourceMemory] = memResource
-					resources.Limits[corev1.ResourceMemory] = memResource
+				if err := runAttachHook(context.Background(), pod); err != nil {
+					progress.Warnf("%v", err)
 				}
 			}
-
-			podSpec := &corev1.Pod{
-				ObjectMeta: metav1.ObjectMeta{
-					Name:      name,
-					Namespace: flagNamespace,
-					Labels:    podLabels,
-				},
-				Spec: corev1.PodSpec{
-					ServiceAccountName: sa,
-					SecurityContext: &corev1.PodSecurityContext{
-						RunAsUser:  ptr.To[int64](1000),
-						RunAsGroup: ptr.To[int64](1000),
-						FSGroup:    ptr.To[int64](1000),
-						SeccompProfile: &corev1.SeccompProfile{
-							Type: corev1.SeccompProfileTypeRuntimeDefault,
-						},
-					},
-					NodeSelector: nodeSelector,
-					Containers: []corev1.Container{{
-						Name:       "dev",
-						Image:      image,
-						WorkingDir: workdir,
-						Command:    []string{shell, "-lc", "while true; do sleep 3600; done"},
-						Env:        envVars,
-						SecurityContext: &corev1.SecurityContext{
-							RunAsNonRoot:             ptr.To(true),
-							AllowPrivilegeEscalation: ptr.To(false),
-							Capabilities: &corev1.Capabilities{
-								Drop: []corev1.Capability{"ALL"},
-							},
-							ReadOnlyRootFilesystem: ptr.To(false),
-						},
-						Resources: resources,
-						VolumeMounts: []corev1.VolumeMount{{
-							Name:      "work",
-							MountPath: workdir,
-						}},
-					}},
-					Volumes: []corev1.Volume{{
-						Name: "work",
-						VolumeSource: corev1.VolumeSource{
-							PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-								ClaimName: pvc,
-							},
-						},
-					}},
-				},
-			}
-
-			// Create Pod
-			_, err = kubeClient.CoreV1().Pods(flagNamespace).Create(ctx, podSpec, metav1.CreateOptions{})
-			if err != nil {
-				return fmt.Errorf("failed to create Pod: %w", err)
-			}
-
-			fmt.Printf("\nPod %s created in ns/%s. Use 'kdev attach %s -n %s' to enter.\n", name, flagNamespace, name, flagNamespace)
-			return nil
+			stop := startAutoForwards(context.Background(), name, pod)
+			defer stop()
+			return attachSession(context.Background(), name, pod, container, shell, session, !noReconnect, timeout)
 		},
 	}
 
-	c.Flags().StringVar(&name, "name", "", "Pod name (required)")
-	c.Flags().StringVar(&template, "template", "", "Path to Pod template (default templates/pod.yaml)")
-	c.Flags().StringVar(&image, "image", "", "Container image (required)")
-	c.Flags().StringVar(&sa, "service-account", "", "ServiceAccount name (default dev-vscode)")
-	c.Flags().StringVar(&pvc, "pvc", "", "PVC name to mount (default: same as name)")
-	c.Flags().StringVar(&workdir, "workdir", "/workspaces", "Workspace directory inside container")
-	c.Flags().StringSliceVar(&labels, "label", nil, "Extra labels key=value (repeatable)")
-	c.Flags().StringSliceVar(&envs, "env", nil, "Env vars KEY=VALUE (repeatable)")
-	c.Flags().StringVar(&cpu, "cpu", "", "CPU request/limit, e.g. 500m")
-	c.Flags().StringVar(&memory, "memory", "", "Memory request/limit, e.g. 1Gi")
-	c.Flags().StringSliceVar(&nodeSel, "node", nil, "Node selector key=value (repeatable)")
-	c.Flags().StringVar(&shell, "shell", "", "Login shell inside container (default /bin/bash)")
-	c.Flags().StringVar(&storageClass, "storage-class", "", "StorageClass for the PVC (default local-path)")
-	c.Flags().StringVar(&storageSize, "storage", "", "PVC storage size (default 20Gi)")
-
-	_ = c.MarkFlagRequired("name")
-	_ = c.MarkFlagRequired("image")
+	c.Flags().StringVar(&name, "name", "", "Environment name (picked interactively when omitted)")
+	c.Flags().StringVar(&shell, "shell", "", "Shell to start inside container (default: the environment's shell, else bash, zsh or sh, whichever exists)")
+	c.Flags().StringVarP(&container, "container", "c", "", containerFlagUsage)
+	c.Flags().BoolVar(&noWait, "no-wait", false, "Attach immediately without waiting for the pod to be ready")
+	c.Flags().BoolVar(&persistent, "persistent", false, "Run the shell in a tmux (or screen) session in the pod that survives disconnects; attaching again resumes it")
+	c.Flags().StringVar(&session, "session", "", "Name of the --persistent session (default \"kdev\"; implies --persistent)")
+	c.Flags().BoolVar(&noReconnect, "no-reconnect", false, "Do not reconnect when the connection drops")
+	c.Flags().DurationVar(&timeout, "timeout", 5*time.Minute, "How long to wait for the pod to become ready, also when reconnecting")
+	c.Flags().BoolVar(&create, "create", false, "Create the environment if it does not exist, asking for missing settings")
+	c.Flags().StringVar(&image, "image", "", "Container image for --create")
+	c.Flags().StringVar(&size, "size", "", "Resource preset for --create")
+	c.Flags().StringVar(&profile, "profile", "", "Profile of the user config for --create")
+	deprecateNameFlag(c)
+	_ = c.RegisterFlagCompletionFunc("container", completeContainers)
+	_ = c.RegisterFlagCompletionFunc("size", completeSizes)
+	_ = c.RegisterFlagCompletionFunc("profile", completeProfiles)
 	return c
 }
 
-func cmdAttach() *cobra.Command {
-	var (
-		name  string
-		shell string
-	)
-
-	c := &cobra.Command{
-		Use:   "attach",
-		Short: "Attach an interactive shell to the dev pod",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			if name == "" {
-				return errors.New("--name is required")
-			}
-			if shell == "" {
-				shell = "/bin/bash"
-			}
-			req := kubeClient.CoreV1().RESTClient().Post().
-				Resource("pods").
-				Name(name).
-				Namespace(flagNamespace).
-				SubResource("exec").
-				VersionedParams(&corev1.PodExecOptions{
-					Container: "dev",
-					Command:   []string{shell},
-					Stdin:     true,
-					Stdout:    true,
-					Stderr:    true,
-					TTY:       true,
-				}, kubeScheme.ParameterCodec)
-
-			config, err := clientcmd.BuildConfigFromFlags("", filepath.Join(homedir.HomeDir(), ".kube", "config"))
-			if err != nil {
-				return fmt.Errorf("failed to get kubeconfig: %w", err)
-			}
-
-			exec, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
-			if err != nil {
-				return fmt.Errorf("failed to create executor: %w", err)
-			}
-
-			return exec.StreamWithContext(context.Background(), remotecommand.StreamOptions{
-				Stdin:  os.Stdin,
-				Stdout: os.Stdout,
-				Stderr: os.Stderr,
-				Tty:    true,
-			})
-		},
+// guardManaged refuses to touch objects kdev did not create, and warns when
+// the object belongs to someone else or is owned by a controller.
+func guardManaged(kind string, meta *metav1.ObjectMeta) error {
+	if meta.Labels["app"] != "kdev" {
+		return fmt.Errorf("%s %s is not managed by kdev (missing app=kdev label); use --no-guard to delete it anyway", kind, meta.Name)
 	}
-
-	c.Flags().StringVar(&name, "name", "", "Pod name (required)")
-	c.Flags().StringVar(&shell, "shell", "", "Shell to start inside container (default /bin/bash)")
-	_ = c.MarkFlagRequired("name")
-	return c
-}
-
-func cmdLS() *cobra.Command {
-	c := &cobra.Command{
-		Use:   "ls",
-		Short: "List dev pods in the namespace",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			fmt.Printf("Namespace: %s\n", flagNamespace)
-
-			pods, err := kubeClient.CoreV1().Pods(flagNamespace).List(context.Background(), metav1.ListOptions{
-				LabelSelector: "app=kdev",
-			})
-			if err != nil {
-				return fmt.Errorf("failed to list pods: %w", err)
-			}
-
-			if len(pods.Items) == 0 {
-				fmt.Println("No pods found")
-				return nil
-			}
-
-			fmt.Printf("%-30s %-15s %-10s %-20s %-15s\n", "NAME", "READY", "STATUS", "NODE", "AGE")
-			for _, pod := range pods.Items {
-				ready := 0
-				for _, c := range pod.Status.ContainerStatuses {
-					if c.Ready {
-						ready++
-					}
-				}
-				age := time.Since(pod.CreationTimestamp.Time).Round(time.Second)
-				fmt.Printf("%-30s %d/%-13d %-10s %-20s %-15s\n",
-					pod.Name,
-					ready,
-					len(pod.Spec.Containers),
-					pod.Status.Phase,
-					pod.Spec.NodeName,
-					age.String())
-			}
-			return nil
-		},
+	if owner := meta.Labels["kdev/owner"]; owner != "" && owner != currentOwner() {
+		progress.Warnf("%s %s belongs to %s, not %s", kind, meta.Name, owner, currentOwner())
 	}
-	return c
+	if ref := metav1.GetControllerOfNoCopy(meta); ref != nil {
+		progress.Warnf("%s %s is controlled by %s/%s and may be recreated", kind, meta.Name, ref.Kind, ref.Name)
+	}
+	return nil
 }
 
-func cmdRM() *cobra.Command {
-	var (
-		name      string
-		deletePVC bool
-	)
-
-	c := &cobra.Command{
-		Use:   "rm",
-		Short: "Delete a dev pod (optionally its PVC)",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			if name == "" {
-				return errors.New("--name is required")
-			}
-
-			ctx := context.Background()
-
-			if err := kubeClient.CoreV1().Pods(flagNamespace).Delete(ctx, name, metav1.DeleteOptions{}); err != nil {
-				return fmt.Errorf("failed to delete pod: %w", err)
-			}
-
-			if deletePVC {
-				if err := kubeClient.CoreV1().PersistentVolumeClaims(flagNamespace).Delete(ctx, name, metav1.DeleteOptions{}); err != nil {
-					return fmt.Errorf("failed to delete PVC: %w", err)
-				}
-			}
-
-			fmt.Printf("Pod %s deleted in namespace %s\n", name, flagNamespace)
-			if deletePVC {
-				fmt.Printf("PVC %s deleted in namespace %s\n", name, flagNamespace)
-			}
-			return nil
-		},
+// currentOwner returns the local user name as a valid label value. It is
+// recorded on created resources so rm can spot someone else's environment.
+func currentOwner() string {
+	u, err := user.Current()
+	if err != nil {
+		return ""
 	}
-
-	c.Flags().StringVar(&name, "name", "", "Pod name (required)")
-	c.Flags().BoolVar(&deletePVC, "with-pvc", false, "Also delete PVC named like the pod")
-	_ = c.MarkFlagRequired("name")
-	return c
+	name := u.Username
+	if i := strings.LastIndexAny(name, `\/`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = invalidLabelChars.ReplaceAllString(name, "-")
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return strings.Trim(name, "-_.")
 }
+
+var invalidLabelChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
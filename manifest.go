@@ -0,0 +1,608 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/noopduck/kdev/internal/spec"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/utils/ptr"
+)
+
+// devContainer is the name of the container kdev execs into.
+const devContainer = "dev"
+
+// basePod returns the fixed parts of every dev pod: identity labels, the
+// hardened security context and the workspace volume wiring. Everything a
+// user can choose is filled in by applySpec.
+func basePod(namespace, name string) *corev1.Pod {
+	return &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels: map[string]string{
+				"app":        "kdev",
+				"kdev/name":  name,
+				"kdev/owner": currentOwner(),
+			},
+		},
+		Spec: corev1.PodSpec{
+			SecurityContext: &corev1.PodSecurityContext{
+				SeccompProfile: &corev1.SeccompProfile{
+					Type: corev1.SeccompProfileTypeRuntimeDefault,
+				},
+			},
+			Containers: []corev1.Container{{
+				Name: devContainer,
+				SecurityContext: &corev1.SecurityContext{
+					RunAsNonRoot:             ptr.To(true),
+					AllowPrivilegeEscalation: ptr.To(false),
+					Capabilities: &corev1.Capabilities{
+						Drop: []corev1.Capability{"ALL"},
+					},
+					ReadOnlyRootFilesystem: ptr.To(false),
+				},
+				VolumeMounts: []corev1.VolumeMount{{
+					Name: "work",
+				}},
+			}},
+			Volumes: []corev1.Volume{{
+				Name: "work",
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{},
+				},
+			}},
+		},
+	}
+}
+
+// buildPod renders the dev pod for a fully defaulted spec.
+func buildPod(namespace string, s spec.Spec) (*corev1.Pod, error) {
+	pod := basePod(namespace, s.Name)
+	if err := applySpec(pod, s); err != nil {
+		return nil, err
+	}
+	return pod, nil
+}
+
+// applySpec writes every set field of s onto pod, leaving the rest alone.
+// It is used both to build a pod from scratch and to overlay flags on top of
+// a user template.
+func applySpec(pod *corev1.Pod, s spec.Spec) error {
+	c := findContainer(pod, devContainer)
+	if c == nil {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: devContainer})
+		c = &pod.Spec.Containers[len(pod.Spec.Containers)-1]
+	}
+
+	if s.Image != "" {
+		c.Image = s.Image
+	}
+	if s.ServiceAccount != "" {
+		pod.Spec.ServiceAccountName = s.ServiceAccount
+	}
+	if s.Workdir != "" {
+		c.WorkingDir = s.Workdir
+		setVolumeMount(c, "work", s.Workdir)
+	}
+	if s.Shell != "" {
+		setAnnotation(pod, shellAnnotation, s.Shell)
+	}
+	if s.User != "" {
+		uid, gid, err := parseUser(s.User)
+		if err != nil {
+			return err
+		}
+		if pod.Spec.SecurityContext == nil {
+			pod.Spec.SecurityContext = &corev1.PodSecurityContext{}
+		}
+		sc := pod.Spec.SecurityContext
+		sc.RunAsUser, sc.RunAsGroup, sc.FSGroup = ptr.To(uid), ptr.To(gid), ptr.To(gid)
+	}
+	if s.Keepalive != "" {
+		if !slices.Contains(keepaliveModes, s.Keepalive) && !strings.ContainsAny(s.Keepalive, " \t") {
+			return fmt.Errorf("invalid keepalive %q (want %s or a shell command)", s.Keepalive, strings.Join(keepaliveModes, ", "))
+		}
+		setAnnotation(pod, keepaliveAnnotation, s.Keepalive)
+		applyKeepalive(pod, c, s.Keepalive)
+	}
+	if s.PVC != "" {
+		setClaim(pod, "work", s.PVC)
+	}
+	if s.Hostname != "" {
+		pod.Spec.Hostname = s.Hostname
+	}
+	if s.Subdomain != "" {
+		pod.Spec.Subdomain = s.Subdomain
+		if pod.Labels == nil {
+			pod.Labels = map[string]string{}
+		}
+		pod.Labels[subdomainLabel] = s.Subdomain
+	}
+	for k, v := range s.Labels {
+		if pod.Labels == nil {
+			pod.Labels = map[string]string{}
+		}
+		pod.Labels[k] = v
+	}
+	for k, v := range s.Annotations {
+		if pod.Annotations == nil {
+			pod.Annotations = map[string]string{}
+		}
+		pod.Annotations[k] = v
+	}
+	for _, k := range sortedKeys(s.Env) {
+		setEnv(c, k, s.Env[k])
+	}
+	if s.Timezone != "" {
+		setEnv(c, "TZ", s.Timezone)
+	}
+	if s.Locale != "" {
+		setEnv(c, "LANG", s.Locale)
+	}
+	for _, ref := range s.EnvFrom {
+		src, err := parseEnvFrom(ref)
+		if err != nil {
+			return err
+		}
+		if !slices.ContainsFunc(c.EnvFrom, func(e corev1.EnvFromSource) bool { return envFromRef(e) == envFromRef(src) }) {
+			c.EnvFrom = append(c.EnvFrom, src)
+		}
+	}
+	for k, v := range s.NodeSelector {
+		if pod.Spec.NodeSelector == nil {
+			pod.Spec.NodeSelector = map[string]string{}
+		}
+		pod.Spec.NodeSelector[k] = v
+	}
+	for _, t := range s.Tolerations {
+		tol, err := parseToleration(t)
+		if err != nil {
+			return err
+		}
+		if !slices.ContainsFunc(pod.Spec.Tolerations, func(o corev1.Toleration) bool { return o.MatchToleration(&tol) }) {
+			pod.Spec.Tolerations = append(pod.Spec.Tolerations, tol)
+		}
+	}
+	for _, secret := range s.ImagePullSecrets {
+		if !slices.Contains(pod.Spec.ImagePullSecrets, corev1.LocalObjectReference{Name: secret}) {
+			pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, corev1.LocalObjectReference{Name: secret})
+		}
+	}
+	if s.SSH {
+		addSSHSidecar(pod)
+	}
+	if s.WebIDE != "" {
+		addWebIDESidecar(pod, s.WebIDE)
+	}
+	if err := setResource(c, corev1.ResourceCPU, s.CPU); err != nil {
+		return fmt.Errorf("invalid cpu: %w", err)
+	}
+	if err := setResource(c, corev1.ResourceMemory, s.Memory); err != nil {
+		return fmt.Errorf("invalid memory: %w", err)
+	}
+	for _, name := range sortedKeys(s.ExtraResources) {
+		if !strings.Contains(name, "/") {
+			return fmt.Errorf("invalid extra resource %q: want a domain-prefixed name such as nvidia.com/gpu", name)
+		}
+		if err := setResource(c, corev1.ResourceName(name), s.ExtraResources[name]); err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// buildPVC renders the workspace PVC. The storage class is taken verbatim;
+// resolveStorageClass turns "default" into the cluster default later.
+func buildPVC(namespace string, s spec.Spec) (*corev1.PersistentVolumeClaim, error) {
+	pvc := &corev1.PersistentVolumeClaim{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "PersistentVolumeClaim"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.PVC,
+			Namespace: namespace,
+			Labels: map[string]string{
+				"app":        "kdev",
+				"kdev/name":  s.Name,
+				"kdev/owner": currentOwner(),
+			},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			VolumeMode:  ptr.To(corev1.PersistentVolumeFilesystem),
+		},
+	}
+	if err := applyStorage(pvc, s); err != nil {
+		return nil, err
+	}
+	return pvc, nil
+}
+
+// applyStorage writes the storage fields and annotations of s onto pvc.
+func applyStorage(pvc *corev1.PersistentVolumeClaim, s spec.Spec) error {
+	for k, v := range s.Annotations {
+		if pvc.Annotations == nil {
+			pvc.Annotations = map[string]string{}
+		}
+		pvc.Annotations[k] = v
+	}
+	if s.StorageClass != "" {
+		pvc.Spec.StorageClassName = ptr.To(s.StorageClass)
+	}
+	if s.StorageSize != "" {
+		q, err := resource.ParseQuantity(s.StorageSize)
+		if err != nil {
+			return fmt.Errorf("invalid storage size %q: %w", s.StorageSize, err)
+		}
+		if pvc.Spec.Resources.Requests == nil {
+			pvc.Spec.Resources.Requests = corev1.ResourceList{}
+		}
+		pvc.Spec.Resources.Requests[corev1.ResourceStorage] = q
+	}
+	return nil
+}
+
+// parseUser parses "UID[:GID]"; the group defaults to the UID. The dev
+// container runs as non-root, so UID 0 is refused.
+func parseUser(s string) (uid, gid int64, err error) {
+	u, g, hasGroup := strings.Cut(s, ":")
+	if uid, err = strconv.ParseInt(u, 10, 64); err != nil || uid < 0 {
+		return 0, 0, fmt.Errorf("invalid user %q: want UID[:GID]", s)
+	}
+	gid = uid
+	if hasGroup {
+		if gid, err = strconv.ParseInt(g, 10, 64); err != nil || gid < 0 {
+			return 0, 0, fmt.Errorf("invalid user %q: want UID[:GID]", s)
+		}
+	}
+	if uid == 0 {
+		return 0, 0, fmt.Errorf("invalid user %q: the dev container cannot run as root", s)
+	}
+	return uid, gid, nil
+}
+
+// managedAnnotation reports whether key is set by kdev itself, kubectl or a
+// Kubernetes controller rather than by the user, so it is not part of the
+// spec: kdev's kdev/* bookkeeping and the kubernetes.io and k8s.io domains.
+func managedAnnotation(key string) bool {
+	prefix, _, ok := strings.Cut(key, "/")
+	if !ok {
+		return false
+	}
+	for _, domain := range []string{"kdev", "kubernetes.io", "k8s.io"} {
+		if prefix == domain || strings.HasSuffix(prefix, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// specFromPod recovers the kdev-level spec from rendered objects. Either
+// argument may be nil.
+func specFromPod(pod *corev1.Pod, pvc *corev1.PersistentVolumeClaim) spec.Spec {
+	var s spec.Spec
+	if pod != nil {
+		s.Name = pod.Name
+		s.ServiceAccount = pod.Spec.ServiceAccountName
+		s.Hostname = pod.Spec.Hostname
+		s.Subdomain = pod.Spec.Subdomain
+		s.NodeSelector = pod.Spec.NodeSelector
+		if sc := pod.Spec.SecurityContext; sc != nil && sc.RunAsUser != nil {
+			s.User = strconv.FormatInt(*sc.RunAsUser, 10)
+			if sc.RunAsGroup != nil {
+				s.User += ":" + strconv.FormatInt(*sc.RunAsGroup, 10)
+			}
+		}
+		for _, ref := range pod.Spec.ImagePullSecrets {
+			s.ImagePullSecrets = append(s.ImagePullSecrets, ref.Name)
+		}
+		for _, t := range pod.Spec.Tolerations {
+			// Those with a timeout are added by admission, like not-ready.
+			if t.TolerationSeconds == nil {
+				s.Tolerations = append(s.Tolerations, formatToleration(t))
+			}
+		}
+		for k, v := range pod.Labels {
+			switch k {
+			case "app", "kdev/name", "kdev/owner", subdomainLabel:
+				continue
+			}
+			if s.Labels == nil {
+				s.Labels = map[string]string{}
+			}
+			s.Labels[k] = v
+		}
+		s.Shell = pod.Annotations[shellAnnotation]
+		s.Keepalive = pod.Annotations[keepaliveAnnotation]
+		for k, v := range pod.Annotations {
+			if managedAnnotation(k) {
+				continue
+			}
+			if s.Annotations == nil {
+				s.Annotations = map[string]string{}
+			}
+			s.Annotations[k] = v
+		}
+		for _, v := range pod.Spec.Volumes {
+			if v.Name == "work" && v.PersistentVolumeClaim != nil {
+				s.PVC = v.PersistentVolumeClaim.ClaimName
+			}
+		}
+		s.SSH = findContainer(pod, sshContainer) != nil
+		if c := findContainer(pod, webIDEContainer); c != nil {
+			s.WebIDE = c.Image
+		}
+		if c := findContainer(pod, devContainer); c != nil {
+			s.Image = c.Image
+			s.Workdir = c.WorkingDir
+			if len(c.Command) == 3 && c.Command[1] == "-lc" && c.Command[2] == legacyKeepaliveScript && s.Shell == "" {
+				s.Shell = c.Command[0]
+			}
+			for _, e := range c.Env {
+				if e.ValueFrom != nil {
+					continue
+				}
+				switch e.Name {
+				case "TZ":
+					s.Timezone = e.Value
+					continue
+				case "LANG":
+					s.Locale = e.Value
+					continue
+				}
+				if s.Env == nil {
+					s.Env = map[string]string{}
+				}
+				s.Env[e.Name] = e.Value
+			}
+			for _, e := range c.EnvFrom {
+				if ref := envFromRef(e); ref != "" {
+					s.EnvFrom = append(s.EnvFrom, ref)
+				}
+			}
+			if q, ok := c.Resources.Requests[corev1.ResourceCPU]; ok {
+				s.CPU = q.String()
+			}
+			if q, ok := c.Resources.Requests[corev1.ResourceMemory]; ok {
+				s.Memory = q.String()
+			}
+			for name, q := range c.Resources.Limits {
+				if strings.Contains(string(name), "/") {
+					if s.ExtraResources == nil {
+						s.ExtraResources = map[string]string{}
+					}
+					s.ExtraResources[string(name)] = q.String()
+				}
+			}
+		}
+	}
+	if pvc != nil {
+		if pvc.Spec.StorageClassName != nil {
+			s.StorageClass = *pvc.Spec.StorageClassName
+		}
+		if q, ok := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; ok {
+			s.StorageSize = q.String()
+		}
+	}
+	return s
+}
+
+// validatePod runs the client-side checks the API server would otherwise
+// report one at a time.
+func validatePod(pod *corev1.Pod) error {
+	var errs []error
+	for _, msg := range validation.IsDNS1123Subdomain(pod.Name) {
+		errs = append(errs, fmt.Errorf("name %q: %s", pod.Name, msg))
+	}
+	for k, v := range pod.Labels {
+		for _, msg := range validation.IsQualifiedName(k) {
+			errs = append(errs, fmt.Errorf("label key %q: %s", k, msg))
+		}
+		for _, msg := range validation.IsValidLabelValue(v) {
+			errs = append(errs, fmt.Errorf("label %s=%q: %s", k, v, msg))
+		}
+	}
+	if h := pod.Spec.Hostname; h != "" {
+		for _, msg := range validation.IsDNS1123Label(h) {
+			errs = append(errs, fmt.Errorf("hostname %q: %s", h, msg))
+		}
+	}
+	if d := pod.Spec.Subdomain; d != "" {
+		for _, msg := range validation.IsDNS1123Label(d) {
+			errs = append(errs, fmt.Errorf("subdomain %q: %s", d, msg))
+		}
+	}
+	for k := range pod.Annotations {
+		for _, msg := range validation.IsQualifiedName(k) {
+			errs = append(errs, fmt.Errorf("annotation key %q: %s", k, msg))
+		}
+	}
+	volumes := map[string]bool{}
+	for _, v := range pod.Spec.Volumes {
+		volumes[v.Name] = true
+	}
+	for _, c := range pod.Spec.Containers {
+		if c.Image == "" {
+			errs = append(errs, fmt.Errorf("container %s: image is required (set --image or use a template that sets it)", c.Name))
+		}
+		for _, e := range c.Env {
+			for _, msg := range validation.IsEnvVarName(e.Name) {
+				errs = append(errs, fmt.Errorf("container %s: env %q: %s", c.Name, e.Name, msg))
+			}
+		}
+		for _, m := range c.VolumeMounts {
+			if !volumes[m.Name] {
+				errs = append(errs, fmt.Errorf("container %s: volumeMount %q has no matching volume", c.Name, m.Name))
+			}
+			if m.MountPath == "" {
+				errs = append(errs, fmt.Errorf("container %s: volumeMount %q has no mountPath", c.Name, m.Name))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func findContainer(pod *corev1.Pod, name string) *corev1.Container {
+	for i := range pod.Spec.Containers {
+		if pod.Spec.Containers[i].Name == name {
+			return &pod.Spec.Containers[i]
+		}
+	}
+	return nil
+}
+
+func setVolumeMount(c *corev1.Container, volume, path string) {
+	for i := range c.VolumeMounts {
+		if c.VolumeMounts[i].Name == volume {
+			c.VolumeMounts[i].MountPath = path
+			return
+		}
+	}
+	c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{Name: volume, MountPath: path})
+}
+
+func setClaim(pod *corev1.Pod, volume, claim string) {
+	for i := range pod.Spec.Volumes {
+		v := &pod.Spec.Volumes[i]
+		if v.Name == volume {
+			v.VolumeSource = corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim},
+			}
+			return
+		}
+	}
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: volume,
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim},
+		},
+	})
+}
+
+func setAnnotation(pod *corev1.Pod, key, value string) {
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[key] = value
+}
+
+func setEnv(c *corev1.Container, name, value string) {
+	for i := range c.Env {
+		if c.Env[i].Name == name {
+			c.Env[i] = corev1.EnvVar{Name: name, Value: value}
+			return
+		}
+	}
+	c.Env = append(c.Env, corev1.EnvVar{Name: name, Value: value})
+}
+
+// setResource sets both request and limit, as kdev pods are sized exactly.
+func setResource(c *corev1.Container, name corev1.ResourceName, value string) error {
+	if value == "" {
+		return nil
+	}
+	q, err := resource.ParseQuantity(value)
+	if err != nil {
+		return err
+	}
+	if c.Resources.Requests == nil {
+		c.Resources.Requests = corev1.ResourceList{}
+	}
+	if c.Resources.Limits == nil {
+		c.Resources.Limits = corev1.ResourceList{}
+	}
+	c.Resources.Requests[name] = q
+	c.Resources.Limits[name] = q
+	return nil
+}
+
+// parseKeyValues parses repeatable key=value flags.
+func parseKeyValues(flag string, values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	m := make(map[string]string, len(values))
+	for _, kv := range values {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid --%s %q, expected key=value", flag, kv)
+		}
+		m[k] = v
+	}
+	return m, nil
+}
+
+// parseToleration reads kubectl taint syntax, key[=value][:effect]. Without
+// a value any value of the key is tolerated, without an effect any effect.
+func parseToleration(s string) (corev1.Toleration, error) {
+	rest, effect, _ := strings.Cut(s, ":")
+	key, value, hasValue := strings.Cut(rest, "=")
+	t := corev1.Toleration{Key: key, Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffect(effect)}
+	if hasValue {
+		t.Operator, t.Value = corev1.TolerationOpEqual, value
+	}
+	switch t.Effect {
+	case "", corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+	default:
+		return t, fmt.Errorf("invalid toleration %q: effect must be NoSchedule, PreferNoSchedule or NoExecute", s)
+	}
+	if key == "" || len(validation.IsQualifiedName(key)) > 0 {
+		return t, fmt.Errorf("invalid toleration %q: want key[=value][:effect]", s)
+	}
+	return t, nil
+}
+
+// formatToleration is the inverse of parseToleration.
+func formatToleration(t corev1.Toleration) string {
+	s := t.Key
+	if t.Operator != corev1.TolerationOpExists {
+		s += "=" + t.Value
+	}
+	if t.Effect != "" {
+		s += ":" + string(t.Effect)
+	}
+	return s
+}
+
+// parseEnvFrom turns "secret/NAME" or "configmap/NAME" into an envFrom
+// source.
+func parseEnvFrom(ref string) (corev1.EnvFromSource, error) {
+	kind, name, _ := strings.Cut(ref, "/")
+	if name == "" || len(validation.IsDNS1123Subdomain(name)) > 0 {
+		return corev1.EnvFromSource{}, fmt.Errorf("invalid --env-from %q, expected secret/NAME or configmap/NAME", ref)
+	}
+	switch strings.ToLower(kind) {
+	case "secret":
+		return corev1.EnvFromSource{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: name}}}, nil
+	case "configmap", "cm":
+		return corev1.EnvFromSource{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: name}}}, nil
+	}
+	return corev1.EnvFromSource{}, fmt.Errorf("invalid --env-from %q, expected secret/NAME or configmap/NAME", ref)
+}
+
+// envFromRef is the inverse of parseEnvFrom.
+func envFromRef(e corev1.EnvFromSource) string {
+	switch {
+	case e.SecretRef != nil:
+		return "secret/" + e.SecretRef.Name
+	case e.ConfigMapRef != nil:
+		return "configmap/" + e.ConfigMapRef.Name
+	}
+	return ""
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/noopduck/kdev/internal/registry"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// flagOffline is the global --offline flag.
+var flagOffline bool
+
+// offline reports whether --offline or the user config forbid operations
+// that need the internet.
+func offline() bool {
+	return flagOffline || (userConfig != nil && userConfig.Offline)
+}
+
+// requireOnline fails in offline mode with what needs the network.
+func requireOnline(what string) error {
+	if offline() {
+		return fmt.Errorf("%s needs internet access, which offline mode forbids", what)
+	}
+	return nil
+}
+
+// resolveImage applies the mirror rules of the user config to ref. In
+// offline mode a reference still pointing at a public registry is an error.
+func resolveImage(ref string) (string, error) {
+	var mirrors map[string]string
+	if userConfig != nil {
+		mirrors = userConfig.Mirrors
+	}
+	out := registry.Rewrite(ref, mirrors)
+	if offline() && registry.IsPublic(out) {
+		host, _ := registry.Split(out)
+		return "", fmt.Errorf("image %s is on %s, which offline mode cannot reach; add a mirror for it to the user config", ref, host)
+	}
+	return out, nil
+}
+
+// resolvePodImages applies resolveImage to every container of spec.
+func resolvePodImages(spec *corev1.PodSpec) error {
+	for _, list := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for i := range list {
+			img, err := resolveImage(list[i].Image)
+			if err != nil {
+				return err
+			}
+			list[i].Image = img
+		}
+	}
+	return nil
+}
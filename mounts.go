@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/noopduck/kdev/internal/devcontainer"
+	"github.com/noopduck/kdev/internal/progress"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+var invalidClaimChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// volumeClaimName is the PVC backing the named devcontainer volume source.
+// Like a Docker volume it is shared by every environment that mounts it;
+// across nodes only with the ReadWriteMany volumeStorageClass of the user
+// config.
+func volumeClaimName(source string) string {
+	name := "kdev-vol-" + strings.Trim(invalidClaimChars.ReplaceAllString(strings.ToLower(source), "-"), "-.")
+	if len(name) > 253 {
+		name = name[:253]
+	}
+	return strings.TrimRight(name, "-.")
+}
+
+// applyMounts adds devcontainer mounts to container of m. A named volume
+// becomes a PVC like the workspace one, an anonymous volume an emptyDir and
+// a tmpfs an in-memory emptyDir. Bind mounts cannot reach the local machine
+// from a pod and are skipped with a warning. With scoped the PVCs belong to
+// the environment, as compose volumes belong to their project, and kdev rm
+// --with-pvc deletes them with it.
+func applyMounts(m *manifests, container string, mounts []devcontainer.Mount, scoped bool) error {
+	c := findContainer(m.Pod, container)
+	if c == nil {
+		return fmt.Errorf("pod %s has no %s container", m.Pod.Name, container)
+	}
+	for _, mt := range mounts {
+		volume := corev1.Volume{Name: mountVolumeName(m.Pod)}
+		switch {
+		case mt.Type == "bind":
+			progress.Warnf("skipping bind mount of %s on %s: a pod cannot mount local files; use kdev cp, or a volume mount", mt.Source, mt.Target)
+			continue
+		case mt.Type == "tmpfs":
+			volume.EmptyDir = &corev1.EmptyDirVolumeSource{Medium: corev1.StorageMediumMemory}
+		case mt.Source == "":
+			volume.EmptyDir = &corev1.EmptyDirVolumeSource{}
+		default:
+			claim := volumeClaimName(mt.Source)
+			volume.PersistentVolumeClaim = &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim}
+			if slices.ContainsFunc(m.Volumes, func(pvc *corev1.PersistentVolumeClaim) bool { return pvc.Name == claim }) {
+				break
+			}
+			pvc := m.PVC.DeepCopy()
+			pvc.Name = claim
+			pvc.Labels = map[string]string{"app": "kdev", "kdev/owner": currentOwner()}
+			if scoped {
+				pvc.Labels["kdev/name"] = m.Pod.Name
+			} else if userConfig != nil && userConfig.VolumeStorageClass != "" {
+				pvc.Spec.StorageClassName = &userConfig.VolumeStorageClass
+				pvc.Spec.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}
+			}
+			pvc.Annotations = map[string]string{"kdev/volume": mt.Source}
+			pvc.ResourceVersion, pvc.UID = "", ""
+			m.Volumes = append(m.Volumes, pvc)
+		}
+		m.Pod.Spec.Volumes = append(m.Pod.Spec.Volumes, volume)
+		c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{Name: volume.Name, MountPath: mt.Target, ReadOnly: mt.ReadOnly})
+	}
+	syncSSHSidecar(m.Pod)
+	syncWebIDESidecar(m.Pod)
+	return validatePod(m.Pod)
+}
+
+// mountVolumeName returns a pod volume name for a mount not yet used in
+// pod.
+func mountVolumeName(pod *corev1.Pod) string {
+	for i := 0; ; i++ {
+		name := fmt.Sprintf("devcontainer-mount-%d", i)
+		if !slices.ContainsFunc(pod.Spec.Volumes, func(v corev1.Volume) bool { return v.Name == name }) {
+			return name
+		}
+	}
+}
+
+// ensureVolumes creates the PVCs of the devcontainer volumes that do not
+// exist yet. Existing ones are left alone: other environments may use them.
+func ensureVolumes(ctx context.Context, m *manifests) error {
+	pvcs := kubeClient.CoreV1().PersistentVolumeClaims(flagNamespace)
+	for _, pvc := range m.Volumes {
+		live, err := pvcs.Get(ctx, pvc.Name, metav1.GetOptions{})
+		if err == nil {
+			if err := checkExisting("PVC", &live.ObjectMeta); err != nil {
+				return err
+			}
+			continue
+		}
+		if !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to get PVC %s: %w", pvc.Name, err)
+		}
+		if _, err := serverSideApply(ctx, pvcs.Patch, pvc.Name, pvc); err != nil {
+			return fmt.Errorf("failed to create PVC %s: %w", pvc.Name, err)
+		}
+		progress.Infof("Created PVC %s for volume %s", pvc.Name, pvc.Annotations["kdev/volume"])
+	}
+	return nil
+}
+
+// deleteEnvVolumes deletes the PVCs of the volumes scoped to environment
+// name, as applyMounts labels them, and returns their names.
+func deleteEnvVolumes(ctx context.Context, name string) ([]string, error) {
+	pvcs := kubeClient.CoreV1().PersistentVolumeClaims(flagNamespace)
+	list, err := pvcs.List(ctx, metav1.ListOptions{LabelSelector: labels.Set{"app": "kdev", "kdev/name": name}.String()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the volumes of %s: %w", name, err)
+	}
+	var deleted []string
+	for _, pvc := range list.Items {
+		// The workspace PVC carries the same labels but no volume source.
+		if pvc.Annotations["kdev/volume"] == "" {
+			continue
+		}
+		if err := pvcs.Delete(ctx, pvc.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return deleted, fmt.Errorf("failed to delete PVC %s: %w", pvc.Name, err)
+		}
+		deleted = append(deleted, pvc.Name)
+	}
+	return deleted, nil
+}
+
+// diagnoseVolumeConflict fails for a pod the scheduler cannot place because
+// a named volume PVC it mounts is bound to the local storage of another
+// node, which it would wait for forever.
+func diagnoseVolumeConflict(pod *corev1.Pod) error {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type != corev1.PodScheduled || cond.Status != corev1.ConditionFalse || !strings.Contains(cond.Message, "volume node affinity conflict") {
+			continue
+		}
+		for _, v := range pod.Spec.Volumes {
+			if v.PersistentVolumeClaim != nil && strings.HasPrefix(v.PersistentVolumeClaim.ClaimName, "kdev-vol-") {
+				return fmt.Errorf("pod %s cannot be scheduled: volume PVC %s is bound to another node, where an environment mounting it ran first; "+
+					"set volumeStorageClass in the user config to a ReadWriteMany StorageClass to share volumes across nodes", pod.Name, v.PersistentVolumeClaim.ClaimName)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// pickerRows is how many matches the picker shows at once.
+const pickerRows = 10
+
+// errNameRequired is returned when a command needs an environment name and
+// none was given.
+var errNameRequired = errors.New("an environment name is required")
+
+// errPickCancelled is returned when the user leaves the picker.
+var errPickCancelled = errors.New("no environment selected")
+
+// pickItem is one row of the picker.
+type pickItem struct {
+	name, status, age string
+}
+
+// nameArg returns the environment name given as the first argument or, for
+// compatibility, through the deprecated --name flag.
+func nameArg(args []string, flag string) (string, error) {
+	if len(args) > 0 && flag != "" && flag != args[0] {
+		return "", fmt.Errorf("environment given twice: %s and --name %s", args[0], flag)
+	}
+	if len(args) > 0 {
+		return args[0], nil
+	}
+	return flag, nil
+}
+
+// deprecateNameFlag hides --name in favour of the positional NAME argument.
+func deprecateNameFlag(c *cobra.Command) {
+	_ = c.Flags().MarkDeprecated("name", "pass the environment name as an argument instead")
+}
+
+// envNameOrPick returns name, or lets the user pick an environment when it
+// is empty and stdin and stderr are terminals. Otherwise it fails with the
+// usual missing-name error. The picker offers every environment ls lists,
+// stopped and hibernated ones included, and workloads still without a pod.
+func envNameOrPick(ctx context.Context, name string) (string, error) {
+	if name != "" {
+		return name, nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stderr.Fd())) {
+		return "", errNameRequired
+	}
+	envs, err := listEnvs(ctx, flagNamespace)
+	if err != nil {
+		return "", err
+	}
+	seen := map[string]bool{}
+	var items []pickItem
+	for _, env := range envs {
+		if seen[env.Name] {
+			continue
+		}
+		seen[env.Name] = true
+		items = append(items, pickItem{name: env.Name, status: env.Status, age: env.Age})
+	}
+	// Workloads whose pod has not been created yet are not in the listing.
+	opts := metav1.ListOptions{LabelSelector: "app=kdev"}
+	sets, err := kubeClient.AppsV1().StatefulSets(flagNamespace).List(ctx, opts)
+	if err != nil {
+		return "", fmt.Errorf("failed to list StatefulSets: %w", err)
+	}
+	deploys, err := kubeClient.AppsV1().Deployments(flagNamespace).List(ctx, opts)
+	if err != nil {
+		return "", fmt.Errorf("failed to list Deployments: %w", err)
+	}
+	var workloads []metav1.ObjectMeta
+	for _, s := range sets.Items {
+		workloads = append(workloads, s.ObjectMeta)
+	}
+	for _, d := range deploys.Items {
+		workloads = append(workloads, d.ObjectMeta)
+	}
+	for _, w := range workloads {
+		if seen[w.Name] {
+			continue
+		}
+		seen[w.Name] = true
+		items = append(items, pickItem{name: w.Name, status: string(corev1.PodPending), age: age(w.CreationTimestamp.Time)})
+	}
+	if len(items) == 0 {
+		return "", fmt.Errorf("no kdev environments in namespace %s", flagNamespace)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].name < items[j].name })
+	return runPicker(items)
+}
+
+// fuzzyScore matches query as a case-insensitive subsequence of s. Higher
+// scores mean tighter matches: consecutive runs and word starts count extra.
+func fuzzyScore(query, s string) (int, bool) {
+	if query == "" {
+		return 0, true
+	}
+	q := []rune(strings.ToLower(query))
+	score, qi, prev := 0, 0, -2
+	for i, r := range strings.ToLower(s) {
+		if qi == len(q) {
+			break
+		}
+		if r != q[qi] {
+			continue
+		}
+		score++
+		if i == prev+1 {
+			score += 2
+		}
+		if i == 0 || !unicode.IsLetter(rune(s[i-1])) {
+			score += 3
+		}
+		prev = i
+		qi++
+	}
+	return score, qi == len(q)
+}
+
+func filterItems(items []pickItem, query string) []pickItem {
+	type scored struct {
+		item  pickItem
+		score int
+	}
+	var matches []scored
+	for _, it := range items {
+		if score, ok := fuzzyScore(query, it.name); ok {
+			matches = append(matches, scored{it, score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+	out := make([]pickItem, len(matches))
+	for i, m := range matches {
+		out[i] = m.item
+	}
+	return out
+}
+
+// runPicker shows items on stderr and lets the user filter them by typing,
+// move with the arrow keys (or Ctrl-P/Ctrl-N) and choose with Enter.
+func runPicker(items []pickItem) (string, error) {
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return "", fmt.Errorf("failed to read from terminal: %w", err)
+	}
+	defer term.Restore(fd, state)
+
+	query, cursor, drawn := "", 0, 0
+	width := 0
+	for _, it := range items {
+		width = max(width, len(it.name))
+	}
+	draw := func(matches []pickItem) {
+		if drawn > 0 {
+			fmt.Fprintf(os.Stderr, "\x1b[%dA", drawn)
+		}
+		fmt.Fprintf(os.Stderr, "\r\x1b[J")
+		start := max(0, cursor-pickerRows+1)
+		end := min(len(matches), start+pickerRows)
+		for i := start; i < end; i++ {
+			marker := "  "
+			if i == cursor {
+				marker = "> "
+			}
+			fmt.Fprintf(os.Stderr, "%s%-*s  %-18s %s\r\n", marker, width, matches[i].name, matches[i].status, matches[i].age)
+		}
+		fmt.Fprintf(os.Stderr, "  %d/%d\r\nPick environment: %s", len(matches), len(items), query)
+		drawn = end - start + 1
+	}
+	clear := func() {
+		fmt.Fprintf(os.Stderr, "\x1b[%dA\r\x1b[J", drawn)
+	}
+
+	buf := make([]byte, 16)
+	for {
+		matches := filterItems(items, query)
+		cursor = min(cursor, max(0, len(matches)-1))
+		draw(matches)
+
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			clear()
+			return "", err
+		}
+		switch in := string(buf[:n]); in {
+		case "\r", "\n":
+			clear()
+			if len(matches) == 0 {
+				return "", errPickCancelled
+			}
+			return matches[cursor].name, nil
+		case "\x03", "\x1b", "\x04":
+			clear()
+			return "", errPickCancelled
+		case "\x1b[A", "\x10":
+			cursor = max(0, cursor-1)
+		case "\x1b[B", "\x0e":
+			cursor = min(len(matches)-1, cursor+1)
+		case "\x7f", "\b":
+			if r := []rune(query); len(r) > 0 {
+				query = string(r[:len(r)-1])
+			}
+			cursor = 0
+		default:
+			if strings.HasPrefix(in, "\x1b") {
+				continue
+			}
+			for _, r := range in {
+				if unicode.IsPrint(r) {
+					query += string(r)
+				}
+			}
+			cursor = 0
+		}
+	}
+}
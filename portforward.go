@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/noopduck/kdev/internal/progress"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// maxForwardBackoff caps the wait between reconnect attempts of
+// port-forward.
+const maxForwardBackoff = 30 * time.Second
+
+func cmdPortForward() *cobra.Command {
+	var addresses []string
+
+	c := &cobra.Command{
+		Use:   "port-forward NAME [LOCAL:]REMOTE...",
+		Short: "Forward local ports to an environment",
+		Long: `Port-forward makes ports of the dev pod reachable on this machine until
+interrupted. Each mapping is REMOTE (same port locally), LOCAL:REMOTE, or
+:REMOTE to pick a free local port. When the connection drops, for example
+because the pod was recreated, kdev reconnects with the same local ports.
+
+  kdev port-forward mydev 3000 8080:80 :5432`,
+		Args:              cobra.MinimumNArgs(2),
+		ValidArgsFunction: completeEnvNameArg,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ports, err := parsePortMappings(args[1:])
+			if err != nil {
+				return err
+			}
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer stop()
+			return forwardEnvPorts(ctx, args[0], addresses, ports, nil)
+		},
+	}
+
+	c.Flags().StringSliceVar(&addresses, "address", []string{"localhost"}, "Local addresses to listen on (comma-separated)")
+	return c
+}
+
+// parsePortMappings validates REMOTE, LOCAL:REMOTE and :REMOTE mappings and
+// returns them in the LOCAL:REMOTE form the forwarder expects, with 0 for a
+// free local port.
+func parsePortMappings(args []string) ([]string, error) {
+	var ports []string
+	for _, arg := range args {
+		local, remote, ok := strings.Cut(arg, ":")
+		if !ok {
+			local, remote = arg, arg
+		}
+		if local == "" {
+			local = "0"
+		}
+		for _, p := range []string{local, remote} {
+			n, err := strconv.ParseUint(p, 10, 16)
+			if err != nil || (n == 0 && p == remote) {
+				return nil, fmt.Errorf("invalid port mapping %q: expected REMOTE, LOCAL:REMOTE or :REMOTE", arg)
+			}
+		}
+		ports = append(ports, local+":"+remote)
+	}
+	return ports, nil
+}
+
+// forwardEnvPorts forwards ports to the pod of environment name until ctx
+// is cancelled. A failure before the first connection is returned; later
+// drops are retried with backoff against whatever pod then backs the
+// environment. The ports are printed once they listen, or passed to report
+// when it is not nil.
+func forwardEnvPorts(ctx context.Context, name string, addresses, ports []string, report func([]portforward.ForwardedPort)) error {
+	connected := false
+	backoff := time.Second
+	for {
+		pod, err := readyEnvPod(ctx, name)
+		if err == nil {
+			var ready bool
+			ready, err = forwardOnce(ctx, pod, addresses, ports, func(fwd []portforward.ForwardedPort) {
+				if !connected && report != nil {
+					report(fwd)
+				} else if !connected {
+					for _, addr := range addresses {
+						for _, p := range fwd {
+							fmt.Printf("Forwarding %s:%d -> %s:%d\n", addr, p.Local, name, p.Remote)
+						}
+					}
+				} else {
+					progress.Infof("Reconnected to pod %s", pod)
+				}
+				// Keep the chosen local ports across reconnects.
+				for i, p := range fwd {
+					ports[i] = fmt.Sprintf("%d:%d", p.Local, p.Remote)
+				}
+			})
+			if ready {
+				connected = true
+				backoff = time.Second
+			}
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if !connected {
+			return err
+		}
+
+		progress.Warnf("port forward to %s lost: %v; reconnecting in %s", name, err, backoff)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxForwardBackoff)
+	}
+}
+
+// forwardOnce runs a single port-forward session to pod, calling onReady
+// with the listening ports once they are open. It returns when the
+// connection ends or ctx is cancelled, and reports whether the session got
+// as far as listening.
+func forwardOnce(ctx context.Context, pod string, addresses, ports []string, onReady func([]portforward.ForwardedPort)) (bool, error) {
+	transport, upgrader, err := spdy.RoundTripperFor(kubeRestConfig)
+	if err != nil {
+		return false, fmt.Errorf("failed to create round tripper: %w", err)
+	}
+	url := kubeClient.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(flagNamespace).
+		Name(pod).
+		SubResource("portforward").
+		URL()
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, url)
+
+	stop, ready := make(chan struct{}), make(chan struct{})
+	pf, err := portforward.NewOnAddresses(dialer, addresses, ports, stop, ready, io.Discard, os.Stderr)
+	if err != nil {
+		return false, fmt.Errorf("failed to set up port forward: %w", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- pf.ForwardPorts() }()
+
+	select {
+	case err := <-done:
+		return false, err
+	case <-ctx.Done():
+		close(stop)
+		<-done
+		return false, nil
+	case <-ready:
+	}
+	fwd, err := pf.GetPorts()
+	if err == nil {
+		onReady(fwd)
+	}
+
+	select {
+	case err := <-done:
+		if err == nil {
+			err = portforward.ErrLostConnectionToPod
+		}
+		return true, err
+	case <-ctx.Done():
+		close(stop)
+		<-done
+		return true, nil
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/noopduck/kdev/internal/devcontainer"
+	"github.com/noopduck/kdev/internal/progress"
+	"github.com/spf13/cobra"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+// prebuildLabel is the buildLabel value of prebuild CronJobs.
+const prebuildLabel = "prebuild"
+
+func cmdPrebuild() *cobra.Command {
+	var (
+		image     string
+		registry  string
+		tag       string
+		branch    string
+		schedule  string
+		timeZone  string
+		gitSecret string
+		now       bool
+		remove    bool
+	)
+
+	c := &cobra.Command{
+		Use:   "prebuild",
+		Short: "Rebuild and push the devcontainer image on a schedule with a CronJob",
+		Long: `Prebuild installs a CronJob that builds the Dockerfile of
+.devcontainer/devcontainer.json with Kaniko, as kdev devcontainer build
+--in-cluster kaniko does, and pushes the image, nightly by default. The
+CronJob clones the branch from the origin remote of the checkout, so each
+run builds its latest commit and kdev up pulls a warm, current image.
+Running it again updates the CronJob.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			dc, err := devcontainer.Load(devcontainer.DefaultPath)
+			if err != nil {
+				return err
+			}
+			name := prebuildName()
+			cronJobs := kubeClient.BatchV1().CronJobs(flagNamespace)
+
+			if remove {
+				err := cronJobs.Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: ptr.To(metav1.DeletePropagationBackground)})
+				if apierrors.IsNotFound(err) {
+					return fmt.Errorf("no prebuild %s in namespace %s", name, flagNamespace)
+				}
+				if err != nil {
+					return fmt.Errorf("failed to delete CronJob: %w", err)
+				}
+				fmt.Printf("Prebuild %s deleted\n", name)
+				return nil
+			}
+
+			src, err := devcontainer.GitBuildOf(ctx, dc, branch)
+			if err != nil {
+				return err
+			}
+			imageName, err := devcontainer.ImageName(ctx, dc, image, cmp.Or(registry, userConfig.Registry), cmp.Or(tag, src.Tag()))
+			if err != nil {
+				return err
+			}
+			auth, err := pushDockerConfig(imageName)
+			if err != nil {
+				return err
+			}
+			cj, err := prebuildCronJob(name, imageName, src, schedule, timeZone, gitSecret, auth != nil)
+			if err != nil {
+				return err
+			}
+
+			live, err := cronJobs.Get(ctx, name, metav1.GetOptions{})
+			switch {
+			case apierrors.IsNotFound(err):
+				if live, err = cronJobs.Create(ctx, cj, metav1.CreateOptions{}); err != nil {
+					return fmt.Errorf("failed to create CronJob: %w", err)
+				}
+			case err != nil:
+				return fmt.Errorf("failed to get CronJob: %w", err)
+			case live.Labels[buildLabel] != prebuildLabel:
+				return fmt.Errorf("CronJob %s exists and is no kdev prebuild", name)
+			default:
+				live.Labels, live.Spec = cj.Labels, cj.Spec
+				if live, err = cronJobs.Update(ctx, live, metav1.UpdateOptions{}); err != nil {
+					return fmt.Errorf("failed to update CronJob: %w", err)
+				}
+			}
+			if auth != nil {
+				if err := applyPrebuildSecret(ctx, live, auth); err != nil {
+					return err
+				}
+			}
+			fmt.Printf("Prebuild %s builds %s of %s into %s on schedule %q\n", name, src.Branch, src.Repository, imageName, schedule)
+
+			if now {
+				job, err := startPrebuild(ctx, live)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Started job %s; follow it with: kubectl logs -f -n %s job/%s\n", job.Name, flagNamespace, job.Name)
+			}
+			return nil
+		},
+	}
+
+	c.Flags().StringVar(&image, "image", "", "Image name (can include registry and tag)")
+	c.Flags().StringVar(&registry, "registry", "", "Container registry to push to when --image is not set (default: registry in the user config)")
+	c.Flags().StringVar(&tag, "tag", "", "Image tag (default: the branch, e.g. main)")
+	c.Flags().StringVar(&branch, "branch", "", "Branch of the origin remote to build (default: the one checked out)")
+	c.Flags().StringVar(&schedule, "schedule", "0 3 * * *", "When to build, in cron format")
+	c.Flags().StringVar(&timeZone, "time-zone", "", "Time zone of the schedule, e.g. Europe/Oslo (default: the cluster's, usually UTC)")
+	c.Flags().StringVar(&gitSecret, "git-secret", "", "Secret with username and password keys to clone a private repository with")
+	c.Flags().BoolVar(&now, "now", false, "Also start a build right away")
+	c.Flags().BoolVar(&remove, "delete", false, "Delete the prebuild of this devcontainer")
+	c.MarkFlagsMutuallyExclusive("delete", "now")
+	return c
+}
+
+// prebuildName names the CronJob of the devcontainer; CronJob names have at
+// most 52 characters.
+func prebuildName() string {
+	name := "kdev-prebuild"
+	if repo := devcontainer.DefaultRepository(); repo != "" {
+		name += "-" + strings.ReplaceAll(repo, "/", "-")
+	}
+	if len(name) > 52 {
+		name = name[:52]
+	}
+	return strings.Trim(strings.ReplaceAll(name, "_", "-"), "-.")
+}
+
+// prebuildCronJob runs Kaniko on the git context of src, pushing to
+// imageName. With auth the push credentials come from the Secret named
+// like the CronJob.
+func prebuildCronJob(name, imageName string, src *devcontainer.GitBuild, schedule, timeZone, gitSecret string, auth bool) (*batchv1.CronJob, error) {
+	args := []string{
+		"--context=git://" + src.Repository + "#refs/heads/" + src.Branch,
+		"--dockerfile=" + src.Dockerfile,
+		"--destination=" + imageName,
+		// Nightly builds pick up new packages, which a layer cache hides.
+		"--cache=false",
+	}
+	if src.ContextDir != "" {
+		args = append(args, "--context-sub-path="+src.ContextDir)
+	}
+	for _, k := range sortedKeys(src.Args) {
+		args = append(args, "--build-arg="+k+"="+src.Args[k])
+	}
+	for _, k := range sortedKeys(src.Labels) {
+		args = append(args, "--label="+k+"="+src.Labels[k])
+	}
+
+	kaniko := corev1.Container{Name: kanikoContainer, Image: kanikoImage, Args: args}
+	if gitSecret != "" {
+		secretEnv := func(name, key string) corev1.EnvVar {
+			return corev1.EnvVar{Name: name, ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: gitSecret},
+				Key:                  key,
+			}}}
+		}
+		kaniko.Env = []corev1.EnvVar{
+			secretEnv("GIT_USERNAME", corev1.BasicAuthUsernameKey),
+			secretEnv("GIT_PASSWORD", corev1.BasicAuthPasswordKey),
+		}
+	}
+	spec := corev1.PodSpec{
+		RestartPolicy:                corev1.RestartPolicyNever,
+		AutomountServiceAccountToken: ptr.To(false),
+		Containers:                   []corev1.Container{kaniko},
+	}
+	if auth {
+		spec.Volumes = []corev1.Volume{{Name: "docker-config", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{
+			SecretName: name,
+			Items:      []corev1.KeyToPath{{Key: corev1.DockerConfigJsonKey, Path: "config.json"}},
+		}}}}
+		spec.Containers[0].VolumeMounts = []corev1.VolumeMount{{Name: "docker-config", MountPath: "/kaniko/.docker", ReadOnly: true}}
+	}
+	if err := resolvePodImages(&spec); err != nil {
+		return nil, err
+	}
+
+	// The pod is not labeled app=kdev: it is no environment.
+	labels := map[string]string{"kdev/owner": currentOwner(), buildLabel: prebuildLabel}
+	cronLabels := map[string]string{"app": "kdev"}
+	maps.Copy(cronLabels, labels)
+	cj := &batchv1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: flagNamespace, Labels: cronLabels},
+		Spec: batchv1.CronJobSpec{
+			Schedule:                   schedule,
+			ConcurrencyPolicy:          batchv1.ForbidConcurrent,
+			SuccessfulJobsHistoryLimit: ptr.To[int32](1),
+			FailedJobsHistoryLimit:     ptr.To[int32](1),
+			JobTemplate: batchv1.JobTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: batchv1.JobSpec{
+					BackoffLimit:          ptr.To[int32](1),
+					ActiveDeadlineSeconds: ptr.To(int64(inClusterBuildTimeout.Seconds())),
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{Labels: labels},
+						Spec:       spec,
+					},
+				},
+			},
+		},
+	}
+	if timeZone != "" {
+		cj.Spec.TimeZone = &timeZone
+	}
+	return cj, nil
+}
+
+// applyPrebuildSecret stores the push credentials of cj in the Secret it
+// mounts, owned by cj so it goes with it.
+func applyPrebuildSecret(ctx context.Context, cj *batchv1.CronJob, auth []byte) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            cj.Name,
+			Namespace:       cj.Namespace,
+			Labels:          map[string]string{"app": "kdev", "kdev/owner": currentOwner()},
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "batch/v1", Kind: "CronJob", Name: cj.Name, UID: cj.UID}},
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{corev1.DockerConfigJsonKey: auth},
+	}
+	secrets := kubeClient.CoreV1().Secrets(cj.Namespace)
+	_, err := secrets.Create(ctx, secret, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		live, gerr := secrets.Get(ctx, secret.Name, metav1.GetOptions{})
+		if gerr != nil {
+			return fmt.Errorf("failed to get push secret: %w", gerr)
+		}
+		if live.Type != corev1.SecretTypeDockerConfigJson {
+			return fmt.Errorf("secret %s exists and holds no docker config", secret.Name)
+		}
+		live.Data, live.OwnerReferences = secret.Data, secret.OwnerReferences
+		_, err = secrets.Update(ctx, live, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store push secret: %w", err)
+	}
+	return nil
+}
+
+// startPrebuild runs cj once now, as kubectl create job --from does.
+func startPrebuild(ctx context.Context, cj *batchv1.CronJob) (*batchv1.Job, error) {
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName:    cj.Name + "-",
+			Namespace:       cj.Namespace,
+			Labels:          cj.Spec.JobTemplate.Labels,
+			Annotations:     map[string]string{"cronjob.kubernetes.io/instantiate": "manual"},
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "batch/v1", Kind: "CronJob", Name: cj.Name, UID: cj.UID, Controller: ptr.To(true)}},
+		},
+		Spec: cj.Spec.JobTemplate.Spec,
+	}
+	job, err := kubeClient.BatchV1().Jobs(cj.Namespace).Create(ctx, job, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start prebuild: %w", err)
+	}
+	progress.Infof("Job %s may take a while to clone and build; it gives up after %s", job.Name, inClusterBuildTimeout)
+	return job, nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/noopduck/kdev/internal/progress"
+	"github.com/noopduck/kdev/internal/registry"
+	"github.com/spf13/cobra"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	// prepullName is the DaemonSet keeping dev images on the nodes; there
+	// is one per namespace.
+	prepullName = "kdev-prepull"
+	// prepullLabel marks its objects, which are no environments.
+	prepullLabel = "kdev/prepull"
+)
+
+func cmdPrepull() *cobra.Command {
+	var (
+		nodeSel     []string
+		pullSecrets []string
+		remove      bool
+		noWait      bool
+		timeout     time.Duration
+	)
+
+	c := &cobra.Command{
+		Use:   "prepull [IMAGE...]",
+		Short: "Keep dev images pulled on the nodes with a DaemonSet so environments start fast",
+		Long: `Prepull creates or updates the kdev-prepull DaemonSet, whose pods pull the
+given images on every selected node and keep them in use, so the kubelet
+neither has to pull them when an environment starts nor garbage collects
+them. Without images it pulls those of the environments in the namespace.
+Images are pinned by digest, so run it again after pushing a new image.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			daemonSets := kubeClient.AppsV1().DaemonSets(flagNamespace)
+
+			if remove {
+				err := daemonSets.Delete(ctx, prepullName, metav1.DeleteOptions{PropagationPolicy: ptr.To(metav1.DeletePropagationBackground)})
+				if apierrors.IsNotFound(err) {
+					return fmt.Errorf("nothing is prepulled in namespace %s", flagNamespace)
+				}
+				if err != nil {
+					return fmt.Errorf("failed to delete DaemonSet: %w", err)
+				}
+				fmt.Printf("DaemonSet %s deleted; the nodes may now garbage collect its images\n", prepullName)
+				return nil
+			}
+
+			selector, err := parseKeyValues("node", nodeSel)
+			if err != nil {
+				return err
+			}
+			images, err := prepullImages(ctx, args)
+			if err != nil {
+				return err
+			}
+			ds, err := prepullDaemonSet(images, selector, pullSecrets)
+			if err != nil {
+				return err
+			}
+
+			live, err := daemonSets.Get(ctx, prepullName, metav1.GetOptions{})
+			switch {
+			case apierrors.IsNotFound(err):
+				if _, err := daemonSets.Create(ctx, ds, metav1.CreateOptions{}); err != nil {
+					return fmt.Errorf("failed to create DaemonSet: %w", err)
+				}
+			case err != nil:
+				return fmt.Errorf("failed to get DaemonSet: %w", err)
+			case live.Labels[prepullLabel] != "true":
+				return fmt.Errorf("DaemonSet %s exists and was not created by kdev", prepullName)
+			default:
+				live.Labels, live.Spec = ds.Labels, ds.Spec
+				if _, err := daemonSets.Update(ctx, live, metav1.UpdateOptions{}); err != nil {
+					return fmt.Errorf("failed to update DaemonSet: %w", err)
+				}
+			}
+			for _, img := range images {
+				progress.Infof("Prepulling %s", img)
+			}
+			if noWait {
+				fmt.Printf("DaemonSet %s is pulling %d images\n", prepullName, len(images))
+				return nil
+			}
+
+			sp := progress.Start(fmt.Sprintf("Pulling %d images on the nodes", len(images)))
+			nodes, err := waitForDaemonSet(ctx, flagNamespace, prepullName, timeout)
+			sp.Done(err)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%d images are pulled on %d nodes\n", len(images), nodes)
+			return nil
+		},
+	}
+
+	c.Flags().StringSliceVar(&nodeSel, "node", nil, "Only prepull on nodes with this label key=value (repeatable)")
+	c.Flags().StringSliceVar(&pullSecrets, "image-pull-secret", nil, "Secret used to pull the images (repeatable)")
+	c.Flags().BoolVar(&remove, "delete", false, "Delete the DaemonSet, letting the nodes garbage collect the images")
+	c.Flags().BoolVar(&noWait, "no-wait", false, "Do not wait for the nodes to pull the images")
+	c.Flags().DurationVar(&timeout, "timeout", 30*time.Minute, "How long to wait for the nodes to pull the images")
+	return c
+}
+
+// prepullImages pins images by digest. Without images it returns those the
+// dev containers of the environments in the namespace run, as the nodes
+// resolved them.
+func prepullImages(ctx context.Context, images []string) ([]string, error) {
+	var pinned []string
+	if len(images) == 0 {
+		pods, err := kubeClient.CoreV1().Pods(flagNamespace).List(ctx, metav1.ListOptions{LabelSelector: "app=kdev"})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list environments: %w", err)
+		}
+		for _, pod := range pods.Items {
+			if img := runningImage(&pod); img != "" {
+				pinned = append(pinned, img)
+			}
+		}
+		if len(pinned) == 0 {
+			return nil, fmt.Errorf("no environments in namespace %s; name the images to prepull", flagNamespace)
+		}
+	}
+	for _, img := range images {
+		host, _ := registry.Split(img)
+		if flagOffline && registry.IsPublicHost(host) {
+			pinned = append(pinned, img)
+			continue
+		}
+		p, err := pinImage(ctx, img)
+		if err != nil {
+			progress.Warnf("%v; prepulling %s by tag", err, img)
+			p = img
+		}
+		pinned = append(pinned, p)
+	}
+	slices.Sort(pinned)
+	return slices.Compact(pinned), nil
+}
+
+// runningImage returns the image of the dev container of pod by digest when
+// the kubelet has reported it, else as specified.
+func runningImage(pod *corev1.Pod) string {
+	c := findContainer(pod, devContainer)
+	if c == nil {
+		return ""
+	}
+	if strings.Contains(c.Image, "@") {
+		return c.Image
+	}
+	for _, s := range pod.Status.ContainerStatuses {
+		if s.Name != devContainer {
+			continue
+		}
+		// ImageID is repo@digest, with docker-pullable:// on older runtimes.
+		if _, digest, ok := strings.Cut(s.ImageID, "@"); ok {
+			repo := c.Image
+			if i := strings.LastIndex(repo, ":"); i > strings.LastIndex(repo, "/") {
+				repo = repo[:i]
+			}
+			return repo + "@" + digest
+		}
+	}
+	return c.Image
+}
+
+// prepullDaemonSet pulls each image in an init container that exits right
+// away, using the busybox of the pause init container as images may have
+// no shell, and then keeps a tiny busybox container running.
+func prepullDaemonSet(images []string, nodeSelector map[string]string, pullSecrets []string) (*appsv1.DaemonSet, error) {
+	if len(images) == 0 {
+		return nil, errors.New("no images to prepull")
+	}
+	main := corev1.Container{
+		Name:    "pause",
+		Image:   pauseImage,
+		Command: []string{"sh", "-c", sleepScript},
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1m"), corev1.ResourceMemory: resource.MustParse("4Mi")},
+			Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("16Mi")},
+		},
+	}
+	pod := &corev1.Pod{Spec: corev1.PodSpec{
+		AutomountServiceAccountToken:  ptr.To(false),
+		TerminationGracePeriodSeconds: ptr.To[int64](1),
+		NodeSelector:                  nodeSelector,
+		Containers:                    []corev1.Container{main},
+	}}
+	addPause(pod, &corev1.Container{})
+	mount := corev1.VolumeMount{Name: pauseVolume, MountPath: pauseDir, ReadOnly: true}
+	for i, img := range images {
+		pod.Spec.InitContainers = append(pod.Spec.InitContainers, corev1.Container{
+			Name:            fmt.Sprintf("pull-%d", i),
+			Image:           img,
+			ImagePullPolicy: corev1.PullIfNotPresent,
+			Command:         []string{pauseDir + "/busybox", "true"},
+			Resources:       main.Resources,
+			VolumeMounts:    []corev1.VolumeMount{mount},
+		})
+	}
+	for _, secret := range pullSecrets {
+		pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, corev1.LocalObjectReference{Name: secret})
+	}
+	if err := resolvePodImages(&pod.Spec); err != nil {
+		return nil, err
+	}
+
+	// The pods are not labeled app=kdev: they are no environments.
+	labels := map[string]string{prepullLabel: "true"}
+	return &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      prepullName,
+			Namespace: flagNamespace,
+			Labels:    map[string]string{"app": "kdev", prepullLabel: "true", "kdev/owner": currentOwner()},
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec:       pod.Spec,
+			},
+			UpdateStrategy: appsv1.DaemonSetUpdateStrategy{
+				Type: appsv1.RollingUpdateDaemonSetStrategyType,
+				// Pulling is the point; let every node pull at once.
+				RollingUpdate: &appsv1.RollingUpdateDaemonSet{MaxUnavailable: ptr.To(intstr.FromString("100%"))},
+			},
+		},
+	}, nil
+}
+
+// waitForDaemonSet waits until every node of the DaemonSet runs its
+// current pod and returns how many nodes that is.
+func waitForDaemonSet(ctx context.Context, namespace, name string, timeout time.Duration) (int32, error) {
+	var nodes int32
+	err := wait.PollUntilContextTimeout(ctx, 2*time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		ds, err := kubeClient.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Errorf("failed to get DaemonSet: %w", err)
+		}
+		s := ds.Status
+		nodes = s.DesiredNumberScheduled
+		return s.ObservedGeneration >= ds.Generation && s.UpdatedNumberScheduled == nodes && s.NumberReady == nodes, nil
+	})
+	if wait.Interrupted(err) {
+		return nodes, fmt.Errorf("timed out after %s waiting for the nodes to pull the images; see kubectl get pods -n %s -l %s=true", timeout, namespace, prepullLabel)
+	}
+	return nodes, err
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"slices"
+
+	"github.com/noopduck/kdev/internal/config"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Probe defaults. The check only proves the container still accepts exec
+// sessions, which is what attach relies on.
+var defaultProbeCommand = []string{"sh", "-c", "true"}
+
+// busyboxProbeCommand is the probe of dev containers whose image may have
+// no shell: those with the pause or entrypoint keepalive.
+var busyboxProbeCommand = []string{pauseDir + "/busybox", "true"}
+
+const (
+	defaultProbePeriod    = 30
+	defaultProbeTimeout   = 5
+	defaultProbeFailures  = 3
+	defaultStartupSeconds = 300
+	startupProbePeriod    = 5
+)
+
+// applyProbes adds the startup and liveness probes configured by p (nil
+// means defaults) to the dev container. A template merged afterwards can
+// still replace them.
+func applyProbes(pod *corev1.Pod, p *config.Probes) {
+	if p == nil {
+		p = &config.Probes{}
+	}
+	c := findContainer(pod, devContainer)
+	if c == nil || p.Disabled {
+		return
+	}
+	command := p.Command
+	if len(command) == 0 {
+		command = probeCommand(pod)
+	}
+	handler := corev1.ProbeHandler{Exec: &corev1.ExecAction{Command: command}}
+	timeout := orDefault(p.TimeoutSeconds, defaultProbeTimeout)
+
+	c.LivenessProbe = &corev1.Probe{
+		ProbeHandler:     handler,
+		PeriodSeconds:    orDefault(p.PeriodSeconds, defaultProbePeriod),
+		TimeoutSeconds:   timeout,
+		FailureThreshold: orDefault(p.FailureThreshold, defaultProbeFailures),
+	}
+	// The startup probe holds off liveness checks while a slow image starts.
+	startup := orDefault(p.StartupSeconds, defaultStartupSeconds)
+	c.StartupProbe = &corev1.Probe{
+		ProbeHandler:     handler,
+		PeriodSeconds:    startupProbePeriod,
+		TimeoutSeconds:   timeout,
+		FailureThreshold: max(startup/startupProbePeriod, 1),
+	}
+	// A failed probe only helps if the kubelet restarts the container.
+	pod.Spec.RestartPolicy = corev1.RestartPolicyAlways
+}
+
+// probeCommand returns the default probe command for pod: the busybox of
+// the pause init container when there is one, as the image may lack sh.
+func probeCommand(pod *corev1.Pod) []string {
+	if slices.ContainsFunc(pod.Spec.InitContainers, func(ic corev1.Container) bool { return ic.Name == pauseInitContainer }) {
+		return busyboxProbeCommand
+	}
+	return defaultProbeCommand
+}
+
+// syncProbeCommand switches probes of c that run a default command to the
+// one matching the current keepalive, so changing it never leaves a probe
+// that cannot run. Configured commands are kept.
+func syncProbeCommand(pod *corev1.Pod, c *corev1.Container) {
+	want := probeCommand(pod)
+	for _, probe := range []*corev1.Probe{c.LivenessProbe, c.StartupProbe} {
+		if probe == nil || probe.Exec == nil {
+			continue
+		}
+		if slices.Equal(probe.Exec.Command, defaultProbeCommand) || slices.Equal(probe.Exec.Command, busyboxProbeCommand) {
+			probe.Exec.Command = want
+		}
+	}
+}
+
+func orDefault(v, def int32) int32 {
+	if v > 0 {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/noopduck/kdev/internal/spec"
+	"github.com/spf13/cobra"
+)
+
+// applyProfile layers the user config profile name under the explicitly
+// set fields of user, so flags still win over it. It returns the fields
+// the profile set.
+func applyProfile(user *spec.Spec, name string) ([]string, error) {
+	var profiles map[string]spec.Spec
+	if userConfig != nil {
+		profiles = userConfig.Profiles
+	}
+	p, ok := profiles[name]
+	if !ok {
+		if len(profiles) == 0 {
+			return nil, fmt.Errorf("unknown --profile %q: the user config defines no profiles", name)
+		}
+		return nil, fmt.Errorf("unknown --profile %q (available: %s)", name, strings.Join(slices.Sorted(maps.Keys(profiles)), ", "))
+	}
+	set := user.SetFields()
+	var fields []string
+	for _, f := range p.SetFields() {
+		if !slices.Contains(set, f) {
+			fields = append(fields, f)
+		}
+	}
+	var merged spec.Spec
+	merged.Merge(p)
+	merged.Merge(*user)
+	*user = merged
+	return fields, nil
+}
+
+// completeProfiles completes --profile with the profiles of the user config.
+func completeProfiles(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if userConfig == nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return slices.Sorted(maps.Keys(userConfig.Profiles)), cobra.ShellCompDirectiveNoFileComp
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/noopduck/kdev/internal/config"
+	"github.com/noopduck/kdev/internal/devcontainer"
+	"github.com/noopduck/kdev/internal/progress"
+	"github.com/noopduck/kdev/internal/spec"
+	"sigs.k8s.io/yaml"
+)
+
+// project is the kdev.yaml of a repository: the team-standard environment
+// kdev up creates without flags. Its spec sits between the user config and
+// the template; flags override it field by field.
+type project struct {
+	spec.Spec
+	// Size is a --size preset; cpu, memory and storageSize override it.
+	Size string `json:"size,omitempty"`
+	// Volumes are mounted into the dev container like devcontainer.json
+	// mounts: named volumes become shared PVCs.
+	Volumes []devcontainer.Mount `json:"volumes,omitempty"`
+	// Ports are forwarded by kdev attach, like forwardPorts: port numbers
+	// or "host:port" strings.
+	Ports []interface{} `json:"ports,omitempty"`
+}
+
+// findProjectFile looks for kdev.yaml in dir and its parents, up to the
+// root of the git checkout. It returns "" when there is none.
+func findProjectFile(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		path := filepath.Join(dir, projectFile)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return "", nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// findAndLoadProject loads the kdev.yaml of the project in the current
+// directory, or returns an empty project when there is none. Its size
+// preset is resolved against the cluster's, or without a cluster against
+// the built-in and user config presets.
+func findAndLoadProject(ctx context.Context) (*project, error) {
+	path, err := findProjectFile(".")
+	if err != nil || path == "" {
+		return &project{}, err
+	}
+	p, err := loadProject(path)
+	if err != nil {
+		return nil, err
+	}
+	if p.Size != "" {
+		var shared *config.Shared
+		if kubeClient != nil {
+			if shared, _, err = loadSharedConfig(ctx, flagNamespace); err != nil {
+				return nil, err
+			}
+		}
+		if err := applySize(&p.Spec, p.Size, availableSizes(shared)); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", path, err)
+		}
+	}
+	progress.Infof("Using %s", path)
+	return p, nil
+}
+
+// loadProject reads the kdev.yaml at path.
+func loadProject(path string) (*project, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	var p project
+	if err := yaml.UnmarshalStrict(raw, &p); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	if _, err := p.forwardPorts(); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	return &p, nil
+}
+
+// forwardPorts resolves Ports the way devcontainer.json forwardPorts are.
+func (p *project) forwardPorts() ([]devcontainer.ForwardPort, error) {
+	return (&devcontainer.DevContainerConfig{ForwardPorts: p.Ports}).Ports()
+}
+
+// applyProject adds the volumes and ports of p to m, after those of a
+// devcontainer.json.
+func applyProject(m *manifests, p *project) error {
+	if len(p.Volumes) > 0 {
+		if err := applyMounts(m, devContainer, p.Volumes, false); err != nil {
+			return err
+		}
+	}
+	ports, err := podForwardPorts(m.Pod)
+	if err != nil {
+		return err
+	}
+	extra, err := p.forwardPorts()
+	if err != nil {
+		return err
+	}
+	for _, fp := range extra {
+		if !slices.ContainsFunc(ports, func(o devcontainer.ForwardPort) bool { return o.Host == fp.Host && o.Port == fp.Port }) {
+			ports = append(ports, fp)
+		}
+	}
+	return setForwardPorts(m.Pod, ports)
+}
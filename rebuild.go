@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/noopduck/kdev/internal/devcontainer"
+	"github.com/noopduck/kdev/internal/progress"
+	"github.com/noopduck/kdev/internal/registry"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/yaml"
+)
+
+func cmdRebuild() *cobra.Command {
+	var (
+		build   imageFlags
+		yes     bool
+		timeout time.Duration
+	)
+
+	c := &cobra.Command{
+		Use:   "rebuild NAME",
+		Short: "Rebuild the devcontainer image, push it and replace the environment's pod",
+		Long: `Rebuild builds and pushes the image of .devcontainer/devcontainer.json like
+kdev devcontainer build --push, then rolls it into environment NAME. Only
+the dev container image changes: the pod is recreated with the same labels,
+env and volumes, and the workspace PVC is kept. The image is pinned by
+digest so a rebuilt tag is always pulled again.`,
+		ValidArgsFunction: completeEnvNameArg,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, err := nameArg(args, "")
+			if err != nil {
+				return err
+			}
+			if name == "" {
+				return errNameRequired
+			}
+			ctx := cmd.Context()
+
+			pod, err := resolvePod(ctx, flagNamespace, name)
+			if err != nil {
+				return err
+			}
+			if err := guardManaged("pod", &pod.ObjectMeta); err != nil {
+				return err
+			}
+			name = envName(pod)
+			if findContainer(pod, devContainer) == nil {
+				return fmt.Errorf("pod %s has no %s container", pod.Name, devContainer)
+			}
+
+			dc, err := devcontainer.Load(devcontainer.DefaultPath)
+			if err != nil {
+				return err
+			}
+			imageName, err := build.build(ctx, dc)
+			if err != nil {
+				return err
+			}
+			pinned, err := pinImage(ctx, imageName)
+			if err != nil {
+				return err
+			}
+
+			desired := recreatablePod(pod)
+			findContainer(desired, devContainer).Image = pinned
+			before, err := yaml.Marshal(specFromPod(pod, nil))
+			if err != nil {
+				return err
+			}
+			after, err := yaml.Marshal(specFromPod(desired, nil))
+			if err != nil {
+				return err
+			}
+			if string(before) == string(after) {
+				fmt.Printf("Environment %s already runs %s\n", name, pinned)
+				return nil
+			}
+			printDiff(os.Stdout, string(before), string(after))
+			if !yes {
+				ok, err := confirm("Replace the pod?")
+				if err != nil {
+					return fmt.Errorf("%w; pass --yes to replace without asking", err)
+				}
+				if !ok {
+					fmt.Println("Rebuild pushed, pod left unchanged.")
+					return nil
+				}
+			}
+
+			if controlledByWorkload(pod) {
+				if err := rollWorkloadImage(ctx, name, pinned); err != nil {
+					return err
+				}
+			} else if err := replacePod(ctx, pod, desired, timeout); err != nil {
+				return err
+			}
+
+			sp := progress.Start(fmt.Sprintf("Waiting for environment %s to run the new image", name))
+			live, err := waitForEnvImage(ctx, flagNamespace, name, pinned, timeout)
+			sp.Done(err)
+			if err != nil {
+				return err
+			}
+			if err := runStartHooks(ctx, live); err != nil {
+				return err
+			}
+			fmt.Printf("Environment %s rebuilt with %s\n", name, pinned)
+			fmt.Printf("Attach with: kdev attach %s\n", name)
+			return nil
+		},
+	}
+
+	build.register(c)
+	c.Flags().BoolVarP(&yes, "yes", "y", false, "Replace the pod without asking for confirmation")
+	c.Flags().DurationVar(&timeout, "timeout", 5*time.Minute, "How long to wait for the old pod to terminate and the new one to become ready")
+	return c
+}
+
+// pinImage resolves the tag of imageName to the digest just pushed, so the
+// kubelet pulls the new image even when the tag is reused.
+func pinImage(ctx context.Context, imageName string) (string, error) {
+	host, path := registry.Split(imageName)
+	if strings.Contains(path, "@") {
+		return imageName, nil
+	}
+	repo, tag := path, "latest"
+	if i := strings.LastIndex(path, ":"); i > strings.LastIndex(path, "/") {
+		repo, tag = path[:i], path[i+1:]
+	}
+	client, err := registry.New(host, "kdev/"+buildVersion)
+	if err != nil {
+		return "", err
+	}
+	digest, err := client.Digest(ctx, repo, tag)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve the digest of %s: %w", imageName, err)
+	}
+	return host + "/" + repo + "@" + digest, nil
+}
+
+// replacePod deletes a plain pod environment and creates desired in its
+// place. The workspace PVC is untouched.
+func replacePod(ctx context.Context, pod, desired *corev1.Pod, timeout time.Duration) error {
+	if err := kubeClient.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{}); err != nil {
+		return fmt.Errorf("failed to delete pod: %w", err)
+	}
+	sp := progress.Start(fmt.Sprintf("Recreating pod %s (PVC kept)", pod.Name))
+	err := waitForPodDeleted(ctx, pod.Namespace, pod.Name, timeout)
+	sp.Done(err)
+	if err != nil {
+		return err
+	}
+	if err := createPod(ctx, desired); err != nil {
+		return fmt.Errorf("failed to recreate pod (the workspace is intact; re-run kdev rebuild %s): %w", envName(pod), err)
+	}
+	return nil
+}
+
+// rollWorkloadImage sets the dev container image in the pod template of the
+// StatefulSet or Deployment of environment name, which rolls its pod.
+func rollWorkloadImage(ctx context.Context, name, image string) error {
+	kind, _, err := envWorkload(ctx, flagNamespace, name)
+	if err != nil {
+		return err
+	}
+	patch := fmt.Sprintf(`{"spec":{"template":{"spec":{"containers":[{"name":%q,"image":%q}]}}}}`, devContainer, image)
+	switch kind {
+	case controllerStatefulSet:
+		_, err = kubeClient.AppsV1().StatefulSets(flagNamespace).Patch(ctx, name, types.StrategicMergePatchType, []byte(patch), metav1.PatchOptions{})
+	case controllerDeployment:
+		_, err = kubeClient.AppsV1().Deployments(flagNamespace).Patch(ctx, name, types.StrategicMergePatchType, []byte(patch), metav1.PatchOptions{})
+	default:
+		return fmt.Errorf("environment %s has no StatefulSet or Deployment", name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update %s %s: %w", kind, name, err)
+	}
+	return nil
+}
+
+// waitForEnvImage waits until a ready pod of environment name runs image in
+// its dev container. Unlike waitForEnvPod it skips the pods being replaced.
+func waitForEnvImage(ctx context.Context, namespace, name, image string, timeout time.Duration) (*corev1.Pod, error) {
+	opts := metav1.ListOptions{LabelSelector: labels.Set(envSelector(name)).String()}
+	var found *corev1.Pod
+	err := wait.PollUntilContextTimeout(ctx, 2*time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		list, err := kubeClient.CoreV1().Pods(namespace).List(ctx, opts)
+		if err != nil {
+			return false, fmt.Errorf("failed to list pods of %s: %w", name, err)
+		}
+		for i := range list.Items {
+			pod := &list.Items[i]
+			if c := findContainer(pod, devContainer); c != nil && c.Image == image && podReady(pod) {
+				found = pod
+				return true, nil
+			}
+			if pod.Status.Phase == corev1.PodPending {
+				if err := diagnoseImagePull(ctx, pod); err != nil {
+					return false, err
+				}
+			}
+		}
+		return false, nil
+	})
+	if wait.Interrupted(err) {
+		return nil, fmt.Errorf("timed out after %s waiting for environment %s to run %s", timeout, name, image)
+	}
+	return found, err
+}
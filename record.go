@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/user"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/noopduck/kdev/internal/progress"
+	"github.com/noopduck/kdev/internal/recording"
+	"golang.org/x/term"
+)
+
+// recordingUploadTimeout bounds the upload at the end of a recorded session.
+const recordingUploadTimeout = 30 * time.Second
+
+var unsafeRecordingChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// recordingDir returns where recordings are written.
+func recordingDir() (string, error) {
+	if userConfig.Recording.Dir != "" {
+		return userConfig.Recording.Dir, nil
+	}
+	return recording.DefaultDir()
+}
+
+// startRecording starts recording a session in container of podName when
+// the user config turns recording on; otherwise it returns nil.
+func startRecording(podName, container string, command []string, tty bool) (*recording.Recorder, error) {
+	if userConfig == nil || userConfig.Recording == nil || !userConfig.Recording.Enabled {
+		return nil, nil
+	}
+	dir, err := recordingDir()
+	if err != nil {
+		return nil, err
+	}
+
+	who := "unknown"
+	if u, err := user.Current(); err == nil {
+		who = u.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		who += "@" + host
+	}
+	h := recording.Header{
+		Width:   80,
+		Height:  24,
+		Command: strings.Join(command, " "),
+		Title:   fmt.Sprintf("%s in %s/%s (container %s)", who, flagNamespace, podName, container),
+		Env:     map[string]string{"TERM": os.Getenv("TERM")},
+	}
+	if w, ht, err := term.GetSize(int(os.Stdout.Fd())); tty && err == nil {
+		h.Width, h.Height = w, ht
+	}
+	stamp := time.Now().UTC().Format("20060102T150405.000Z")
+	name := unsafeRecordingChars.ReplaceAllString(flagNamespace+"_"+podName+"_"+stamp, "-")
+	rec, err := recording.Start(dir, name, h)
+	if err != nil {
+		return nil, fmt.Errorf("session recording is enabled but could not be started: %w", err)
+	}
+	return rec, nil
+}
+
+// finishRecording closes rec and, with an S3 bucket configured, uploads it
+// along with any recording an earlier upload left behind. Failures are
+// warnings: the recording stays on disk.
+func finishRecording(rec *recording.Recorder) {
+	if err := rec.Close(); err != nil {
+		progress.Warnf("%v", err)
+	}
+	bucket := userConfig.Recording.S3
+	if bucket == nil {
+		progress.Infof("Session recorded to %s", rec.Path())
+		return
+	}
+	dir, err := recordingDir()
+	if err != nil {
+		progress.Warnf("%v", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), recordingUploadTimeout)
+	defer cancel()
+	if err := recording.Upload(ctx, dir, bucket); err != nil {
+		progress.Warnf("%v; recordings are kept in %s and retried after the next session", err, dir)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// remoteEnvAnnotation stores the devcontainer.json remoteEnv of an
+// environment on its pod. Unlike containerEnv, which becomes the container's
+// environment, remoteEnv only applies to the sessions kdev opens in it.
+const remoteEnvAnnotation = "kdev/remote-env"
+
+var (
+	envVarName       = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	containerEnvRef  = regexp.MustCompile(`\$\{containerEnv:([A-Za-z_][A-Za-z0-9_]*)(?::([^}]*))?\}`)
+	shellDoubleQuote = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`")
+)
+
+// setRemoteEnv records env on pod. A nil value unsets the variable.
+func setRemoteEnv(pod *corev1.Pod, env map[string]*string) error {
+	if len(env) == 0 {
+		return nil
+	}
+	for k := range env {
+		if !envVarName.MatchString(k) {
+			return fmt.Errorf("invalid remoteEnv name %q", k)
+		}
+	}
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[remoteEnvAnnotation] = string(data)
+	return nil
+}
+
+// withRemoteEnv wraps command so it runs with the remoteEnv of pod when it
+// runs in the dev container. ${containerEnv:VAR} references are resolved by
+// the container's shell.
+func withRemoteEnv(pod *corev1.Pod, container string, command []string) []string {
+	data := pod.Annotations[remoteEnvAnnotation]
+	if data == "" || container != devContainer {
+		return command
+	}
+	var env map[string]*string
+	if json.Unmarshal([]byte(data), &env) != nil {
+		return command
+	}
+	names := make([]string, 0, len(env))
+	for k := range env {
+		if envVarName.MatchString(k) {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+
+	var script strings.Builder
+	for _, k := range names {
+		if env[k] == nil {
+			fmt.Fprintf(&script, "unset %s; ", k)
+			continue
+		}
+		fmt.Fprintf(&script, "export %s=\"%s\"; ", k, shellValue(*env[k]))
+	}
+	script.WriteString(`exec "$@"`)
+	return append([]string{"/bin/sh", "-c", script.String(), "kdev"}, command...)
+}
+
+// shellValue renders v for use inside double quotes, turning
+// ${containerEnv:VAR} and ${containerEnv:VAR:default} into shell expansions.
+func shellValue(v string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range containerEnvRef.FindAllStringSubmatchIndex(v, -1) {
+		b.WriteString(shellDoubleQuote.Replace(v[last:m[0]]))
+		name := v[m[2]:m[3]]
+		if m[4] >= 0 {
+			fmt.Fprintf(&b, "${%s:-%s}", name, shellDoubleQuote.Replace(v[m[4]:m[5]]))
+		} else {
+			fmt.Fprintf(&b, "${%s}", name)
+		}
+		last = m[1]
+	}
+	b.WriteString(shellDoubleQuote.Replace(v[last:]))
+	return b.String()
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+	"time"
+
+	"github.com/noopduck/kdev/internal/progress"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/utils/ptr"
+)
+
+func cmdRename() *cobra.Command {
+	var (
+		name     string
+		newName  string
+		clonePVC bool
+		timeout  time.Duration
+	)
+
+	c := &cobra.Command{
+		Use:   "rename NAME NEW_NAME",
+		Short: "Rename a dev environment, keeping its workspace",
+		Long: `Rename recreates the pod under the new name. PVCs cannot be renamed in
+Kubernetes, so by default the existing PVC is relabelled and mounted by the new
+pod. With --clone-pvc a new PVC named after the environment is cloned from the
+old one (requires a CSI driver with volume cloning); the old PVC is kept. The
+clone is checked before the old pod is stopped, and the old pod is restored if
+the new one cannot be created.`,
+		Args:              cobra.MaximumNArgs(2),
+		ValidArgsFunction: completeEnvNameArg,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 2 {
+				if newName != "" && newName != args[1] {
+					return fmt.Errorf("new name given twice: %s and --to %s", args[1], newName)
+				}
+				newName, args = args[1], args[:1]
+			}
+			name, err := nameArg(args, name)
+			if err != nil {
+				return err
+			}
+			if name == "" || newName == "" {
+				return errors.New("the current and the new name are required")
+			}
+			ctx := context.Background()
+			pods := kubeClient.CoreV1().Pods(flagNamespace)
+
+			old, err := pods.Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return fmt.Errorf("failed to get pod: %w", err)
+			}
+			if err := guardManaged("pod", &old.ObjectMeta); err != nil {
+				return err
+			}
+			if _, err := pods.Get(ctx, newName, metav1.GetOptions{}); err == nil {
+				return fmt.Errorf("pod %s already exists", newName)
+			} else if !apierrors.IsNotFound(err) {
+				return fmt.Errorf("failed to check pod %s: %w", newName, err)
+			}
+
+			pod := recreatablePod(old)
+			pod.Name = newName
+			pod.Labels["kdev/name"] = newName
+			renameAnnotations(pod.Annotations, name, newName)
+
+			// Find the environment's PVCs and check that clones can be made
+			// while the old pod still runs, so a rejected clone leaves the
+			// environment as it was.
+			claims := kubeClient.CoreV1().PersistentVolumeClaims(flagNamespace)
+			var relabel []*corev1.PersistentVolumeClaim
+			var clone *corev1.PersistentVolumeClaim
+			for i := range pod.Spec.Volumes {
+				v := &pod.Spec.Volumes[i]
+				if v.PersistentVolumeClaim == nil {
+					continue
+				}
+				claim, err := claims.Get(ctx, v.PersistentVolumeClaim.ClaimName, metav1.GetOptions{})
+				if err != nil {
+					return fmt.Errorf("failed to get PVC: %w", err)
+				}
+				if claim.Labels["kdev/name"] != name {
+					continue
+				}
+				if clonePVC && claim.Name == name {
+					clone = clonedPVC(claim, newName)
+					if err := checkClone(ctx, claim, clone); err != nil {
+						return err
+					}
+					v.PersistentVolumeClaim.ClaimName = clone.Name
+					continue
+				}
+				relabel = append(relabel, claim)
+			}
+
+			// Everything up to stopping the old pod is safe while it runs and
+			// undone if a later step fails. The SSH and web IDE objects are
+			// named after the environment, so they are copied.
+			undo := func() {
+				_ = deleteSSHSecret(ctx, newName)
+				_ = deleteWebIDE(ctx, newName)
+				_ = relabelServices(ctx, newName, name)
+				_ = relabelClaims(ctx, relabel, newName, name)
+			}
+			err = moveSSHSecret(ctx, pod, name, newName)
+			if err == nil {
+				err = moveWebIDE(ctx, pod, name, newName)
+			}
+			if err == nil {
+				err = relabelClaims(ctx, relabel, name, newName)
+			}
+			if err == nil {
+				err = relabelServices(ctx, name, newName)
+			}
+			if err != nil {
+				undo()
+				return err
+			}
+
+			// Stop the old pod so nothing writes to the volume while it is
+			// cloned or mounted by the new pod.
+			if err := pods.Delete(ctx, name, metav1.DeleteOptions{}); err != nil {
+				return fmt.Errorf("failed to delete pod: %w", err)
+			}
+			sp := progress.Start(fmt.Sprintf("Waiting for pod %s to terminate", name))
+			err = waitForPodDeleted(ctx, flagNamespace, name, timeout)
+			sp.Done(err)
+			if err != nil {
+				return err
+			}
+
+			if clone != nil {
+				if _, err := claims.Create(ctx, clone, metav1.CreateOptions{}); err != nil {
+					// Put the old pod back; its PVC is untouched.
+					undo()
+					if rerr := createPod(ctx, recreatablePod(old)); rerr != nil {
+						return fmt.Errorf("failed to clone PVC %s: %w (and failed to restore pod %s: %v)", name, err, name, rerr)
+					}
+					return fmt.Errorf("failed to clone PVC %s (pod %s restored): %w", name, name, err)
+				}
+				progress.Infof("PVC %s cloned to %s (old PVC kept)", name, clone.Name)
+			}
+
+			if err := createPod(ctx, pod); err != nil {
+				if rerr := createPod(ctx, recreatablePod(old)); rerr == nil {
+					undo()
+					return fmt.Errorf("failed to create pod %s (pod %s restored): %w", newName, name, err)
+				}
+				return fmt.Errorf("failed to create pod %s (the workspace is intact; re-run kdev up %s): %w", newName, newName, err)
+			}
+			if err := deleteSSHSecret(ctx, name); err != nil {
+				progress.Warnf("%v", err)
+			}
+			if err := deleteWebIDE(ctx, name); err != nil {
+				progress.Warnf("%v", err)
+			}
+			fmt.Printf("Environment %s renamed to %s in namespace %s\n", name, newName, flagNamespace)
+			return nil
+		},
+	}
+
+	c.Flags().StringVar(&name, "name", "", "Current environment name")
+	c.Flags().StringVar(&newName, "to", "", "New environment name")
+	c.Flags().BoolVar(&clonePVC, "clone-pvc", false, "Clone the workspace PVC to a PVC with the new name instead of reusing it")
+	c.Flags().DurationVar(&timeout, "timeout", 2*time.Minute, "How long to wait for the old pod to terminate")
+	deprecateNameFlag(c)
+	_ = c.Flags().MarkDeprecated("to", "pass the new name as the second argument instead")
+	return c
+}
+
+// recreatablePod copies a live pod's desired state, dropping everything the
+// API server fills in so the copy can be created again. The copy shares no
+// maps with live, so callers may relabel it.
+func recreatablePod(live *corev1.Pod) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        live.Name,
+			Namespace:   live.Namespace,
+			Labels:      maps.Clone(live.Labels),
+			Annotations: maps.Clone(live.Annotations),
+		},
+		Spec: *live.Spec.DeepCopy(),
+	}
+	if pod.Labels == nil {
+		pod.Labels = map[string]string{}
+	}
+	pod.Spec.NodeName = ""
+
+	// Drop the projected token volume injected by ServiceAccount admission;
+	// it is added again on create.
+	var volumes []corev1.Volume
+	for _, v := range pod.Spec.Volumes {
+		if !strings.HasPrefix(v.Name, "kube-api-access-") {
+			volumes = append(volumes, v)
+		}
+	}
+	pod.Spec.Volumes = volumes
+	dropTokenMounts(pod.Spec.InitContainers)
+	dropTokenMounts(pod.Spec.Containers)
+	return pod
+}
+
+// dropTokenMounts removes the mounts of the projected token volume, which
+// admission adds to init containers as well as to containers.
+func dropTokenMounts(containers []corev1.Container) {
+	for i := range containers {
+		c := &containers[i]
+		var mounts []corev1.VolumeMount
+		for _, m := range c.VolumeMounts {
+			if !strings.HasPrefix(m.Name, "kube-api-access-") {
+				mounts = append(mounts, m)
+			}
+		}
+		c.VolumeMounts = mounts
+	}
+}
+
+// clonedPVC returns a PVC with the same shape as claim that is populated
+// from it through CSI volume cloning.
+func clonedPVC(claim *corev1.PersistentVolumeClaim, name string) *corev1.PersistentVolumeClaim {
+	clone := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   claim.Namespace,
+			Labels:      map[string]string{},
+			Annotations: map[string]string{"kdev/cloned-from": claim.Name},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      claim.Spec.AccessModes,
+			Resources:        claim.Spec.Resources,
+			StorageClassName: claim.Spec.StorageClassName,
+			VolumeMode:       claim.Spec.VolumeMode,
+			DataSource: &corev1.TypedLocalObjectReference{
+				Kind: "PersistentVolumeClaim",
+				Name: claim.Name,
+			},
+		},
+	}
+	for k, v := range claim.Labels {
+		clone.Labels[k] = v
+	}
+	clone.Labels["kdev/name"] = name
+	if clone.Spec.VolumeMode == nil {
+		clone.Spec.VolumeMode = ptr.To(corev1.PersistentVolumeFilesystem)
+	}
+	return clone
+}
+
+// checkClone makes sure clone can be created from claim: its StorageClass
+// must use a CSI driver, which is what implements cloning, and the API
+// server must accept the object.
+func checkClone(ctx context.Context, claim, clone *corev1.PersistentVolumeClaim) error {
+	if class := ptr.Deref(claim.Spec.StorageClassName, ""); class != "" {
+		sc, err := kubeClient.StorageV1().StorageClasses().Get(ctx, class, metav1.GetOptions{})
+		switch {
+		case err == nil && strings.HasPrefix(sc.Provisioner, "kubernetes.io/"):
+			return fmt.Errorf("StorageClass %s uses the in-tree provisioner %s, which cannot clone volumes; rename without --clone-pvc", class, sc.Provisioner)
+		case err != nil && !apierrors.IsNotFound(err) && !apierrors.IsForbidden(err):
+			return fmt.Errorf("failed to get StorageClass %s: %w", class, err)
+		}
+	}
+	_, err := kubeClient.CoreV1().PersistentVolumeClaims(clone.Namespace).Create(ctx, clone, metav1.CreateOptions{DryRun: []string{metav1.DryRunAll}})
+	if err != nil {
+		return fmt.Errorf("cannot clone PVC %s to %s: %w", claim.Name, clone.Name, err)
+	}
+	return nil
+}
+
+// relabelClaims moves claims from environment from to environment to.
+func relabelClaims(ctx context.Context, claims []*corev1.PersistentVolumeClaim, from, to string) error {
+	pvcs := kubeClient.CoreV1().PersistentVolumeClaims(flagNamespace)
+	for _, claim := range claims {
+		// Re-read the claim so undoing a partial relabel does not conflict.
+		cur, err := pvcs.Get(ctx, claim.Name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get PVC %s: %w", claim.Name, err)
+		}
+		if cur.Labels["kdev/name"] != from {
+			continue
+		}
+		cur.Labels["kdev/name"] = to
+		renameAnnotations(cur.Annotations, from, to)
+		if _, err := pvcs.Update(ctx, cur, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to relabel PVC %s: %w", claim.Name, err)
+		}
+		progress.Infof("PVC %s now belongs to %s", claim.Name, to)
+	}
+	return nil
+}
+
+// renameAnnotations rewrites the kdev annotations that name environment
+// from, such as kdev/cloned-from, to name environment to.
+func renameAnnotations(annotations map[string]string, from, to string) {
+	for k, v := range annotations {
+		if strings.HasPrefix(k, "kdev/") && v == from {
+			annotations[k] = to
+		}
+	}
+}
+
+// relabelServices points kdev-managed Services of environment from at to.
+func relabelServices(ctx context.Context, from, to string) error {
+	svcs := kubeClient.CoreV1().Services(flagNamespace)
+	list, err := svcs.List(ctx, metav1.ListOptions{
+		LabelSelector: labels.Set{"app": "kdev", "kdev/name": from}.String(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list services: %w", err)
+	}
+	for i := range list.Items {
+		svc := &list.Items[i]
+		svc.Labels["kdev/name"] = to
+		renameAnnotations(svc.Annotations, from, to)
+		if svc.Spec.Selector["kdev/name"] == from {
+			svc.Spec.Selector["kdev/name"] = to
+		}
+		if _, err := svcs.Update(ctx, svc, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to update service %s: %w", svc.Name, err)
+		}
+		progress.Infof("Service %s now selects %s", svc.Name, to)
+	}
+	return nil
+}
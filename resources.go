@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/noopduck/kdev/internal/progress"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+)
+
+// saAdmissionBackoff bounds how long pod creation is retried while the
+// ServiceAccount admission plugin has not yet observed a new ServiceAccount.
+var saAdmissionBackoff = wait.Backoff{
+	Steps:    8,
+	Duration: 250 * time.Millisecond,
+	Factor:   2,
+	Cap:      5 * time.Second,
+}
+
+// ensureServiceAccount makes sure the ServiceAccount exists, creating it when
+// missing. A concurrent create by someone else counts as success.
+func ensureServiceAccount(ctx context.Context, sa *corev1.ServiceAccount) error {
+	namespace, name := sa.Namespace, sa.Name
+	_, err := kubeClient.CoreV1().ServiceAccounts(namespace).Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		return nil
+	case apierrors.IsForbidden(err):
+		// Some users may create but not read ServiceAccounts; try anyway.
+	case !apierrors.IsNotFound(err):
+		return fmt.Errorf("failed to look up ServiceAccount %s: %w", name, err)
+	}
+
+	_, err = kubeClient.CoreV1().ServiceAccounts(namespace).Create(ctx, sa, metav1.CreateOptions{})
+	switch {
+	case err == nil, apierrors.IsAlreadyExists(err):
+		return nil
+	case apierrors.IsForbidden(err):
+		return fmt.Errorf("not allowed to create ServiceAccount %s in %s; ask an admin to create it or pass --service-account with an existing one: %w", name, namespace, err)
+	default:
+		return fmt.Errorf("failed to create ServiceAccount: %w", err)
+	}
+}
+
+// createPod creates the pod, retrying while admission still rejects it
+// because a freshly created ServiceAccount is not visible yet.
+func createPod(ctx context.Context, pod *corev1.Pod) error {
+	return retry.OnError(saAdmissionBackoff, isServiceAccountNotReady, func() error {
+		_, err := kubeClient.CoreV1().Pods(pod.Namespace).Create(ctx, pod, metav1.CreateOptions{})
+		return err
+	})
+}
+
+// isServiceAccountNotReady matches the admission error returned when a pod
+// references a ServiceAccount the API server has not caught up with.
+func isServiceAccountNotReady(err error) bool {
+	if !apierrors.IsForbidden(err) && !apierrors.IsServerTimeout(err) {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "service account") && (strings.Contains(msg, "not found") || strings.Contains(msg, "no api token"))
+}
+
+// checkEnvFrom verifies that the Secrets and ConfigMaps referenced by
+// --env-from exist, since a missing one only shows up later as
+// CreateContainerConfigError.
+func checkEnvFrom(ctx context.Context, namespace string, refs []string) error {
+	for _, ref := range refs {
+		src, err := parseEnvFrom(ref)
+		if err != nil {
+			return err
+		}
+		switch {
+		case src.SecretRef != nil:
+			_, err = kubeClient.CoreV1().Secrets(namespace).Get(ctx, src.SecretRef.Name, metav1.GetOptions{})
+		case src.ConfigMapRef != nil:
+			_, err = kubeClient.CoreV1().ConfigMaps(namespace).Get(ctx, src.ConfigMapRef.Name, metav1.GetOptions{})
+		}
+		switch {
+		case apierrors.IsNotFound(err):
+			return fmt.Errorf("--env-from %s: not found in namespace %s", ref, namespace)
+		case err != nil && !apierrors.IsForbidden(err):
+			return fmt.Errorf("failed to look up %s: %w", ref, err)
+		}
+	}
+	return nil
+}
+
+// subdomainLabel selects the pods of a shared headless Service: every
+// environment started with the same --subdomain joins it.
+const subdomainLabel = "kdev/subdomain"
+
+// headlessService returns the Service that gives pods with subdomain a DNS
+// name of the form <hostname>.<subdomain>.<namespace>.svc.
+func headlessService(namespace, subdomain string) *corev1.Service {
+	return &corev1.Service{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      subdomain,
+			Namespace: namespace,
+			Labels:    map[string]string{"app": "kdev", subdomainLabel: subdomain},
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: corev1.ClusterIPNone,
+			Selector:  map[string]string{"app": "kdev", subdomainLabel: subdomain},
+			// Dev pods have no readiness probe to speak of; publish them as
+			// soon as they have an IP.
+			PublishNotReadyAddresses: true,
+		},
+	}
+}
+
+// ensureHeadlessService creates svc unless a Service of that name exists.
+// An existing Service is left alone, even if someone else manages it.
+func ensureHeadlessService(ctx context.Context, svc *corev1.Service) error {
+	services := kubeClient.CoreV1().Services(svc.Namespace)
+	existing, err := services.Get(ctx, svc.Name, metav1.GetOptions{})
+	if err == nil {
+		if existing.Labels["app"] != "kdev" {
+			progress.Warnf("Service %s exists and is not managed by kdev; pod DNS only works if it selects %s=%s", svc.Name, subdomainLabel, svc.Name)
+		}
+		return nil
+	}
+	if !apierrors.IsNotFound(err) && !apierrors.IsForbidden(err) {
+		return fmt.Errorf("failed to look up Service %s: %w", svc.Name, err)
+	}
+	if _, err := services.Create(ctx, svc, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create headless Service %s: %w", svc.Name, err)
+	}
+	return nil
+}
+
+// podDNSName is the stable in-cluster name of a pod with a subdomain.
+func podDNSName(pod *corev1.Pod) string {
+	if pod.Spec.Subdomain == "" {
+		return ""
+	}
+	host := pod.Spec.Hostname
+	if host == "" {
+		host = pod.Name
+	}
+	return fmt.Sprintf("%s.%s.%s.svc", host, pod.Spec.Subdomain, pod.Namespace)
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/noopduck/kdev/internal/progress"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func cmdRM() *cobra.Command {
+	var (
+		name      string
+		deletePVC bool
+		noGuard   bool
+		selector  string
+		all       bool
+		force     bool
+		wait      bool
+		timeout   time.Duration
+	)
+
+	c := &cobra.Command{
+		Use:   "rm [NAME...]",
+		Short: "Delete dev environments (optionally their PVCs)",
+		Long: `Rm deletes the named environments. Instead of names, -l selects the
+environments whose labels match a selector (e.g. team=payments) and --all
+selects every environment in the namespace; both only consider resources
+labelled app=kdev. When several environments are deleted, rm carries on past
+failures and ends with a summary.
+
+Rm asks for confirmation first; --force skips the question and is required
+when stdin is not a terminal. With --wait it returns only once the pods and
+PVCs are gone.`,
+		ValidArgsFunction: completeEnvNames,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+			names := args
+			if name != "" {
+				names = append(names, name)
+			}
+
+			switch {
+			case selector != "" && all:
+				return errors.New("--selector and --all cannot be combined")
+			case (selector != "" || all) && len(names) > 0:
+				return errors.New("names cannot be combined with --selector or --all")
+			case selector != "" || all:
+				var err error
+				if names, err = selectEnvNames(ctx, selector); err != nil {
+					return err
+				}
+				if len(names) == 0 {
+					fmt.Printf("No environments match in namespace %s\n", flagNamespace)
+					return nil
+				}
+			case len(names) == 0:
+				picked, err := envNameOrPick(ctx, "")
+				if err != nil {
+					return err
+				}
+				names = []string{picked}
+			}
+
+			if !force {
+				what := strings.Join(names, ", ")
+				if deletePVC {
+					what += " and the workspace PVC"
+					if len(names) > 1 {
+						what += "s"
+					}
+				}
+				ok, err := confirm(fmt.Sprintf("Delete %s in namespace %s?", what, flagNamespace))
+				if errors.Is(err, errNotInteractive) {
+					return fmt.Errorf("refusing to delete without confirmation, pass --force: %w", err)
+				}
+				if err != nil {
+					return err
+				}
+				if !ok {
+					return errors.New("aborted")
+				}
+			}
+
+			var deleted, failed, claims []string
+			for _, n := range names {
+				claim, err := rmEnv(ctx, n, deletePVC, noGuard)
+				if err != nil && len(names) == 1 {
+					return err
+				}
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "failed to delete %s: %v\n", n, err)
+					failed = append(failed, n)
+					continue
+				}
+				deleted = append(deleted, n)
+				if deletePVC {
+					volumes, err := deleteEnvVolumes(ctx, n)
+					for _, v := range volumes {
+						fmt.Printf("PVC %s deleted in namespace %s\n", v, flagNamespace)
+					}
+					if err != nil {
+						progress.Warnf("%v", err)
+					}
+				}
+				if err := deleteSSHSecret(ctx, n); err != nil {
+					progress.Warnf("%v", err)
+				}
+				if err := deleteWebIDE(ctx, n); err != nil {
+					progress.Warnf("%v", err)
+				}
+				if claim != "" {
+					claims = append(claims, claim)
+				}
+			}
+			if wait && len(deleted) > 0 {
+				sp := progress.Start(fmt.Sprintf("Waiting for %s to be deleted", strings.Join(deleted, ", ")))
+				err := waitForEnvsDeleted(ctx, deleted, claims, timeout)
+				sp.Done(err)
+				if err != nil {
+					return err
+				}
+			}
+			if len(names) == 1 {
+				return nil
+			}
+
+			fmt.Printf("\nDeleted %d of %d environments in namespace %s", len(deleted), len(names), flagNamespace)
+			if len(deleted) > 0 {
+				fmt.Printf(": %s", strings.Join(deleted, ", "))
+			}
+			fmt.Println()
+			if len(failed) > 0 {
+				return fmt.Errorf("failed to delete %s", strings.Join(failed, ", "))
+			}
+			return nil
+		},
+	}
+
+	c.Flags().StringVar(&name, "name", "", "Environment name (picked interactively when omitted)")
+	c.Flags().StringVarP(&selector, "selector", "l", "", "Delete the environments matching this label selector, e.g. team=payments")
+	c.Flags().BoolVar(&all, "all", false, "Delete every kdev environment in the namespace")
+	c.Flags().BoolVar(&deletePVC, "with-pvc", false, "Also delete the workspace PVC and the compose volume PVCs of the environment")
+	c.Flags().BoolVar(&noGuard, "no-guard", false, "Delete even if the resources are not labelled app=kdev")
+	c.Flags().BoolVarP(&force, "force", "y", false, "Do not ask for confirmation")
+	c.Flags().BoolVar(&wait, "wait", false, "Wait until the pods and PVCs are gone")
+	c.Flags().DurationVar(&timeout, "timeout", 2*time.Minute, "How long --wait waits")
+	deprecateNameFlag(c)
+	return c
+}
+
+// rmEnv deletes environment name: its workload or pod and, with deletePVC,
+// the workspace PVC, whose name it returns.
+func rmEnv(ctx context.Context, name string, deletePVC, noGuard bool) (string, error) {
+	kind, workload, err := envWorkload(ctx, flagNamespace, name)
+	if err != nil {
+		return "", err
+	}
+	if workload != nil {
+		return rmWorkload(ctx, kind, workload, deletePVC, noGuard)
+	}
+
+	pod, err := kubeClient.CoreV1().Pods(flagNamespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm, cerr := kubeClient.CoreV1().ConfigMaps(flagNamespace).Get(ctx, hibernateConfigMapName(name), metav1.GetOptions{})
+		if cerr == nil {
+			return rmHibernated(ctx, cm, deletePVC, noGuard)
+		}
+	}
+	if !noGuard {
+		if err != nil {
+			return "", fmt.Errorf("failed to get pod: %w", err)
+		}
+		if err := guardManaged("pod", &pod.ObjectMeta); err != nil {
+			return "", err
+		}
+		if deletePVC {
+			claim, err := kubeClient.CoreV1().PersistentVolumeClaims(flagNamespace).Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return "", fmt.Errorf("failed to get PVC: %w", err)
+			}
+			if err := guardManaged("PVC", &claim.ObjectMeta); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if err := kubeClient.CoreV1().Pods(flagNamespace).Delete(ctx, name, metav1.DeleteOptions{}); err != nil {
+		return "", fmt.Errorf("failed to delete pod: %w", err)
+	}
+
+	if deletePVC {
+		if err := kubeClient.CoreV1().PersistentVolumeClaims(flagNamespace).Delete(ctx, name, metav1.DeleteOptions{}); err != nil {
+			return "", fmt.Errorf("failed to delete PVC: %w", err)
+		}
+	}
+
+	fmt.Printf("Pod %s deleted in namespace %s\n", name, flagNamespace)
+	if !deletePVC {
+		return "", nil
+	}
+	fmt.Printf("PVC %s deleted in namespace %s\n", name, flagNamespace)
+	return name, nil
+}
+
+// rmHibernated deletes the hibernated environment stored in cm and, with
+// deletePVC, its workspace PVC, whose name it returns.
+func rmHibernated(ctx context.Context, cm *corev1.ConfigMap, deletePVC, noGuard bool) (string, error) {
+	name := cm.Labels["kdev/name"]
+	claim := name
+	var pod corev1.Pod
+	if json.Unmarshal([]byte(cm.Data[hibernatePodKey]), &pod) == nil {
+		if _, pvc := podImageAndClaim(&pod.Spec); pvc != "" {
+			claim = pvc
+		}
+	}
+	claims := kubeClient.CoreV1().PersistentVolumeClaims(flagNamespace)
+	if !noGuard {
+		if err := guardManaged("ConfigMap", &cm.ObjectMeta); err != nil {
+			return "", err
+		}
+		if deletePVC {
+			pvc, err := claims.Get(ctx, claim, metav1.GetOptions{})
+			if err != nil {
+				return "", fmt.Errorf("failed to get PVC: %w", err)
+			}
+			if err := guardManaged("PVC", &pvc.ObjectMeta); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if err := kubeClient.CoreV1().ConfigMaps(flagNamespace).Delete(ctx, cm.Name, metav1.DeleteOptions{}); err != nil {
+		return "", fmt.Errorf("failed to delete ConfigMap %s: %w", cm.Name, err)
+	}
+	fmt.Printf("Hibernated environment %s deleted in namespace %s\n", name, flagNamespace)
+	if !deletePVC {
+		return "", nil
+	}
+	if err := claims.Delete(ctx, claim, metav1.DeleteOptions{}); err != nil {
+		return "", fmt.Errorf("failed to delete PVC: %w", err)
+	}
+	fmt.Printf("PVC %s deleted in namespace %s\n", claim, flagNamespace)
+	return claim, nil
+}
+
+// selectEnvNames returns the environments whose pod, StatefulSet, Deployment
+// or hibernation ConfigMap matches selector, always restricted to app=kdev. An empty
+// selector selects every environment.
+func selectEnvNames(ctx context.Context, selector string) ([]string, error) {
+	sel, err := labels.Parse(selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector %q: %w", selector, err)
+	}
+	reqs, _ := sel.Requirements()
+	sel = labels.SelectorFromSet(labels.Set{"app": "kdev"}).Add(reqs...)
+	opts := metav1.ListOptions{LabelSelector: sel.String()}
+
+	var names []string
+	pods, err := kubeClient.CoreV1().Pods(flagNamespace).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+	for i := range pods.Items {
+		names = append(names, envName(&pods.Items[i]))
+	}
+	sets, err := kubeClient.AppsV1().StatefulSets(flagNamespace).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list StatefulSets: %w", err)
+	}
+	for _, s := range sets.Items {
+		names = append(names, s.Name)
+	}
+	deploys, err := kubeClient.AppsV1().Deployments(flagNamespace).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Deployments: %w", err)
+	}
+	for _, d := range deploys.Items {
+		names = append(names, d.Name)
+	}
+	// Hibernated environments have nothing but their ConfigMap.
+	state, _ := labels.NewRequirement(hibernateStateLabel, selection.Equals, []string{hibernatedState})
+	hibernated, err := kubeClient.CoreV1().ConfigMaps(flagNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: sel.Add(*state).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list hibernated environments: %w", err)
+	}
+	for _, cm := range hibernated.Items {
+		names = append(names, cm.Labels["kdev/name"])
+	}
+	slices.Sort(names)
+	return slices.Compact(names), nil
+}
+
+// waitForEnvsDeleted polls until the pods of the environments in names and
+// the PVCs in claims no longer exist.
+func waitForEnvsDeleted(ctx context.Context, names, claims []string, timeout time.Duration) error {
+	err := wait.PollUntilContextTimeout(ctx, time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		for _, n := range names {
+			pods, err := kubeClient.CoreV1().Pods(flagNamespace).List(ctx, metav1.ListOptions{LabelSelector: labels.Set(envSelector(n)).String()})
+			if err != nil || len(pods.Items) > 0 {
+				return false, err
+			}
+		}
+		for _, claim := range claims {
+			_, err := kubeClient.CoreV1().PersistentVolumeClaims(flagNamespace).Get(ctx, claim, metav1.GetOptions{})
+			if !apierrors.IsNotFound(err) {
+				return false, err
+			}
+		}
+		return true, nil
+	})
+	if wait.Interrupted(err) {
+		return fmt.Errorf("timed out after %s waiting for the deletion to finish (PVCs stay until no pod uses them)", timeout)
+	}
+	return err
+}
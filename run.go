@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/noopduck/kdev/internal/progress"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/utils/ptr"
+)
+
+// exitCodeError carries the exit status of a remote command so kdev can
+// exit with it.
+type exitCodeError struct {
+	code int
+}
+
+func (e *exitCodeError) Error() string {
+	return fmt.Sprintf("command exited with code %d", e.code)
+}
+
+func cmdRun() *cobra.Command {
+	var (
+		name    string
+		image   string
+		envs    []string
+		keep    bool
+		timeout time.Duration
+	)
+
+	c := &cobra.Command{
+		Use:   "run NAME -- COMMAND [ARGS...]",
+		Short: "Run a command in a short-lived copy of an environment",
+		Long: `Run starts a one-off pod with the image, volumes, env and resources of the
+named environment, streams the command's output and deletes the pod when the
+command finishes. kdev exits with the command's exit code, so it can drive
+test suites from CI. The pod is placed on the environment's node so
+ReadWriteOnce workspaces can be shared.`,
+		Args:              cobra.MinimumNArgs(1),
+		ValidArgsFunction: completeEnvNameArg,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Everything after -- is the command; with the deprecated
+			// --name, so is everything else.
+			if name == "" && cmd.ArgsLenAtDash() != 0 {
+				name, args = args[0], args[1:]
+			}
+			if name == "" {
+				return errNameRequired
+			}
+			if len(args) == 0 {
+				return errors.New("a command to run is required")
+			}
+			extra, err := parseKeyValues("env", envs)
+			if err != nil {
+				return err
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer stop()
+
+			env, err := resolvePod(ctx, flagNamespace, name)
+			if err != nil {
+				return err
+			}
+			pod, err := runPod(env, args, image, extra, timeout)
+			if err != nil {
+				return err
+			}
+
+			pods := kubeClient.CoreV1().Pods(flagNamespace)
+			pod, err = pods.Create(ctx, pod, metav1.CreateOptions{})
+			if err != nil {
+				return fmt.Errorf("failed to create pod: %w", err)
+			}
+			if !keep {
+				defer func() {
+					// ctx may already be cancelled by Ctrl-C.
+					_ = pods.Delete(context.Background(), pod.Name, metav1.DeleteOptions{GracePeriodSeconds: ptr.To[int64](0)})
+				}()
+			}
+
+			sp := progress.Start(fmt.Sprintf("Starting pod %s", pod.Name))
+			err = waitForPodStarted(ctx, pod, timeout)
+			sp.Done(err)
+			if err != nil {
+				return err
+			}
+			logs, err := pods.GetLogs(pod.Name, &corev1.PodLogOptions{Container: devContainer, Follow: true}).Stream(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to stream output: %w", err)
+			}
+			_, err = io.Copy(os.Stdout, logs)
+			logs.Close()
+			if err != nil && ctx.Err() == nil {
+				return fmt.Errorf("failed to stream output: %w", err)
+			}
+
+			code, err := waitForExitCode(ctx, pod.Name, devContainer, timeout)
+			if err != nil {
+				return err
+			}
+			if keep {
+				progress.Infof("Pod %s kept in namespace %s", pod.Name, flagNamespace)
+			}
+			if code != 0 {
+				return &exitCodeError{code: code}
+			}
+			return nil
+		},
+	}
+
+	c.Flags().StringVar(&name, "name", "", "Environment to copy")
+	c.Flags().StringVar(&image, "image", "", "Run with this image instead of the environment's")
+	c.Flags().StringSliceVar(&envs, "env", nil, "Extra env vars KEY=VALUE (repeatable)")
+	c.Flags().BoolVar(&keep, "keep", false, "Keep the pod after the command finishes")
+	c.Flags().DurationVar(&timeout, "timeout", time.Hour, "Maximum run time including startup")
+	deprecateNameFlag(c)
+	return c
+}
+
+// runPod derives the one-off pod from the environment's live pod.
+func runPod(env *corev1.Pod, command []string, image string, extra map[string]string, timeout time.Duration) (*corev1.Pod, error) {
+	pod := recreatablePod(env)
+	source := envName(env)
+	pod.ObjectMeta = metav1.ObjectMeta{
+		GenerateName: source + "-run-",
+		Namespace:    env.Namespace,
+		Labels: map[string]string{
+			"kdev/run-of": source,
+			"kdev/owner":  currentOwner(),
+		},
+	}
+	pod.Spec.RestartPolicy = corev1.RestartPolicyNever
+	if timeout >= time.Second {
+		pod.Spec.ActiveDeadlineSeconds = ptr.To(int64(timeout.Seconds()))
+	}
+	// Pin to the environment's node: a ReadWriteOnce workspace can only be
+	// mounted by pods on the node it is attached to.
+	pod.Spec.NodeName = env.Spec.NodeName
+
+	c := findContainer(pod, devContainer)
+	if c == nil {
+		return nil, fmt.Errorf("pod %s has no %q container", env.Name, devContainer)
+	}
+	c.Command = command
+	c.Args = nil
+	c.ReadinessProbe, c.LivenessProbe, c.StartupProbe = nil, nil, nil
+	if image != "" {
+		img, err := resolveImage(image)
+		if err != nil {
+			return nil, err
+		}
+		c.Image = img
+	}
+	for _, k := range sortedKeys(extra) {
+		setEnv(c, k, extra[k])
+	}
+	// Drop sidecars; only the command's container should run.
+	pod.Spec.Containers = []corev1.Container{*c}
+	return pod, nil
+}
+
+// waitForPodStarted waits until the pod's container has started (or
+// already finished), failing fast on image pull errors.
+func waitForPodStarted(ctx context.Context, pod *corev1.Pod, timeout time.Duration) error {
+	err := wait.PollUntilContextTimeout(ctx, time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		p, err := kubeClient.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if err := diagnoseImagePull(ctx, p); err != nil {
+			return false, err
+		}
+		return p.Status.Phase != corev1.PodPending, nil
+	})
+	if wait.Interrupted(err) {
+		return fmt.Errorf("timed out after %s waiting for pod %s to start", timeout, pod.Name)
+	}
+	return err
+}
+
+// waitForExitCode waits for container of pod name to terminate and returns
+// its exit code.
+func waitForExitCode(ctx context.Context, name, container string, timeout time.Duration) (int, error) {
+	code := -1
+	err := wait.PollUntilContextTimeout(ctx, time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		p, err := kubeClient.CoreV1().Pods(flagNamespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		for _, s := range p.Status.ContainerStatuses {
+			if s.Name == container && s.State.Terminated != nil {
+				code = int(s.State.Terminated.ExitCode)
+				return true, nil
+			}
+		}
+		if p.Status.Phase == corev1.PodFailed {
+			return false, fmt.Errorf("pod %s failed: %s", name, p.Status.Reason)
+		}
+		return false, nil
+	})
+	if wait.Interrupted(err) {
+		return code, fmt.Errorf("timed out after %s waiting for the command to finish", timeout)
+	}
+	return code, err
+}
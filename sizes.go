@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/noopduck/kdev/internal/config"
+	"github.com/noopduck/kdev/internal/spec"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// builtinSizes are the --size presets available without any shared config.
+var builtinSizes = map[string]config.Size{
+	"s":  {CPU: "500m", Memory: "1Gi", Storage: "10Gi"},
+	"m":  {CPU: "1", Memory: "2Gi", Storage: "20Gi"},
+	"l":  {CPU: "2", Memory: "4Gi", Storage: "50Gi"},
+	"xl": {CPU: "4", Memory: "8Gi", Storage: "100Gi"},
+}
+
+// sizeAliases spell out the built-in preset names.
+var sizeAliases = map[string]string{
+	"small":  "s",
+	"medium": "m",
+	"large":  "l",
+	"xlarge": "xl",
+}
+
+// sharedConfigNamespace is where cluster-wide kdev settings live when the
+// target namespace has none; every authenticated user can read it.
+const sharedConfigNamespace = "kube-public"
+
+// loadSharedConfig reads the kdev-config ConfigMap from namespace, falling
+// back to kube-public. A missing or unreadable ConfigMap yields an empty
+// config; a malformed one is an error so admins notice.
+func loadSharedConfig(ctx context.Context, namespace string) (*config.Shared, string, error) {
+	for _, ns := range []string{namespace, sharedConfigNamespace} {
+		cm, err := kubeClient.CoreV1().ConfigMaps(ns).Get(ctx, config.SharedConfigMap, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) || apierrors.IsForbidden(err) {
+			continue
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read shared config: %w", err)
+		}
+		source := fmt.Sprintf("configmap %s/%s", ns, config.SharedConfigMap)
+		shared, err := config.ParseShared(cm.Data[config.SharedConfigKey], source)
+		return shared, source, err
+	}
+	return &config.Shared{}, "", nil
+}
+
+// availableSizes merges the built-in presets with the shared config's and
+// then the user config's.
+func availableSizes(shared *config.Shared) map[string]config.Size {
+	sizes := make(map[string]config.Size, len(builtinSizes))
+	for k, v := range builtinSizes {
+		sizes[k] = v
+	}
+	if shared != nil {
+		for k, v := range shared.Sizes {
+			sizes[k] = v
+		}
+	}
+	if userConfig != nil {
+		for k, v := range userConfig.Sizes {
+			sizes[k] = v
+		}
+	}
+	return sizes
+}
+
+// applySize fills the resource fields of user that were not set explicitly
+// from the named preset. small, medium, large and xlarge name s, m, l and
+// xl unless a config defines them.
+func applySize(user *spec.Spec, name string, sizes map[string]config.Size) error {
+	size, ok := sizes[name]
+	if !ok && sizeAliases[name] != "" {
+		size, ok = sizes[sizeAliases[name]]
+	}
+	if !ok {
+		return fmt.Errorf("unknown --size %q (available: %s)", name, strings.Join(sizeNames(sizes), ", "))
+	}
+	for _, q := range []string{size.CPU, size.Memory, size.Storage} {
+		if q == "" {
+			continue
+		}
+		if _, err := resource.ParseQuantity(q); err != nil {
+			return fmt.Errorf("size %q: invalid quantity %q: %w", name, q, err)
+		}
+	}
+	if user.CPU == "" {
+		user.CPU = size.CPU
+	}
+	if user.Memory == "" {
+		user.Memory = size.Memory
+	}
+	if user.StorageSize == "" {
+		user.StorageSize = size.Storage
+	}
+	return nil
+}
+
+// sizeNames orders presets by CPU, then name, so s/m/l/xl read naturally.
+func sizeNames(sizes map[string]config.Size) []string {
+	names := make([]string, 0, len(sizes))
+	for k := range sizes {
+		names = append(names, k)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, aerr := resource.ParseQuantity(sizes[names[i]].CPU)
+		b, berr := resource.ParseQuantity(sizes[names[j]].CPU)
+		if aerr == nil && berr == nil && a.Cmp(b) != 0 {
+			return a.Cmp(b) < 0
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+func describeSize(s config.Size) string {
+	var parts []string
+	if s.CPU != "" {
+		parts = append(parts, "cpu "+s.CPU)
+	}
+	if s.Memory != "" {
+		parts = append(parts, "memory "+s.Memory)
+	}
+	if s.Storage != "" {
+		parts = append(parts, "storage "+s.Storage)
+	}
+	return strings.Join(parts, ", ")
+}
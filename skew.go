@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+
+	"github.com/noopduck/kdev/internal/progress"
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// clientGoFallback is the Kubernetes minor version matching the vendored
+// client-go, used when build info is unavailable.
+var clientGoFallback = version.MajorMinor(1, 34)
+
+// maxMinorSkew is how many minor releases the cluster may differ from
+// client-go before kdev warns, following the kubectl skew policy.
+const maxMinorSkew = 1
+
+// clusterFeature is an optional Kubernetes capability some kdev commands
+// build on. A feature is available when the server is new enough and, for
+// add-on APIs, serves groupVersion.
+type clusterFeature struct {
+	name         string
+	min          *version.Version
+	groupVersion string
+}
+
+var (
+	featureEphemeralContainers = clusterFeature{name: "ephemeral containers", min: version.MajorMinor(1, 25)}
+	featureVolumeSnapshots     = clusterFeature{name: "VolumeSnapshots", min: version.MajorMinor(1, 20), groupVersion: "snapshot.storage.k8s.io/v1"}
+)
+
+// clientKubeVersion maps the client-go module version (v0.X.Y) to the
+// Kubernetes release it was cut from (1.X).
+func clientKubeVersion() *version.Version {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return clientGoFallback
+	}
+	for _, dep := range info.Deps {
+		if dep.Path != "k8s.io/client-go" {
+			continue
+		}
+		v, err := version.ParseSemantic(strings.TrimPrefix(dep.Version, "v"))
+		if err != nil || v.Major() != 0 {
+			break
+		}
+		return version.MajorMinor(1, v.Minor())
+	}
+	return clientGoFallback
+}
+
+// versionSkew describes a significant difference between client-go and the
+// cluster, or returns "" when they are compatible.
+func versionSkew(server *version.Version) string {
+	if server == nil {
+		return ""
+	}
+	client := clientKubeVersion()
+	diff := int(server.Minor()) - int(client.Minor())
+	switch {
+	case diff > maxMinorSkew:
+		return fmt.Sprintf("cluster v%s is newer than kdev's client (v%s); newer API fields may be ignored, upgrade kdev with 'kdev upgrade'", server, client)
+	case diff < -maxMinorSkew:
+		return fmt.Sprintf("cluster v%s is older than kdev's client (v%s); some features may be unavailable", server, client)
+	}
+	return ""
+}
+
+// warnVersionSkew prints the skew warning, if any, to stderr.
+func warnVersionSkew() {
+	if msg := versionSkew(serverVersion); msg != "" {
+		progress.Warnf("%s", msg)
+	}
+}
+
+// requireFeature fails with an actionable error when the cluster lacks f.
+func requireFeature(f clusterFeature) error {
+	if f.min != nil {
+		if err := requireServerVersion(f.min, f.name); err != nil {
+			return err
+		}
+	}
+	if f.groupVersion == "" {
+		return nil
+	}
+	if _, err := kubeClient.Discovery().ServerResourcesForGroupVersion(f.groupVersion); err != nil {
+		return &kubeError{
+			reason: "feature unavailable",
+			err:    fmt.Errorf("%s need the %s API, which the cluster does not serve: %w", f.name, f.groupVersion, err),
+			hint:   "ask your cluster admin to install it, or avoid " + f.name,
+		}
+	}
+	return nil
+}
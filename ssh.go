@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// The SSH sidecar runs sshd from the dev image, so sessions get the same
+// tools, environment and workspace as attach. It listens on localhost only:
+// the way in is a port-forward through the Kubernetes API.
+const (
+	sshContainer  = "ssh"
+	sshPort       = 2222
+	sshKeysVolume = "kdev-ssh-keys"
+	sshKeysDir    = "/kdev/ssh-keys"
+	sshRunVolume  = "kdev-ssh-run"
+	sshRunDir     = "/kdev/ssh"
+)
+
+// sshdScript generates a host key and an sshd_config for an unprivileged
+// sshd and runs it. The container's environment is handed to sessions with
+// SetEnv where sshd supports it (OpenSSH 8.7 and later).
+var sshdScript = `set -e
+d=` + sshRunDir + `
+sshd=$(command -v sshd || echo /usr/sbin/sshd)
+rm -f $d/host_ed25519_key $d/host_ed25519_key.pub
+ssh-keygen -q -t ed25519 -N '' -f $d/host_ed25519_key
+cat > $d/sshd_config <<EOF
+Port ` + strconv.Itoa(sshPort) + `
+ListenAddress 127.0.0.1
+HostKey $d/host_ed25519_key
+PidFile $d/sshd.pid
+AuthorizedKeysFile ` + sshKeysDir + `/authorized_keys
+PasswordAuthentication no
+KbdInteractiveAuthentication no
+UsePAM no
+StrictModes no
+PrintMotd no
+AllowTcpForwarding yes
+Subsystem sftp internal-sftp
+EOF
+cp $d/sshd_config $d/sshd_config.base
+env | while IFS='=' read -r k v; do
+	case "$k" in ''|HOME|PWD|SHLVL|HOSTNAME|_|*[!A-Za-z0-9_]*) continue ;; esac
+	case "$v" in *'"'*) continue ;; esac
+	printf 'SetEnv %s="%s"\n' "$k" "$v"
+done >> $d/sshd_config
+"$sshd" -t -f $d/sshd_config 2>/dev/null || cp $d/sshd_config.base $d/sshd_config
+exec "$sshd" -D -e -f $d/sshd_config`
+
+func cmdSSH() *cobra.Command {
+	var stdio bool
+
+	c := &cobra.Command{
+		Use:   "ssh NAME [SSH ARGS...]",
+		Short: "Open an SSH session to an environment created with up --ssh",
+		Long: `Ssh connects to the SSH sidecar of environment NAME through a port-forward
+and runs the local ssh client against it, so scp, rsync and remote-SSH IDE
+features work as with any SSH host. Arguments after NAME are passed to ssh,
+e.g. a command to run or -L to forward a port.
+
+  kdev ssh mydev
+  kdev ssh mydev -L 8080:localhost:8080
+  kdev ssh mydev -- make test
+
+The transport is authenticated by Kubernetes, and the sidecar creates a new
+host key whenever the pod starts, so host keys are not checked.
+
+With --stdio, kdev does not run ssh but connects its stdin and stdout to the
+SSH server, as an ssh ProxyCommand; kdev ssh-config writes such entries.`,
+		Args:              cobra.MinimumNArgs(1),
+		ValidArgsFunction: completeEnvNameArg,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+			if stdio {
+				if len(args) > 1 {
+					return errors.New("--stdio takes no ssh arguments")
+				}
+				return sshStdio(ctx, args[0])
+			}
+			port, user, stop, err := sshTunnel(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			defer stop()
+
+			sshArgs := append(sshOptions(port), user+"@127.0.0.1")
+			ssh := exec.CommandContext(ctx, "ssh", append(sshArgs, args[1:]...)...)
+			ssh.Stdin, ssh.Stdout, ssh.Stderr = os.Stdin, os.Stdout, os.Stderr
+			err = ssh.Run()
+			var exit *exec.ExitError
+			if errors.As(err, &exit) {
+				return &exitCodeError{code: exit.ExitCode()}
+			}
+			if errors.Is(err, exec.ErrNotFound) {
+				return errors.New("kdev ssh needs the OpenSSH client (ssh) on your PATH")
+			}
+			return err
+		},
+	}
+
+	c.Flags().SetInterspersed(false)
+	c.Flags().BoolVar(&stdio, "stdio", false, "Connect stdin/stdout to the SSH server instead of running ssh (for ProxyCommand)")
+	return c
+}
+
+// sshOptions are the ssh client options for a tunnel on local port.
+func sshOptions(port int) []string {
+	return []string{
+		"-p", strconv.Itoa(port),
+		"-o", "StrictHostKeyChecking=no",
+		"-o", "UserKnownHostsFile=" + os.DevNull,
+		"-o", "LogLevel=ERROR",
+	}
+}
+
+// sshPod returns the ready pod of environment name, which must have the
+// SSH sidecar.
+func sshPod(ctx context.Context, name string) (*corev1.Pod, error) {
+	pod, err := resolvePod(ctx, flagNamespace, name)
+	if err != nil {
+		return nil, err
+	}
+	if findContainer(pod, sshContainer) == nil {
+		return nil, fmt.Errorf("environment %s has no SSH server; recreate it with 'kdev up %s --ssh'", name, name)
+	}
+	if !podReady(pod) {
+		return nil, fmt.Errorf("environment %s is not ready (%s)", name, podStatus(pod))
+	}
+	return pod, nil
+}
+
+// sshTunnel forwards a free local port to the SSH sidecar of environment
+// name and returns it with the user to log in as. stop ends the forward.
+func sshTunnel(ctx context.Context, name string) (port int, user string, stop func(), err error) {
+	pod, err := sshPod(ctx, name)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	if user, err = sshUser(ctx, pod.Name); err != nil {
+		return 0, "", nil, err
+	}
+	if port, stop, err = forwardSSH(ctx, pod.Name); err != nil {
+		return 0, "", nil, fmt.Errorf("failed to forward to the SSH server of %s: %w", name, err)
+	}
+	return port, user, stop, nil
+}
+
+// forwardSSH forwards a free local port to the SSH port of pod until stop
+// is called.
+func forwardSSH(ctx context.Context, pod string) (port int, stop func(), err error) {
+	fwdCtx, cancel := context.WithCancel(ctx)
+	ready := make(chan int, 1)
+	done := make(chan error, 1)
+	go func() {
+		_, err := forwardOnce(fwdCtx, pod, []string{"127.0.0.1"}, []string{"0:" + strconv.Itoa(sshPort)}, func(fwd []portforward.ForwardedPort) {
+			ready <- int(fwd[0].Local)
+		})
+		done <- err
+	}()
+	select {
+	case port = <-ready:
+		return port, cancel, nil
+	case err = <-done:
+		cancel()
+		if err == nil {
+			err = errors.New("port forward ended")
+		}
+		return 0, nil, err
+	}
+}
+
+// sshStdio connects stdin and stdout to the SSH server of environment name,
+// for use as an ssh ProxyCommand.
+func sshStdio(ctx context.Context, name string) error {
+	pod, err := sshPod(ctx, name)
+	if err != nil {
+		return err
+	}
+	port, stop, err := forwardSSH(ctx, pod.Name)
+	if err != nil {
+		return fmt.Errorf("failed to forward to the SSH server of %s: %w", name, err)
+	}
+	defer stop()
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		return fmt.Errorf("failed to connect to the SSH server of %s: %w", name, err)
+	}
+	defer conn.Close()
+
+	go func() {
+		_, _ = io.Copy(conn, os.Stdin)
+		// Pass on the end of input, but keep reading the server's reply.
+		_ = conn.(*net.TCPConn).CloseWrite()
+	}()
+	_, err = io.Copy(os.Stdout, conn)
+	return err
+}
+
+// sshUser returns the name of the user the sidecar runs as, which is the
+// only one an unprivileged sshd lets in.
+func sshUser(ctx context.Context, pod string) (string, error) {
+	var out, stderr bytes.Buffer
+	err := execStream(ctx, pod, sshContainer, []string{"id", "-un"}, remotecommand.StreamOptions{Stdout: &out, Stderr: &stderr})
+	if err != nil {
+		return "", fmt.Errorf("failed to look up the SSH user (the image needs a passwd entry for its user): %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// addSSHSidecar adds the SSH sidecar to pod; syncSSHSidecar fills it in once
+// the dev container is final.
+func addSSHSidecar(pod *corev1.Pod) {
+	if findContainer(pod, sshContainer) == nil {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: sshContainer})
+	}
+}
+
+// syncSSHSidecar makes the SSH sidecar run sshd from the dev container's
+// image, with its environment and mounts plus the authorized keys Secret.
+func syncSSHSidecar(pod *corev1.Pod) {
+	dev, c := findContainer(pod, devContainer), findContainer(pod, sshContainer)
+	if dev == nil || c == nil {
+		return
+	}
+	c.Image = dev.Image
+	c.ImagePullPolicy = dev.ImagePullPolicy
+	c.Command = []string{"/bin/sh", "-c", sshdScript}
+	c.Args = nil
+	c.WorkingDir = dev.WorkingDir
+	c.Env = slices.Clone(dev.Env)
+	c.EnvFrom = slices.Clone(dev.EnvFrom)
+	c.SecurityContext = dev.SecurityContext.DeepCopy()
+	c.VolumeMounts = append(slices.Clone(dev.VolumeMounts),
+		corev1.VolumeMount{Name: sshKeysVolume, MountPath: sshKeysDir, ReadOnly: true},
+		corev1.VolumeMount{Name: sshRunVolume, MountPath: sshRunDir},
+	)
+
+	pod.Spec.Volumes = slices.DeleteFunc(pod.Spec.Volumes, func(v corev1.Volume) bool {
+		return v.Name == sshKeysVolume || v.Name == sshRunVolume
+	})
+	pod.Spec.Volumes = append(pod.Spec.Volumes,
+		corev1.Volume{Name: sshKeysVolume, VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: sshSecretName(pod.Name)}}},
+		corev1.Volume{Name: sshRunVolume, VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+	)
+}
+
+func sshSecretName(name string) string {
+	return "kdev-ssh-" + name
+}
+
+// buildSSHSecret holds the public keys allowed to log in to environment
+// name: the given key files, or else every ~/.ssh/*.pub.
+func buildSSHSecret(namespace, name string, keyFiles []string) (*corev1.Secret, error) {
+	if len(keyFiles) == 0 {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve home directory: %w", err)
+		}
+		keyFiles, _ = filepath.Glob(filepath.Join(home, ".ssh", "*.pub"))
+		if len(keyFiles) == 0 {
+			return nil, fmt.Errorf("no public keys in %s; create one with ssh-keygen or pass --ssh-key", filepath.Join(home, ".ssh"))
+		}
+	}
+	var keys bytes.Buffer
+	for _, f := range keyFiles {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SSH key: %w", err)
+		}
+		if strings.Contains(string(data), "PRIVATE KEY") {
+			return nil, fmt.Errorf("%s is a private key; pass the .pub file", f)
+		}
+		keys.Write(bytes.TrimSpace(data))
+		keys.WriteByte('\n')
+	}
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      sshSecretName(name),
+			Namespace: namespace,
+			Labels:    envSelector(name),
+		},
+		Data: map[string][]byte{"authorized_keys": keys.Bytes()},
+	}, nil
+}
+
+// applySSHSecret creates or updates the authorized keys Secret.
+func applySSHSecret(ctx context.Context, secret *corev1.Secret) error {
+	secrets := kubeClient.CoreV1().Secrets(secret.Namespace)
+	if live, err := secrets.Get(ctx, secret.Name, metav1.GetOptions{}); err == nil {
+		if err := checkExisting("Secret", &live.ObjectMeta); err != nil {
+			return err
+		}
+	}
+	if _, err := serverSideApply(ctx, secrets.Patch, secret.Name, secret); err != nil {
+		return fmt.Errorf("failed to apply Secret %s: %w", secret.Name, err)
+	}
+	return nil
+}
+
+// ensureSSHSecret applies m.SSHSecret or, for an environment with the SSH
+// sidecar but no keys given, creates the Secret from ~/.ssh/*.pub unless it
+// exists.
+func ensureSSHSecret(ctx context.Context, m *manifests) error {
+	if findContainer(m.Pod, sshContainer) == nil {
+		return nil
+	}
+	secret := m.SSHSecret
+	if secret == nil {
+		_, err := kubeClient.CoreV1().Secrets(m.Pod.Namespace).Get(ctx, sshSecretName(m.Pod.Name), metav1.GetOptions{})
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		if secret, err = buildSSHSecret(m.Pod.Namespace, m.Pod.Name, nil); err != nil {
+			return err
+		}
+	}
+	return applySSHSecret(ctx, secret)
+}
+
+// moveSSHSecret copies the authorized keys Secret of environment from to the
+// name of environment to and points pod at the copy, so kdev rm and kdev up
+// find it under the new name. The old Secret is left for deleteSSHSecret.
+func moveSSHSecret(ctx context.Context, pod *corev1.Pod, from, to string) error {
+	secrets := kubeClient.CoreV1().Secrets(flagNamespace)
+	old, err := secrets.Get(ctx, sshSecretName(from), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get Secret %s: %w", sshSecretName(from), err)
+	}
+	moved := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: sshSecretName(to), Namespace: old.Namespace, Labels: envSelector(to)},
+		Data:       old.Data,
+	}
+	if _, err := secrets.Create(ctx, moved, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create Secret %s: %w", moved.Name, err)
+	}
+	for i := range pod.Spec.Volumes {
+		if v := pod.Spec.Volumes[i].Secret; v != nil && v.SecretName == old.Name {
+			v.SecretName = moved.Name
+		}
+	}
+	return nil
+}
+
+// deleteSSHSecret removes the authorized keys Secret of environment name,
+// if there is one. Users who may not touch Secrets cannot have created it.
+func deleteSSHSecret(ctx context.Context, name string) error {
+	err := kubeClient.CoreV1().Secrets(flagNamespace).Delete(ctx, sshSecretName(name), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) && !apierrors.IsForbidden(err) {
+		return fmt.Errorf("failed to delete Secret %s: %w", sshSecretName(name), err)
+	}
+	return nil
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/noopduck/kdev/internal/progress"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The entries kdev writes live between these markers in the ssh config, so
+// they can be updated without touching anything else in the file.
+const (
+	sshConfigBegin = "# BEGIN kdev (managed by kdev ssh-config; edits here are overwritten)"
+	sshConfigEnd   = "# END kdev"
+)
+
+// sshHost is one Host stanza in the managed block.
+type sshHost struct {
+	alias string
+	lines []string
+}
+
+func cmdSSHConfig() *cobra.Command {
+	var (
+		user   string
+		file   string
+		remove bool
+		print  bool
+	)
+
+	c := &cobra.Command{
+		Use:   "ssh-config [NAME...]",
+		Short: "Write ~/.ssh/config entries for environments created with up --ssh",
+		Long: `Ssh-config adds a Host entry named NAME.NAMESPACE.kdev to ~/.ssh/config for
+each environment, or for every environment in the namespace that has an SSH
+sidecar when no names are given. The entries connect through
+'kdev ssh --stdio', so plain ssh, scp, rsync and editors such as VS Code
+Remote-SSH reach the environment like any other host:
+
+  kdev ssh-config mydev
+  ssh mydev.dev.kdev
+  code --remote ssh-remote+mydev.dev.kdev /workspaces
+
+The entries pin the namespace, the kubeconfig context and --kubeconfig, so
+they keep working when the current context changes. They are kept in a block
+of their own at the top of the file; running ssh-config again updates them and
+--remove deletes them.`,
+		ValidArgsFunction: completeEnvNameArg,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+			if file == "" {
+				var err error
+				if file, err = defaultSSHConfigFile(); err != nil {
+					return err
+				}
+			}
+
+			var hosts []sshHost
+			if !remove || len(args) > 0 {
+				names := args
+				if len(names) == 0 {
+					var err error
+					if names, err = sshEnvNames(ctx); err != nil {
+						return err
+					}
+					if len(names) == 0 {
+						return fmt.Errorf("no environments with an SSH server in namespace %s; create one with 'kdev up NAME --ssh'", flagNamespace)
+					}
+				}
+				for _, name := range names {
+					h, err := sshConfigHost(ctx, name, user, remove)
+					if err != nil {
+						return err
+					}
+					hosts = append(hosts, h)
+				}
+			}
+			if print {
+				for _, h := range hosts {
+					fmt.Print(h.String())
+				}
+				return nil
+			}
+
+			if err := saveSSHHosts(file, hosts, remove); err != nil {
+				return err
+			}
+			for _, h := range hosts {
+				if remove {
+					progress.Infof("Removed %s from %s", h.alias, file)
+				} else {
+					progress.Infof("Wrote %s to %s; connect with: ssh %s", h.alias, file, h.alias)
+				}
+			}
+			if remove && len(args) == 0 {
+				progress.Infof("Removed all kdev entries from %s", file)
+			}
+			return nil
+		},
+	}
+
+	c.Flags().StringVar(&user, "user", "", "User to log in as (default: the user the environment's image runs as)")
+	c.Flags().StringVar(&file, "file", "", "SSH config file to update (default: ~/.ssh/config)")
+	c.Flags().BoolVar(&remove, "remove", false, "Remove the entries for NAME, or all kdev entries when no names are given")
+	c.Flags().BoolVar(&print, "print", false, "Print the entries instead of writing them")
+	return c
+}
+
+// defaultSSHConfigFile returns the path of the user's ssh config.
+func defaultSSHConfigFile() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find home directory: %w", err)
+	}
+	return filepath.Join(home, ".ssh", "config"), nil
+}
+
+// saveSSHHosts applies updateSSHConfig to file.
+func saveSSHHosts(file string, hosts []sshHost, remove bool) error {
+	data, err := os.ReadFile(file)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	out, err := updateSSHConfig(string(data), hosts, remove)
+	if err != nil {
+		return fmt.Errorf("failed to update %s: %w", file, err)
+	}
+	return writeSSHConfig(file, out)
+}
+
+// sshEnvNames returns the environments in the namespace that have the SSH
+// sidecar.
+func sshEnvNames(ctx context.Context) ([]string, error) {
+	pods, err := kubeClient.CoreV1().Pods(flagNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: "app=kdev",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+	var names []string
+	for i := range pods.Items {
+		if findContainer(&pods.Items[i], sshContainer) != nil {
+			names = append(names, envName(&pods.Items[i]))
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names), nil
+}
+
+// sshAlias is the Host name of environment name in the current namespace.
+func sshAlias(name string) string {
+	return name + "." + flagNamespace + ".kdev"
+}
+
+// sshConfigHost builds the Host stanza of environment name. Only the alias
+// is needed to remove an entry, so the cluster is not asked then.
+func sshConfigHost(ctx context.Context, name, user string, aliasOnly bool) (sshHost, error) {
+	h := sshHost{alias: sshAlias(name)}
+	if aliasOnly {
+		return h, nil
+	}
+	if user == "" {
+		pod, err := sshPod(ctx, name)
+		if err != nil {
+			return h, fmt.Errorf("%w (or pass --user)", err)
+		}
+		if user, err = sshUser(ctx, pod.Name); err != nil {
+			return h, err
+		}
+	}
+	proxy, err := sshProxyCommand(name)
+	if err != nil {
+		return h, err
+	}
+	h.lines = []string{
+		"User " + user,
+		"ProxyCommand " + proxy,
+		"StrictHostKeyChecking no",
+		"UserKnownHostsFile " + os.DevNull,
+		"LogLevel ERROR",
+	}
+	return h, nil
+}
+
+// sshProxyCommand returns the command ssh runs to reach environment name,
+// with the namespace, context and kubeconfig of this invocation pinned.
+func sshProxyCommand(name string) (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the kdev executable: %w", err)
+	}
+	kubeContext, err := currentKubeContext()
+	if err != nil {
+		return "", err
+	}
+
+	args := []string{exe, "ssh", "--stdio", "-n", flagNamespace}
+	if kubeContext != "" {
+		args = append(args, "--context", kubeContext)
+	}
+	if flagKubeconfig != "" {
+		path, err := filepath.Abs(flagKubeconfig)
+		if err != nil {
+			return "", err
+		}
+		args = append(args, "--kubeconfig", path)
+	}
+	args = append(args, name)
+
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		// ssh runs the command with /bin/sh after expanding % tokens.
+		quoted[i] = strings.ReplaceAll(shellQuote(a), "%", "%%")
+	}
+	return strings.Join(quoted, " "), nil
+}
+
+// currentKubeContext returns the name of the kubeconfig context in use.
+func currentKubeContext() (string, error) {
+	if flagContext != "" {
+		return flagContext, nil
+	}
+	raw, err := kubeConfig.RawConfig()
+	if err != nil {
+		return "", fmt.Errorf("failed to read kubeconfig: %w", err)
+	}
+	return raw.CurrentContext, nil
+}
+
+// shellQuote quotes s for /bin/sh unless it is made of safe characters only.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./:=@,+") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func (h sshHost) String() string {
+	var b strings.Builder
+	b.WriteString("Host " + h.alias + "\n")
+	for _, l := range h.lines {
+		b.WriteString("  " + l + "\n")
+	}
+	return b.String()
+}
+
+// updateSSHConfig replaces, adds or, with remove, deletes hosts in the
+// managed block of config. Removing without hosts drops the whole block.
+// Other entries of the block and everything outside it are kept.
+func updateSSHConfig(config string, hosts []sshHost, remove bool) (string, error) {
+	lines := strings.SplitAfter(config, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	begin, end := -1, -1
+	for i, l := range lines {
+		switch strings.TrimSpace(l) {
+		case sshConfigBegin:
+			begin = i
+		case sshConfigEnd:
+			if begin >= 0 && end < 0 {
+				end = i
+			}
+		}
+	}
+	if begin >= 0 && end < 0 {
+		return "", fmt.Errorf("found %q without %q; fix or remove the block by hand", sshConfigBegin, sshConfigEnd)
+	}
+
+	var existing []sshHost
+	if begin >= 0 {
+		inHost := false
+		for _, l := range lines[begin+1 : end] {
+			t := strings.TrimSpace(l)
+			alias, isHost := strings.CutPrefix(t, "Host ")
+			switch {
+			case isHost:
+				alias = strings.TrimSpace(alias)
+				inHost = alias != "*"
+				if inHost {
+					existing = append(existing, sshHost{alias: alias})
+				}
+			case t != "" && inHost:
+				last := &existing[len(existing)-1]
+				last.lines = append(last.lines, t)
+			}
+		}
+	}
+
+	merged := existing
+	if remove && len(hosts) == 0 {
+		merged = nil
+	}
+	for _, h := range hosts {
+		i := slices.IndexFunc(merged, func(e sshHost) bool { return e.alias == h.alias })
+		switch {
+		case remove && i >= 0:
+			merged = slices.Delete(merged, i, i+1)
+		case remove:
+		case i >= 0:
+			merged[i] = h
+		default:
+			merged = append(merged, h)
+		}
+	}
+
+	var block []string
+	if len(merged) > 0 {
+		block = append(block, sshConfigBegin+"\n")
+		for _, h := range merged {
+			block = append(block, h.String())
+		}
+		// Options after the block belong to all hosts again, as they did
+		// before it was inserted.
+		block = append(block, "Host *\n", sshConfigEnd+"\n")
+	}
+
+	// The block goes first: ssh uses the first value it finds for an option,
+	// so a later "Host *" in the user's file cannot override it. An existing
+	// block stays where it is.
+	before, after := []string(nil), lines
+	if begin >= 0 {
+		before, after = lines[:begin], lines[end+1:]
+		if len(block) == 0 && len(before) == 0 && len(after) > 0 && strings.TrimSpace(after[0]) == "" {
+			after = after[1:] // the separator added with the block
+		}
+	} else if len(block) > 0 && len(after) > 0 {
+		block = append(block, "\n")
+	}
+	out := strings.Join(before, "") + strings.Join(block, "") + strings.Join(after, "")
+	if out != "" && !strings.HasSuffix(out, "\n") {
+		out += "\n"
+	}
+	return out, nil
+}
+
+// writeSSHConfig replaces file with data, keeping its permissions and
+// following a symlink to it. A new file gets the permissions ssh expects.
+func writeSSHConfig(file, data string) error {
+	if target, err := filepath.EvalSymlinks(file); err == nil {
+		file = target
+	}
+	mode := os.FileMode(0o600)
+	if fi, err := os.Stat(file); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(file), err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".kdev-ssh-config-*")
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", file, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", file, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file, err)
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file, err)
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file, err)
+	}
+	return nil
+}
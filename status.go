@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Statuses of environments that have no pod on purpose: hibernated ones are
+// kept in a ConfigMap, stopped ones in a workload scaled to zero.
+const (
+	statusHibernated = "Hibernated"
+	statusStopped    = "Stopped"
+)
+
+// podStatus computes the STATUS shown for a dev pod. It mirrors kubectl's
+// column logic: the phase is refined by init/container waiting and
+// termination reasons, and a pod being deleted is reported as Terminating.
+func podStatus(pod *corev1.Pod) string {
+	reason := string(pod.Status.Phase)
+	if pod.Status.Reason != "" {
+		reason = pod.Status.Reason
+	}
+
+	initializing := false
+	for i, c := range pod.Status.InitContainerStatuses {
+		switch {
+		case c.State.Terminated != nil && c.State.Terminated.ExitCode == 0:
+			continue
+		case c.State.Terminated != nil:
+			reason = "Init:" + terminatedReason(c.State.Terminated)
+		case c.State.Waiting != nil && c.State.Waiting.Reason != "" && c.State.Waiting.Reason != "PodInitializing":
+			reason = "Init:" + c.State.Waiting.Reason
+		default:
+			reason = fmt.Sprintf("Init:%d/%d", i, len(pod.Spec.InitContainers))
+		}
+		initializing = true
+		break
+	}
+
+	if !initializing {
+		hasRunning := false
+		for i := len(pod.Status.ContainerStatuses) - 1; i >= 0; i-- {
+			c := pod.Status.ContainerStatuses[i]
+			switch {
+			case c.State.Waiting != nil && c.State.Waiting.Reason != "":
+				reason = c.State.Waiting.Reason
+			case c.State.Terminated != nil:
+				reason = terminatedReason(c.State.Terminated)
+			case c.Ready && c.State.Running != nil:
+				hasRunning = true
+			}
+		}
+		// A restarted container can leave "Completed" behind while another
+		// container is already serving again.
+		if reason == "Completed" && hasRunning {
+			reason = string(corev1.PodRunning)
+		}
+	}
+
+	if pod.DeletionTimestamp != nil {
+		if pod.Status.Reason == "NodeLost" {
+			return "Unknown"
+		}
+		return "Terminating"
+	}
+	return reason
+}
+
+// envStatusWithoutPod returns statusHibernated or statusStopped when
+// environment name was hibernated or stopped, and "" otherwise.
+func envStatusWithoutPod(ctx context.Context, namespace, name string) string {
+	if _, err := kubeClient.CoreV1().ConfigMaps(namespace).Get(ctx, hibernateConfigMapName(name), metav1.GetOptions{}); err == nil {
+		return statusHibernated
+	}
+	if sts, err := kubeClient.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{}); err == nil {
+		if sts.Spec.Replicas != nil && *sts.Spec.Replicas == 0 {
+			return statusStopped
+		}
+		return ""
+	}
+	if d, err := kubeClient.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{}); err == nil && d.Spec.Replicas != nil && *d.Spec.Replicas == 0 {
+		return statusStopped
+	}
+	return ""
+}
+
+func terminatedReason(t *corev1.ContainerStateTerminated) string {
+	switch {
+	case t.Reason != "":
+		return t.Reason
+	case t.Signal != 0:
+		return fmt.Sprintf("Signal:%d", t.Signal)
+	default:
+		return fmt.Sprintf("ExitCode:%d", t.ExitCode)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/noopduck/kdev/internal/progress"
+	"github.com/spf13/cobra"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// stoppedEnv is a StatefulSet or Deployment environment scaled to zero by
+// kdev stop.
+type stoppedEnv struct {
+	namespace string
+	name      string
+	kind      string
+	created   time.Time
+	image     string
+	pvc       string
+}
+
+func cmdStop() *cobra.Command {
+	var timeout time.Duration
+
+	c := &cobra.Command{
+		Use:   "stop NAME",
+		Short: "Free an environment's pod but keep its workspace",
+		Long: `Stop deletes the pod of environment NAME so it no longer uses CPU and memory.
+The workspace PVC is kept. A plain pod is stored the way kdev hibernate
+stores it; StatefulSet and Deployment environments are scaled to zero
+replicas and keep their spec in the workload. kdev start brings the
+environment back.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeEnvNameArg,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+			name := args[0]
+			kind, meta, err := envWorkload(ctx, flagNamespace, name)
+			if err != nil {
+				return err
+			}
+			if kind == "" {
+				err = hibernateEnv(ctx, name, timeout)
+			} else if err = guardManaged(workloadKindName(kind), meta); err == nil {
+				err = stopWorkload(ctx, kind, name, timeout)
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Environment %s stopped in namespace %s; the workspace is kept. Use 'kdev start %s' to start it again.\n", name, flagNamespace, name)
+			return nil
+		},
+	}
+
+	c.Flags().DurationVar(&timeout, "timeout", 2*time.Minute, "How long to wait for the pod to terminate")
+	return c
+}
+
+func cmdStart() *cobra.Command {
+	var (
+		wait    bool
+		timeout time.Duration
+	)
+
+	c := &cobra.Command{
+		Use:               "start NAME",
+		Short:             "Start an environment stopped with kdev stop",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeStoppedNames,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+			name := args[0]
+			kind, _, err := envWorkload(ctx, flagNamespace, name)
+			if err != nil {
+				return err
+			}
+			if kind == "" {
+				err = wakeEnv(ctx, name, "", wait, timeout)
+			} else {
+				err = startWorkload(ctx, kind, name, wait, timeout)
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Environment %s started in namespace %s\n", name, flagNamespace)
+			return nil
+		},
+	}
+
+	c.Flags().BoolVar(&wait, "wait", true, "Wait until the pod is ready")
+	c.Flags().DurationVar(&timeout, "timeout", 5*time.Minute, "How long --wait waits for the pod")
+	return c
+}
+
+// stopWorkload scales the workload of environment name to zero and waits
+// for its pods to go away.
+func stopWorkload(ctx context.Context, kind, name string, timeout time.Duration) error {
+	if err := scaleWorkload(ctx, kind, name, 0); err != nil {
+		return err
+	}
+	sp := progress.Start(fmt.Sprintf("Waiting for the pods of %s to terminate", name))
+	err := waitForEnvPodsGone(ctx, flagNamespace, name, timeout)
+	sp.Done(err)
+	return err
+}
+
+// startWorkload scales a stopped workload back to one replica.
+func startWorkload(ctx context.Context, kind, name string, wait bool, timeout time.Duration) error {
+	if err := scaleWorkload(ctx, kind, name, 1); err != nil {
+		return err
+	}
+	if !wait {
+		return nil
+	}
+	sp := progress.Start(fmt.Sprintf("Waiting for environment %s to become ready", name))
+	_, err := waitForEnvPod(ctx, flagNamespace, name, timeout)
+	sp.Done(err)
+	return err
+}
+
+// scaleWorkload sets the replicas of the StatefulSet or Deployment name,
+// leaving the rest of the spec untouched. kdev up applies one replica again.
+func scaleWorkload(ctx context.Context, kind, name string, replicas int32) error {
+	patch := []byte(fmt.Sprintf(`{"spec":{"replicas":%d}}`, replicas))
+	opts := metav1.PatchOptions{FieldManager: fieldManager}
+	var err error
+	switch kind {
+	case controllerStatefulSet:
+		_, err = kubeClient.AppsV1().StatefulSets(flagNamespace).Patch(ctx, name, types.MergePatchType, patch, opts)
+	case controllerDeployment:
+		_, err = kubeClient.AppsV1().Deployments(flagNamespace).Patch(ctx, name, types.MergePatchType, patch, opts)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to scale %s %s: %w", workloadKindName(kind), name, err)
+	}
+	return nil
+}
+
+// waitForEnvPodsGone polls until no pod of environment name is left.
+func waitForEnvPodsGone(ctx context.Context, namespace, name string, timeout time.Duration) error {
+	selector := labels.Set(envSelector(name)).String()
+	err := wait.PollUntilContextTimeout(ctx, time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		list, err := kubeClient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return false, err
+		}
+		return len(list.Items) == 0, nil
+	})
+	if wait.Interrupted(err) {
+		return fmt.Errorf("timed out after %s waiting for the pods of %s to terminate", timeout, name)
+	}
+	return err
+}
+
+// stoppedWorkloads lists the StatefulSet and Deployment environments in
+// namespace that are scaled to zero.
+func stoppedWorkloads(ctx context.Context, namespace string) ([]stoppedEnv, error) {
+	opts := metav1.ListOptions{LabelSelector: "app=kdev"}
+	sets, err := kubeClient.AppsV1().StatefulSets(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list StatefulSets: %w", err)
+	}
+	deploys, err := kubeClient.AppsV1().Deployments(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Deployments: %w", err)
+	}
+	return stoppedEnvs(sets.Items, deploys.Items), nil
+}
+
+// stoppedEnvs picks the workloads scaled to zero.
+func stoppedEnvs(sets []appsv1.StatefulSet, deploys []appsv1.Deployment) []stoppedEnv {
+	var envs []stoppedEnv
+	for _, s := range sets {
+		if s.Spec.Replicas != nil && *s.Spec.Replicas == 0 {
+			image, _ := podImageAndClaim(&s.Spec.Template.Spec)
+			envs = append(envs, stoppedEnv{namespace: s.Namespace, name: s.Name, kind: controllerStatefulSet, created: s.CreationTimestamp.Time, image: image, pvc: statefulSetClaimName(s.Name)})
+		}
+	}
+	for _, d := range deploys {
+		if d.Spec.Replicas != nil && *d.Spec.Replicas == 0 {
+			image, pvc := podImageAndClaim(&d.Spec.Template.Spec)
+			envs = append(envs, stoppedEnv{namespace: d.Namespace, name: d.Name, kind: controllerDeployment, created: d.CreationTimestamp.Time, image: image, pvc: pvc})
+		}
+	}
+	return envs
+}
+
+// completeStoppedNames lists environments that kdev start can bring back.
+func completeStoppedNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 || !completionClient() {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	ctx, cancel := completionContext()
+	defer cancel()
+	names, _ := completeHibernatedNames(cmd, args, toComplete)
+	envs, err := stoppedWorkloads(ctx, flagNamespace)
+	if err != nil {
+		return names, cobra.ShellCompDirectiveNoFileComp
+	}
+	for _, e := range envs {
+		if strings.HasPrefix(e.name, toComplete) {
+			names = append(names, e.name+"\tStopped")
+		}
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// clusterDefaultStorageClass is the --storage-class value (besides "") that
+// selects the cluster's default StorageClass.
+const clusterDefaultStorageClass = "default"
+
+// resolveStorageClass validates the requested StorageClass and returns the
+// value for the PVC's storageClassName. Empty or "default" selects the
+// cluster default, which is expressed by leaving the field unset.
+func resolveStorageClass(ctx context.Context, requested string) (*string, error) {
+	classes, err := kubeClient.StorageV1().StorageClasses().List(ctx, metav1.ListOptions{})
+	if apierrors.IsForbidden(err) {
+		// StorageClasses are cluster-scoped; users without read access
+		// still get the API server's own validation.
+		if requested == "" || requested == clusterDefaultStorageClass {
+			return nil, nil
+		}
+		return &requested, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list StorageClasses: %w", err)
+	}
+
+	if requested == "" || requested == clusterDefaultStorageClass {
+		if defaultStorageClass(classes.Items) == "" {
+			return nil, fmt.Errorf("cluster has no default StorageClass; pick one with --storage-class (available: %s)", describeStorageClasses(classes.Items))
+		}
+		return nil, nil
+	}
+	for _, sc := range classes.Items {
+		if sc.Name == requested {
+			return &requested, nil
+		}
+	}
+	return nil, fmt.Errorf("StorageClass %q not found; available: %s (use --storage-class default for the cluster default)", requested, describeStorageClasses(classes.Items))
+}
+
+// defaultStorageClass returns the name of the class marked as cluster
+// default, or "" if there is none.
+func defaultStorageClass(classes []storagev1.StorageClass) string {
+	for _, sc := range classes {
+		if isDefaultStorageClass(&sc) {
+			return sc.Name
+		}
+	}
+	return ""
+}
+
+func isDefaultStorageClass(sc *storagev1.StorageClass) bool {
+	return sc.Annotations["storageclass.kubernetes.io/is-default-class"] == "true" ||
+		sc.Annotations["storageclass.beta.kubernetes.io/is-default-class"] == "true"
+}
+
+func describeStorageClasses(classes []storagev1.StorageClass) string {
+	if len(classes) == 0 {
+		return "none"
+	}
+	names := make([]string, 0, len(classes))
+	for _, sc := range classes {
+		name := sc.Name
+		if isDefaultStorageClass(&sc) {
+			name += " (default)"
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// printUpSummary tells the user what up created and how to get in.
+func printUpSummary(ctx context.Context, w io.Writer, m *manifests, podName string, ready bool) {
+	env := m.Pod.Name
+	headline := "Environment %s created\n"
+	if ready {
+		headline = "Environment %s is ready\n"
+	}
+	row := func(key, format string, args ...interface{}) {
+		fmt.Fprintf(w, "  %-11s%s\n", key+":", fmt.Sprintf(format, args...))
+	}
+
+	fmt.Fprintf(w, "\n"+headline, env)
+	row("Namespace", "%s", flagNamespace)
+	switch {
+	case m.StatefulSet != nil:
+		row("Pod", "%s (StatefulSet %s)", podName, env)
+	case m.Deployment != nil:
+		row("Pod", "%s (Deployment %s)", podName, env)
+	default:
+		row("Pod", "%s", podName)
+	}
+	if owner := m.Pod.Labels["kdev/owner"]; owner != "" {
+		row("Owner", "%s", owner)
+	}
+
+	eff := specFromPod(m.Pod, m.PVC)
+	claim := m.PVC.Name
+	if m.StatefulSet != nil {
+		claim = statefulSetClaimName(env)
+	}
+	storage := []string{eff.StorageSize}
+	if sc := m.PVC.Spec.StorageClassName; sc != nil {
+		storage = append(storage, *sc)
+	} else {
+		storage = append(storage, "cluster default class")
+	}
+	row("Workspace", "PVC %s (%s) at %s", claim, strings.Join(storage, ", "), eff.Workdir)
+
+	if m.Service != nil {
+		live := m.Pod.DeepCopy()
+		live.Name = podName
+		if m.StatefulSet != nil {
+			// StatefulSet pods always use their own name as hostname.
+			live.Spec.Hostname = ""
+		}
+		row("DNS", "%s", podDNSName(live))
+	}
+	for _, port := range envServicePorts(ctx, env) {
+		row("Port", "%s", port)
+	}
+	if findContainer(m.Pod, webIDEContainer) != nil {
+		row("Web IDE", "%s", webIDEURL(ctx, env))
+		row("Password", "kubectl get secret %s -n %s -o jsonpath='{.data.%s}' | base64 -d", webIDEName(env), flagNamespace, webIDEPasswordKey)
+	}
+	row("Attach", "kdev attach %s -n %s", env, flagNamespace)
+}
+
+// envServicePorts lists the ports of the environment's Services as
+// service:port/protocol, for display.
+func envServicePorts(ctx context.Context, env string) []string {
+	svcs, err := kubeClient.CoreV1().Services(flagNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.Set(envSelector(env)).String(),
+	})
+	if err != nil {
+		return nil
+	}
+	var ports []string
+	for _, svc := range svcs.Items {
+		for _, p := range svc.Spec.Ports {
+			proto := p.Protocol
+			if proto == "" {
+				proto = corev1.ProtocolTCP
+			}
+			ports = append(ports, fmt.Sprintf("%s.%s.svc:%d/%s", svc.Name, svc.Namespace, p.Port, proto))
+		}
+	}
+	return ports
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/noopduck/kdev/internal/config"
+	"github.com/noopduck/kdev/internal/telemetry"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// telemetrySendTimeout bounds the upload at the end of a command, so a slow
+// endpoint never holds up the CLI noticeably.
+const telemetrySendTimeout = 2 * time.Second
+
+func cmdTelemetry() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "telemetry",
+		Short: "Show or change whether kdev reports anonymous usage",
+		Long: `Telemetry is off unless you turn it on. When on, kdev records the command
+run, the names of the flags set (never their values), how long it took and the
+category of any error. Arguments, environment names, namespaces and cluster
+addresses are not recorded. Each event is written as a JSON file to the
+telemetry-spool directory next to the user config (kdev telemetry status
+shows where), which you can read at any time, and events are sent to
+telemetryEndpoint from the user config in batches. DO_NOT_TRACK=1 disables
+recording regardless of this setting.`,
+		Annotations: map[string]string{annotationNoCluster: "true"},
+	}
+	c.AddCommand(
+		&cobra.Command{
+			Use:   "status",
+			Short: "Show the telemetry setting, endpoint and spooled events",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return printTelemetryStatus()
+			},
+		},
+		&cobra.Command{
+			Use:   "on",
+			Short: "Opt in to anonymous usage telemetry",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if _, err := telemetry.Enable(); err != nil {
+					return err
+				}
+				fmt.Println("Telemetry enabled, thank you.")
+				return printTelemetryStatus()
+			},
+		},
+		&cobra.Command{
+			Use:   "off",
+			Short: "Opt out and delete unsent events",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if err := telemetry.Disable(); err != nil {
+					return err
+				}
+				fmt.Println("Telemetry disabled; unsent events deleted.")
+				return nil
+			},
+		},
+	)
+	return c
+}
+
+func printTelemetryStatus() error {
+	state, err := telemetry.LoadState()
+	if err != nil {
+		return err
+	}
+	spool, err := telemetry.SpoolPath()
+	if err != nil {
+		return err
+	}
+	events, err := telemetry.Pending()
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", spool, err)
+	}
+
+	status := "off"
+	switch {
+	case telemetry.DoNotTrack():
+		status = "off (DO_NOT_TRACK is set)"
+	case state.Enabled:
+		status = "on"
+	}
+	endpoint := "(none, events stay in the spool)"
+	if userConfig != nil && userConfig.TelemetryEndpoint != "" {
+		endpoint = userConfig.TelemetryEndpoint
+	}
+	fmt.Printf("Telemetry:  %s\n", status)
+	if state.Install != "" {
+		fmt.Printf("Install ID: %s\n", state.Install)
+	}
+	fmt.Printf("Endpoint:   %s\n", endpoint)
+	fmt.Printf("Spool:      %s (%d unsent events)\n", spool, len(events))
+	return nil
+}
+
+// recordUsage spools an event for the command that just ran and sends the
+// spool when a batch is due. Telemetry failures are never reported: they must
+// not change the outcome of the command.
+func recordUsage(cmd *cobra.Command, err error, took time.Duration) {
+	// Shell completion requests run on every tab press.
+	if cmd == nil || strings.HasPrefix(cmd.Name(), "__") {
+		return
+	}
+	e := telemetry.Event{
+		Time:     time.Now().UTC(),
+		Version:  buildVersion,
+		OS:       runtime.GOOS,
+		Arch:     runtime.GOARCH,
+		Command:  cmd.CommandPath(),
+		Duration: took.Milliseconds(),
+		Error:    errorCategory(err),
+	}
+	cmd.Flags().Visit(func(f *pflag.Flag) { e.Flags = append(e.Flags, f.Name) })
+	if telemetry.Record(e) != nil {
+		return
+	}
+
+	if userConfig == nil || userConfig.TelemetryEndpoint == "" || offline() {
+		return
+	}
+	events, perr := telemetry.Pending()
+	if perr != nil || !telemetry.Due(events) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), telemetrySendTimeout)
+	defer cancel()
+	_ = telemetry.Send(ctx, userConfig.TelemetryEndpoint, "kdev/"+buildVersion)
+}
+
+// errorCategory maps err, after diagnoseKubeError, to a coarse category that
+// carries no part of the message.
+func errorCategory(err error) string {
+	if err == nil {
+		return ""
+	}
+	var (
+		exit    *exitCodeError
+		pull    *imagePullError
+		kube    *kubeError
+		invalid *config.ValidationError
+	)
+	switch {
+	case errors.As(err, &exit):
+		return "exit-code"
+	case errors.As(err, &pull):
+		return "image-pull"
+	case errors.As(err, &invalid):
+		return "config"
+	case errors.Is(err, errNotInteractive):
+		return "not-interactive"
+	case errors.As(err, &kube):
+		return strings.ReplaceAll(kube.reason, " ", "-")
+	case isTimeout(err):
+		return "timeout"
+	}
+	if reason := apierrors.ReasonForError(err); reason != metav1.StatusReasonUnknown {
+		return "api-" + strings.ToLower(string(reason))
+	}
+	return "other"
+}
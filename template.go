@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/noopduck/kdev/internal/config"
+	"github.com/noopduck/kdev/internal/spec"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"sigs.k8s.io/yaml"
+)
+
+// Field sources reported by up --dry-run.
+const (
+	sourceDefault  = "default"
+	sourceConfig   = "config"
+	sourceTemplate = "template"
+	sourceFlag     = "flag"
+	// sourceLocal is the timezone or locale of this machine.
+	sourceLocal = "local"
+	// sourceProject is a field set in the project's kdev.yaml.
+	sourceProject = "kdev.yaml"
+	// sourceProfile is a field set by the --profile of the user config.
+	sourceProfile = "profile"
+	// sourceDevcontainer is a field kdev up --from-devcontainer set.
+	sourceDevcontainer = "devcontainer"
+)
+
+// manifests is everything kdev up creates for one environment.
+type manifests struct {
+	ServiceAccount *corev1.ServiceAccount
+	PVC            *corev1.PersistentVolumeClaim
+	// Volumes are the PVCs of named devcontainer volumes, shared with
+	// other environments and never replaced.
+	Volumes []*corev1.PersistentVolumeClaim
+	Pod     *corev1.Pod
+	// StatefulSet, when set, owns Pod and PVC and is created instead of them.
+	StatefulSet *appsv1.StatefulSet
+	// Deployment, when set, owns Pod and is created instead of it.
+	Deployment *appsv1.Deployment
+	// Service is the headless Service for the pod's subdomain, if any.
+	Service *corev1.Service
+	// SSHSecret holds the authorized keys of the SSH sidecar. When nil, an
+	// existing Secret is kept and a missing one is made from ~/.ssh/*.pub.
+	SSHSecret *corev1.Secret
+	// WebIDESecret, WebIDEService and WebIDEIngress expose the web IDE
+	// sidecar. An existing password Secret is never replaced.
+	WebIDESecret  *corev1.Secret
+	WebIDEService *corev1.Service
+	WebIDEIngress *networkingv1.Ingress
+	// Sources maps each spec field to the layer that set it.
+	Sources map[string]string
+}
+
+// buildManifests renders the environment's objects. Precedence, lowest
+// first: kdev defaults, local (the timezone and locale of this machine),
+// the user config, the project's kdev.yaml spec proj, the template at
+// templatePath (if any), then the explicitly set fields of user.
+func buildManifests(namespace string, local, user, proj spec.Spec, templatePath string) (*manifests, error) {
+	eff := spec.Defaults(user.Name)
+	eff.Merge(local)
+	cfg := configDefaults()
+	eff.Merge(cfg)
+	eff.Merge(proj)
+	eff.Merge(user)
+
+	pod, err := buildPod(namespace, eff)
+	if err != nil {
+		return nil, err
+	}
+	var probes *config.Probes
+	if userConfig != nil {
+		probes = userConfig.Probes
+	}
+	applyProbes(pod, probes)
+	pvc, err := buildPVC(namespace, eff)
+	if err != nil {
+		return nil, err
+	}
+	m := &manifests{Pod: pod, PVC: pvc, Sources: map[string]string{}}
+	for _, f := range spec.Fields() {
+		m.Sources[f] = sourceDefault
+	}
+	for _, f := range local.SetFields() {
+		m.Sources[f] = sourceLocal
+	}
+	for _, f := range cfg.SetFields() {
+		m.Sources[f] = sourceConfig
+	}
+	for _, f := range proj.SetFields() {
+		m.Sources[f] = sourceProject
+	}
+
+	if templatePath != "" {
+		raw, err := os.ReadFile(templatePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template: %w", err)
+		}
+		tmpl, err := parseTemplate(renderTemplate(string(raw), namespace, eff))
+		if err != nil {
+			return nil, fmt.Errorf("invalid template %s: %w", templatePath, err)
+		}
+		if tmpl.pod != nil {
+			if m.Pod, err = mergeOver(m.Pod, tmpl.pod); err != nil {
+				return nil, fmt.Errorf("failed to merge template pod: %w", err)
+			}
+		}
+		if tmpl.pvc != nil {
+			if m.PVC, err = mergeOver(m.PVC, tmpl.pvc); err != nil {
+				return nil, fmt.Errorf("failed to merge template PVC: %w", err)
+			}
+		}
+		m.ServiceAccount = tmpl.sa
+
+		// Render again with every placeholder empty to learn which fields
+		// the template sets literally rather than through placeholders.
+		if literal, err := parseTemplate(renderTemplate(string(raw), namespace, spec.Spec{})); err == nil {
+			for _, f := range specFromPod(literal.pod, literal.pvc).SetFields() {
+				m.Sources[f] = sourceTemplate
+			}
+		}
+
+		// Flags override whatever the template says.
+		if err := applySpec(m.Pod, user); err != nil {
+			return nil, err
+		}
+		if err := applyStorage(m.PVC, user); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range user.SetFields() {
+		m.Sources[f] = sourceFlag
+	}
+
+	// Identity labels are what ls and rm rely on; a template cannot drop them.
+	m.Pod.Name, m.Pod.Namespace = eff.Name, namespace
+	m.Pod.Labels["app"], m.Pod.Labels["kdev/name"] = "kdev", eff.Name
+	m.PVC.Namespace = namespace
+	if m.PVC.Labels == nil {
+		m.PVC.Labels = map[string]string{}
+	}
+	m.PVC.Labels["app"], m.PVC.Labels["kdev/name"] = "kdev", eff.Name
+	if claim := specFromPod(m.Pod, nil).PVC; claim != "" {
+		m.PVC.Name = claim
+	}
+
+	if m.ServiceAccount == nil || m.ServiceAccount.Name != m.Pod.Spec.ServiceAccountName {
+		m.ServiceAccount = &corev1.ServiceAccount{}
+		m.ServiceAccount.Name = m.Pod.Spec.ServiceAccountName
+	}
+	m.ServiceAccount.APIVersion, m.ServiceAccount.Kind = "v1", "ServiceAccount"
+	m.ServiceAccount.Namespace = namespace
+
+	if sub := m.Pod.Spec.Subdomain; sub != "" {
+		m.Service = headlessService(namespace, sub)
+	}
+
+	syncSSHSidecar(m.Pod)
+	syncWebIDESidecar(m.Pod)
+
+	if err := validatePod(m.Pod); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// configDefaults returns the spec fields set in the user config.
+func configDefaults() spec.Spec {
+	if userConfig == nil {
+		return spec.Spec{}
+	}
+	return spec.Spec{
+		Annotations:    userConfig.Annotations,
+		Keepalive:      userConfig.Keepalive,
+		ServiceAccount: userConfig.ServiceAccount,
+		StorageClass:   userConfig.StorageClass,
+		StorageSize:    userConfig.StorageSize,
+		Shell:          userConfig.Shell,
+	}
+}
+
+// parsedTemplate holds the objects found in a template file.
+type parsedTemplate struct {
+	pod *corev1.Pod
+	pvc *corev1.PersistentVolumeClaim
+	sa  *corev1.ServiceAccount
+}
+
+var placeholderRe = regexp.MustCompile(`\{\{([A-Z_]+)\}\}`)
+
+// renderTemplate substitutes the {{PLACEHOLDER}} variables documented in the
+// README. Block placeholders (labels, env, node selector) are indented to fit
+// under the line preceding them.
+func renderTemplate(raw, namespace string, s spec.Spec) string {
+	scalars := map[string]string{
+		"NAME":            s.Name,
+		"NAMESPACE":       namespace,
+		"IMAGE":           s.Image,
+		"SERVICE_ACCOUNT": s.ServiceAccount,
+		"PVC_NAME":        s.PVC,
+		"WORKDIR":         s.Workdir,
+		"CPU":             s.CPU,
+		"MEMORY":          s.Memory,
+		"SHELL":           s.Shell,
+		"STORAGE_CLASS":   s.StorageClass,
+		"STORAGE_SIZE":    s.StorageSize,
+	}
+
+	lines := strings.Split(raw, "\n")
+	out := make([]string, 0, len(lines))
+	for i, line := range lines {
+		switch strings.TrimSpace(line) {
+		case "{{LABELS_EXTRA}}", "{{ENVS}}", "{{NODE_SELECTOR}}":
+			indent := 0
+			if i > 0 {
+				prev := lines[i-1]
+				indent = len(prev) - len(strings.TrimLeft(prev, " "))
+				if strings.HasSuffix(strings.TrimSpace(prev), ":") {
+					indent += 2
+				}
+			}
+			pad := strings.Repeat(" ", indent)
+			switch strings.TrimSpace(line) {
+			case "{{LABELS_EXTRA}}":
+				for _, k := range sortedKeys(s.Labels) {
+					out = append(out, pad+quote(k)+": "+quote(s.Labels[k]))
+				}
+			case "{{NODE_SELECTOR}}":
+				for _, k := range sortedKeys(s.NodeSelector) {
+					out = append(out, pad+quote(k)+": "+quote(s.NodeSelector[k]))
+				}
+			case "{{ENVS}}":
+				for _, k := range sortedKeys(s.Env) {
+					out = append(out, pad+"- name: "+quote(k), pad+"  value: "+quote(s.Env[k]))
+				}
+			}
+			continue
+		}
+		out = append(out, placeholderRe.ReplaceAllStringFunc(line, func(m string) string {
+			return scalars[placeholderRe.FindStringSubmatch(m)[1]]
+		}))
+	}
+	return strings.Join(out, "\n")
+}
+
+// quote renders s as a YAML-safe double-quoted scalar.
+func quote(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// parseTemplate decodes the Pod, PVC and ServiceAccount documents of a
+// multi-document YAML file. Empty placeholders leave null values behind; they
+// are dropped so they do not erase defaults when merged.
+func parseTemplate(rendered string) (*parsedTemplate, error) {
+	t := &parsedTemplate{}
+	for _, doc := range splitYAMLDocuments(rendered) {
+		var obj map[string]interface{}
+		if err := yaml.Unmarshal([]byte(doc), &obj); err != nil {
+			return nil, err
+		}
+		if obj == nil {
+			continue
+		}
+		data, err := json.Marshal(dropNulls(obj))
+		if err != nil {
+			return nil, err
+		}
+		switch obj["kind"] {
+		case "Pod":
+			t.pod = &corev1.Pod{}
+			err = json.Unmarshal(data, t.pod)
+		case "PersistentVolumeClaim":
+			t.pvc = &corev1.PersistentVolumeClaim{}
+			err = json.Unmarshal(data, t.pvc)
+		case "ServiceAccount":
+			t.sa = &corev1.ServiceAccount{}
+			err = json.Unmarshal(data, t.sa)
+		default:
+			return nil, fmt.Errorf("unsupported kind %v in template", obj["kind"])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %v: %w", obj["kind"], err)
+		}
+	}
+	return t, nil
+}
+
+func splitYAMLDocuments(s string) []string {
+	var docs []string
+	var cur bytes.Buffer
+	for _, line := range strings.Split(s, "\n") {
+		if strings.TrimRight(line, " ") == "---" {
+			docs = append(docs, cur.String())
+			cur.Reset()
+			continue
+		}
+		cur.WriteString(line)
+		cur.WriteByte('\n')
+	}
+	return append(docs, cur.String())
+}
+
+func dropNulls(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			if val == nil {
+				delete(t, k)
+				continue
+			}
+			t[k] = dropNulls(val)
+		}
+	case []interface{}:
+		for i := range t {
+			t[i] = dropNulls(t[i])
+		}
+	}
+	return v
+}
+
+// mergeOver deep-merges overlay onto base with Kubernetes strategic merge
+// semantics, so containers, env and volumes merge by name.
+func mergeOver[T any](base, overlay *T) (*T, error) {
+	baseJSON, err := json.Marshal(base)
+	if err != nil {
+		return nil, err
+	}
+	overlayJSON, err := json.Marshal(overlay)
+	if err != nil {
+		return nil, err
+	}
+	var zero T
+	merged, err := strategicpatch.StrategicMergePatch(baseJSON, overlayJSON, zero)
+	if err != nil {
+		return nil, err
+	}
+	out := new(T)
+	if err := json.Unmarshal(merged, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
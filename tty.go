@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/term"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// terminal is the local side of an interactive exec session: stdin in raw
+// mode so keys like Ctrl-C and arrows reach the remote shell, and the window
+// size reported to the remote TTY as it changes.
+type terminal struct {
+	in    int
+	out   int
+	state *term.State
+	sizes chan remotecommand.TerminalSize
+	last  remotecommand.TerminalSize
+	stop  chan struct{}
+}
+
+// rawTerminal prepares the terminal for an exec session. It returns nil when
+// stdin is not a terminal, e.g. when input is piped.
+func rawTerminal() (*terminal, error) {
+	t := &terminal{in: int(os.Stdin.Fd()), out: int(os.Stdout.Fd())}
+	if !term.IsTerminal(t.in) {
+		return nil, nil
+	}
+	state, err := term.MakeRaw(t.in)
+	if err != nil {
+		return nil, err
+	}
+	t.state = state
+	t.sizes = make(chan remotecommand.TerminalSize, 1)
+	t.stop = make(chan struct{})
+	t.resized()
+	go watchResize(t.stop, t.resized)
+	return t, nil
+}
+
+// resized queues the current window size if it changed, replacing one not
+// yet sent.
+func (t *terminal) resized() {
+	w, h, err := term.GetSize(t.out)
+	if err != nil {
+		return
+	}
+	size := remotecommand.TerminalSize{Width: uint16(w), Height: uint16(h)}
+	if size == t.last {
+		return
+	}
+	t.last = size
+	select {
+	case <-t.sizes:
+	default:
+	}
+	t.sizes <- size
+}
+
+// Next implements remotecommand.TerminalSizeQueue.
+func (t *terminal) Next() *remotecommand.TerminalSize {
+	select {
+	case size := <-t.sizes:
+		return &size
+	case <-t.stop:
+		return nil
+	}
+}
+
+// Restore leaves raw mode and stops watching the window size.
+func (t *terminal) Restore() {
+	close(t.stop)
+	_ = term.Restore(t.in, t.state)
+}
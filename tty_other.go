@@ -0,0 +1,28 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// setupConsole is only needed on Windows.
+func setupConsole() {}
+
+// watchResize calls resized on every SIGWINCH, which the terminal sends when
+// its window changes size.
+func watchResize(stop <-chan struct{}, resized func()) {
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-winch:
+			resized()
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// setupConsole lets the Windows console interpret the ANSI escape sequences
+// used by spinners, the picker and remote shells. Windows Terminal does this
+// by default; the classic console host needs it switched on.
+func setupConsole() {
+	for _, f := range []*os.File{os.Stdout, os.Stderr} {
+		h := windows.Handle(f.Fd())
+		var mode uint32
+		if windows.GetConsoleMode(h, &mode) == nil {
+			_ = windows.SetConsoleMode(h, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING)
+		}
+	}
+}
+
+// watchResize calls resized when the console window changes size. Windows
+// has no resize signal, so the size is polled.
+func watchResize(stop <-chan struct{}, resized func()) {
+	tick := time.NewTicker(250 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick.C:
+			resized()
+		}
+	}
+}
@@ -0,0 +1,580 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/noopduck/kdev/internal/devcontainer"
+	"github.com/noopduck/kdev/internal/progress"
+	"github.com/noopduck/kdev/internal/spec"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func cmdUp() *cobra.Command {
+	var (
+		user     spec.Spec
+		template string
+		labels   []string
+		annots   []string
+		envs     []string
+		envFiles []string
+		nodeSel  []string
+		ctrl     string
+		size     string
+		syncLoc  bool
+		open     bool
+		wait     bool
+		timeout  time.Duration
+		dryRun   bool
+		sshKeys  []string
+		dcPath   string
+		webIDE   bool
+		webOpts  webIDEOptions
+		noProj   bool
+		profile  string
+		tolers   []string
+		extraRes []string
+	)
+
+	c := &cobra.Command{
+		Use:   "up NAME",
+		Short: "Create (or update) a dev pod from a template",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			if user.Name, err = nameArg(args, user.Name); err != nil {
+				return err
+			}
+			ctx := context.Background()
+			proj := &project{}
+			if !noProj {
+				if proj, err = findAndLoadProject(ctx); err != nil {
+					return err
+				}
+			}
+			user.Name = cmp.Or(user.Name, proj.Name)
+			if user.Name == "" {
+				return errNameRequired
+			}
+			if user.Labels, err = parseKeyValues("label", labels); err != nil {
+				return err
+			}
+			if user.Annotations, err = parseKeyValues("annotation", annots); err != nil {
+				return err
+			}
+			if user.Env, err = readEnvFiles(envFiles); err != nil {
+				return err
+			}
+			flagEnv, err := parseKeyValues("env", envs)
+			if err != nil {
+				return err
+			}
+			for k, v := range flagEnv {
+				if user.Env == nil {
+					user.Env = map[string]string{}
+				}
+				user.Env[k] = v
+			}
+			if user.NodeSelector, err = parseKeyValues("node", nodeSel); err != nil {
+				return err
+			}
+			if user.ExtraResources, err = parseKeyValues("extra-resource", extraRes); err != nil {
+				return err
+			}
+			user.Tolerations = tolers
+			if !slices.Contains(controllerKinds, ctrl) {
+				return fmt.Errorf("invalid --controller %q (want one of %s)", ctrl, strings.Join(controllerKinds, ", "))
+			}
+			if len(sshKeys) > 0 {
+				user.SSH = true
+			}
+			if (webIDE || webOpts != webIDEOptions{}) && user.WebIDE == "" {
+				user.WebIDE = defaultWebIDEImage
+			}
+			if cmd.Flags().Changed("storage-class") && user.StorageClass == "" {
+				user.StorageClass = clusterDefaultStorageClass
+			}
+
+			if !cmd.Flags().Changed("sync-locale") && userConfig != nil && userConfig.SyncLocale != nil {
+				syncLoc = *userConfig.SyncLocale
+			}
+			// The local timezone and locale only fill gaps: the template,
+			// kdev.yaml and a profile override them.
+			var local spec.Spec
+			if syncLoc {
+				syncLocale(&local)
+			}
+
+			if size != "" {
+				shared, _, err := loadSharedConfig(ctx, flagNamespace)
+				if err != nil {
+					return err
+				}
+				if err := applySize(&user, size, availableSizes(shared)); err != nil {
+					return err
+				}
+			}
+			var fromProfile []string
+			if profile != "" {
+				if fromProfile, err = applyProfile(&user, profile); err != nil {
+					return err
+				}
+			}
+
+			var (
+				dc     *devcontainer.DevContainerConfig
+				comp   *devcontainer.Compose
+				fromDC []string
+			)
+			imageFromProj := false
+			if dcPath != "" {
+				// The image of kdev.yaml wins over the devcontainer's, as
+				// --image does.
+				if user.Image == "" && proj.Image != "" {
+					user.Image, imageFromProj = proj.Image, true
+				}
+				if dc, comp, fromDC, err = loadDevcontainer(dcPath, &user, template); err != nil {
+					return err
+				}
+			}
+
+			m, err := buildManifests(flagNamespace, local, user, proj.Spec, template)
+			if err != nil {
+				return err
+			}
+			for _, f := range fromProfile {
+				m.Sources[f] = sourceProfile
+			}
+			for _, f := range fromDC {
+				m.Sources[f] = sourceDevcontainer
+			}
+			if imageFromProj {
+				m.Sources["image"] = sourceProject
+			}
+			if dc != nil {
+				if err := applyDevcontainer(ctx, m, dc, comp, user); err != nil {
+					return err
+				}
+			}
+			if err := applyProject(m, proj); err != nil {
+				return err
+			}
+			if user.SSH {
+				if m.SSHSecret, err = buildSSHSecret(flagNamespace, m.Pod.Name, sshKeys); err != nil {
+					return err
+				}
+			}
+			if user.WebIDE != "" {
+				if m.WebIDESecret, m.WebIDEService, m.WebIDEIngress, err = buildWebIDE(flagNamespace, m.Pod.Name, webOpts); err != nil {
+					return err
+				}
+			}
+
+			if err := finishManifests(ctx, m, ctrl); err != nil {
+				return err
+			}
+			if dryRun {
+				return printManifests(os.Stdout, m, template)
+			}
+
+			name, err := createEnv(ctx, m, timeout)
+			if err != nil {
+				return err
+			}
+			if open {
+				wait = true
+			}
+			if wait {
+				if name, err = waitForNewEnv(ctx, m, name, timeout); err != nil {
+					return err
+				}
+				live, err := resolvePod(ctx, flagNamespace, name)
+				if err != nil {
+					return err
+				}
+				if err := runStartHooks(ctx, live); err != nil {
+					return err
+				}
+			} else if _, ok := m.Pod.Annotations[lifecycleAnnotation]; ok {
+				progress.Infof("The devcontainer lifecycle commands run on the first kdev attach, or pass --wait to run them now")
+			}
+
+			printUpSummary(ctx, os.Stdout, m, name, wait)
+			if open {
+				fmt.Println()
+				live, err := resolvePod(ctx, flagNamespace, name)
+				if err != nil {
+					return err
+				}
+				if err := runAttachHook(ctx, live); err != nil {
+					progress.Warnf("%v", err)
+				}
+				stop := startAutoForwards(ctx, m.Pod.Name, live)
+				defer stop()
+				shell, err := detectShell(ctx, name, devContainer, podShell(m.Pod))
+				if err != nil {
+					return err
+				}
+				_, err = attachShell(ctx, live, devContainer, shell, "")
+				return err
+			}
+			return nil
+		},
+	}
+
+	c.Flags().StringVar(&user.Name, "name", "", "Pod name")
+	c.Flags().StringVar(&template, "template", "", "Pod template used as base; flags override it (e.g. templates/pod.yaml)")
+	c.Flags().StringVar(&user.Image, "image", "", "Container image (required unless set by the template or the devcontainer.json of --from-devcontainer)")
+	c.Flags().StringVar(&user.ServiceAccount, "service-account", "", "ServiceAccount name (default: serviceAccount in the user config, then dev-vscode)")
+	c.Flags().StringVar(&user.PVC, "pvc", "", "PVC name to mount (default: same as name)")
+	c.Flags().StringVar(&user.Workdir, "workdir", "", "Workspace directory inside container (default /workspaces)")
+	c.Flags().StringSliceVar(&labels, "label", nil, "Extra labels key=value (repeatable)")
+	c.Flags().StringArrayVar(&annots, "annotation", nil, "Annotations key=value for the pod and PVC (repeatable; overrides config annotations)")
+	c.Flags().StringSliceVar(&envs, "env", nil, "Env vars KEY=VALUE (repeatable)")
+	c.Flags().StringSliceVar(&user.EnvFrom, "env-from", nil, "Import all keys of secret/NAME or configmap/NAME as env vars (repeatable)")
+	c.Flags().StringArrayVar(&envFiles, "env-file", nil, "Read env vars from a dotenv file (repeatable; later files and --env override)")
+	c.Flags().StringVar(&size, "size", "", "Resource preset: s (small), m (medium), l (large), xl or one defined in the shared or user config (--cpu/--memory/--storage override it)")
+	c.Flags().StringVar(&user.CPU, "cpu", "", "CPU request/limit, e.g. 500m")
+	c.Flags().StringVar(&user.Memory, "memory", "", "Memory request/limit, e.g. 1Gi")
+	c.Flags().StringArrayVar(&extraRes, "extra-resource", nil, "Extended resource name=quantity requested and limited, e.g. nvidia.com/gpu=1 (repeatable)")
+	c.Flags().StringSliceVar(&nodeSel, "node", nil, "Node selector key=value (repeatable)")
+	c.Flags().StringArrayVar(&tolers, "toleration", nil, "Tolerate a node taint, key[=value][:effect] as in kubectl taint (repeatable)")
+	c.Flags().StringVar(&profile, "profile", "", "Settings bundle from profiles in the user config, e.g. gpu; flags override it")
+	c.Flags().StringVar(&user.Hostname, "hostname", "", "Pod hostname (default: the pod name)")
+	c.Flags().StringVar(&user.Subdomain, "subdomain", "", "Join the headless Service of this name, giving the pod the DNS name <hostname>.<subdomain>.<namespace>.svc")
+	c.Flags().StringVar(&user.Timezone, "timezone", "", "Timezone for TZ and /etc/localtime, e.g. Europe/Oslo (default: the local timezone)")
+	c.Flags().StringVar(&user.Locale, "locale", "", "Locale for LANG, e.g. en_US.UTF-8 (default: the local LANG)")
+	c.Flags().BoolVar(&syncLoc, "sync-locale", true, "Copy the local timezone and locale into the pod unless --timezone/--locale are given")
+	c.Flags().StringVar(&user.Shell, "shell", "", "Shell kdev attach starts (default: shell in the user config, then /bin/bash)")
+	c.Flags().StringVar(&user.User, "user", "", "UID[:GID] the pod runs as (default 1000:1000, or the remoteUser/containerUser of --from-devcontainer)")
+	c.Flags().StringVar(&user.Keepalive, "keepalive", "", "Main process keeping the container alive: auto, sleep (POSIX sh), pause (busybox for images without a shell), entrypoint (the image's own) or a shell command (default auto)")
+	c.Flags().StringVar(&user.StorageClass, "storage-class", "", "StorageClass for the PVC (default: storageClass in the user config, then local-path; \"\" or \"default\" uses the cluster default)")
+	c.Flags().StringVar(&user.StorageSize, "storage", "", "PVC storage size (default: storageSize in the user config, then 20Gi)")
+	c.Flags().StringSliceVar(&user.ImagePullSecrets, "image-pull-secret", nil, "Secret used to pull the image (repeatable)")
+	c.Flags().BoolVar(&user.SSH, "ssh", false, "Add an SSH server sidecar for kdev ssh, scp, rsync and remote-SSH IDEs (the image needs openssh-server)")
+	c.Flags().StringArrayVar(&sshKeys, "ssh-key", nil, "Public key file allowed to log in over SSH (repeatable; default: ~/.ssh/*.pub; implies --ssh)")
+	c.Flags().BoolVar(&webIDE, "web-ide", false, "Add a code-server sidecar serving VS Code in the browser, behind a Service and a generated password")
+	c.Flags().StringVar(&user.WebIDE, "web-ide-image", "", "code-server image for --web-ide (default "+defaultWebIDEImage+"; implies --web-ide)")
+	c.Flags().StringVar(&webOpts.host, "web-ide-host", "", "Expose the web IDE through an Ingress for this host (implies --web-ide)")
+	c.Flags().StringVar(&webOpts.tlsSecret, "web-ide-tls-secret", "", "TLS Secret for the --web-ide-host Ingress")
+	c.Flags().StringVar(&webOpts.ingressClass, "web-ide-ingress-class", "", "IngressClass for the --web-ide-host Ingress (default: the cluster default)")
+	c.Flags().StringVar(&ctrl, "controller", controllerPod, "What manages the pod: pod (a bare pod), statefulset (rescheduled after node failures) or deployment (recreated after eviction or drain)")
+	c.Flags().StringVar(&dcPath, "from-devcontainer", "", "Use a devcontainer.json (default "+devcontainer.DefaultPath+"): its image or docker-compose services, remoteUser, containerEnv, remoteEnv, mounts and lifecycle commands apply to the environment and attach forwards its forwardPorts")
+	c.Flags().Lookup("from-devcontainer").NoOptDefVal = devcontainer.DefaultPath
+	c.Flags().BoolVar(&wait, "wait", false, "Wait until the pod is ready, showing scheduling, volume and image events; fails with the reason on timeout")
+	c.Flags().BoolVar(&open, "open", false, "Wait for the pod and attach a shell right away")
+	c.Flags().DurationVar(&timeout, "timeout", 5*time.Minute, "How long --wait waits for the pod")
+	c.Flags().BoolVar(&noProj, "no-project", false, "Ignore the kdev.yaml of the project")
+	c.Flags().BoolVar(&dryRun, "dry-run", false, "Print the resulting manifests and where each field came from, without creating anything")
+
+	deprecateNameFlag(c)
+	_ = c.RegisterFlagCompletionFunc("template", completeYAMLFiles)
+	_ = c.MarkFlagFilename("env-file")
+	_ = c.MarkFlagFilename("ssh-key", "pub")
+	_ = c.RegisterFlagCompletionFunc("env-from", completeEnvFromRefs)
+	_ = c.RegisterFlagCompletionFunc("size", completeSizes)
+	_ = c.RegisterFlagCompletionFunc("profile", completeProfiles)
+	_ = c.RegisterFlagCompletionFunc("storage-class", completeStorageClasses)
+	_ = c.RegisterFlagCompletionFunc("keepalive", cobra.FixedCompletions(keepaliveModes, cobra.ShellCompDirectiveNoFileComp))
+	_ = c.RegisterFlagCompletionFunc("controller", cobra.FixedCompletions(controllerKinds, cobra.ShellCompDirectiveNoFileComp))
+	return c
+}
+
+// finishManifests resolves the StorageClass and image mirrors, mounts the
+// local zoneinfo and wraps the pod in the requested controller.
+func finishManifests(ctx context.Context, m *manifests, ctrl string) error {
+	storageClassName, err := resolveStorageClass(ctx, ptrValue(m.PVC.Spec.StorageClassName))
+	if err != nil {
+		return err
+	}
+	m.PVC.Spec.StorageClassName = storageClassName
+	for _, pvc := range m.Volumes {
+		pvc.Spec.StorageClassName = storageClassName
+	}
+	if err := resolvePodImages(&m.Pod.Spec); err != nil {
+		return err
+	}
+	if tz := specFromPod(m.Pod, nil).Timezone; tz != "" {
+		if _, ok := readZoneinfo(tz); ok {
+			mountZoneinfo(m.Pod, tz)
+		}
+	}
+	switch ctrl {
+	case controllerStatefulSet:
+		m.StatefulSet = buildStatefulSet(m)
+	case controllerDeployment:
+		m.Deployment = buildDeployment(m)
+	}
+	return nil
+}
+
+// createEnv creates the objects of m, dependencies first, or converges
+// existing ones to m with server-side apply. It returns the name of the pod
+// to wait for; timeout bounds the wait when a pod has to be recreated.
+func createEnv(ctx context.Context, m *manifests, timeout time.Duration) (string, error) {
+	if err := checkEnvFrom(ctx, flagNamespace, specFromPod(m.Pod, nil).EnvFrom); err != nil {
+		return "", err
+	}
+
+	// The ServiceAccount must exist before the pod references it
+	if err := ensureServiceAccount(ctx, m.ServiceAccount); err != nil {
+		return "", err
+	}
+
+	if m.Service != nil {
+		if err := ensureHeadlessService(ctx, m.Service); err != nil {
+			return "", err
+		}
+	}
+	if err := ensureSSHSecret(ctx, m); err != nil {
+		return "", err
+	}
+	if err := ensureWebIDE(ctx, m); err != nil {
+		return "", err
+	}
+	if tz := specFromPod(m.Pod, nil).Timezone; tz != "" {
+		if zoneinfo, ok := readZoneinfo(tz); ok {
+			if err := ensureZoneinfo(ctx, flagNamespace, tz, zoneinfo); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if err := ensureVolumes(ctx, m); err != nil {
+		return "", err
+	}
+	if err := checkControllerKind(ctx, m); err != nil {
+		return "", err
+	}
+	name := m.Pod.Name
+	if m.StatefulSet != nil {
+		action, err := applyStatefulSet(ctx, m.StatefulSet)
+		if err != nil {
+			return "", err
+		}
+		reportApplied("StatefulSet", name, action)
+		return name + "-0", nil
+	}
+
+	if err := applyPVC(ctx, m.PVC); err != nil {
+		return "", err
+	}
+	var (
+		kind   = "Pod"
+		action string
+		err    error
+	)
+	if m.Deployment != nil {
+		kind = "Deployment"
+		action, err = applyDeployment(ctx, m.Deployment)
+	} else {
+		action, err = applyPod(ctx, m.Pod, timeout)
+	}
+	if err != nil {
+		return "", err
+	}
+	reportApplied(kind, name, action)
+	return name, nil
+}
+
+// reportApplied notes what re-running up did to an existing object.
+func reportApplied(kind, name, action string) {
+	if action != "created" {
+		progress.Infof("%s %s %s", kind, name, action)
+	}
+}
+
+// waitForNewEnv waits until the pod created by createEnv is ready and
+// returns its name, which changes for Deployments.
+func waitForNewEnv(ctx context.Context, m *manifests, name string, timeout time.Duration) (string, error) {
+	sp := progress.Start(fmt.Sprintf("Waiting for pod %s to become ready", name))
+	evCtx, stopEvents := context.WithCancel(ctx)
+	defer stopEvents()
+	streaming := make(chan struct{})
+	go func() {
+		defer close(streaming)
+		streamPodEvents(evCtx, flagNamespace, envPodMatcher(evCtx, m, name), sp)
+	}()
+	var (
+		pod *corev1.Pod
+		err error
+	)
+	if m.Deployment != nil {
+		pod, err = waitForEnvPod(ctx, flagNamespace, name, timeout)
+	} else {
+		pod, err = waitForPod(ctx, flagNamespace, name, timeout)
+	}
+	stopEvents()
+	<-streaming
+	sp.Done(err)
+	if err != nil {
+		return "", err
+	}
+	return pod.Name, nil
+}
+
+// envPodMatcher tells whether a pod name belongs to the environment being
+// waited for. Deployment pods get generated names, so those are looked up
+// once and recognised by their kdev/name label.
+func envPodMatcher(ctx context.Context, m *manifests, name string) func(string) bool {
+	if m.Deployment == nil {
+		return func(pod string) bool { return pod == name }
+	}
+	known := map[string]bool{}
+	return func(pod string) bool {
+		if ok, seen := known[pod]; seen {
+			return ok
+		}
+		p, err := kubeClient.CoreV1().Pods(flagNamespace).Get(ctx, pod, metav1.GetOptions{})
+		known[pod] = err == nil && p.Labels["kdev/name"] == name
+		return known[pod]
+	}
+}
+
+// createOnMissing creates environment name for attach --create the way
+// kdev up does, so the project kdev.yaml, profile, size and user config
+// apply alike. It asks on the terminal for an image and size when neither
+// the flags nor kdev.yaml give one, and waits until the pod is ready.
+func createOnMissing(ctx context.Context, name, image, size, profile, shell string, timeout time.Duration) (*corev1.Pod, error) {
+	switch envStatusWithoutPod(ctx, flagNamespace, name) {
+	case statusHibernated:
+		return nil, fmt.Errorf("environment %s is hibernated; use 'kdev wake %s' to restore it", name, name)
+	case statusStopped:
+		return nil, fmt.Errorf("environment %s is stopped; use 'kdev start %s' to start it", name, name)
+	}
+
+	var projImage string
+	if path, err := findProjectFile("."); err == nil && path != "" {
+		if proj, err := loadProject(path); err == nil {
+			projImage = proj.Image
+		}
+	}
+	if image == "" && projImage == "" && profile == "" {
+		shared, _, err := loadSharedConfig(ctx, flagNamespace)
+		if err != nil {
+			return nil, err
+		}
+		progress.Infof("Environment %s does not exist in namespace %s", name, flagNamespace)
+		if image, err = ask("Image", ""); err != nil {
+			return nil, fmt.Errorf("%w; pass --image to create %s", err, name)
+		}
+		if image == "" {
+			return nil, errors.New("an image is required to create the environment")
+		}
+		if size == "" {
+			if size, err = ask("Size ("+strings.Join(sizeNames(availableSizes(shared)), ", ")+"; empty for defaults)", ""); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	args := []string{name, "--wait", "--timeout", timeout.String()}
+	for _, f := range [][2]string{{"image", image}, {"size", size}, {"profile", profile}, {"shell", shell}} {
+		if f[1] != "" {
+			args = append(args, "--"+f[0], f[1])
+		}
+	}
+	if err := runCommand(ctx, cmdUp(), args...); err != nil {
+		return nil, err
+	}
+	return resolvePod(ctx, flagNamespace, name)
+}
+
+// printManifests writes the rendered objects as a multi-document YAML stream,
+// preceded by a comment block naming the source of each spec field.
+func printManifests(w io.Writer, m *manifests, template string) error {
+	eff := specFromPod(m.Pod, m.PVC)
+	values, err := specValues(eff)
+	if err != nil {
+		return err
+	}
+	if template != "" {
+		fmt.Fprintf(w, "# Field sources (template: %s):\n", template)
+	} else {
+		fmt.Fprintln(w, "# Field sources:")
+	}
+	for _, f := range spec.Fields() {
+		v, ok := values[f]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(w, "#   %-17s %-9s %s\n", f, m.Sources[f], v)
+	}
+	objs := []interface{}{m.ServiceAccount, m.PVC, m.Pod}
+	switch {
+	case m.StatefulSet != nil:
+		objs = []interface{}{m.ServiceAccount, m.StatefulSet}
+	case m.Deployment != nil:
+		objs = []interface{}{m.ServiceAccount, m.PVC, m.Deployment}
+	}
+	for _, pvc := range m.Volumes {
+		objs = append(objs, pvc)
+	}
+	if m.Service != nil {
+		objs = append(objs, m.Service)
+	}
+	if m.SSHSecret != nil {
+		objs = append(objs, m.SSHSecret)
+	}
+	if m.WebIDEService != nil {
+		// The password is generated anew on every run; never print it.
+		secret := m.WebIDESecret.DeepCopy()
+		secret.Data = nil
+		secret.StringData = map[string]string{webIDEPasswordKey: "<generated on create>"}
+		objs = append(objs, secret, m.WebIDEService)
+	}
+	if m.WebIDEIngress != nil {
+		objs = append(objs, m.WebIDEIngress)
+	}
+	for _, obj := range objs {
+		out, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "---\n%s", out)
+	}
+	return nil
+}
+
+// specValues renders each set field of s as compact JSON for display.
+func specValues(s spec.Spec) (map[string]string, error) {
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := yaml.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	out := make(map[string]string, len(fields))
+	for k, v := range fields {
+		if str, ok := v.(string); ok {
+			out[k] = str
+			continue
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		out[k] = string(b)
+	}
+	return out, nil
+}
+
+func ptrValue(p *string) string {
+	if p == nil {
+		return ""
+	}
+	return *p
+}
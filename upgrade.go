@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/noopduck/kdev/internal/progress"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// Set at release build time with -ldflags "-X main.buildVersion=v1.2.3
+// -X main.releasePublicKey=<base64 ed25519 key>".
+var (
+	buildVersion     = "dev"
+	releasePublicKey = ""
+)
+
+const releasesAPI = "https://api.github.com/repos/noopduck/kdev/releases"
+
+// githubRelease is the subset of the GitHub release API kdev uses.
+type githubRelease struct {
+	TagName    string `json:"tag_name"`
+	Prerelease bool   `json:"prerelease"`
+	Draft      bool   `json:"draft"`
+	Assets     []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+func (r *githubRelease) assetURL(name string) string {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL
+		}
+	}
+	return ""
+}
+
+func cmdUpgrade() *cobra.Command {
+	var (
+		channel   string
+		toVersion string
+		check     bool
+	)
+
+	c := &cobra.Command{
+		Use:         "upgrade",
+		Short:       "Replace this kdev binary with the latest GitHub release",
+		Annotations: map[string]string{annotationNoCluster: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := requireOnline("kdev upgrade"); err != nil {
+				return err
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+			defer cancel()
+
+			rel, err := findRelease(ctx, channel, toVersion)
+			if err != nil {
+				return err
+			}
+			if toVersion == "" && !isNewer(rel.TagName, buildVersion) {
+				fmt.Printf("kdev %s is up to date (latest %s release: %s)\n", buildVersion, channel, rel.TagName)
+				return nil
+			}
+			if check {
+				fmt.Printf("kdev %s is available (current: %s). Run 'kdev upgrade' to install it.\n", rel.TagName, buildVersion)
+				return nil
+			}
+
+			asset := fmt.Sprintf("kdev-%s-%s", runtime.GOOS, runtime.GOARCH)
+			if runtime.GOOS == "windows" {
+				asset += ".exe"
+			}
+			binURL := rel.assetURL(asset)
+			if binURL == "" {
+				return fmt.Errorf("release %s has no binary for %s/%s", rel.TagName, runtime.GOOS, runtime.GOARCH)
+			}
+			sumsURL := rel.assetURL("checksums.txt")
+			if sumsURL == "" {
+				return fmt.Errorf("release %s has no checksums.txt; refusing to install an unverified binary", rel.TagName)
+			}
+
+			sums, err := download(ctx, sumsURL)
+			if err != nil {
+				return err
+			}
+			if err := verifyChecksumsSignature(ctx, rel, sums); err != nil {
+				return err
+			}
+			want, err := checksumFor(sums, asset)
+			if err != nil {
+				return err
+			}
+
+			sp := progress.Start(fmt.Sprintf("Downloading kdev %s (%s)", rel.TagName, asset))
+			bin, err := download(ctx, binURL)
+			sp.Done(err)
+			if err != nil {
+				return err
+			}
+			got := sha256.Sum256(bin)
+			if hex.EncodeToString(got[:]) != want {
+				return fmt.Errorf("checksum mismatch for %s: expected %s, got %x", asset, want, got)
+			}
+
+			if err := replaceExecutable(bin); err != nil {
+				return err
+			}
+			fmt.Printf("✅ kdev upgraded from %s to %s\n", buildVersion, rel.TagName)
+			return nil
+		},
+	}
+
+	c.Flags().StringVar(&channel, "channel", "stable", "Release channel: stable or prerelease")
+	c.Flags().StringVar(&toVersion, "to-version", "", "Install this release tag (e.g. v0.3.0) instead of the latest")
+	c.Flags().BoolVar(&check, "check", false, "Only report whether a newer release exists")
+	return c
+}
+
+// findRelease picks the release to install: an explicit tag, or the newest
+// release on the channel.
+func findRelease(ctx context.Context, channel, tag string) (*githubRelease, error) {
+	if tag != "" {
+		var rel githubRelease
+		if err := getJSON(ctx, releasesAPI+"/tags/"+tag, &rel); err != nil {
+			return nil, fmt.Errorf("failed to find release %s: %w", tag, err)
+		}
+		return &rel, nil
+	}
+	switch channel {
+	case "stable":
+		var rel githubRelease
+		if err := getJSON(ctx, releasesAPI+"/latest", &rel); err != nil {
+			return nil, fmt.Errorf("failed to find latest release: %w", err)
+		}
+		return &rel, nil
+	case "prerelease":
+		var rels []githubRelease
+		if err := getJSON(ctx, releasesAPI+"?per_page=20", &rels); err != nil {
+			return nil, fmt.Errorf("failed to list releases: %w", err)
+		}
+		for i := range rels {
+			if !rels[i].Draft {
+				return &rels[i], nil
+			}
+		}
+		return nil, errors.New("no releases found")
+	default:
+		return nil, fmt.Errorf("unknown channel %q (use stable or prerelease)", channel)
+	}
+}
+
+// isNewer reports whether tag is a newer version than current. Development
+// builds are always considered older.
+func isNewer(tag, current string) bool {
+	latest, err := version.ParseSemantic(tag)
+	if err != nil {
+		return false
+	}
+	cur, err := version.ParseSemantic(current)
+	if err != nil {
+		return true
+	}
+	return latest.GreaterThan(cur)
+}
+
+// verifyChecksumsSignature checks checksums.txt.sig against the ed25519
+// release key compiled into the binary.
+func verifyChecksumsSignature(ctx context.Context, rel *githubRelease, sums []byte) error {
+	if releasePublicKey == "" {
+		progress.Warnf("this kdev build has no release signing key; only checksums are verified")
+		return nil
+	}
+	key, err := base64.StdEncoding.DecodeString(releasePublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New("invalid release public key compiled into kdev")
+	}
+	sigURL := rel.assetURL("checksums.txt.sig")
+	if sigURL == "" {
+		return fmt.Errorf("release %s is not signed (missing checksums.txt.sig)", rel.TagName)
+	}
+	sig, err := download(ctx, sigURL)
+	if err != nil {
+		return err
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig))); err == nil {
+		sig = decoded
+	}
+	if !ed25519.Verify(ed25519.PublicKey(key), sums, sig) {
+		return fmt.Errorf("signature verification of checksums.txt failed for %s", rel.TagName)
+	}
+	return nil
+}
+
+// checksumFor finds the sha256 of name in a sha256sum-formatted file.
+func checksumFor(sums []byte, name string) (string, error) {
+	sc := bufio.NewScanner(strings.NewReader(string(sums)))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("checksums.txt has no entry for %s", name)
+}
+
+// replaceExecutable swaps the running binary for bin. The new file is
+// written next to the old one and renamed over it so a failed download never
+// leaves a half-written kdev behind.
+func replaceExecutable(bin []byte) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate kdev binary: %w", err)
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return fmt.Errorf("failed to resolve kdev binary: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".kdev-upgrade-*")
+	if err != nil {
+		return fmt.Errorf("cannot write next to %s (try with sudo): %w", exe, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(bin); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write new binary: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o755); err != nil {
+		return err
+	}
+	if runtime.GOOS == "windows" {
+		// A running executable cannot be overwritten on Windows, but it
+		// can be renamed out of the way.
+		old := exe + ".old"
+		_ = os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			return fmt.Errorf("failed to move old binary aside: %w", err)
+		}
+	}
+	if err := os.Rename(tmp.Name(), exe); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", exe, err)
+	}
+	return nil
+}
+
+func getJSON(ctx context.Context, url string, into interface{}) error {
+	body, err := download(ctx, url)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, into)
+}
+
+func download(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "kdev/"+buildVersion)
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" && strings.HasPrefix(url, "https://api.github.com/") {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
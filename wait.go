@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/noopduck/kdev/internal/progress"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+)
+
+// waitForPod blocks until every container of the pod is ready. It gives up
+// early when the pod fails or its image cannot be pulled, and returns the
+// last observed pod together with the error.
+func waitForPod(ctx context.Context, namespace, name string, timeout time.Duration) (*corev1.Pod, error) {
+	opts := metav1.ListOptions{FieldSelector: fields.OneTermEqualSelector("metadata.name", name).String()}
+	return waitForPods(ctx, namespace, "pod "+name, opts, false, timeout)
+}
+
+// waitForEnvPod is waitForPod for controller-managed environments, whose
+// pod name is not known in advance and whose pods may be replaced while
+// waiting.
+func waitForEnvPod(ctx context.Context, namespace, name string, timeout time.Duration) (*corev1.Pod, error) {
+	opts := metav1.ListOptions{LabelSelector: labels.Set(envSelector(name)).String()}
+	return waitForPods(ctx, namespace, "environment "+name, opts, true, timeout)
+}
+
+// waitForPods waits until a pod matching opts is ready. With replaceable,
+// deleted and terminating pods are ignored because a controller recreates
+// them.
+func waitForPods(ctx context.Context, namespace, desc string, opts metav1.ListOptions, replaceable bool, timeout time.Duration) (*corev1.Pod, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	lw := &cache.ListWatch{
+		ListWithContextFunc: func(ctx context.Context, o metav1.ListOptions) (runtime.Object, error) {
+			o.FieldSelector, o.LabelSelector = opts.FieldSelector, opts.LabelSelector
+			return kubeClient.CoreV1().Pods(namespace).List(ctx, o)
+		},
+		WatchFuncWithContext: func(ctx context.Context, o metav1.ListOptions) (watch.Interface, error) {
+			o.FieldSelector, o.LabelSelector = opts.FieldSelector, opts.LabelSelector
+			return kubeClient.CoreV1().Pods(namespace).Watch(ctx, o)
+		},
+	}
+
+	var last *corev1.Pod
+	_, err := watchtools.UntilWithSync(ctx, lw, &corev1.Pod{}, nil, func(ev watch.Event) (bool, error) {
+		if ev.Type == watch.Deleted {
+			if replaceable {
+				return false, nil
+			}
+			return false, fmt.Errorf("%s was deleted while waiting", desc)
+		}
+		pod, ok := ev.Object.(*corev1.Pod)
+		if !ok || (replaceable && pod.DeletionTimestamp != nil) {
+			return false, nil
+		}
+		last = pod
+		if pod.Status.Phase == corev1.PodFailed || pod.Status.Phase == corev1.PodSucceeded {
+			return false, fmt.Errorf("pod %s exited (%s)", pod.Name, podStatus(pod))
+		}
+		if err := diagnoseImagePull(ctx, pod); err != nil {
+			return false, err
+		}
+		if err := diagnoseVolumeConflict(pod); err != nil {
+			return false, err
+		}
+		return podReady(pod), nil
+	})
+	if wait.Interrupted(err) || errors.Is(err, watchtools.ErrWatchClosed) {
+		status := "not created"
+		if last != nil {
+			status = podStatus(last)
+			// ctx is done; give the lookup of the reason a moment of its own.
+			lctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if ev := latestPodEvent(lctx, last, fields.Set{"type": corev1.EventTypeWarning}); ev != nil {
+				status += fmt.Sprintf("; last warning: %s: %s", ev.Reason, ev.Message)
+			}
+		}
+		return last, fmt.Errorf("timed out after %s waiting for %s (status: %s)", timeout, desc, status)
+	}
+	return last, err
+}
+
+// podReady reports whether the pod is running with all containers ready.
+func podReady(pod *corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodRunning || pod.DeletionTimestamp != nil {
+		return false
+	}
+	for _, c := range pod.Status.ContainerStatuses {
+		if !c.Ready {
+			return false
+		}
+	}
+	return len(pod.Status.ContainerStatuses) > 0
+}
+
+// imagePullError explains why the kubelet could not pull a container image.
+type imagePullError struct {
+	image  string
+	cause  string
+	detail string
+	hints  []string
+}
+
+func (e *imagePullError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "failed to pull image %q: %s", e.image, e.cause)
+	if e.detail != "" {
+		fmt.Fprintf(&b, "\n  registry said: %s", e.detail)
+	}
+	for _, h := range e.hints {
+		fmt.Fprintf(&b, "\nhint: %s", h)
+	}
+	return b.String()
+}
+
+// diagnoseImagePull returns an *imagePullError when a container of pod is
+// stuck pulling its image, nil otherwise.
+func diagnoseImagePull(ctx context.Context, pod *corev1.Pod) error {
+	for _, c := range slices.Concat(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses) {
+		w := c.State.Waiting
+		if w == nil {
+			continue
+		}
+		switch w.Reason {
+		case "ErrImagePull", "ImagePullBackOff", "InvalidImageName", "ErrImageNeverPull":
+		default:
+			continue
+		}
+		// The back-off message only says "Back-off pulling image"; the
+		// registry's answer is in the latest Failed event.
+		detail := w.Message
+		if msg := latestPullFailure(ctx, pod); msg != "" {
+			detail = msg
+		}
+		return classifyPullFailure(pod.Namespace, c.Image, w.Reason, detail)
+	}
+	return nil
+}
+
+func classifyPullFailure(namespace, image, reason, detail string) *imagePullError {
+	msg := strings.ToLower(detail)
+	e := &imagePullError{image: image, detail: detail}
+	switch {
+	case reason == "InvalidImageName":
+		e.cause = "invalid image reference"
+		e.hints = []string{"image names look like registry.example.com/team/image:tag"}
+	case containsAny(msg, "unauthorized", "authentication required", "denied", "401", "403", "forbidden"):
+		e.cause = "registry requires authentication"
+		e.hints = []string{
+			"create a pull secret: kubectl create secret docker-registry regcred --docker-server=<registry> --docker-username=<user> --docker-password=<token> -n " + namespace,
+			"then pass --image-pull-secret regcred to kdev up",
+		}
+	case containsAny(msg, "no matching manifest", "platform", "exec format"):
+		e.cause = "image not built for the node's architecture"
+		e.hints = []string{"build a multi-arch image (kdev devcontainer build --platform linux/amd64,linux/arm64) or use --node kubernetes.io/arch=<arch>"}
+	case containsAny(msg, "manifest unknown", "not found", "404", "does not exist"):
+		e.cause = "image or tag does not exist"
+		e.hints = []string{"check the tag for typos", "push the image first: kdev devcontainer build --push"}
+	case containsAny(msg, "no such host", "i/o timeout", "connection refused", "tls", "x509"):
+		e.cause = "registry unreachable from the node"
+		e.hints = []string{"check that cluster nodes can resolve and reach the registry (proxy, firewall, private CA)"}
+	default:
+		e.cause = reason
+		e.hints = []string{"run kubectl describe pod to see the full event history"}
+	}
+	return e
+}
+
+// latestPullFailure returns the message of the newest "Failed" event for pod,
+// which carries the kubelet's pull error.
+func latestPullFailure(ctx context.Context, pod *corev1.Pod) string {
+	if ev := latestPodEvent(ctx, pod, fields.Set{"reason": "Failed"}); ev != nil {
+		return ev.Message
+	}
+	return ""
+}
+
+// latestPodEvent returns the newest event of pod matching the extra field
+// selectors in match, or nil.
+func latestPodEvent(ctx context.Context, pod *corev1.Pod, match fields.Set) *corev1.Event {
+	sel := fields.Set{
+		"involvedObject.kind": "Pod",
+		"involvedObject.name": pod.Name,
+		"involvedObject.uid":  string(pod.UID),
+	}
+	for k, v := range match {
+		sel[k] = v
+	}
+	events, err := kubeClient.CoreV1().Events(pod.Namespace).List(ctx, metav1.ListOptions{FieldSelector: sel.AsSelector().String()})
+	if err != nil {
+		return nil
+	}
+	var latest *corev1.Event
+	for i := range events.Items {
+		e := &events.Items[i]
+		// Not every API implementation honours all event field selectors.
+		if (match["type"] != "" && e.Type != match["type"]) || (match["reason"] != "" && e.Reason != match["reason"]) {
+			continue
+		}
+		if latest == nil || eventTime(latest).Before(eventTime(e)) {
+			latest = e
+		}
+	}
+	return latest
+}
+
+// streamPodEvents reports the events of the pods accepted by match until
+// ctx ends. Warnings such as FailedScheduling, FailedMount or BackOff are
+// printed above the spinner, each distinct message once; normal progress
+// (Scheduled, Pulling, Started) becomes the spinner's detail.
+func streamPodEvents(ctx context.Context, namespace string, match func(podName string) bool, sp *progress.Spinner) {
+	events := kubeClient.CoreV1().Events(namespace)
+	kindPod := fields.OneTermEqualSelector("involvedObject.kind", "Pod").String()
+	// Start after the events that exist already, which belong to earlier pods.
+	list, err := events.List(ctx, metav1.ListOptions{FieldSelector: kindPod, Limit: 1})
+	if err != nil {
+		return
+	}
+	w, err := events.Watch(ctx, metav1.ListOptions{FieldSelector: kindPod, ResourceVersion: list.ResourceVersion})
+	if err != nil {
+		return
+	}
+	defer w.Stop()
+
+	seen := map[string]bool{}
+	for {
+		var ev watch.Event
+		select {
+		case <-ctx.Done():
+			return
+		case ev = <-w.ResultChan():
+		}
+		if ev.Object == nil {
+			// The watch ended.
+			return
+		}
+		e, ok := ev.Object.(*corev1.Event)
+		if !ok || ev.Type == watch.Deleted || !match(e.InvolvedObject.Name) {
+			continue
+		}
+		if e.Type != corev1.EventTypeWarning {
+			sp.Detail(e.Message)
+			continue
+		}
+		if key := e.Reason + "\x00" + e.Message; !seen[key] {
+			seen[key] = true
+			sp.Logf("%s: %s", e.Reason, e.Message)
+		}
+	}
+}
+
+func eventTime(ev *corev1.Event) time.Time {
+	if !ev.LastTimestamp.IsZero() {
+		return ev.LastTimestamp.Time
+	}
+	return ev.EventTime.Time
+}
+
+func containsAny(s string, subs ...string) bool {
+	for _, sub := range subs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// waitForPodDeleted polls until the pod is gone.
+func waitForPodDeleted(ctx context.Context, namespace, name string, timeout time.Duration) error {
+	err := wait.PollUntilContextTimeout(ctx, time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		_, err := kubeClient.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+	if wait.Interrupted(err) {
+		return fmt.Errorf("timed out after %s waiting for pod %s to terminate", timeout, name)
+	}
+	return err
+}
+
+// runningEnvPod returns the ready pod of environment name, starting it
+// first when it was stopped or hibernated and waiting up to timeout.
+func runningEnvPod(ctx context.Context, name string, timeout time.Duration) (*corev1.Pod, error) {
+	pod, err := resolvePod(ctx, flagNamespace, name)
+	if apierrors.IsNotFound(err) {
+		kind, _, werr := envWorkload(ctx, flagNamespace, name)
+		if werr != nil {
+			return nil, werr
+		}
+		if kind != "" {
+			progress.Infof("Starting stopped environment %s", name)
+			if werr = startWorkload(ctx, kind, name, false, timeout); werr != nil {
+				return nil, werr
+			}
+		} else if cm, cerr := kubeClient.CoreV1().ConfigMaps(flagNamespace).Get(ctx, hibernateConfigMapName(name), metav1.GetOptions{}); cerr == nil && cm.Labels[hibernateStateLabel] == hibernatedState {
+			progress.Infof("Waking hibernated environment %s", name)
+			if werr = wakeEnv(ctx, name, "", false, timeout); werr != nil {
+				return nil, werr
+			}
+		} else {
+			return nil, err
+		}
+		pod, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if pod != nil && podReady(pod) {
+		return pod, nil
+	}
+
+	desc := name
+	if pod != nil {
+		desc = fmt.Sprintf("%s (%s)", pod.Name, podStatus(pod))
+	}
+	sp := progress.Start("Waiting for environment " + desc)
+	if pod != nil && !controlledByWorkload(pod) {
+		pod, err = waitForPod(ctx, flagNamespace, pod.Name, timeout)
+	} else {
+		pod, err = waitForEnvPod(ctx, flagNamespace, name, timeout)
+	}
+	sp.Done(err)
+	return pod, err
+}
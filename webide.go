@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+)
+
+// The web IDE sidecar runs code-server on the workspace. It is reached
+// through a ClusterIP Service, and optionally an Ingress, and asks for the
+// password kept in a Secret.
+const (
+	webIDEContainer    = "web-ide"
+	webIDEPort         = 8080
+	defaultWebIDEImage = "codercom/code-server:latest"
+	webIDEPasswordKey  = "password"
+)
+
+// webIDEOptions are the up flags that shape how the web IDE is exposed.
+type webIDEOptions struct {
+	host         string
+	tlsSecret    string
+	ingressClass string
+}
+
+// webIDEName names the Service, Ingress and Secret of environment name.
+func webIDEName(name string) string {
+	return "kdev-web-ide-" + name
+}
+
+// addWebIDESidecar adds the code-server sidecar to pod; syncWebIDESidecar
+// fills it in once the dev container is final.
+func addWebIDESidecar(pod *corev1.Pod, image string) {
+	c := findContainer(pod, webIDEContainer)
+	if c == nil {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: webIDEContainer})
+		c = &pod.Spec.Containers[len(pod.Spec.Containers)-1]
+	}
+	c.Image = image
+}
+
+// syncWebIDESidecar makes the web IDE sidecar serve the dev container's
+// working directory with its mounts and user, protected by the password
+// Secret.
+func syncWebIDESidecar(pod *corev1.Pod) {
+	dev, c := findContainer(pod, devContainer), findContainer(pod, webIDEContainer)
+	if dev == nil || c == nil {
+		return
+	}
+	c.Command = []string{"code-server"}
+	c.Args = []string{"--bind-addr", fmt.Sprintf("0.0.0.0:%d", webIDEPort), "--auth", "password", "--disable-telemetry", dev.WorkingDir}
+	c.WorkingDir = dev.WorkingDir
+	c.SecurityContext = dev.SecurityContext.DeepCopy()
+	c.VolumeMounts = slices.Clone(dev.VolumeMounts)
+	c.Env = []corev1.EnvVar{{
+		Name: "PASSWORD",
+		ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: webIDEName(pod.Name)},
+			Key:                  webIDEPasswordKey,
+		}},
+	}}
+	c.Ports = []corev1.ContainerPort{{Name: "web-ide", ContainerPort: webIDEPort, Protocol: corev1.ProtocolTCP}}
+	c.ReadinessProbe = &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{Path: "/healthz", Port: intstr.FromInt32(webIDEPort)}},
+	}
+}
+
+// buildWebIDE returns the password Secret, the Service and, with a host,
+// the Ingress of the web IDE of environment name.
+func buildWebIDE(namespace, name string, opts webIDEOptions) (*corev1.Secret, *corev1.Service, *networkingv1.Ingress, error) {
+	token := make([]byte, 24)
+	if _, err := rand.Read(token); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to generate web IDE password: %w", err)
+	}
+	meta := metav1.ObjectMeta{Name: webIDEName(name), Namespace: namespace, Labels: envSelector(name)}
+
+	secret := &corev1.Secret{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: *meta.DeepCopy(),
+		Data:       map[string][]byte{webIDEPasswordKey: []byte(hex.EncodeToString(token))},
+	}
+	svc := &corev1.Service{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+		ObjectMeta: *meta.DeepCopy(),
+		Spec: corev1.ServiceSpec{
+			Selector: envSelector(name),
+			Ports: []corev1.ServicePort{{
+				Name:       "http",
+				Port:       80,
+				TargetPort: intstr.FromString("web-ide"),
+				Protocol:   corev1.ProtocolTCP,
+			}},
+		},
+	}
+	if opts.host == "" {
+		if opts.tlsSecret != "" || opts.ingressClass != "" {
+			return nil, nil, nil, errors.New("--web-ide-tls-secret and --web-ide-ingress-class need --web-ide-host")
+		}
+		return secret, svc, nil, nil
+	}
+
+	ing := &networkingv1.Ingress{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "networking.k8s.io/v1", Kind: "Ingress"},
+		ObjectMeta: *meta.DeepCopy(),
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{{
+				Host: opts.host,
+				IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{
+					Paths: []networkingv1.HTTPIngressPath{{
+						Path:     "/",
+						PathType: ptr.To(networkingv1.PathTypePrefix),
+						Backend: networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{
+							Name: svc.Name,
+							Port: networkingv1.ServiceBackendPort{Name: "http"},
+						}},
+					}},
+				}},
+			}},
+		},
+	}
+	if opts.ingressClass != "" {
+		ing.Spec.IngressClassName = ptr.To(opts.ingressClass)
+	}
+	if opts.tlsSecret != "" {
+		ing.Spec.TLS = []networkingv1.IngressTLS{{Hosts: []string{opts.host}, SecretName: opts.tlsSecret}}
+	}
+	return secret, svc, ing, nil
+}
+
+// ensureWebIDE creates what the web IDE sidecar of m needs: the password
+// Secret unless it exists, so the password survives kdev up, the Service,
+// and the Ingress when one was asked for. An existing Ingress is kept
+// otherwise.
+func ensureWebIDE(ctx context.Context, m *manifests) error {
+	if findContainer(m.Pod, webIDEContainer) == nil {
+		return nil
+	}
+	secret, svc, ing := m.WebIDESecret, m.WebIDEService, m.WebIDEIngress
+	if secret == nil || svc == nil {
+		var err error
+		if secret, svc, _, err = buildWebIDE(m.Pod.Namespace, m.Pod.Name, webIDEOptions{}); err != nil {
+			return err
+		}
+	}
+
+	secrets := kubeClient.CoreV1().Secrets(secret.Namespace)
+	live, err := secrets.Get(ctx, secret.Name, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		if err := checkExisting("Secret", &live.ObjectMeta); err != nil {
+			return err
+		}
+	case apierrors.IsNotFound(err):
+		if _, err := secrets.Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create Secret %s: %w", secret.Name, err)
+		}
+	default:
+		return fmt.Errorf("failed to get Secret %s: %w", secret.Name, err)
+	}
+
+	services := kubeClient.CoreV1().Services(svc.Namespace)
+	if live, err := services.Get(ctx, svc.Name, metav1.GetOptions{}); err == nil {
+		if err := checkExisting("Service", &live.ObjectMeta); err != nil {
+			return err
+		}
+	}
+	if _, err := serverSideApply(ctx, services.Patch, svc.Name, svc); err != nil {
+		return fmt.Errorf("failed to apply Service %s: %w", svc.Name, err)
+	}
+
+	if ing == nil {
+		return nil
+	}
+	ingresses := kubeClient.NetworkingV1().Ingresses(ing.Namespace)
+	if live, err := ingresses.Get(ctx, ing.Name, metav1.GetOptions{}); err == nil {
+		if err := checkExisting("Ingress", &live.ObjectMeta); err != nil {
+			return err
+		}
+	}
+	if _, err := serverSideApply(ctx, ingresses.Patch, ing.Name, ing); err != nil {
+		return fmt.Errorf("failed to apply Ingress %s: %w", ing.Name, err)
+	}
+	return nil
+}
+
+// moveWebIDE recreates the web IDE Secret, Service and Ingress of
+// environment from under the name of environment to, keeping the password
+// and the Ingress settings, and points pod at them. The old objects are left
+// for deleteWebIDE.
+func moveWebIDE(ctx context.Context, pod *corev1.Pod, from, to string) error {
+	c := findContainer(pod, webIDEContainer)
+	if c == nil {
+		return nil
+	}
+	var opts webIDEOptions
+	ing, err := kubeClient.NetworkingV1().Ingresses(flagNamespace).Get(ctx, webIDEName(from), metav1.GetOptions{})
+	switch {
+	case err == nil:
+		if len(ing.Spec.Rules) > 0 {
+			opts.host = ing.Spec.Rules[0].Host
+		}
+		if len(ing.Spec.TLS) > 0 {
+			opts.tlsSecret = ing.Spec.TLS[0].SecretName
+		}
+		opts.ingressClass = ptr.Deref(ing.Spec.IngressClassName, "")
+	case !apierrors.IsNotFound(err):
+		return fmt.Errorf("failed to get Ingress %s: %w", webIDEName(from), err)
+	}
+	secret, svc, newIng, err := buildWebIDE(flagNamespace, to, opts)
+	if err != nil {
+		return err
+	}
+	old, err := kubeClient.CoreV1().Secrets(flagNamespace).Get(ctx, webIDEName(from), metav1.GetOptions{})
+	switch {
+	case err == nil:
+		secret.Data = old.Data
+	case !apierrors.IsNotFound(err):
+		return fmt.Errorf("failed to get Secret %s: %w", webIDEName(from), err)
+	}
+	for i := range c.Env {
+		if ref := c.Env[i].ValueFrom; ref != nil && ref.SecretKeyRef != nil && ref.SecretKeyRef.Name == webIDEName(from) {
+			ref.SecretKeyRef.Name = secret.Name
+		}
+	}
+	return ensureWebIDE(ctx, &manifests{Pod: pod, WebIDESecret: secret, WebIDEService: svc, WebIDEIngress: newIng})
+}
+
+// webIDEURL returns where the web IDE of environment name is reached: the
+// Ingress host, or else a port-forward.
+func webIDEURL(ctx context.Context, name string) string {
+	ing, err := kubeClient.NetworkingV1().Ingresses(flagNamespace).Get(ctx, webIDEName(name), metav1.GetOptions{})
+	if err != nil || len(ing.Spec.Rules) == 0 {
+		return fmt.Sprintf("http://localhost:%d (run: kdev port-forward %s %d -n %s)", webIDEPort, name, webIDEPort, flagNamespace)
+	}
+	scheme := "http"
+	if len(ing.Spec.TLS) > 0 {
+		scheme = "https"
+	}
+	return scheme + "://" + ing.Spec.Rules[0].Host + "/"
+}
+
+// deleteWebIDE removes the Ingress, Service and Secret of the web IDE of
+// environment name, ignoring the ones that do not exist.
+func deleteWebIDE(ctx context.Context, name string) error {
+	n := webIDEName(name)
+	var errs []error
+	for kind, del := range map[string]func(context.Context, string, metav1.DeleteOptions) error{
+		"Ingress": kubeClient.NetworkingV1().Ingresses(flagNamespace).Delete,
+		"Service": kubeClient.CoreV1().Services(flagNamespace).Delete,
+		"Secret":  kubeClient.CoreV1().Secrets(flagNamespace).Delete,
+	} {
+		if err := del(ctx, n, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) && !apierrors.IsForbidden(err) {
+			errs = append(errs, fmt.Errorf("failed to delete %s %s: %w", kind, n, err))
+		}
+	}
+	return errors.Join(errs...)
+}